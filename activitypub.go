@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	activityJSON  = "application/activity+json"
+	publicAddress = "https://www.w3.org/ns/activitystreams#Public"
+	// 受け付ける署名のDateと今の時刻のずれ (古い署名の使い回しを防ぐ)
+	apMaxClockSkew = 5 * time.Minute
+)
+
+// 受信したアクティビティの署名に含まれていなければならないヘッダー
+// 宛先、本文 (Digest)、日時を署名で守らないと、別のリクエストの署名を使い回せる
+var apRequiredSignedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// 読んだ記事をActivityPubのNoteとして配信するアクター
+type apActor struct {
+	cfg activityPubConfig
+	key *rsa.PrivateKey
+}
+
+func newAPActor(c activityPubConfig) (*apActor, error) {
+	if c.BaseURL == "" || c.Username == "" {
+		return nil, errors.New("activitypub: base_url and username are required")
+	}
+	c.BaseURL = strings.TrimRight(c.BaseURL, "/")
+	if c.PrivateKey == "" {
+		c.PrivateKey = "activitypub.pem"
+	}
+	key, err := loadOrCreateKey(c.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &apActor{cfg: c, key: key}, nil
+}
+
+// 秘密鍵を読み込む
+// ファイルがなければ作成する
+func loadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		b = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("activitypub: no PEM data in %s", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("activitypub: %s is not an RSA key", path)
+	}
+	return key, nil
+}
+
+func (a *apActor) id() string        { return a.cfg.BaseURL + "/ap/actor" }
+func (a *apActor) keyID() string     { return a.id() + "#main-key" }
+func (a *apActor) followers() string { return a.cfg.BaseURL + "/ap/followers" }
+
+func (a *apActor) domain() string {
+	u, err := url.Parse(a.cfg.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// ActivityPubのエンドポイントを登録
+func (a *apActor) register(mux *http.ServeMux) {
+	mux.HandleFunc("/.well-known/webfinger", a.handleWebFinger)
+	mux.HandleFunc("/ap/actor", a.handleActor)
+	mux.HandleFunc("/ap/outbox", a.handleOutbox)
+	mux.HandleFunc("/ap/followers", a.handleFollowers)
+	mux.HandleFunc("/ap/inbox", a.handleInbox)
+	mux.HandleFunc("/ap/notes/", a.handleNote)
+}
+
+func writeActivity(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", activityJSON)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write activity: %v", err)
+	}
+}
+
+// GET /.well-known/webfinger?resource=acct:user@domain
+func (a *apActor) handleWebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	if resource != "acct:"+a.cfg.Username+"@"+a.domain() && resource != a.id() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jrd+json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"subject": "acct:" + a.cfg.Username + "@" + a.domain(),
+		"links": []map[string]string{{
+			"rel":  "self",
+			"type": activityJSON,
+			"href": a.id(),
+		}},
+	})
+}
+
+// GET /ap/actor
+func (a *apActor) handleActor(w http.ResponseWriter, r *http.Request) {
+	pub, err := x509.MarshalPKIXPublicKey(&a.key.PublicKey)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	name := a.cfg.DisplayName
+	if name == "" {
+		name = a.cfg.Username
+	}
+	writeActivity(w, map[string]any{
+		"@context":          []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		"id":                a.id(),
+		"type":              "Person",
+		"preferredUsername": a.cfg.Username,
+		"name":              name,
+		"summary":           html.EscapeString(a.cfg.Summary),
+		"inbox":             a.cfg.BaseURL + "/ap/inbox",
+		"outbox":            a.cfg.BaseURL + "/ap/outbox",
+		"followers":         a.followers(),
+		"publicKey": map[string]string{
+			"id":           a.keyID(),
+			"owner":        a.id(),
+			"publicKeyPem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+		},
+	})
+}
+
+// GET /ap/outbox
+func (a *apActor) handleOutbox(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), `
+SELECT n.url, a.title, n.published_at FROM ap_notes n JOIN articles a ON a.url = n.url
+ORDER BY n.published_at DESC LIMIT 50`)
+	if err != nil {
+		log.Printf("outbox: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	items := []any{}
+	for rows.Next() {
+		var u, title, published string
+		if err := rows.Scan(&u, &title, &published); err != nil {
+			log.Printf("outbox: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		items = append(items, a.create(article{title: title, url: u}, published))
+	}
+	writeActivity(w, map[string]any{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           a.cfg.BaseURL + "/ap/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	})
+}
+
+// GET /ap/followers
+func (a *apActor) handleFollowers(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM ap_followers").Scan(&n); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeActivity(w, map[string]any{
+		"@context":   "https://www.w3.org/ns/activitystreams",
+		"id":         a.followers(),
+		"type":       "OrderedCollection",
+		"totalItems": n,
+	})
+}
+
+// GET /ap/notes/{id}, /ap/notes/{id}/activity
+// 配信したNoteとそれを包んだCreateを返す
+func (a *apActor) handleNote(w http.ResponseWriter, r *http.Request) {
+	id, suffix, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/ap/notes/"), "/")
+	if suffix != "" && suffix != "activity" {
+		http.NotFound(w, r)
+		return
+	}
+	art, published, err := findNote(r.Context(), id)
+	if errors.Is(err, errNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("note %s: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if suffix == "activity" {
+		writeActivity(w, a.create(art, published))
+		return
+	}
+	note := a.note(art, published)
+	note["@context"] = "https://www.w3.org/ns/activitystreams"
+	writeActivity(w, note)
+}
+
+// 配信したNoteを探す
+// NoteのIDは記事のURLのハッシュなので、配信済みの記事のURLと順に比べる
+func findNote(ctx context.Context, id string) (article, string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT n.url, a.title, n.published_at FROM ap_notes n JOIN articles a ON a.url = n.url")
+	if err != nil {
+		return article{}, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var art article
+		var published string
+		if err := rows.Scan(&art.url, &art.title, &published); err != nil {
+			return article{}, "", err
+		}
+		if noteID(art.url) == id {
+			return art, published, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return article{}, "", err
+	}
+	return article{}, "", fmt.Errorf("note %q: %w", id, errNotFound)
+}
+
+func noteID(articleURL string) string {
+	return sha256Hex([]byte(articleURL))[:16]
+}
+
+// 受信したアクティビティ
+type apActivity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// POST /ap/inbox
+// Followとその取り消しだけを扱う
+func (a *apActor) handleInbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var act apActivity
+	if err := json.Unmarshal(body, &act); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	remote, err := a.verify(r, body)
+	if err != nil || remote.ID != act.Actor {
+		log.Printf("inbox: signature verification failed for %s: %v", act.Actor, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	switch act.Type {
+	case "Follow":
+		_, err = db.ExecContext(ctx, `
+INSERT INTO ap_followers (actor, inbox, followed_at) VALUES (?, ?, ?)
+ON CONFLICT (actor) DO UPDATE SET inbox = excluded.inbox`,
+			remote.ID, remote.sharedInbox(), time.Now().UTC().Format(time.RFC3339))
+		if err == nil {
+			// 承認を返すのはレスポンスの後でよい
+			go func() {
+				accept := map[string]any{
+					"@context": "https://www.w3.org/ns/activitystreams",
+					"id":       a.cfg.BaseURL + "/ap/accept/" + sha256Hex([]byte(act.ID))[:16],
+					"type":     "Accept",
+					"actor":    a.id(),
+					"object":   act,
+				}
+				if err := a.deliver(context.Background(), remote.Inbox, accept); err != nil {
+					log.Printf("accept follow %s: %v", remote.ID, err)
+				}
+			}()
+		}
+	case "Undo":
+		var inner apActivity
+		if json.Unmarshal(act.Object, &inner) == nil && inner.Type == "Follow" {
+			_, err = db.ExecContext(ctx, "DELETE FROM ap_followers WHERE actor = ?", remote.ID)
+		}
+	}
+	if err != nil {
+		log.Printf("inbox: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// リモートのアクター
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+func (r *remoteActor) sharedInbox() string {
+	if r.Endpoints.SharedInbox != "" {
+		return r.Endpoints.SharedInbox
+	}
+	return r.Inbox
+}
+
+// 取得したアクターの文書を確かめる
+// idが取得したURLと同じで、配送先 (inbox, sharedInbox) がアクターと同じホストのhttpsのURLであること
+// 署名付きの配送を、相手の文書が指す任意のURLへ送らないようにする
+func (r *remoteActor) validate(fetchedFrom string) error {
+	if r.ID != fetchedFrom {
+		return fmt.Errorf("actor %s has id %q", fetchedFrom, r.ID)
+	}
+	u, err := url.Parse(r.ID)
+	if err != nil {
+		return err
+	}
+	inboxes := []string{r.Inbox}
+	if r.Endpoints.SharedInbox != "" {
+		inboxes = append(inboxes, r.Endpoints.SharedInbox)
+	}
+	for _, inbox := range inboxes {
+		iu, err := url.Parse(inbox)
+		if err != nil || iu.Scheme != "https" || !strings.EqualFold(iu.Host, u.Host) {
+			return fmt.Errorf("actor %s: inbox %q is not on %s", r.ID, inbox, u.Host)
+		}
+	}
+	return nil
+}
+
+// アクターの取得と配送に使うクライアント (内部のアドレスには接続しない)
+var (
+	apClientOnce sync.Once
+	apHTTPClient *http.Client
+)
+
+func apClient() *http.Client {
+	apClientOnce.Do(func() { apHTTPClient = newPublicOnlyClient() })
+	return apHTTPClient
+}
+
+func fetchRemoteActor(ctx context.Context, id string) (*remoteActor, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", activityJSON)
+	resp, err := apClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch actor %s: status code %d", id, resp.StatusCode)
+	}
+	var ra remoteActor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ra); err != nil {
+		return nil, err
+	}
+	if err := ra.validate(id); err != nil {
+		return nil, err
+	}
+	return &ra, nil
+}
+
+// HTTP Signatureを検証して送信元のアクターを返す
+// 宛先、Host、Date、Digestが署名されていて、Digestが本文と一致し、Dateが新しいものだけを受け付ける
+func (a *apActor) verify(r *http.Request, body []byte) (*remoteActor, error) {
+	params := map[string]string{}
+	for _, p := range strings.Split(r.Header.Get("Signature"), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if ok {
+			params[k] = strings.Trim(v, `"`)
+		}
+	}
+	if params["keyId"] == "" || params["signature"] == "" {
+		return nil, errors.New("missing signature")
+	}
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	signed := map[string]bool{}
+	for _, h := range headers {
+		signed[h] = true
+	}
+	for _, h := range apRequiredSignedHeaders {
+		if !signed[h] {
+			return nil, fmt.Errorf("%s is not signed", h)
+		}
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("digest mismatch")
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid date: %w", err)
+	}
+	if skew := time.Since(date); skew > apMaxClockSkew || skew < -apMaxClockSkew {
+		return nil, fmt.Errorf("date %s is too far from now", r.Header.Get("Date"))
+	}
+	signingString := buildSigningString(r, headers)
+
+	// 検証の前に取得するので、httpsのURLだけを、内部のアドレスには接続しないクライアントで取りに行く
+	actorID, _, _ := strings.Cut(params["keyId"], "#")
+	if u, err := url.Parse(actorID); err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("keyId %q is not an https URL", params["keyId"])
+	}
+	remote, err := fetchRemoteActor(r.Context(), actorID)
+	if err != nil {
+		return nil, err
+	}
+	if remote.PublicKey.ID != params["keyId"] {
+		return nil, fmt.Errorf("actor %s has no key %s", actorID, params["keyId"])
+	}
+	block, _ := pem.Decode([]byte(remote.PublicKey.PublicKeyPem))
+	if block == nil {
+		return nil, errors.New("actor has no public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256([]byte(signingString))
+	if err := rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, hashed[:], sig); err != nil {
+		return nil, err
+	}
+	return remote, nil
+}
+
+// 署名対象の文字列を組み立てる
+func buildSigningString(r *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, "(request-target): "+strings.ToLower(r.Method)+" "+r.URL.RequestURI())
+		case "host":
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			lines = append(lines, "host: "+host)
+		default:
+			lines = append(lines, h+": "+r.Header.Get(h))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 署名付きでアクティビティを送信
+func (a *apActor) deliver(ctx context.Context, inbox string, activity any) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("Content-Type", activityJSON)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+
+	headers := []string{"(request-target)", "host", "date", "digest"}
+	hashed := sha256.Sum256([]byte(buildSigningString(req, headers)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		a.keyID(), strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+
+	resp, err := apClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("deliver to %s: status code %d", inbox, resp.StatusCode)
+	}
+	return nil
+}
+
+// 記事のNote
+func (a *apActor) note(art article, published string) map[string]any {
+	return map[string]any{
+		"id":           a.cfg.BaseURL + "/ap/notes/" + noteID(art.url),
+		"type":         "Note",
+		"attributedTo": a.id(),
+		"content":      fmt.Sprintf(`<p>📖 <a href="%s">%s</a></p>`, html.EscapeString(art.url), html.EscapeString(art.title)),
+		"url":          art.url,
+		"published":    published,
+		"to":           []string{publicAddress},
+		"cc":           []string{a.followers()},
+	}
+}
+
+// 記事のNoteを包んだCreateアクティビティ
+func (a *apActor) create(art article, published string) map[string]any {
+	note := a.note(art, published)
+	return map[string]any{
+		"@context":  "https://www.w3.org/ns/activitystreams",
+		"id":        note["id"].(string) + "/activity",
+		"type":      "Create",
+		"actor":     a.id(),
+		"published": published,
+		"to":        note["to"],
+		"cc":        note["cc"],
+		"object":    note,
+	}
+}
+
+// まだ配信していない既読記事をフォロワーへ配信する
+// 公開ページと同じ基準で公開してよい記事だけを対象にする
+func (a *apActor) publish(ctx context.Context, pub publicConfig) error {
+	rows, err := db.QueryContext(ctx, `
+SELECT title, url, date, public FROM articles
+WHERE read = 1 AND read_at IS NOT NULL AND url NOT IN (SELECT url FROM ap_notes)
+ORDER BY read_at`)
+	if err != nil {
+		return err
+	}
+	var pending []article
+	for rows.Next() {
+		var art article
+		var optedIn bool
+		if err := rows.Scan(&art.title, &art.url, &art.date, &optedIn); err != nil {
+			rows.Close()
+			return err
+		}
+		if pub.allows(art, optedIn) {
+			pending = append(pending, art)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	inboxes, err := apFollowerInboxes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, art := range pending {
+		published := time.Now().UTC().Format(time.RFC3339)
+		activity := a.create(art, published)
+		for _, inbox := range inboxes {
+			if err := a.deliver(ctx, inbox, activity); err != nil {
+				// 届かなかったフォロワーがいても他への配信は続ける
+				log.Printf("activitypub: %v", err)
+			}
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO ap_notes (url, published_at) VALUES (?, ?)", art.url, published); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 共有inboxごとにまとめたフォロワーの配信先
+func apFollowerInboxes(ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT inbox FROM ap_followers")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var inboxes []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		inboxes = append(inboxes, s)
+	}
+	return inboxes, rows.Err()
+}
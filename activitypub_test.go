@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestRemoteActorValidate(t *testing.T) {
+	const id = "https://social.example/users/alice"
+	tests := []struct {
+		name        string
+		actorID     string
+		inbox       string
+		sharedInbox string
+		wantErr     bool
+	}{
+		{name: "inbox only", actorID: id, inbox: id + "/inbox"},
+		{name: "shared inbox", actorID: id, inbox: id + "/inbox", sharedInbox: "https://social.example/inbox"},
+		{name: "other id", actorID: "https://other.example/users/alice", inbox: "https://other.example/inbox", wantErr: true},
+		{name: "inbox on another host", actorID: id, inbox: "https://internal.example/hook", wantErr: true},
+		{name: "shared inbox on another host", actorID: id, inbox: id + "/inbox", sharedInbox: "https://evil.example/inbox", wantErr: true},
+		{name: "http inbox", actorID: id, inbox: "http://social.example/users/alice/inbox", wantErr: true},
+		{name: "no inbox", actorID: id, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &remoteActor{ID: tt.actorID, Inbox: tt.inbox}
+			r.Endpoints.SharedInbox = tt.sharedInbox
+			err := r.validate(id)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 追跡しているブログ以外から手で追加した記事の配信元
+const manualSource = "manual"
+
+// add-url: 任意のページを1件取得して記事として保存する
+func cmdAddURL(ctx context.Context, dests []destination, args []string) error {
+	fs := flag.NewFlagSet("add-url", flag.ExitOnError)
+	notify := fs.Bool("notify", false, "notify the article right away")
+	title := fs.String("title", "", "use this title instead of the one on the page")
+	parseFlags(fs, args)
+	raw := fs.Arg(0)
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return usageErr("usage: add-url [-notify] [-title title] <http(s) url>")
+	}
+
+	page, err := fetchArticlePage(ctx, httpClient, raw)
+	if err != nil {
+		return err
+	}
+	a := article{url: raw, status: statusOK, source: manualSource}
+	if strings.Contains(page.contentType, "html") || page.contentType == "" {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.body))
+		if err != nil {
+			return err
+		}
+		a.title = extractTitle(doc)
+		if t, ok := extractPublishedTime(doc); ok {
+			a.publishedAt = t.UTC().Format(time.RFC3339)
+			a.date = t.In(sourceLocation()).Format("2006-01-02")
+		}
+	}
+	if *title != "" {
+		a.title = *title
+	}
+	if a.title == "" {
+		a.title = raw
+	}
+	// 日付がわからなければ追加した日
+	if a.date == "" {
+		a.date = time.Now().In(sourceLocation()).Format("2006-01-02")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM articles WHERE url = ?", raw).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%s is already stored", raw)
+	}
+	a.provenance = provenance{Run: runStartedAt, Via: viaManual}
+	_, err = tx.ExecContext(ctx, "INSERT INTO articles (title, url, date, status, published_at, source, provenance) VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?)",
+		a.title, a.url, a.date, a.status, a.publishedAt, a.source, a.provenance.json())
+	if err != nil {
+		return err
+	}
+	if err := recordEvent(ctx, tx, a.url, eventAdded); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("added %s (%s) %s\n", a.title, a.date, a.url)
+	if !*notify {
+		return nil
+	}
+	return notifyArticle(ctx, dests, a)
+}
+
+// ページのタイトル (og:title、JSON-LDのheadline、titleの順)
+func extractTitle(doc *goquery.Document) string {
+	if v, ok := doc.Find(`meta[property="og:title"]`).Attr("content"); ok && strings.TrimSpace(v) != "" {
+		return strings.TrimSpace(v)
+	}
+	var headline string
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if vs := jsonLDValues([]byte(s.Text()), "headline"); len(vs) > 0 {
+			headline = strings.TrimSpace(vs[0])
+		}
+		return headline == ""
+	})
+	if headline != "" {
+		return headline
+	}
+	return strings.TrimSpace(doc.Find("title").First().Text())
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 取得とブログの追加を同時に1つだけ行う (定期実行、POST /fetch、POST /sources)
+// ブログの一覧の読み書きはblogsMuで守る
+var fetchMu sync.Mutex
+
+// APIで返すブログ
+type sourceJSON struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Mode    string `json:"mode"`
+	FeedURL string `json:"feed_url,omitempty"`
+}
+
+// ログインを有効にしていなければGETとHEADだけを通す
+func requireLoginToChange(auth *sessionAuth, next http.Handler) http.Handler {
+	if auth != nil {
+		return auth.require(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusForbidden, "set server.auth.enabled to use "+r.Method+" "+r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GET /articles/{id}
+// POST /articles/{id}/read, /articles/{id}/unread
+func handleArticle(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/articles/"), "/")
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch {
+	case action == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+	case (action == "read" || action == "unread") && r.Method == http.MethodPost:
+	case action == "" || action == "read" || action == "unread":
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	a, err := lookupArticle(r.Context(), id)
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "article not found")
+		return
+	}
+	if err != nil {
+		log.Printf("article %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	switch action {
+	case "read":
+		err = acknowledge(r.Context(), a.url)
+	case "unread":
+		err = markUnread(r.Context(), a.url)
+	}
+	if err != nil {
+		log.Printf("%s %s: %v", action, a.url, err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	articles, err := queryArticles(r.Context(), articleFilter{url: a.url, excludeSources: hiddenSources(r.Context())})
+	if err != nil {
+		log.Printf("article %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	// 非公開のブログの記事はログインしていなければないものとして扱う
+	if len(articles) == 0 {
+		writeError(w, http.StatusNotFound, "article not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, toArticleJSON(articles[0]))
+}
+
+// GET /sources
+// POST /sources (blogs[]と同じ項目のJSON、例: {"url": "...", "mode": "feed"})
+// 追加したブログは設定ファイルのblogsに書き、次の取得から使う
+func handleSources(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		res := []sourceJSON{}
+		for _, b := range blogs() {
+			res = append(res, toSourceJSON(b))
+		}
+		writeJSON(w, http.StatusOK, res)
+	case http.MethodPost:
+		b, status, err := addSource(r)
+		if err != nil {
+			if status == http.StatusInternalServerError {
+				log.Printf("add source: %v", err)
+				err = errors.New("internal error")
+			}
+			writeError(w, status, err.Error())
+			return
+		}
+		log.Printf("added the blog %s (%s)", b.name(), b.url)
+		writeJSON(w, http.StatusCreated, toSourceJSON(b))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func toSourceJSON(b blog) sourceJSON {
+	mode := b.cfg.Mode
+	if mode == "" {
+		mode = sourceModeHTML
+	}
+	return sourceJSON{Name: b.name(), URL: b.url, Mode: mode, FeedURL: b.cfg.FeedURL}
+}
+
+// リクエストのブログを検証して設定ファイルと実行中の設定に加える
+// 失敗したら返すHTTPのステータスも返す
+func addSource(r *http.Request) (blog, int, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return blog{}, http.StatusBadRequest, err
+	}
+	// JSONはYAMLとしても読めるので、設定ファイルと同じ名前と検証を使う
+	var s sourceConfig
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return blog{}, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err)
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(body, &node); err != nil || len(node.Content) != 1 || node.Content[0].Kind != yaml.MappingNode {
+		return blog{}, http.StatusBadRequest, errors.New("body must be a JSON object")
+	}
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return blog{}, http.StatusBadRequest, errors.New("url must be an http or https URL")
+	}
+	if err := s.validate("source"); err != nil {
+		return blog{}, http.StatusBadRequest, err
+	}
+	if conf.path == "" {
+		return blog{}, http.StatusInternalServerError, errors.New("no config file to write")
+	}
+
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	added := blog{url: s.URL, cfg: s}
+	// ブログを増やすのはここだけで、fetchMuを持っているので調べてから加えるまでに変わらない
+	all := blogs()
+	for _, b := range all {
+		if b.url == s.URL {
+			return blog{}, http.StatusConflict, fmt.Errorf("%s is already configured as %s", s.URL, b.name())
+		}
+	}
+	for _, b := range all {
+		if b.name() == added.name() {
+			return blog{}, http.StatusConflict, fmt.Errorf("a blog named %s already exists", b.name())
+		}
+	}
+	// 設定ファイルには送られた項目だけを書く (JSONの書き方のままにしない)
+	n := node.Content[0]
+	blockStyle(n)
+	if err := addBlogsToConfig(conf.path, []*yaml.Node{n}); err != nil {
+		return blog{}, http.StatusInternalServerError, err
+	}
+	// addBlogsToConfigと同じく、sourceだけなら先頭のブログにする
+	blogsMu.Lock()
+	defer blogsMu.Unlock()
+	blogsNow := conf.Blogs
+	if len(blogsNow) == 0 {
+		if current := all[0]; current.url != "" {
+			current.cfg.URL = current.url
+			blogsNow = []sourceConfig{current.cfg}
+		}
+	}
+	conf.Blogs = append(blogsNow[:len(blogsNow):len(blogsNow)], s)
+	return added, http.StatusCreated, nil
+}
+
+// JSONのフロー形式をブロック形式にする
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// POST /fetch ({"source": "..."}、省くとすべてのブログ)
+// 取得が終わるまで待ち、新しく保存した記事の数を返す
+func handleFetch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Source string `json:"source"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	targets := blogs()
+	if req.Source != "" {
+		b, err := findBlog(req.Source)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		targets = []blog{b}
+	}
+	if !fetchMu.TryLock() {
+		writeError(w, http.StatusConflict, "a fetch is already running")
+		return
+	}
+	defer fetchMu.Unlock()
+	before, err := articleCount(r.Context())
+	if err != nil {
+		log.Printf("fetch: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	fetchErr := fetchPhase(r.Context(), targets)
+	after, err := articleCount(r.Context())
+	if err != nil {
+		log.Printf("fetch: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	res := struct {
+		New   int    `json:"new"`
+		Error string `json:"error,omitempty"`
+	}{New: after - before}
+	status := http.StatusOK
+	if fetchErr != nil {
+		log.Printf("fetch: %v", fetchErr)
+		res.Error = fetchErr.Error()
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, res)
+}
+
+func articleCount(ctx context.Context) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM articles").Scan(&n)
+	return n, err
+}
@@ -0,0 +1,402 @@
+// Package client はfetch-blogのserveが提供するHTTP APIのクライアント
+//
+// ほかのツールから記事の一覧、既読、公開の切り替え、同期を呼び出せる
+// ログインと同期のトークンによる認証、送信の間隔の制限、一時的な失敗のやり直しを内部で行う
+//
+//	c := client.New("https://blog.example.com", client.WithLogin("alice", "secret"))
+//	articles, err := c.Articles(ctx, client.ArticleQuery{Read: client.Bool(false), Limit: 10})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// サーバーが状態を変えるリクエストに求めるCSRFトークンのヘッダー
+const csrfHeader = "X-CSRF-Token"
+
+// APIの記事
+type Article struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	Date  string `json:"date"`
+	Read  bool   `json:"read"`
+}
+
+// 同期でやり取りする記事の状態
+type SyncArticle struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Date        string `json:"date"`
+	PublishedAt string `json:"published_at,omitempty"`
+	Read        bool   `json:"read"`
+	ReadAt      string `json:"read_at,omitempty"`
+	Status      string `json:"status"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// 記事の絞り込み条件 (空の項目は絞り込まない)
+type ArticleQuery struct {
+	Read   *bool
+	Status string
+	Source string
+	// YYYY-MM-DD (両端を含む)
+	Since, Until string
+	// trueなら新しい順
+	NewestFirst bool
+	// 0なら無制限
+	Limit int
+}
+
+func Bool(b bool) *bool { return &b }
+
+// APIが返したエラー
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("api: status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("api: status code %d: %s", e.StatusCode, e.Message)
+}
+
+// ログインが必要なのに認証情報がないか、ログインに失敗した
+var ErrUnauthorized = errors.New("api: unauthorized")
+
+type Client struct {
+	baseURL string
+	hc      *http.Client
+
+	user, password string
+	syncToken      string
+
+	// 送信の間隔 (nextは次のリクエストを送ってよい時刻) とCSRFトークン
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	csrf     string
+
+	maxAttempts int
+	maxBackoff  time.Duration
+}
+
+type Option func(*Client)
+
+// ユーザー名とパスワードでログインする (server.auth.enabledのとき)
+func WithLogin(user, password string) Option {
+	return func(c *Client) { c.user, c.password = user, password }
+}
+
+// 同期のAPIに使うトークン (sync.token)
+func WithSyncToken(token string) Option {
+	return func(c *Client) { c.syncToken = token }
+}
+
+// 使うHTTPクライアント (Cookieはクライアントが持つので、Jarは置き換える)
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		cp := *hc
+		c.hc = &cp
+	}
+}
+
+// 1秒あたりのリクエスト数の上限 (既定は5、0以下なら制限しない)
+func WithRateLimit(perSecond float64) Option {
+	return func(c *Client) {
+		if perSecond <= 0 {
+			c.interval = 0
+			return
+		}
+		c.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+// 最初の1回を含む回数 (既定は3) と待つ時間の上限 (既定は30秒)
+func WithRetry(maxAttempts int, maxBackoff time.Duration) Option {
+	return func(c *Client) { c.maxAttempts, c.maxBackoff = maxAttempts, maxBackoff }
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		hc:          &http.Client{Timeout: 30 * time.Second},
+		interval:    200 * time.Millisecond,
+		maxAttempts: 3,
+		maxBackoff:  30 * time.Second,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	jar, _ := cookiejar.New(nil)
+	c.hc.Jar = jar
+	return c
+}
+
+// 条件に合う記事
+func (c *Client) Articles(ctx context.Context, q ArticleQuery) ([]Article, error) {
+	v := url.Values{}
+	if q.Read != nil {
+		v.Set("read", strconv.FormatBool(*q.Read))
+	}
+	for k, s := range map[string]string{"status": q.Status, "source": q.Source, "since": q.Since, "until": q.Until} {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	if q.NewestFirst {
+		v.Set("order", "newest")
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	path := "/articles"
+	if len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+	var res []Article
+	err := c.do(ctx, http.MethodGet, path, nil, &res, true)
+	return res, err
+}
+
+// 記事を既読にする
+func (c *Client) MarkRead(ctx context.Context, articleURL string) error {
+	return c.do(ctx, http.MethodPost, "/articles/read", map[string]string{"url": articleURL}, nil, true)
+}
+
+// 記事を公開ページに載せるかを切り替える
+func (c *Client) SetPublic(ctx context.Context, articleURL string, public bool) error {
+	return c.do(ctx, http.MethodPost, "/articles/public", map[string]any{"url": articleURL, "public": public}, nil, true)
+}
+
+// since (RFC3339) 以降に変わった記事
+func (c *Client) SyncChanges(ctx context.Context, since string) ([]SyncArticle, error) {
+	var res struct {
+		Articles []SyncArticle `json:"articles"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/sync?since="+url.QueryEscape(since), nil, &res, false)
+	return res.Articles, err
+}
+
+// 記事の状態を送って取り込ませ、取り込まれた件数を返す
+func (c *Client) PushSync(ctx context.Context, articles []SyncArticle) (int, error) {
+	var res struct {
+		Applied int `json:"applied"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/sync", map[string]any{"articles": articles}, &res, false)
+	return res.Applied, err
+}
+
+// 同期のAPIはトークンで認証する
+func (c *Client) bearer(req *http.Request) error {
+	if c.syncToken == "" {
+		return fmt.Errorf("%w: sync token is not set", ErrUnauthorized)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.syncToken)
+	return nil
+}
+
+// セッションのCookieはJarが付ける
+// 状態を変えるリクエストにはCSRFトークンを付ける
+func (c *Client) session(req *http.Request) {
+	if req.Method == http.MethodGet {
+		return
+	}
+	c.mu.Lock()
+	token := c.csrf
+	c.mu.Unlock()
+	if token != "" {
+		req.Header.Set(csrfHeader, token)
+	}
+}
+
+// ログインしてセッションのCookieとCSRFトークンを受け取る
+// ログイン後の移動先で認証の必要なページを開き、そこでCSRFトークンを受け取る
+func (c *Client) login(ctx context.Context) error {
+	if c.user == "" {
+		return fmt.Errorf("%w: login required", ErrUnauthorized)
+	}
+	form := url.Values{"user": {c.user}, "password": {c.password}, "next": {"/articles?limit=1"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: login failed (status code %d)", ErrUnauthorized, resp.StatusCode)
+	}
+	c.saveCSRF(resp)
+	return nil
+}
+
+func (c *Client) saveCSRF(resp *http.Response) {
+	if t := resp.Header.Get(csrfHeader); t != "" {
+		c.mu.Lock()
+		c.csrf = t
+		c.mu.Unlock()
+	}
+}
+
+// リクエストを送り、一時的な失敗 (接続のエラー、429、5xx) はやり直す
+// どのAPIも同じ内容で何度送っても結果が変わらないので、POSTもやり直してよい
+// セッションで認証するAPIは、ログインが切れていれば (401) ログインし直して1回だけ送り直す
+func (c *Client) do(ctx context.Context, method, path string, in, out any, useSession bool) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	relogged := false
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, method, path, body, useSession)
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || errors.Is(err, ErrUnauthorized) || attempt >= c.maxAttempts {
+				return err
+			}
+			wait = c.backoff(attempt)
+		case resp.StatusCode == http.StatusUnauthorized && useSession && !relogged && c.user != "":
+			resp.Body.Close()
+			relogged = true
+			if err := c.login(ctx); err != nil {
+				return err
+			}
+			attempt--
+			continue
+		case (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) && attempt < c.maxAttempts:
+			wait = c.backoff(attempt)
+			if d, ok := retryAfter(resp); ok {
+				wait = d
+			}
+			resp.Body.Close()
+		default:
+			defer resp.Body.Close()
+			return decode(resp, out)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte, useSession bool) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if useSession {
+		c.session(req)
+	} else if err := c.bearer(req); err != nil {
+		return nil, err
+	}
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	c.saveCSRF(resp)
+	return resp, nil
+}
+
+// 送信の間隔を空ける
+func (c *Client) wait(ctx context.Context) error {
+	c.mu.Lock()
+	now := time.Now()
+	at := c.next
+	if at.Before(now) {
+		at = now
+	}
+	c.next = at.Add(c.interval)
+	c.mu.Unlock()
+	if d := time.Until(at); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// attempt回目の失敗のあとに待つ時間 (倍々の上限までのどこか)
+func (c *Client) backoff(attempt int) time.Duration {
+	limit := 500 * time.Millisecond
+	for i := 1; i < attempt && limit < c.maxBackoff; i++ {
+		limit *= 2
+	}
+	if limit > c.maxBackoff {
+		limit = c.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// Retry-After (秒数か日時)
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// 成功なら本文をoutに読み込み、失敗ならErrorにする
+func decode(resp *http.Response, out any) error {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		if json.Unmarshal(raw, &e) != nil {
+			e.Error = strings.TrimSpace(string(raw))
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("%w: %s", ErrUnauthorized, e.Error)
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: e.Error}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
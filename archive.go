@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"fetch-blog/fetcher"
+)
+
+// 記事ページを保存先に書き込む処理
+// HTMLだけでなくPDFや画像への直リンクもそのまま保存する
+// HTMLは埋め込まれた画像も保存する (archiveImages)
+func archiveStep(store blobStore, c archiveConfig) pageStep {
+	return pageStep{
+		name:    "archive",
+		pending: "url NOT IN (SELECT url FROM archives)",
+		handle: func(ctx context.Context, p *articlePage) error {
+			key := archiveKey(p.url, p.contentType)
+			if c.Naming == archiveNamingSlug {
+				var title, date string
+				if err := db.QueryRowContext(ctx, "SELECT title, date FROM articles WHERE url = ?", p.url).Scan(&title, &date); err != nil {
+					return err
+				}
+				var err error
+				if key, err = slugArchiveKey(ctx, p.url, title, date, archiveExt(p.contentType), c.Transliterate); err != nil {
+					return err
+				}
+			}
+			body, assets, err := archiveImages(ctx, store, p, key)
+			if err != nil {
+				return err
+			}
+			if err := store.put(ctx, key, p.contentType, body); err != nil {
+				return err
+			}
+			// 容量の上限は埋め込んだ画像も含めて数える
+			size := len(body)
+			for _, a := range assets {
+				size += a.size
+				if _, err := db.ExecContext(ctx, "INSERT OR REPLACE INTO archive_assets (url, src, key, size) VALUES (?, ?, ?, ?)",
+					p.url, a.src, a.key, a.size); err != nil {
+					return err
+				}
+			}
+			_, err = db.ExecContext(ctx, "INSERT INTO archives (url, key, size, archived_at, etag, last_modified) VALUES (?, ?, ?, ?, ?, ?)",
+				p.url, key, size, time.Now().UTC().Format(time.RFC3339), p.etag, p.lastModified)
+			return err
+		},
+	}
+}
+
+// HTMLに埋め込む画像の上限
+const (
+	archiveMaxImages    = 50
+	archiveMaxImageSize = 10 << 20
+)
+
+// 保存した画像
+type archiveAsset struct {
+	src  string
+	key  string
+	size int
+}
+
+// HTMLが埋め込む画像 (img src) を保存し、srcを保存した画像への相対パスに書き換える
+// 画像はページのキーの隣の "<名前>_files/" に置くので、ローカルでもS3でもそのまま開ける
+// 取得できなかった画像は元のURLのまま残す
+func archiveImages(ctx context.Context, store blobStore, p *articlePage, key string) ([]byte, []archiveAsset, error) {
+	if mt, _, _ := mime.ParseMediaType(p.contentType); mt != "text/html" && p.contentType != "" {
+		return p.body, nil, nil
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(p.body))
+	if err != nil {
+		return nil, nil, err
+	}
+	base, err := url.Parse(p.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := strings.TrimSuffix(path.Base(key), path.Ext(key)) + "_files"
+	saved := map[string]string{}
+	var assets []archiveAsset
+	doc.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+		src, _ := img.Attr("src")
+		ref, err := base.Parse(strings.TrimSpace(src))
+		if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+			return
+		}
+		abs := ref.String()
+		rel, ok := saved[abs]
+		if !ok {
+			if len(assets) >= archiveMaxImages {
+				return
+			}
+			data, contentType, err := fetchArchiveImage(ctx, abs)
+			if err != nil {
+				log.Printf("archive %s: image %s: %v", p.url, abs, err)
+				return
+			}
+			rel = dir + "/" + sha256Hex([]byte(abs))[:16] + archiveExt(contentType)
+			assetKey := path.Join(path.Dir(key), rel)
+			if err := store.put(ctx, assetKey, contentType, data); err != nil {
+				log.Printf("archive %s: image %s: %v", p.url, abs, err)
+				return
+			}
+			saved[abs] = rel
+			assets = append(assets, archiveAsset{src: abs, key: assetKey, size: len(data)})
+		}
+		img.SetAttr("src", rel)
+		// srcsetが残るとブラウザが元のURLを読みに行く
+		img.RemoveAttr("srcset")
+	})
+	if len(assets) == 0 {
+		return p.body, nil, nil
+	}
+	doc.Find("picture source[srcset]").Remove()
+	html, err := doc.Html()
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(html), assets, nil
+}
+
+// 埋め込まれた画像を取得する
+func fetchArchiveImage(ctx context.Context, imageURL string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &fetcher.StatusError{Code: resp.StatusCode}
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("not an image: %q", contentType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, archiveMaxImageSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > archiveMaxImageSize {
+		return nil, "", fmt.Errorf("larger than %s", formatSize(archiveMaxImageSize))
+	}
+	return data, contentType, nil
+}
+
+// 保存先のキーの付け方
+const (
+	// URLのハッシュ (既定)
+	archiveNamingHash = "hash"
+	// 日付とタイトルのスラッグ
+	archiveNamingSlug = "slug"
+)
+
+// URLから保存先のキーを作る
+func archiveKey(articleURL, contentType string) string {
+	return sha256Hex([]byte(articleURL))[:32] + archiveExt(contentType)
+}
+
+// Content-Typeに合う拡張子
+func archiveExt(contentType string) string {
+	ext := ".html"
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mt {
+		case "text/html":
+		case "application/pdf":
+			ext = ".pdf"
+		// ExtensionsByTypeは.jfifを先に返す
+		case "image/jpeg":
+			ext = ".jpg"
+		default:
+			if exts, _ := mime.ExtensionsByType(mt); len(exts) > 0 {
+				ext = exts[0]
+			}
+		}
+	}
+	return ext
+}
+
+// 保存容量の上限とTTLを超えた分を古い順に削除する
+// 行はETagなどとともに残し、削除したことだけを記録する (残した行があるので取得し直さない)
+func evictArchives(ctx context.Context, store blobStore, c archiveConfig) error {
+	maxSize, err := parseSize(c.MaxSize)
+	if err != nil {
+		return fmt.Errorf("archive.max_size: %w", err)
+	}
+	if c.TTL > 0 {
+		cutoff := time.Now().Add(-c.TTL).UTC().Format(time.RFC3339)
+		n, err := evictWhere(ctx, store, "archived_at < ?", cutoff)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("archive: evicted %d pages older than %s", n, c.TTL)
+		}
+	}
+	if maxSize <= 0 {
+		return nil
+	}
+	var total int64
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size), 0) FROM archives WHERE evicted_at IS NULL").Scan(&total); err != nil {
+		return err
+	}
+	if total <= maxSize {
+		return nil
+	}
+	// 上限に収まるまで古いものから消す
+	rows, err := db.QueryContext(ctx, "SELECT url, key, size FROM archives WHERE evicted_at IS NULL ORDER BY archived_at")
+	if err != nil {
+		return err
+	}
+	type victim struct {
+		url, key string
+	}
+	var victims []victim
+	for rows.Next() && total > maxSize {
+		var v victim
+		var size int64
+		if err := rows.Scan(&v.url, &v.key, &size); err != nil {
+			rows.Close()
+			return err
+		}
+		victims = append(victims, v)
+		total -= size
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, v := range victims {
+		if err := evictArchive(ctx, store, v.url, v.key); err != nil {
+			return err
+		}
+	}
+	log.Printf("archive: evicted %d pages to stay under %s", len(victims), c.MaxSize)
+	return nil
+}
+
+// 条件に合う保存済みのページを削除する
+func evictWhere(ctx context.Context, store blobStore, cond string, args ...any) (int, error) {
+	query, qargs := selectFrom("archives", "url", "key").where("evicted_at IS NULL").where(cond, args...).build()
+	rows, err := db.QueryContext(ctx, query, qargs...)
+	if err != nil {
+		return 0, err
+	}
+	var urls, keys []string
+	for rows.Next() {
+		var u, k string
+		if err := rows.Scan(&u, &k); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		urls, keys = append(urls, u), append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for i := range urls {
+		if err := evictArchive(ctx, store, urls[i], keys[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(urls), nil
+}
+
+// ページと埋め込んだ画像を削除する
+func evictArchive(ctx context.Context, store blobStore, url, key string) error {
+	assetKeys, err := archiveAssetKeys(ctx, url)
+	if err != nil {
+		return err
+	}
+	for _, k := range append(assetKeys, key) {
+		if err := store.delete(ctx, k); err != nil {
+			return fmt.Errorf("evict %s: %w", url, err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM archive_assets WHERE url = ?", url); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE archives SET evicted_at = ? WHERE url = ?", time.Now().UTC().Format(time.RFC3339), url)
+	return err
+}
+
+func archiveAssetKeys(ctx context.Context, url string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT key FROM archive_assets WHERE url = ?", url)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// "500MB" のような容量をバイト数にする (空なら0)
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return int64(n * float64(u.mult)), nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n, nil
+}
+
+// cache: 保存したページの容量を確認・削除する
+//
+//	cache stats
+//	cache clear [-older-than 720h]
+func cmdCache(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageErr("usage: cache stats | cache clear [-older-than duration]")
+	}
+	switch args[0] {
+	case "stats":
+		return printCacheStats(ctx)
+	case "clear":
+		fs := flag.NewFlagSet("cache clear", flag.ExitOnError)
+		olderThan := fs.String("older-than", "", "only remove pages archived before this long ago, e.g. 30d")
+		parseFlags(fs, args[1:])
+		store, err := newBlobStore(conf.Archive)
+		if err != nil {
+			return err
+		}
+		cond, cargs := "1 = 1", []any(nil)
+		if *olderThan != "" {
+			d, err := parseDuration(*olderThan)
+			if err != nil {
+				return err
+			}
+			cond, cargs = "archived_at < ?", []any{time.Now().Add(-d).UTC().Format(time.RFC3339)}
+		}
+		n, err := evictWhere(ctx, store, cond, cargs...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d pages\n", n)
+		return nil
+	default:
+		return usageErr(fmt.Sprintf("unknown cache command %q", args[0]))
+	}
+}
+
+func printCacheStats(ctx context.Context) error {
+	var stored, evicted int
+	var size int64
+	var oldest, newest string
+	err := db.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(evicted_at IS NULL), 0), COALESCE(SUM(evicted_at IS NOT NULL), 0),
+       COALESCE(SUM(CASE WHEN evicted_at IS NULL THEN size END), 0),
+       COALESCE(MIN(CASE WHEN evicted_at IS NULL THEN archived_at END), ''),
+       COALESCE(MAX(CASE WHEN evicted_at IS NULL THEN archived_at END), '')
+FROM archives`).Scan(&stored, &evicted, &size, &oldest, &newest)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "stored\t%d pages\n", stored)
+	fmt.Fprintf(w, "size\t%s\n", formatSize(size))
+	if c := conf.Archive; c.MaxSize != "" || c.TTL > 0 {
+		fmt.Fprintf(w, "limit\tmax_size=%s ttl=%s\n", c.MaxSize, c.TTL)
+	}
+	fmt.Fprintf(w, "evicted\t%d pages\n", evicted)
+	if stored > 0 {
+		fmt.Fprintf(w, "oldest\t%s\n", oldest)
+		fmt.Fprintf(w, "newest\t%s\n", newest)
+	}
+	return w.Flush()
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
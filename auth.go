@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ログインが切れていて記事一覧の代わりにログインページが返ってきた
+var errLoginRequired = errors.New("login required")
+
+// 保存したCookieを持つHTTPクライアントを作る
+func newSourceClient(ctx context.Context, b blog) (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadCookies(ctx, jar, b); err != nil {
+		return nil, err
+	}
+	client := newHTTPClient(jar)
+	if len(b.cfg.UserAgents) > 0 || b.cfg.Jitter > 0 {
+		client.Transport = &rotatingTransport{base: httpTransport, userAgents: b.cfg.UserAgents, jitter: b.cfg.Jitter}
+	}
+	if b.cfg.Render.enabled() {
+		client.Transport = &renderTransport{base: client.Transport, byHost: renderHosts([]blog{b})}
+	}
+	if conf.Fetch.DebugResponses.Enabled {
+		client.Transport = &recordingTransport{base: client.Transport, source: b.name(), cfg: conf.Fetch.DebugResponses}
+	}
+	return client, nil
+}
+
+// すべてのブログのCookieを持つHTTPクライアントを作る (記事ページの取得用)
+func newPagesClient(ctx context.Context) (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	all := blogs()
+	for _, b := range all {
+		if err := loadCookies(ctx, jar, b); err != nil {
+			return nil, err
+		}
+		// 記事ページは同意の画面か判定しないので、最初から同意済みのCookieを付ける
+		if b.cfg.Consent.enabled() {
+			b.cfg.Consent.setCookies(jar, b.url)
+		}
+	}
+	client := newHTTPClient(jar)
+	// User-Agentと待ち時間は最初のブログの設定を使う
+	if c := all[0].cfg; len(c.UserAgents) > 0 || c.Jitter > 0 {
+		client.Transport = &rotatingTransport{base: httpTransport, userAgents: c.UserAgents, jitter: c.Jitter}
+	}
+	if hosts := renderHosts(all); hosts != nil {
+		client.Transport = &renderTransport{base: client.Transport, byHost: hosts}
+	}
+	return client, nil
+}
+
+// ブログの保存したCookieをjarに入れる
+func loadCookies(ctx context.Context, jar http.CookieJar, b blog) error {
+	u, err := url.Parse(b.url)
+	if err != nil {
+		return err
+	}
+	var stored string
+	err = db.QueryRowContext(ctx, "SELECT cookies FROM source_cookies WHERE source = ?", b.name()).Scan(&stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	header, err := openCookies(stored)
+	if err != nil {
+		return fmt.Errorf("%s: cookies: %w", b.name(), err)
+	}
+	cookies := parseCookieHeader(header)
+	// 暗号化する前に保存したCookieは暗号化して保存し直す
+	if !strings.HasPrefix(stored, sealedCookiesPrefix) && len(cookies) > 0 {
+		if err := storeCookies(ctx, b.name(), cookies); err != nil {
+			return err
+		}
+	}
+	jar.SetCookies(u, cookies)
+	return nil
+}
+
+// クライアントが持っているCookieを保存する
+// サーバーが更新したセッションを次回以降も使えるようにする
+func saveSourceCookies(ctx context.Context, b blog, client *http.Client) error {
+	u, err := url.Parse(b.url)
+	if err != nil {
+		return err
+	}
+	cookies := client.Jar.Cookies(u)
+	if len(cookies) == 0 {
+		return nil
+	}
+	return storeCookies(ctx, b.name(), cookies)
+}
+
+func storeCookies(ctx context.Context, source string, cookies []*http.Cookie) error {
+	parts := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		parts = append(parts, c.Name+"="+c.Value)
+	}
+	sealed, err := sealCookies(strings.Join(parts, "; "))
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+INSERT INTO source_cookies (source, cookies, updated_at) VALUES (?, ?, ?)
+ON CONFLICT (source) DO UPDATE SET cookies = excluded.cookies, updated_at = excluded.updated_at`,
+		source, sealed, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// 暗号化したCookieの先頭 (ないものは暗号化する前に保存した平文)
+const sealedCookiesPrefix = "aesgcm:"
+
+// Cookieを暗号化する鍵 (最初に使うときに読み込む)
+var (
+	cookieKeyOnce sync.Once
+	cookieAEAD    cipher.AEAD
+	cookieKeyErr  error
+)
+
+// ログインのCookieはセッションそのものなので、DBのバックアップや複製からは読めないようにする
+// 鍵はcookie_keyのファイル (既定はcookies.key) に置き、なければ作成する
+func cookieCipher() (cipher.AEAD, error) {
+	cookieKeyOnce.Do(func() {
+		path := conf.CookieKey
+		if path == "" {
+			path = "cookies.key"
+		}
+		var key []byte
+		if key, cookieKeyErr = loadOrCreateSecret(path, 32); cookieKeyErr != nil {
+			return
+		}
+		var block cipher.Block
+		if block, cookieKeyErr = aes.NewCipher(key); cookieKeyErr != nil {
+			return
+		}
+		cookieAEAD, cookieKeyErr = cipher.NewGCM(block)
+	})
+	return cookieAEAD, cookieKeyErr
+}
+
+// 鍵のファイルを読み込む
+// ファイルがなければ作成する
+func loadOrCreateSecret(path string, size int) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, size)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != size {
+		return nil, fmt.Errorf("%s: must hold a base64 %d-byte key", path, size)
+	}
+	return key, nil
+}
+
+func sealCookies(header string) (string, error) {
+	aead, err := cookieCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(header), nil)
+	return sealedCookiesPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openCookies(stored string) (string, error) {
+	enc, ok := strings.CutPrefix(stored, sealedCookiesPrefix)
+	if !ok {
+		return stored, nil
+	}
+	aead, err := cookieCipher()
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil || len(b) < aead.NonceSize() {
+		return "", errors.New("invalid encrypted cookies")
+	}
+	header, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt with the cookie_key file; run login again")
+	}
+	return string(header), nil
+}
+
+// "a=1; b=2" 形式のCookieを読み込む
+func parseCookieHeader(header string) []*http.Cookie {
+	header = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(header), "Cookie:"))
+	if header == "" {
+		return nil
+	}
+	req := http.Request{Header: http.Header{"Cookie": {header}}}
+	return req.Cookies()
+}
+
+// 取得したページがログインページかどうか
+func isLoginPage(b blog, resp *http.Response, doc *goquery.Document) bool {
+	a := b.cfg.Auth
+	if a.LoginMarker != "" && doc.Find(a.LoginMarker).Length() > 0 {
+		return true
+	}
+	// ログインページへリダイレクトされた
+	if a.LoginURL != "" && resp.Request != nil {
+		if lu, err := url.Parse(a.LoginURL); err == nil && resp.Request.URL.Path == lu.Path && resp.Request.URL.Host == lu.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// login: ブログのログイン用Cookieを保存する
+// 貼り付けたCookieを使うか、設定のフォームでログインする
+// OAuthのログインには対応しない。ブラウザでログインしてCookieを貼り付ける
+func cmdLogin(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	cookie := fs.String("cookie", "", `cookies to store, e.g. "session=abc; token=def" (read from stdin if empty)`)
+	form := fs.Bool("form", false, "log in with the form configured in source.auth")
+	parseFlags(fs, args)
+
+	b, err := findBlog(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *form {
+		return formLogin(ctx, b)
+	}
+
+	header := *cookie
+	if header == "" {
+		fmt.Fprintf(os.Stderr, "Paste the Cookie header for %s and press Enter:\n", b.name())
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		header = line
+	}
+	cookies := parseCookieHeader(header)
+	if len(cookies) == 0 {
+		return errors.New("no cookies given")
+	}
+	if err := storeCookies(ctx, b.name(), cookies); err != nil {
+		return err
+	}
+	fmt.Printf("stored %d cookies for %s\n", len(cookies), b.name())
+	return nil
+}
+
+// 設定のログインフォームへ送信し、返ってきたCookieを保存する
+func formLogin(ctx context.Context, b blog) error {
+	a := b.cfg.Auth
+	if a.LoginURL == "" || len(a.Form) == 0 {
+		return fmt.Errorf("%s: auth.login_url and auth.form are required for form login", b.name())
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	client := newHTTPClient(jar)
+	values := url.Values{}
+	for k, v := range a.Form {
+		values.Set(k, os.ExpandEnv(v))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.LoginURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login: status code %d", resp.StatusCode)
+	}
+	u, err := url.Parse(b.url)
+	if err != nil {
+		return err
+	}
+	cookies := jar.Cookies(u)
+	if len(cookies) == 0 {
+		return errors.New("login: the server did not set any cookies")
+	}
+	if err := storeCookies(ctx, b.name(), cookies); err != nil {
+		return err
+	}
+	fmt.Printf("logged in to %s\n", b.name())
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"fetch-blog/fetcher"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 一覧のページを次へ辿って古い記事まで保存する
+// 辿る予定のページ (pending) と辿ったページ (done) をcrawl_frontierに残すので、
+// 中断しても次のbackfillは止まったページから続ける
+const (
+	frontierPending = "pending"
+	frontierDone    = "done"
+)
+
+// backfill: 日付にかかわらず一覧のすべての記事を保存する
+func cmdBackfill(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	maxPages := fs.Int("max-pages", 0, "stop after this many pages (defaults to pagination.max_pages; run again to continue)")
+	restart := fs.Bool("restart", false, "discard the saved progress and start from the first page")
+	parseFlags(fs, args)
+	b, err := findBlog(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return backfill(ctx, b, *maxPages, *restart)
+}
+
+func backfill(ctx context.Context, b blog, maxPages int, restart bool) error {
+	if maxPages < 0 {
+		return usageErr(fmt.Sprintf("invalid -max-pages %d", maxPages))
+	}
+	if maxPages == 0 {
+		maxPages = b.cfg.Pagination.MaxPages
+	}
+	// フィードは次のページを辿らない
+	if b.cfg.Mode == sourceModeFeed {
+		articles, err := fetchListing(ctx, b, true)
+		if err != nil {
+			return err
+		}
+		if articles, err = canonicalize(ctx, b, articles, true); err != nil {
+			return err
+		}
+		res, err := saveAllArticles(ctx, applyFilters(b, applyQualityGate(articles, conf.Quality)))
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: saved %d new articles (%d already stored)", b.name(), res.Inserted, res.Skipped)
+		return nil
+	}
+	resumed, err := seedFrontier(ctx, b, restart)
+	if err != nil {
+		return err
+	}
+	if resumed > 0 {
+		log.Printf("%s: resuming the backfill (%d pages visited)", b.name(), resumed)
+	}
+
+	client, err := newSourceClient(ctx, b)
+	if err != nil {
+		return err
+	}
+	pages, saved, skipped := 0, 0, 0
+	// ページ番号を無視するブログは同じ記事を返し続けるので、前のページと同じなら終える
+	var prevPage string
+	for {
+		if maxPages > 0 && pages >= maxPages {
+			log.Printf("%s: stopped after %d pages, run backfill again to continue", b.name(), pages)
+			break
+		}
+		var pageURL string
+		err := db.QueryRowContext(ctx, "SELECT url FROM crawl_frontier WHERE source = ? AND state = ? ORDER BY rowid LIMIT 1",
+			b.name(), frontierPending).Scan(&pageURL)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("%s: backfill complete", b.name())
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// 失敗したページはpendingのまま残し、次のbackfillでやり直す
+		articles, next, err := crawlPage(ctx, b, client, pageURL)
+		var serr *fetcher.StatusError
+		if b.cfg.Pagination.Param != "" && errors.As(err, &serr) && serr.Code == http.StatusNotFound {
+			// 最後のページを過ぎた
+			articles, next, err = nil, nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		if b.cfg.Pagination.Param != "" {
+			key := articleURLs(articles)
+			if key == prevPage {
+				articles, next = nil, nil
+			}
+			prevPage = key
+		}
+		tagSource(articles, b)
+		// ページ番号は辿った順 (中断したbackfillは辿り済みのページから数える)
+		tagProvenance(articles, provenance{Via: viaBackfill, ListURL: pageURL, Page: resumed + pages + 1, Selectors: selectorVersion(b)})
+		if articles, err = canonicalize(ctx, b, articles, true); err != nil {
+			return err
+		}
+		articles = applyFilters(b, applyQualityGate(articles, conf.Quality))
+		res, err := saveAllArticles(ctx, articles)
+		if err != nil {
+			return err
+		}
+		if err := advanceFrontier(ctx, b, pageURL, next); err != nil {
+			return err
+		}
+		pages++
+		saved += res.Inserted
+		skipped += res.Skipped
+		log.Printf("%s: page %s (%d articles, %d new)", b.name(), pageURL, len(articles), res.Inserted)
+	}
+	if err := saveSourceCookies(ctx, b, client); err != nil {
+		return err
+	}
+	log.Printf("%s: backfilled %d pages, %d new articles (%d already stored)", b.name(), pages, saved, skipped)
+	return nil
+}
+
+// 辿るページがなければ一覧のURLから始める
+// 前回の途中なら訪れたページの数を返す
+func seedFrontier(ctx context.Context, b blog, restart bool) (int, error) {
+	var pending, done int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(SUM(state = ?), 0), COALESCE(SUM(state = ?), 0)
+		FROM crawl_frontier WHERE source = ?`, frontierPending, frontierDone, b.name()).Scan(&pending, &done)
+	if err != nil {
+		return 0, err
+	}
+	if pending > 0 && !restart {
+		return done, nil
+	}
+	// 前回は最後まで辿ったか、やり直す
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM crawl_frontier WHERE source = ?", b.name()); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO crawl_frontier (source, url, state, added_at) VALUES (?, ?, ?, ?)",
+		b.name(), b.url, frontierPending, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return 0, err
+	}
+	return 0, tx.Commit()
+}
+
+// ページを辿り終えたことと次のページを同時に記録する
+// 訪れたページは行が残るので、戻るリンクがあっても二度は辿らない
+func advanceFrontier(ctx context.Context, b blog, pageURL string, next []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, u := range next {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO crawl_frontier (source, url, state, added_at) VALUES (?, ?, ?, ?)",
+			b.name(), u, frontierPending, now); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE crawl_frontier SET state = ?, done_at = ? WHERE source = ? AND url = ?",
+		frontierDone, now, b.name(), pageURL); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 一覧の1ページを取得して記事と次のページを取り出す
+func crawlPage(ctx context.Context, b blog, client *http.Client, pageURL string) ([]article, []string, error) {
+	resp, body, err := getListPage(ctx, client, pageURL, listValidators{})
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if isLoginPage(b, resp, doc) {
+		return nil, nil, fmt.Errorf("%s: %w (run \"login %s\")", b.name(), errLoginRequired, b.name())
+	}
+	sel := b.cfg.Selectors.fetcher()
+	// 既定の一覧は記事のURLを一覧のURLにつなげるので、どのページでも一覧のURLを基準にする
+	base := pageURL
+	if sel == (fetcher.Selectors{Next: sel.Next}) {
+		base = b.url
+	}
+	items, skipped := fetcher.ParseList(doc, base, sel)
+	reportSkipped(ctx, b, pageURL, skipped, true)
+	if p := b.cfg.Pagination.Param; p != "" {
+		// 記事のないページで終わる
+		var next []string
+		if len(items) > 0 {
+			u, err := nextPageNumber(pageURL, p)
+			if err != nil {
+				return nil, nil, err
+			}
+			next = []string{u}
+		}
+		return itemArticles(items, b.location()), next, nil
+	}
+	return itemArticles(items, b.location()), fetcher.NextPages(doc, pageURL, sel), nil
+}
+
+// ページ番号のパラメータを1つ進めたURL (なければ1ページ目とみなして2にする)
+func nextPageNumber(pageURL, param string) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	n := 1
+	if v := q.Get(param); v != "" {
+		if n, err = strconv.Atoi(v); err != nil {
+			return "", fmt.Errorf("%s: page number %q is not a number", pageURL, v)
+		}
+	}
+	q.Set(param, strconv.Itoa(n+1))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// ページの記事のURLをつなげたもの (前のページと比べる)
+func articleURLs(articles []article) string {
+	urls := make([]string, len(articles))
+	for i, a := range articles {
+		urls[i] = a.url
+	}
+	return strings.Join(urls, "\n")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// アーカイブの保存先
+type blobStore interface {
+	put(ctx context.Context, key, contentType string, data []byte) error
+	get(ctx context.Context, key string) ([]byte, error)
+	delete(ctx context.Context, key string) error
+}
+
+// 設定から保存先を作成
+func newBlobStore(c archiveConfig) (blobStore, error) {
+	switch c.Backend {
+	case "", "local":
+		dir := c.Dir
+		if dir == "" {
+			dir = "archive"
+		}
+		return &localBlobStore{dir: filepath.Join(dir, filepath.FromSlash(c.Prefix))}, nil
+	case "s3", "gcs":
+		s := &s3BlobStore{
+			endpoint:  strings.TrimRight(c.Endpoint, "/"),
+			region:    c.Region,
+			bucket:    c.Bucket,
+			prefix:    strings.Trim(c.Prefix, "/"),
+			accessKey: c.AccessKeyID,
+			secretKey: c.SecretAccessKey,
+		}
+		if s.accessKey == "" {
+			s.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if s.secretKey == "" {
+			s.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		// GCSはHMACキーによるS3互換APIを使う
+		if c.Backend == "gcs" {
+			if s.endpoint == "" {
+				s.endpoint = "https://storage.googleapis.com"
+			}
+			if s.region == "" {
+				s.region = "auto"
+			}
+		}
+		if s.region == "" {
+			s.region = "us-east-1"
+		}
+		if s.endpoint == "" {
+			s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+		}
+		if s.bucket == "" {
+			return nil, errors.New("archive: bucket is required")
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("archive: unknown backend %q", c.Backend)
+	}
+}
+
+// ローカルディスク
+type localBlobStore struct {
+	dir string
+}
+
+func (l *localBlobStore) path(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(key))
+}
+
+func (l *localBlobStore) put(_ context.Context, key, _ string, data []byte) error {
+	p := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+func (l *localBlobStore) get(_ context.Context, key string) ([]byte, error) {
+	return os.ReadFile(l.path(key))
+}
+
+func (l *localBlobStore) delete(_ context.Context, key string) error {
+	err := os.Remove(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// S3互換のオブジェクトストレージ (AWS S3, GCS)
+// 署名はAWS Signature Version 4
+type s3BlobStore struct {
+	endpoint  string
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+}
+
+func (s *s3BlobStore) put(ctx context.Context, key, contentType string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, contentType, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3BlobStore) get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *s3BlobStore) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// 署名付きリクエストを送信
+// 2xx以外はエラーとして返す
+func (s *s3BlobStore) do(ctx context.Context, method, key, contentType string, body []byte) (*http.Response, error) {
+	objectKey := key
+	if s.prefix != "" {
+		objectKey = s.prefix + "/" + key
+	}
+	// パス形式 (endpoint/bucket/key) でアクセスする
+	path := "/" + s.bucket + "/" + objectKey
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+uriEncode(path, false), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, path, body, time.Now().UTC())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s: status code %d: %s", method, objectKey, resp.StatusCode, msg)
+	}
+	return resp, nil
+}
+
+func (s *s3BlobStore) sign(req *http.Request, path string, body []byte, now time.Time) {
+	const algorithm = "AWS4-HMAC-SHA256"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// 署名対象のヘッダ (名前順)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		signed = []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+		values["content-type"] = ct
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range signed {
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(values[h]) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(path, false),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// RFC 3986の非予約文字以外をパーセントエンコードする
+// encodeSlashがfalseなら"/"はそのまま残す
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"fetch-blog/fetcher"
+)
+
+// 記事を取得するブログ
+type blog struct {
+	url string
+	cfg sourceConfig
+}
+
+// 記事一覧の取得方法
+//
+//	html: 一覧のページをセレクタで解析する (既定)
+//	feed: RSSかAtomのフィード (feed_url、なければurl) を読む
+//	auto: urlがフィードを返せばフィードとして読み、HTMLの一覧に記事がなければページが案内するフィードを読む
+const (
+	sourceModeHTML = "html"
+	sourceModeFeed = "feed"
+	sourceModeAuto = "auto"
+)
+
+// 一覧のCSSセレクタ (空の項目は既定の.article-list li、a、.dateを使う)
+type selectorsConfig struct {
+	List  string `yaml:"list"`
+	Item  string `yaml:"item"`
+	Link  string `yaml:"link"`
+	Title string `yaml:"title"`
+	// タイトルを読む属性 (titleの要素、なければリンクの属性、空なら文字列)
+	TitleAttr string `yaml:"title_attr"`
+	Date      string `yaml:"date"`
+	// 日付を読む属性 (例: datetime、空なら文字列)
+	DateAttr string `yaml:"date_attr"`
+	// 例: "2006.01.02", "Jan 2, 2006"
+	DateFormat string `yaml:"date_format"`
+	// backfillで辿る次のページへのリンク (既定はrel="next")
+	Next string `yaml:"next"`
+}
+
+func (s selectorsConfig) fetcher() fetcher.Selectors {
+	return fetcher.Selectors{List: s.List, Item: s.Item, Link: s.Link, Title: s.Title, TitleAttr: s.TitleAttr,
+		Date: s.Date, DateAttr: s.DateAttr, DateFormat: s.DateFormat, Next: s.Next}
+}
+
+// conf.Blogsを守る (serveではPOST /sourcesで実行中に増える)
+var blogsMu sync.RWMutex
+
+// 取得するブログの一覧
+// blogsがなければsource (URLは既定でurl.txt) の1つだけ
+func blogs() []blog {
+	blogsMu.RLock()
+	defer blogsMu.RUnlock()
+	if len(conf.Blogs) == 0 {
+		u := conf.Source.URL
+		if u == "" {
+			u = baseURL
+		}
+		return []blog{{url: u, cfg: conf.Source}}
+	}
+	res := make([]blog, 0, len(conf.Blogs))
+	for _, c := range conf.Blogs {
+		res = append(res, blog{url: c.URL, cfg: c})
+	}
+	return res
+}
+
+// 名前でブログを探す (空なら最初のブログ)
+func findBlog(name string) (blog, error) {
+	all := blogs()
+	if name == "" {
+		return all[0], nil
+	}
+	for _, b := range all {
+		if b.name() == name {
+			return b, nil
+		}
+	}
+	return blog{}, fmt.Errorf("source %q: %w", name, errNotFound)
+}
+
+// ブログの名前 (既定はURLのホスト名)
+// 保存する記事のsourceにも使う
+func (b blog) name() string {
+	if b.cfg.Name != "" {
+		return b.cfg.Name
+	}
+	u, err := url.Parse(b.url)
+	if err != nil {
+		return b.url
+	}
+	return u.Host
+}
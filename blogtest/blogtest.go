@@ -0,0 +1,204 @@
+// Package blogtest はnotifier, fetcher, storeのインターフェースのメモリ上の偽物を提供する
+//
+// 独自の通知先や取得方法を作るときに、DBやSlackなしでテストできるようにする
+// どれも複数のgoroutineから使える
+package blogtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"fetch-blog/fetcher"
+	"fetch-blog/notifier"
+	"fetch-blog/store"
+)
+
+var (
+	_ notifier.BlockNotifier = (*Notifier)(nil)
+	_ fetcher.Fetcher        = (*Fetcher)(nil)
+	_ store.ArticleStore     = (*Store)(nil)
+)
+
+// 送られたメッセージ
+type Message struct {
+	Text   string
+	Blocks []any
+	// 送ったときの冪等キー (なければ空)
+	IdempotencyKey string
+}
+
+// 送られたメッセージを記録する通知先
+type Notifier struct {
+	mu       sync.Mutex
+	messages []Message
+	// 設定すると送信のたびにこのエラーを返す (記録はしない)
+	Err error
+}
+
+func (n *Notifier) Notify(ctx context.Context, msg string) error {
+	return n.NotifyBlocks(ctx, msg, nil)
+}
+
+func (n *Notifier) NotifyBlocks(ctx context.Context, msg string, blocks []any) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.Err != nil {
+		return n.Err
+	}
+	n.messages = append(n.messages, Message{Text: msg, Blocks: blocks, IdempotencyKey: notifier.IdempotencyKey(ctx)})
+	return nil
+}
+
+// これまでに送られたメッセージ (送った順)
+func (n *Notifier) Messages() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Message(nil), n.messages...)
+}
+
+// 一覧のURLごとに決めた結果を返すFetcher
+type Fetcher struct {
+	mu    sync.Mutex
+	pages map[string]page
+	calls []string
+}
+
+type page struct {
+	items   []fetcher.Item
+	skipped []error
+	err     error
+}
+
+// listURLを取得したときの記事を決める
+func (f *Fetcher) SetItems(listURL string, items []fetcher.Item, skipped ...error) {
+	f.set(listURL, page{items: items, skipped: skipped})
+}
+
+// listURLを取得したときのエラーを決める
+func (f *Fetcher) SetError(listURL string, err error) {
+	f.set(listURL, page{err: err})
+}
+
+func (f *Fetcher) set(listURL string, p page) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pages == nil {
+		f.pages = map[string]page{}
+	}
+	f.pages[listURL] = p
+}
+
+// 決めていないURLは本物と同じく404のStatusErrorを返す
+// 記事が1件もなければfetcher.ErrNoItemsを返す
+func (f *Fetcher) Fetch(ctx context.Context, listURL string, sel fetcher.Selectors) ([]fetcher.Item, []error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, listURL)
+	p, ok := f.pages[listURL]
+	if !ok {
+		return nil, nil, fmt.Errorf("fetch %s: %w", listURL, &fetcher.StatusError{Code: 404})
+	}
+	if p.err != nil {
+		return nil, nil, p.err
+	}
+	if len(p.items) == 0 && len(p.skipped) == 0 {
+		return nil, nil, fmt.Errorf("%s: %w", listURL, fetcher.ErrNoItems)
+	}
+	return append([]fetcher.Item(nil), p.items...), append([]error(nil), p.skipped...), nil
+}
+
+// 取得した一覧のURL (取得した順)
+func (f *Fetcher) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// 記事をメモリに保存するStore
+// 本物のDBと同じく、同じURLとタイトルの記事は1度だけ保存する
+type Store struct {
+	mu       sync.Mutex
+	articles []StoredArticle
+	events   []Event
+}
+
+// 保存された記事
+type StoredArticle struct {
+	store.Article
+	Read bool
+}
+
+// 記事に起きた出来事
+type Event struct {
+	URL string
+	// store.EventAdded, store.EventRead
+	Type string
+}
+
+func (s *Store) SaveArticles(ctx context.Context, articles []store.Article) (store.SaveResult, error) {
+	var res store.SaveResult
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range articles {
+		if s.find(a.URL, a.Title) {
+			res.Skipped++
+			continue
+		}
+		if a.Status == "" {
+			a.Status = "ok"
+		}
+		s.articles = append(s.articles, StoredArticle{Article: a})
+		s.events = append(s.events, Event{URL: a.URL, Type: store.EventAdded})
+		res.Inserted++
+	}
+	return res, nil
+}
+
+func (s *Store) find(url, title string) bool {
+	for _, a := range s.articles {
+		if a.URL == url && a.Title == title {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) MarkRead(ctx context.Context, url string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	marked := false
+	for i := range s.articles {
+		if s.articles[i].URL == url && !s.articles[i].Read {
+			s.articles[i].Read = true
+			marked = true
+		}
+	}
+	if marked {
+		s.events = append(s.events, Event{URL: url, Type: store.EventRead})
+	}
+	return marked, nil
+}
+
+// 保存された記事 (保存した順)
+func (s *Store) Articles() []StoredArticle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StoredArticle(nil), s.articles...)
+}
+
+// 記録された出来事 (起きた順)
+func (s *Store) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"fetch-blog/fetcher"
+)
+
+// AMPやモバイル版の記事を正規のURLで保存する
+// 規則に一致する記事はページを取得し、rel=canonicalが示すURLに書き換えてから保存と通知を行う
+type canonicalConfig struct {
+	// 書き換える記事のURLの規則
+	// amp (/amp/、.amp.html、?amp=1、amp.のホスト)、mobile (m.とmobile.のホスト)、/.../で囲んだ正規表現
+	Match []string `yaml:"match"`
+}
+
+// 記事のURLに対する1つの規則
+type canonicalRule func(u *url.URL) bool
+
+var canonicalPresets = map[string]canonicalRule{
+	"amp": func(u *url.URL) bool {
+		if strings.HasPrefix(u.Hostname(), "amp.") || u.Query().Has("amp") {
+			return true
+		}
+		p := strings.TrimSuffix(u.Path, "/")
+		return strings.HasSuffix(p, "/amp") || strings.Contains(p, "/amp/") ||
+			strings.HasSuffix(p, ".amp") || strings.HasSuffix(p, ".amp.html")
+	},
+	"mobile": func(u *url.URL) bool {
+		return strings.HasPrefix(u.Hostname(), "m.") || strings.HasPrefix(u.Hostname(), "mobile.")
+	},
+}
+
+func parseCanonicalRule(s string) (canonicalRule, error) {
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		return func(u *url.URL) bool { return re.MatchString(u.String()) }, nil
+	}
+	if r, ok := canonicalPresets[s]; ok {
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown rule %q (use amp, mobile or /regexp/)", s)
+}
+
+func (c canonicalConfig) validate(key string) error {
+	for i, s := range c.Match {
+		if _, err := parseCanonicalRule(s); err != nil {
+			return fmt.Errorf("%s.match[%d]: %w", key, i, err)
+		}
+	}
+	return nil
+}
+
+// 規則はloadConfigで確かめてある
+func (c canonicalConfig) matches(articleURL string) bool {
+	u, err := url.Parse(articleURL)
+	if err != nil {
+		return false
+	}
+	for _, s := range c.Match {
+		if r, err := parseCanonicalRule(s); err == nil && r(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// 規則に一致する記事のURLを正規のURLにする
+// ページを取得できなかった記事は今回は保存せず、次の取得でやり直す
+// writeがfalseなら調べた結果をDBに残さない
+func canonicalize(ctx context.Context, b blog, articles []article, write bool) ([]article, error) {
+	if len(b.cfg.Canonical.Match) == 0 {
+		return articles, nil
+	}
+	var client *http.Client
+	seen := map[string]bool{}
+	res := articles[:0]
+	for _, a := range articles {
+		if b.cfg.Canonical.matches(a.url) {
+			canonical, err := lookupCanonical(ctx, a.url)
+			if err != nil {
+				return nil, err
+			}
+			if canonical == "" {
+				if client == nil {
+					if client, err = newSourceClient(ctx, b); err != nil {
+						return nil, err
+					}
+				}
+				if canonical, err = fetchCanonical(ctx, client, a.url); err != nil {
+					err = fmt.Errorf("canonical URL of %s: %w", a.url, err)
+					log.Print(err)
+					report.addError()
+					if write {
+						recordFetchError(ctx, b.name(), a.url, err)
+					}
+					continue
+				}
+				if write {
+					if err := saveCanonical(ctx, a.url, canonical); err != nil {
+						return nil, err
+					}
+				}
+				if canonical != a.url {
+					log.Printf("%s: using the canonical URL %s for %s", b.name(), canonical, a.url)
+				}
+			}
+			if canonical != a.url {
+				a.provenance.OriginalURL = a.url
+			}
+			a.url = canonical
+		}
+		// AMP版と通常版の両方が一覧にあれば1件にする
+		if seen[a.url] {
+			continue
+		}
+		seen[a.url] = true
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+// 調べ済みの正規のURL (まだなら空)
+func lookupCanonical(ctx context.Context, articleURL string) (string, error) {
+	var canonical string
+	err := db.QueryRowContext(ctx, "SELECT canonical FROM url_aliases WHERE url = ?", articleURL).Scan(&canonical)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return canonical, err
+}
+
+func saveCanonical(ctx context.Context, articleURL, canonical string) error {
+	_, err := db.ExecContext(ctx, `
+INSERT INTO url_aliases (url, canonical, checked_at) VALUES (?, ?, ?)
+ON CONFLICT (url) DO UPDATE SET canonical = excluded.canonical, checked_at = excluded.checked_at`,
+		articleURL, canonical, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// 記事ページのrel=canonicalを読む
+// rel=canonicalがなければ記事のURLをそのまま使う
+func fetchCanonical(ctx context.Context, client *http.Client, articleURL string) (string, error) {
+	page, err := fetchArticlePage(ctx, client, articleURL)
+	if err != nil {
+		return "", err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.body))
+	if err != nil {
+		return "", err
+	}
+	if canonical := fetcher.Canonical(doc, articleURL); canonical != "" {
+		return canonical, nil
+	}
+	return articleURL, nil
+}
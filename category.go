@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 分類に入らなかった記事の見出し
+const uncategorized = "その他"
+
+// LLMに渡す本文の長さ
+const classifyTextLimit = 2000
+
+// 記事の分類
+type categoryConfig struct {
+	Name string `yaml:"name"`
+	// タイトルか本文に含まれていればこの分類にする (大文字小文字は区別しない)
+	Keywords []string `yaml:"keywords"`
+	// LLMに渡すこの分類の記事の例 (タイトルなど)
+	Examples []string `yaml:"examples"`
+}
+
+// 記事ページから分類を決めて保存する
+// キーワードで決まらなければ、llmが設定されていればLLMに尋ねる
+func classifyStep(categories []categoryConfig, llm *llmClient) pageStep {
+	return pageStep{
+		name:    "classify",
+		pending: "classified_at IS NULL",
+		handle: func(ctx context.Context, p *articlePage) error {
+			var title string
+			if err := db.QueryRowContext(ctx, "SELECT title FROM articles WHERE url = ?", p.url).Scan(&title); err != nil {
+				return err
+			}
+			var text string
+			if strings.Contains(p.contentType, "html") || p.contentType == "" {
+				doc, err := goquery.NewDocumentFromReader(bytes.NewReader(p.body))
+				if err != nil {
+					return err
+				}
+				// 画像の代替テキストと図のキャプションも分類に使う
+				text = strings.Join(strings.Fields(extractText(doc.Find("body"))), " ")
+			}
+			names := matchKeywords(categories, title+"\n"+text)
+			if len(names) == 0 && llm != nil {
+				var err error
+				if names, err = classifyWithLLM(ctx, llm, categories, title, text); err != nil {
+					return err
+				}
+			}
+			return saveCategories(ctx, p.url, names)
+		},
+	}
+}
+
+// キーワードを含む分類
+func matchKeywords(categories []categoryConfig, text string) []string {
+	text = strings.ToLower(text)
+	var names []string
+	for _, c := range categories {
+		for _, k := range c.Keywords {
+			if k != "" && strings.Contains(text, strings.ToLower(k)) {
+				names = append(names, c.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// 分類の一覧と例を渡してLLMに選ばせる
+func classifyWithLLM(ctx context.Context, llm *llmClient, categories []categoryConfig, title, text string) ([]string, error) {
+	var b strings.Builder
+	b.WriteString("Categories:\n")
+	for _, c := range categories {
+		fmt.Fprintf(&b, "- %s", c.Name)
+		if len(c.Keywords) > 0 {
+			fmt.Fprintf(&b, " (keywords: %s)", strings.Join(c.Keywords, ", "))
+		}
+		b.WriteString("\n")
+		for _, e := range c.Examples {
+			fmt.Fprintf(&b, "  example: %s\n", e)
+		}
+	}
+	if r := []rune(text); len(r) > classifyTextLimit {
+		text = string(r[:classifyTextLimit])
+	}
+	fmt.Fprintf(&b, "\nTitle: %s\n\n%s", title, text)
+	answer, _, err := llm.complete(ctx, "classify",
+		"Classify the blog article into the given categories. Reply only with the matching category names separated by commas, or \"none\".",
+		b.String())
+	if err != nil {
+		return nil, err
+	}
+	// 設定にある名前だけを受け付ける
+	var names []string
+	for _, s := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == '\n' }) {
+		s = strings.Trim(strings.TrimSpace(s), `"-* `)
+		for _, c := range categories {
+			if strings.EqualFold(s, c.Name) {
+				names = append(names, c.Name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+func saveCategories(ctx context.Context, url string, names []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM article_categories WHERE url = ?", url); err != nil {
+		return err
+	}
+	for _, n := range names {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO article_categories (url, category) VALUES (?, ?)", url, n); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE articles SET classified_at = ? WHERE url = ?", time.Now().UTC().Format(time.RFC3339), url); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 記事がいずれかの分類に入っているか
+func (a article) inCategory(names ...string) bool {
+	for _, c := range a.categories {
+		for _, n := range names {
+			if c == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ダイジェストの見出しにする分類 (設定の順で最初のもの)
+func articleCategory(a article) string {
+	for _, c := range conf.Categories {
+		if a.inCategory(c.Name) {
+			return c.Name
+		}
+	}
+	return uncategorized
+}
+
+// 設定の順に並べるための位置 (分類なしは最後)
+func categoryIndex(name string) int {
+	for i, c := range conf.Categories {
+		if c.Name == name {
+			return i
+		}
+	}
+	return len(conf.Categories)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// list: 記事の一覧を表示
+func cmdList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	read := fs.String("read", "", "filter by read state (true or false)")
+	source := fs.String("source", "", "filter by blog host")
+	status := fs.String("status", "", "filter by status (ok, review or skipped)")
+	category := fs.String("category", "", "filter by category")
+	since := fs.String("since", "", "only articles published on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only articles published on or before this date (YYYY-MM-DD)")
+	asOf := fs.String("as-of", "", "show the unread queue as it was at the end of this date (YYYY-MM-DD)")
+	newest := fs.Bool("newest", false, "list newest articles first")
+	limit := fs.Int("limit", 0, "maximum number of articles (0 for no limit)")
+	ids := fs.Bool("ids", false, "show article ids (for share)")
+	language := fs.String("language", "", "filter by detected language (e.g. en, ja)")
+	minEase := fs.Float64("min-ease", 0, "only English articles with a Flesch Reading Ease of at least this (0-100, higher is easier)")
+	easiest := fs.Bool("easiest", false, "list the easiest articles first and show their reading ease")
+	parseFlags(fs, args)
+
+	f := articleFilter{
+		source:       *source,
+		status:       *status,
+		category:     *category,
+		since:        *since,
+		until:        *until,
+		asOf:         *asOf,
+		newestFirst:  *newest,
+		language:     *language,
+		minEase:      *minEase,
+		easiestFirst: *easiest,
+		limit:        *limit,
+	}
+	for _, d := range []string{*since, *until, *asOf} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return fmt.Errorf("invalid date %q: want YYYY-MM-DD", d)
+		}
+	}
+	switch *read {
+	case "":
+	case "true":
+		f.read = boolPtr(true)
+	case "false":
+		f.read = boolPtr(false)
+	default:
+		return fmt.Errorf("invalid -read value %q", *read)
+	}
+	if f.asOf != "" && f.read != nil {
+		return fmt.Errorf("-as-of cannot be combined with -read")
+	}
+
+	articles, err := queryArticles(ctx, f)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, a := range articles {
+		mark := " "
+		if a.read && f.asOf == "" {
+			mark = "✓"
+		}
+		if *ids {
+			fmt.Fprintf(w, "%d\t", a.id)
+		}
+		if *easiest || *minEase > 0 {
+			ease := "-"
+			if a.readingEase >= 0 {
+				ease = fmt.Sprintf("%.0f", a.readingEase)
+			}
+			fmt.Fprintf(w, "%s\t", ease)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.date, mark, a.title, a.url)
+	}
+	return w.Flush()
+}
+
+// mark-read: 記事を既読にする (URLかlist -idsのid)
+func cmdMarkRead(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("mark-read", flag.ExitOnError)
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		return usageErr("usage: mark-read <url|id>...")
+	}
+	for _, ref := range fs.Args() {
+		a, err := lookupArticle(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if err := acknowledge(ctx, a.url); err != nil {
+			return err
+		}
+		fmt.Printf("marked as read: %s\n", a.url)
+	}
+	return nil
+}
+
+// notify: 未読の記事を通知する (取得はしない)
+func cmdNotify(ctx context.Context, dests []destination, args []string) error {
+	fs := flag.NewFlagSet("notify", flag.ExitOnError)
+	limit := fs.Int("limit", 0, "maximum number of articles to notify (defaults to notify.limit, or digest.limit with -digest)")
+	digestMode := fs.Bool("digest", conf.Digest.Enabled, "send one message with all unread articles instead of one per article")
+	parseFlags(fs, args)
+	if *limit < 0 {
+		return fmt.Errorf("invalid -limit %d", *limit)
+	}
+	if *limit > 0 {
+		conf.Notify.Limit = *limit
+		conf.Digest.Limit = *limit
+	}
+	conf.Digest.Enabled = *digestMode
+	return notifyPhase(ctx, dests)
+}
+
+// fetch: 記事を取得して保存する (通知はしない)
+// -diffなら保存せず、DBとの違いだけを表示する
+func cmdFetch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	source := fs.String("source", "", "blog to fetch (defaults to all blogs, or the first blog with -diff)")
+	diff := fs.Bool("diff", false, "show new, changed and stored articles without writing anything")
+	backfillAll := fs.Bool("backfill", false, "follow the next pages of the list, resuming an interrupted backfill")
+	parseFlags(fs, args)
+	if *backfillAll && *diff {
+		return usageErr("-backfill and -diff cannot be used together")
+	}
+
+	b, err := findBlog(*source)
+	if err != nil {
+		return err
+	}
+	if *backfillAll {
+		targets := blogs()
+		if *source != "" {
+			targets = []blog{b}
+		}
+		for _, b := range targets {
+			if err := backfill(ctx, b, 0, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !*diff {
+		targets := blogs()
+		if *source != "" {
+			targets = []blog{b}
+		}
+		return fetchPhase(ctx, targets)
+	}
+	articles, err := fetchListing(ctx, b, false)
+	if err != nil {
+		return err
+	}
+	if articles, err = canonicalize(ctx, b, articles, false); err != nil {
+		return err
+	}
+	parsed := len(articles)
+	articles = applyFilters(b, applyQualityGate(articles, conf.Quality))
+	return printFetchDiff(ctx, articles, parsed-len(articles))
+}
+
+// 解析した記事をDBと比べて表示する
+func printFetchDiff(ctx context.Context, articles []article, rejected int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATE\tDATE\tTITLE\tURL")
+	counts := map[string]int{}
+	for _, a := range articles {
+		var title, date, status string
+		err := db.QueryRowContext(ctx, "SELECT title, date, status FROM articles WHERE url = ?", a.url).Scan(&title, &date, &status)
+		state := "stored"
+		detail := a.title
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			state = "new"
+			switch a.status {
+			case statusReview:
+				state = "new (review: " + a.reviewReason + ")"
+			case statusSkipped:
+				state = "new (skipped: " + a.reviewReason + ")"
+			}
+		case err != nil:
+			return err
+		case title != a.title || dateOnly(date) != a.date:
+			state = "changed"
+			if title != a.title {
+				detail = fmt.Sprintf("%s -> %s", title, a.title)
+			}
+			if dateOnly(date) != a.date {
+				detail += fmt.Sprintf(" (date %s -> %s)", dateOnly(date), a.date)
+			}
+		}
+		counts[strings.SplitN(state, " ", 2)[0]]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", state, a.date, detail, a.url)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d new, %d changed, %d stored", counts["new"], counts["changed"], counts["stored"])
+	if rejected > 0 {
+		fmt.Printf(", %d rejected by the quality gate or filters", rejected)
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// サブコマンドの一覧
+// 使い方の表示とシェルの補完に使うので、コマンドやフラグを増やしたらここにも足す
+type cliCommand struct {
+	name string
+	help string
+	// 第1引数のサブコマンド (stats sourceなど)
+	subcommands []string
+	flags       []cliFlag
+	// 位置引数の補完 (補完の種類、completionValuesを参照)
+	args string
+	// サブコマンドの後の位置引数の補完
+	subArgs map[string]string
+}
+
+type cliFlag struct {
+	name string
+	// 値の候補 (空白区切り) か、@で始まる補完の種類 (空なら値を取らないか自由入力)
+	values string
+}
+
+var cliCommands = []cliCommand{
+	{name: "fetch", help: "fetch and store articles without notifying", flags: []cliFlag{{"-source", "@sources"}, {"-diff", ""}, {"-backfill", ""}}},
+	{name: "notify", help: "notify unread articles without fetching", flags: []cliFlag{{"-limit", ""}, {"-digest", ""}}},
+	{name: "list", help: "list stored articles", flags: []cliFlag{
+		{"-read", "true false"}, {"-source", "@sources"}, {"-status", "ok review skipped"}, {"-category", "@categories"},
+		{"-since", ""}, {"-until", ""}, {"-as-of", ""}, {"-newest", ""}, {"-limit", ""}, {"-ids", ""},
+		{"-language", ""}, {"-min-ease", ""}, {"-easiest", ""},
+	}},
+	{name: "search", help: "search titles and bodies of stored articles", flags: []cliFlag{
+		{"-read", "true false"}, {"-source", "@sources"}, {"-limit", ""}, {"-ids", ""},
+	}},
+	{name: "diff", help: "show how an article's text changed since the previous version", flags: []cliFlag{{"-full", ""}}},
+	{name: "mark-read", help: "mark articles as read"},
+	{name: "stats", help: "show statistics", subcommands: []string{"source", "fetch", "llm", "errors", "compare"},
+		flags: []cliFlag{{"-from", ""}, {"-to", ""}, {"-baseline", ""}}, subArgs: map[string]string{"source": "@sources"}},
+	{name: "review", help: "list or resolve articles held for review", subcommands: []string{"approve", "reject"}},
+	{name: "due", help: "set or list reading deadlines", flags: []cliFlag{{"-note", ""}, {"-clear", ""}}},
+	{name: "share", help: "send one article to a person or destination", flags: []cliFlag{{"-to", "@recipients"}, {"-note", ""}}},
+	{name: "add-url", help: "add an article by URL", flags: []cliFlag{{"-notify", ""}, {"-title", ""}}},
+	{name: "backfill", help: "fetch older pages of the article list", flags: []cliFlag{{"-max-pages", ""}, {"-restart", ""}}, args: "@sources"},
+	{name: "login", help: "log in to a blog and save its cookies", flags: []cliFlag{{"-cookie", ""}, {"-form", ""}}, args: "@sources"},
+	{name: "users", help: "manage web UI users", subcommands: []string{"add", "invite", "list", "delete"}},
+	{name: "cache", help: "manage the article page cache", subcommands: []string{"stats", "clear"}, flags: []cliFlag{{"-older-than", ""}}},
+	{name: "responses", help: "list or show stored list and feed responses", subcommands: []string{"show"}, flags: []cliFlag{{"-source", "@sources"}}},
+	{name: "provenance", help: "show how stored articles were found", subcommands: []string{"show"}, flags: []cliFlag{
+		{"-source", "@sources"}, {"-run", ""}, {"-via", "list feed backfill manual"}, {"-selectors", ""}, {"-limit", ""},
+	}},
+	{name: "deliveries", help: "show notification deliveries", subcommands: []string{"resolve", "queue"}, flags: []cliFlag{{"-status", "ok failed skipped unknown"}, {"-limit", ""}}},
+	{name: "translations", help: "manage translated titles", subcommands: []string{"purge"}, flags: []cliFlag{{"-language", ""}, {"-older-than", ""}}},
+	{name: "export", help: "export articles to another tool", flags: []cliFlag{
+		{"-format", "sqlite json csv markdown"}, {"-force", ""}, {"-read", "true false"}, {"-source", "@sources"}, {"-since", ""}, {"-until", ""},
+	}},
+	{name: "unread-feed", help: "write an Atom feed of unread articles", flags: []cliFlag{{"-limit", ""}}},
+	{name: "merge", help: "merge another database into this one", flags: []cliFlag{{"-dry-run", ""}}},
+	{name: "import-opml", help: "add blogs from an OPML file to the config", flags: []cliFlag{{"-dry-run", ""}}},
+	{name: "sources", help: "move stored articles and config to a blog's new URL", subcommands: []string{"migrate"}, flags: []cliFlag{{"-dry-run", ""}}},
+	{name: "sync", help: "sync read state with another instance", flags: []cliFlag{{"-interval", ""}}},
+	{name: "warehouse", help: "append articles and events to BigQuery or ClickHouse", flags: []cliFlag{{"-interval", ""}, {"-schema", ""}}},
+	{name: "migrate", help: "apply or list database schema migrations", subcommands: []string{"status"}},
+	{name: "events", help: "print article events as JSON lines", flags: []cliFlag{{"-after", ""}, {"-limit", ""}}},
+	{name: "schema", help: "print the JSON Schema of event payloads", subcommands: []string{"print"}},
+	{name: "sandbox", help: "try a config fragment with an in-memory DB, printing notifications"},
+	{name: "serve", help: "run the HTTP server", flags: []cliFlag{{"-ui", ""}}},
+	{name: "discord", help: "run the Discord bot"},
+	{name: "install-service", help: "run periodically as a system service", flags: []cliFlag{
+		{"-name", ""}, {"-interval", ""}, {"-serve", ""}, {"-os", "linux darwin windows"}, {"-print", ""}, {"-uninstall", ""},
+	}},
+	{name: "self-update", help: "replace this binary with the latest release", flags: []cliFlag{{"-check", ""}, {"-version", ""}, {"-insecure", ""}}},
+	{name: "version", help: "print the version"},
+	{name: "config", help: "print an example config or its JSON Schema", subcommands: []string{"example", "schema"}},
+	{name: "completion", help: "print a shell completion script (bash, zsh or fish)", subcommands: []string{"bash", "zsh", "fish"}},
+}
+
+// コマンドの前に置くフラグ
+var globalFlags = []string{"-config", "-force", "-digest"}
+
+// 使い方に載せるコマンドの一覧
+func printCommands(w io.Writer) {
+	fmt.Fprint(w, "\nWithout a command, fetches and notifies according to the schedule.\n\nCommands:\n")
+	for _, c := range cliCommands {
+		fmt.Fprintf(w, "  %-16s %s\n", c.name, c.help)
+	}
+}
+
+// 補完の候補 (__complete <種類>)
+// 設定ファイルから読むのでDBは開かない
+func completionValues(kind string) []string {
+	var res []string
+	switch kind {
+	case "sources":
+		for _, b := range blogs() {
+			res = append(res, b.name())
+		}
+	case "categories":
+		for _, c := range conf.Categories {
+			res = append(res, c.Name)
+		}
+	case "recipients":
+		for _, s := range conf.Subscriptions {
+			res = append(res, "@"+s.User)
+		}
+		for _, d := range conf.Destinations {
+			if d.Name != "" {
+				res = append(res, d.Name)
+			}
+		}
+	}
+	return res
+}
+
+// completion: シェルの補完スクリプトを出力する
+// 例: source <(fetch-blog completion bash)
+func cmdCompletion(args []string) error {
+	if len(args) != 1 {
+		return usageErr("usage: completion bash|zsh|fish")
+	}
+	prog := filepath.Base(os.Args[0])
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion(prog))
+	case "zsh":
+		// zshはbashの補完関数をそのまま使う
+		fmt.Print("autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion(prog))
+	case "fish":
+		fmt.Print(fishCompletion(prog))
+	default:
+		return usageErr(fmt.Sprintf("unknown shell %q (bash, zsh or fish)", args[0]))
+	}
+	return nil
+}
+
+func bashCompletion(prog string) string {
+	fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(prog)
+	var b strings.Builder
+	fmt.Fprintf(&b, `# %[1]s completion for bash
+%[2]s_values() {
+	local v="$1"
+	if [[ "$v" == @* ]]; then
+		v="$(%[1]s "${cfg[@]}" __complete "${v#@}" 2>/dev/null)"
+	fi
+	COMPREPLY=($(compgen -W "$v" -- "$cur"))
+}
+
+%[2]s() {
+	local cur prev cmd sub i
+	local -a cfg=()
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case "${COMP_WORDS[i]}" in
+		-config | --config)
+			cfg=(-config "${COMP_WORDS[i+1]}")
+			((i++))
+			;;
+		-*) ;;
+		*)
+			if [[ -z "$cmd" ]]; then
+				cmd="${COMP_WORDS[i]}"
+			elif [[ -z "$sub" ]]; then
+				sub="${COMP_WORDS[i]}"
+			fi
+			;;
+		esac
+	done
+	if [[ -z "$cmd" ]]; then
+		case "$prev" in
+		-config | --config)
+			COMPREPLY=($(compgen -f -- "$cur"))
+			return
+			;;
+		esac
+		COMPREPLY=($(compgen -W "%[3]s %[4]s" -- "$cur"))
+		return
+	fi
+	case "$cmd" in
+`, prog, fn, strings.Join(commandNames(), " "), strings.Join(globalFlags, " "))
+	for _, c := range cliCommands {
+		fmt.Fprintf(&b, "\t%s)\n", c.name)
+		// 値を取るフラグの次
+		var valued []cliFlag
+		for _, f := range c.flags {
+			if f.values != "" {
+				valued = append(valued, f)
+			}
+		}
+		if len(valued) > 0 {
+			b.WriteString("\t\tcase \"$prev\" in\n")
+			for _, f := range valued {
+				fmt.Fprintf(&b, "\t\t%s | -%s)\n\t\t\t%s_values %q\n\t\t\treturn\n\t\t\t;;\n", f.name, f.name, fn, f.values)
+			}
+			b.WriteString("\t\tesac\n")
+		}
+		for sub, kind := range c.subArgs {
+			fmt.Fprintf(&b, "\t\tif [[ \"$sub\" == %s && \"$cur\" != -* ]]; then\n\t\t\t%s_values %q\n\t\t\treturn\n\t\tfi\n", sub, fn, kind)
+		}
+		var words []string
+		for _, f := range c.flags {
+			words = append(words, f.name)
+		}
+		if len(c.subcommands) > 0 {
+			fmt.Fprintf(&b, "\t\tif [[ -z \"$sub\" && \"$cur\" != -* ]]; then\n\t\t\t%s_values %q\n\t\t\treturn\n\t\tfi\n", fn, strings.Join(c.subcommands, " "))
+		}
+		if c.args != "" {
+			fmt.Fprintf(&b, "\t\tif [[ \"$cur\" != -* ]]; then\n\t\t\t%s_values %q\n\t\t\treturn\n\t\tfi\n", fn, c.args)
+		}
+		fmt.Fprintf(&b, "\t\t%s_values %q\n\t\t;;\n", fn, strings.Join(words, " "))
+	}
+	fmt.Fprintf(&b, "\tesac\n}\n\ncomplete -F %s %s\n", fn, prog)
+	return b.String()
+}
+
+func fishCompletion(prog string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s completion for fish\n", prog)
+	fmt.Fprintf(&b, "complete -c %s -f\n", prog)
+	fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -o config -r -F -d 'path to config file'\n", prog)
+	fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -o force -d 'run every task regardless of the schedule'\n", prog)
+	fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -o digest -d 'send one message per run'\n", prog)
+	for _, c := range cliCommands {
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", prog, c.name, fishQuote(c.help))
+		cond := "'__fish_seen_subcommand_from " + c.name + "'"
+		for _, f := range c.flags {
+			fmt.Fprintf(&b, "complete -c %s -n %s -o %s", prog, cond, strings.TrimPrefix(f.name, "-"))
+			if f.values != "" {
+				fmt.Fprintf(&b, " -x -a %s", fishValues(prog, f.values))
+			}
+			b.WriteString("\n")
+		}
+		if len(c.subcommands) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n", prog, cond, fishQuote(strings.Join(c.subcommands, " ")))
+		}
+		for sub, kind := range c.subArgs {
+			fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from %s; and __fish_seen_subcommand_from %s' -a %s\n", prog, c.name, sub, fishValues(prog, kind))
+		}
+		if c.args != "" {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n", prog, cond, fishValues(prog, c.args))
+		}
+	}
+	return b.String()
+}
+
+func fishValues(prog, values string) string {
+	if kind, ok := strings.CutPrefix(values, "@"); ok {
+		return fishQuote("(" + prog + " __complete " + kind + " 2>/dev/null)")
+	}
+	return fishQuote(values)
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(cliCommands))
+	for _, c := range cliCommands {
+		names = append(names, c.name)
+	}
+	return names
+}
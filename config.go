@@ -0,0 +1,611 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"fetch-blog/store"
+)
+
+// 設定ファイルの内容
+type config struct {
+	// 読み込んだファイル (POST /sourcesで書き換える)
+	path string
+
+	// SQLiteのDBファイル (sqlite:blog.db の形でもよい、PostgreSQLやMySQLには対応しない)
+	Database     string              `yaml:"database"`
+	SQLite       sqliteConfig        `yaml:"sqlite"`
+	Destinations []destinationConfig `yaml:"destinations"`
+	Notify       notifyConfig        `yaml:"notify"`
+	Digest       digestConfig        `yaml:"digest"`
+	Archive      archiveConfig       `yaml:"archive"`
+	Replication  replicationConfig   `yaml:"replication"`
+	HA           haConfig            `yaml:"ha"`
+	Server       serverConfig        `yaml:"server"`
+	Public       publicConfig        `yaml:"public"`
+	UnreadFeed   unreadFeedConfig    `yaml:"unread_feed"`
+	ActivityPub  activityPubConfig   `yaml:"activitypub"`
+	Schedule     scheduleConfig      `yaml:"schedule"`
+	Source       sourceConfig        `yaml:"source"`
+	Paywall      paywallConfig       `yaml:"paywall"`
+	Readability  readabilityConfig   `yaml:"readability"`
+	Content      contentConfig       `yaml:"content"`
+	Quality      qualityConfig       `yaml:"quality"`
+	Filters      filterConfig        `yaml:"filters"`
+	Slack        slackConfig         `yaml:"slack"`
+	Discord      discordConfig       `yaml:"discord"`
+	Fetch        fetchConfig         `yaml:"fetch"`
+	// 利用者ごとのダイジェスト購読
+	Subscriptions []subscriptionConfig `yaml:"subscriptions"`
+	// 新しい記事を自動で分類する
+	Categories []categoryConfig `yaml:"categories"`
+	LLM        llmConfig        `yaml:"llm"`
+	Related    relatedConfig    `yaml:"related"`
+	// 通知する記事のHacker News, Lobstersでの議論
+	Discussions discussionsConfig `yaml:"discussions"`
+	Translation translationConfig `yaml:"translation"`
+	// 別のインスタンスとの同期
+	Sync syncConfig `yaml:"sync"`
+	Due  dueConfig  `yaml:"due"`
+	// 分析用のデータウェアハウスへの書き出し
+	Warehouse warehouseConfig `yaml:"warehouse"`
+	// 通知しても既読にされない記事のリマインド
+	Reminders remindersConfig `yaml:"reminders"`
+	// Slackでの投票による輪読会の記事選び
+	ReadingClub readingClubConfig `yaml:"reading_club"`
+	// 複数のブログを取得する (指定するとsourceの代わりにこれらを使う、タイムゾーンはsource.timezone)
+	Blogs []sourceConfig `yaml:"blogs"`
+	// 外部への接続の時間制限
+	HTTP httpConfig `yaml:"http"`
+	// GitHubのリリースからの更新 (self-update)
+	SelfUpdate selfUpdateConfig `yaml:"self_update"`
+	// ブログのログイン用Cookieを暗号化してDBに保存する鍵のファイル (既定はcookies.key、なければ作成する)
+	CookieKey string `yaml:"cookie_key"`
+}
+
+// SQLiteの動作 (読み取り専用のルートファイルシステムや小さいコンテナ向け)
+// 一時ファイルはtemp_dirに作り、temp_store: memoryなら作らない
+// ジャーナルはDBと同じディレクトリに作られるので、そこが書けなければjournal_mode: memoryにする
+type sqliteConfig struct {
+	// delete, truncate, persist, memory, wal, off (replication.enabledならwal)
+	JournalMode string `yaml:"journal_mode"`
+	// default, file, memory
+	TempStore string `yaml:"temp_store"`
+	TempDir   string `yaml:"temp_dir"`
+	// 接続ごとのページキャッシュ (KiB)
+	CacheSizeKiB int `yaml:"cache_size_kib"`
+	// スキーマの変更を自動で適用せず、migrateコマンドで適用する
+	// 適用していない変更があればほかのコマンドは実行しない
+	ManualMigrations bool `yaml:"manual_migrations"`
+}
+
+// OpenAI互換のAPIの設定
+// modelを設定すると分類でキーワードに当たらなかった記事をLLMに尋ねる
+type llmConfig struct {
+	// 既定はhttps://api.openai.com/v1
+	Endpoint string `yaml:"endpoint"`
+	// 空なら環境変数OPENAI_API_KEY
+	APIKey string `yaml:"api_key"`
+	Model  string `yaml:"model"`
+	// 使えるトークン数 (プロンプトと応答の合計、0なら無制限)
+	// 超えると分類や翻訳をせずに続ける
+	MonthlyTokenBudget int `yaml:"monthly_token_budget"`
+	RunTokenBudget     int `yaml:"run_token_budget"`
+}
+
+// Slackアプリの設定
+// signing_secretを設定するとserveでEvents APIとInteractivityを受け付ける
+type slackConfig struct {
+	BotToken      string `yaml:"bot_token"`
+	SigningSecret string `yaml:"signing_secret"`
+	// チャンネルに固定して毎週更新する要約
+	Summary slackSummaryConfig `yaml:"summary"`
+}
+
+// 抽出に失敗したとみられる記事の扱い
+type qualityConfig struct {
+	// タイトルから取り除く語 (例: "【新着】")
+	NoiseWords []string `yaml:"noise_words"`
+	// これより短いタイトルは問題ありとする (既定は2文字)
+	MinTitleLength int `yaml:"min_title_length"`
+	// review: 確認待ちとして保存 (既定), reject: 保存しない
+	Action string `yaml:"action"`
+}
+
+// 有料記事の判定
+type paywallConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 既定の判定に加えるCSSセレクタと文言
+	Selectors []string `yaml:"selectors"`
+	Phrases   []string `yaml:"phrases"`
+	// 通知のタイトルに付ける目印 (既定は💰)
+	Emoji string `yaml:"emoji"`
+}
+
+// 取得するブログの設定
+type sourceConfig struct {
+	// 既定はURLのホスト名
+	Name string `yaml:"name"`
+	// 記事一覧のURL (sourceでは既定でurl.txt)
+	URL string `yaml:"url"`
+	// html, feed, auto (既定はhtml)
+	Mode      string          `yaml:"mode"`
+	Selectors selectorsConfig `yaml:"selectors"`
+	// 一覧の古いページの辿り方 (backfillと、first_runなら初回の取得で使う)
+	Pagination paginationConfig `yaml:"pagination"`
+	Auth       authConfig       `yaml:"auth"`
+	// 一覧の日付を解釈するタイムゾーン (例: Asia/Tokyo。blogsでは既定でsource.timezone、それもなければローカル)
+	Timezone string `yaml:"timezone"`
+	// 記事ページから時刻を含む公開日時を読み取る
+	PublishedTimeFromPage bool `yaml:"published_time_from_page"`
+	// ブロックされやすいブログ向け
+	// リクエストごとにこの中から選んだUser-Agentを使う
+	UserAgents []string `yaml:"user_agents"`
+	// リクエストの前に0からこの時間までランダムに待つ
+	Jitter time.Duration `yaml:"jitter"`
+	// 一覧の取得がfallback_after回 (既定は3回) 続けて失敗したらこのフィードで代わりに取得する
+	FeedURL       string `yaml:"feed_url"`
+	FallbackAfter int    `yaml:"fallback_after"`
+	// 初めて取得するときはこれより新しい記事だけを保存する (例: "30d")
+	FirstFetchMaxAge string `yaml:"first_fetch_max_age"`
+	// このブログだけの絞り込み (全体のfiltersとあわせて使う)
+	Filters filterConfig `yaml:"filters"`
+	// JavaScriptで描くブログを描画サービスを通して読む
+	Render renderConfig `yaml:"render"`
+	// 記事の代わりにCookieの同意の画面を返すブログ
+	Consent consentConfig `yaml:"consent"`
+	// AMPやモバイル版の記事を正規のURLで保存する
+	Canonical canonicalConfig `yaml:"canonical"`
+}
+
+// 一覧のページ送り
+type paginationConfig struct {
+	// ページ番号のクエリパラメータ (例: page、?page=2, 3, ...と辿る)
+	// 空ならselectors.nextのリンク (既定はrel="next") を辿る
+	Param string `yaml:"param"`
+	// 辿るページ数の上限 (0なら無制限、paramは記事のないページで止まる)
+	MaxPages int `yaml:"max_pages"`
+	// 初めて取得するときに全ページを辿ってアーカイブ全体を保存する (first_fetch_max_ageは使わない)
+	FirstRun bool `yaml:"first_run"`
+}
+
+// 会員限定のブログへのログイン設定
+// Cookieを貼り付けるか、ID・パスワードのフォームでログインする
+// OAuth (「Googleでログイン」など) のログインには対応しない。ブラウザでログインしてCookieを貼り付ける
+type authConfig struct {
+	// このCSSセレクタに一致する要素があればログインページとみなす
+	LoginMarker string `yaml:"login_marker"`
+	// ログインフォームの送信先。ここへリダイレクトされた場合もログインページとみなす
+	LoginURL string `yaml:"login_url"`
+	// ログインフォームの値。$VARは環境変数で置き換える
+	Form map[string]string `yaml:"form"`
+}
+
+// 読んだ記事をActivityPubで配信する設定
+type activityPubConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 外部から見えるURL (例: https://reading.example.com)
+	BaseURL     string `yaml:"base_url"`
+	Username    string `yaml:"username"`
+	DisplayName string `yaml:"display_name"`
+	Summary     string `yaml:"summary"`
+	// 署名用のRSA秘密鍵 (PEM)。なければ作成する
+	PrivateKey string `yaml:"private_key"`
+}
+
+// 読んだ記事の公開ページ
+type publicConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Title   string `yaml:"title"`
+	// 既読記事をすべて公開するブログのホスト名
+	Sources []string `yaml:"sources"`
+	// 記事ごとに公開を選んでいても載せないブログのホスト名
+	PrivateSources []string `yaml:"private_sources"`
+	// 表示する件数 (既定は50)
+	Limit int `yaml:"limit"`
+}
+
+// HTTPサーバーの設定
+type serverConfig struct {
+	// 既定は"127.0.0.1:8080" (このマシンからだけ使える)
+	// ":8080"などで外に公開するなら、authを有効にするかread_onlyにする
+	Addr string `yaml:"addr"`
+	// trueならGETのエンドポイントだけを公開する
+	ReadOnly bool `yaml:"read_only"`
+	// ログインしないとAPIを使えないようにする
+	Auth serverAuthConfig `yaml:"auth"`
+	// 記事を一覧して既読にする画面を/uiで提供する (serve -uiでも有効になる)
+	UI bool `yaml:"ui"`
+}
+
+// Web UIとAPIのログイン
+// 利用者はusersコマンドで追加する
+type serverAuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ログインの有効期間 (既定は30日)
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	// 招待リンクとOIDCのコールバックに使う公開URL (例: https://blog.example.com)
+	PublicURL string `yaml:"public_url"`
+	// trueならパスワードでのログインを受け付けない (OIDCのみ)
+	DisablePassword bool       `yaml:"disable_password"`
+	OIDC            oidcConfig `yaml:"oidc"`
+}
+
+// OIDCログインの設定
+// 利用者は招待済みの名前とメールアドレス (GitHubならログイン名) で対応付ける
+type oidcConfig struct {
+	// 例: https://accounts.google.com
+	Issuer string `yaml:"issuer"`
+	// githubならissuerは不要
+	Provider     string `yaml:"provider"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+// 複数台構成でのリーダー選出
+type haConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 空ならホスト名とPIDから作る
+	InstanceID string        `yaml:"instance_id"`
+	LeaseTTL   time.Duration `yaml:"lease_ttl"`
+}
+
+// litestreamによるDBの継続的なバックアップ
+type replicationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// litestreamの実行ファイル
+	Litestream string `yaml:"litestream"`
+	// 例: s3://bucket/blog.db
+	ReplicaURL string `yaml:"replica_url"`
+	// litestream.ymlを使う場合はそのパス
+	Config string `yaml:"config"`
+}
+
+// 記事ページのアーカイブ設定
+type archiveConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// local, s3, gcs
+	Backend string `yaml:"backend"`
+	// local: 保存先ディレクトリ
+	Dir string `yaml:"dir"`
+	// s3, gcs: 認証情報が空なら環境変数AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEYを使う
+	Bucket          string `yaml:"bucket"`
+	Prefix          string `yaml:"prefix"`
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+
+	// 保存容量の上限 (例: "2GB")。超えたら古いページから削除する
+	MaxSize string `yaml:"max_size"`
+	// これより古いページを削除する (例: "720h")
+	TTL time.Duration `yaml:"ttl"`
+	// hash: URLのハッシュ (既定), slug: 日付とタイトルから作る (例: 2026-10-01-go-1-22.html)
+	Naming string `yaml:"naming"`
+	// slugでかなをローマ字にする
+	Transliterate bool `yaml:"transliterate"`
+}
+
+// ダイジェスト通知の設定
+// 1回の実行で通知する未読の記事
+type notifyConfig struct {
+	// 最大件数 (既定は3)。未読がこれより少なければあるだけ通知する
+	Limit int `yaml:"limit"`
+	// すべての通知先を合わせて1分間に送るメッセージの上限 (既定は0で無制限)
+	// 超えた分は送れるようになるまで順に待つ
+	MaxPerMinute int `yaml:"max_per_minute"`
+}
+
+const defaultNotifyLimit = 3
+
+func (c notifyConfig) limit() int {
+	if c.Limit <= 0 {
+		return defaultNotifyLimit
+	}
+	return c.Limit
+}
+
+type digestConfig struct {
+	// trueなら記事ごとではなく1通にまとめて通知 (実行ごとに-digestで切り替えられる)
+	Enabled bool `yaml:"enabled"`
+	// oldest, newest, source, date, category, score
+	Order string `yaml:"order"`
+	// 1通にまとめる記事の上限 (既定は0で、未読の記事をすべてまとめる)
+	Limit int `yaml:"limit"`
+}
+
+// 記事ページの取得
+// ブログごとに同時実行数をAIMDで調整する
+type fetchConfig struct {
+	// 初回の同時実行数 (既定は2、以降は前回の値から始める)
+	InitialConcurrency int `yaml:"initial_concurrency"`
+	// 既定は8
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// これより遅い応答は混雑とみなして同時実行数を減らす (既定は5秒)
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+	// 一覧とフィードの応答を保存する (記事が読めないときに調べる)
+	DebugResponses responseDebugConfig `yaml:"debug_responses"`
+}
+
+// Discord Botの設定
+// discordサブコマンドでGatewayに接続し、!next, !statsとリアクションでの既読に応える
+type discordConfig struct {
+	BotToken string `yaml:"bot_token"`
+	// コマンドを受け付けるチャンネル (空なら全チャンネル)
+	ChannelID string `yaml:"channel_id"`
+}
+
+// 通知先の設定
+type destinationConfig struct {
+	// 省略すると種類 (2つ目からはslack-2のように番号付き)
+	Name string `yaml:"name"`
+	// slack, email, bluesky, x, discord
+	Type string `yaml:"type"`
+
+	// slack
+	WebhookURL string `yaml:"webhook_url"`
+	// リンクのプレビュー (auto: Slackに任せる、always: 常に展開する、never: 展開しない)
+	Unfurl string `yaml:"unfurl"`
+
+	// email
+	SMTPHost string   `yaml:"smtp_host"`
+	SMTPPort int      `yaml:"smtp_port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+
+	// 有料記事を送らない
+	SkipPaywalled bool `yaml:"skip_paywalled"`
+	// 指定するとこれらの分類の記事だけを送る
+	Categories []string `yaml:"categories"`
+	// slack, discord, email: メッセージの文字数の上限
+	// 超えた分はURLを残してタイトルなどを切り詰める (既定はslackが40000、discordが2000、emailは無制限)
+	MaxLength int `yaml:"max_length"`
+
+	// bluesky, x: 公開ページの基準で公開してよい記事だけを投稿する
+	PublicOnly bool     `yaml:"public_only"`
+	Hashtags   []string `yaml:"hashtags"`
+	// 投稿の最小間隔 (既定は1分)
+	MinInterval time.Duration `yaml:"min_interval"`
+
+	// bluesky
+	PDS         string `yaml:"pds"`
+	Handle      string `yaml:"handle"`
+	AppPassword string `yaml:"app_password"`
+
+	// x
+	ConsumerKey       string `yaml:"consumer_key"`
+	ConsumerSecret    string `yaml:"consumer_secret"`
+	AccessToken       string `yaml:"access_token"`
+	AccessTokenSecret string `yaml:"access_token_secret"`
+
+	// slack, discord: Botとして投稿する (bot_tokenの既定はslack.bot_token, discord.bot_token)
+	// slackはchannel_idを指定したときだけwebhook_urlの代わりにBotで投稿する
+	// 投稿に✅を付けると既読になる
+	BotToken  string `yaml:"bot_token"`
+	ChannelID string `yaml:"channel_id"`
+}
+
+// ブログの設定を検証し既定値を埋める
+func (s *sourceConfig) validate(key string) error {
+	switch s.Mode {
+	case "", sourceModeHTML, sourceModeFeed, sourceModeAuto:
+	default:
+		return fmt.Errorf("%s.mode: unknown value %q", key, s.Mode)
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("%s.timezone: %w", key, err)
+		}
+	}
+	if s.FirstFetchMaxAge != "" {
+		if _, err := parseDuration(s.FirstFetchMaxAge); err != nil {
+			return fmt.Errorf("%s.first_fetch_max_age: %w", key, err)
+		}
+	}
+	if s.FallbackAfter <= 0 {
+		s.FallbackAfter = defaultFallbackAfter
+	}
+	if s.Pagination.MaxPages < 0 {
+		return fmt.Errorf("%s.pagination.max_pages: must not be negative", key)
+	}
+	if err := s.Selectors.fetcher().Validate(); err != nil {
+		return fmt.Errorf("%s.selectors.%w", key, err)
+	}
+	if err := s.Render.validate(key + ".render"); err != nil {
+		return err
+	}
+	if err := s.Consent.validate(key + ".consent"); err != nil {
+		return err
+	}
+	if err := s.Canonical.validate(key + ".canonical"); err != nil {
+		return err
+	}
+	return s.Filters.validate(key + ".filters")
+}
+
+// 設定を保持
+var conf *config
+
+// 設定ファイルを読み込む
+// ファイルが存在しない場合はwebhook.txtのSlackのみを通知先とする
+// エラーはexitConfigで終了させる
+func loadConfig(path string) (*config, error) {
+	c, err := readConfig(path)
+	return c, withExitCode(exitConfig, err)
+}
+
+func readConfig(path string) (*config, error) {
+	c := &config{path: path}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("read config: %w", err)
+	default:
+		if err := yaml.Unmarshal(b, c); err != nil {
+			return nil, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+
+	if err := c.Filters.validate("filters"); err != nil {
+		return nil, err
+	}
+	if err := c.Source.validate("source"); err != nil {
+		return nil, err
+	}
+	for i := range c.Blogs {
+		if err := c.Blogs[i].validate(fmt.Sprintf("blogs[%d]", i)); err != nil {
+			return nil, err
+		}
+	}
+	if d := c.ReadingClub.PickDay; d != "" {
+		if _, ok := weekdayNames[strings.ToLower(d)]; !ok {
+			return nil, fmt.Errorf("reading_club.pick_day: unknown weekday %q", d)
+		}
+	}
+	if err := c.Warehouse.validate(); err != nil {
+		return nil, err
+	}
+	if err := c.Slack.Summary.validate(c.Slack); err != nil {
+		return nil, err
+	}
+	if c.Reminders.After != "" {
+		if _, err := parseDuration(c.Reminders.After); err != nil {
+			return nil, fmt.Errorf("reminders.after: %w", err)
+		}
+	}
+	if c.Notify.Limit < 0 {
+		return nil, fmt.Errorf("notify.limit: must not be negative")
+	}
+	if c.Notify.MaxPerMinute < 0 {
+		return nil, fmt.Errorf("notify.max_per_minute: must not be negative")
+	}
+	if c.Content.MaxLength < 0 {
+		return nil, fmt.Errorf("content.max_length: must not be negative")
+	}
+	if c.Content.RecheckDays < 0 || c.Content.RecheckMaxAgeDays < 0 {
+		return nil, fmt.Errorf("content: recheck_days and recheck_max_age_days must not be negative")
+	}
+	if c.Digest.Limit < 0 {
+		return nil, fmt.Errorf("digest.limit: must not be negative")
+	}
+	if c.UnreadFeed.Limit < 0 {
+		return nil, fmt.Errorf("unread_feed.limit: must not be negative")
+	}
+	if err := c.Schedule.init(); err != nil {
+		return nil, err
+	}
+	if err := (store.Options{JournalMode: c.SQLite.JournalMode, TempStore: c.SQLite.TempStore, CacheSizeKiB: c.SQLite.CacheSizeKiB}).Validate(); err != nil {
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	if c.Database == "" {
+		c.Database = "blog.db"
+	}
+	// SQLiteのファイルだけを使える (PostgreSQL, MySQLには対応しない、storeのパッケージのコメントを参照)
+	if scheme, _, ok := strings.Cut(c.Database, "://"); ok && scheme != "file" {
+		return nil, fmt.Errorf("database: %s:// is not supported, only SQLite files can be used", scheme)
+	}
+	c.Database = strings.TrimPrefix(c.Database, "sqlite:")
+	switch c.Archive.Naming {
+	case "", archiveNamingHash, archiveNamingSlug:
+	default:
+		return nil, fmt.Errorf("archive.naming: unknown value %q", c.Archive.Naming)
+	}
+	if _, err := parseSize(c.Archive.MaxSize); err != nil {
+		return nil, fmt.Errorf("archive.max_size: %w", err)
+	}
+	if _, err := parseSize(c.Fetch.DebugResponses.MaxSize); err != nil {
+		return nil, fmt.Errorf("fetch.debug_responses.max_size: %w", err)
+	}
+	if c.Fetch.InitialConcurrency <= 0 {
+		c.Fetch.InitialConcurrency = 2
+	}
+	if c.Fetch.MaxConcurrency <= 0 {
+		c.Fetch.MaxConcurrency = 8
+	}
+	if c.Fetch.SlowThreshold <= 0 {
+		c.Fetch.SlowThreshold = 5 * time.Second
+	}
+	if c.Server.Auth.SessionTTL == 0 {
+		c.Server.Auth.SessionTTL = 30 * 24 * time.Hour
+	}
+	if o := c.Server.Auth.OIDC; o.ClientID != "" {
+		if c.Server.Auth.PublicURL == "" {
+			return nil, errors.New("server.auth.public_url is required for oidc")
+		}
+		if o.Issuer == "" && o.Provider != "github" {
+			return nil, errors.New("server.auth.oidc: issuer or provider: github is required")
+		}
+	} else if c.Server.Auth.DisablePassword {
+		return nil, errors.New("server.auth.disable_password requires oidc")
+	}
+	if c.Replication.Enabled && c.Replication.ReplicaURL == "" && c.Replication.Config == "" {
+		return nil, errors.New("replication: replica_url or config is required")
+	}
+	if len(c.Destinations) == 0 {
+		c.Destinations = []destinationConfig{{
+			Name:       "slack",
+			Type:       "slack",
+			WebhookURL: strings.TrimSpace(webhookURL),
+		}}
+	}
+	categories := map[string]bool{}
+	for _, cat := range c.Categories {
+		if cat.Name == "" {
+			return nil, errors.New("categories: name is required")
+		}
+		if categories[cat.Name] {
+			return nil, fmt.Errorf("categories: duplicate name %q", cat.Name)
+		}
+		categories[cat.Name] = true
+	}
+	// 通知の記録は通知先の名前で分けるので、名前は重ならないようにする
+	// 名前がなければ種類から付ける (2つ目からはslack-2のように番号を付ける)
+	names := map[string]bool{}
+	for _, d := range c.Destinations {
+		if d.Name == "" {
+			continue
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("destinations: duplicate name %q", d.Name)
+		}
+		names[d.Name] = true
+	}
+	for i := range c.Destinations {
+		d := &c.Destinations[i]
+		if d.Name == "" {
+			d.Name = d.Type
+			for n := 2; names[d.Name]; n++ {
+				d.Name = fmt.Sprintf("%s-%d", d.Type, n)
+			}
+			names[d.Name] = true
+		}
+		for _, name := range d.Categories {
+			if !categories[name] {
+				return nil, fmt.Errorf("destination %q: unknown category %q", d.Name, name)
+			}
+		}
+		if d.Type == "slack" && d.WebhookURL == "" {
+			d.WebhookURL = strings.TrimSpace(webhookURL)
+		}
+	}
+	users := map[string]bool{}
+	for i := range c.Subscriptions {
+		s := &c.Subscriptions[i]
+		if err := s.normalize(); err != nil {
+			return nil, fmt.Errorf("subscriptions: %w", err)
+		}
+		if users[s.User] {
+			return nil, fmt.Errorf("subscriptions: duplicate user %q", s.User)
+		}
+		users[s.User] = true
+	}
+	return c, nil
+}
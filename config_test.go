@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDestinationNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "unnamed destinations get numbered",
+			yaml: "destinations:\n- type: slack\n- type: slack\n- type: discord\n- type: slack\n",
+			want: []string{"slack", "slack-2", "discord", "slack-3"},
+		},
+		{
+			name: "numbering skips explicit names",
+			yaml: "destinations:\n- type: slack\n- type: slack\n  name: slack-2\n- type: slack\n",
+			want: []string{"slack", "slack-2", "slack-3"},
+		},
+		{
+			name: "explicit name takes the type name first",
+			yaml: "destinations:\n- type: slack\n- type: discord\n  name: slack\n",
+			want: []string{"slack-2", "slack"},
+		},
+		{
+			name:    "duplicate explicit names",
+			yaml:    "destinations:\n- type: slack\n  name: team\n- type: discord\n  name: team\n",
+			wantErr: `duplicate name "team"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			c, err := loadConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if exitCodeOf(err) != exitConfig {
+					t.Errorf("exit code = %d, want %d", exitCodeOf(err), exitConfig)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range c.Destinations {
+				got = append(got, d.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("names = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 設定の説明は構造体のコメントから取る
+// 別に書くとずれるので、ソースを埋め込んで実行時に読む
+//
+//go:embed *.go
+var goSources embed.FS
+
+// 型ごとのコメント
+type structDocs struct {
+	// 型の説明
+	types map[string]string
+	// 型の名前 → フィールドの名前 → 説明
+	fields map[string]map[string]string
+}
+
+func loadStructDocs() (*structDocs, error) {
+	docs := &structDocs{types: map[string]string{}, fields: map[string]map[string]string{}}
+	entries, err := goSources.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		src, err := goSources.ReadFile(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(fset, e.Name(), src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				doc := ts.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				docs.types[ts.Name.Name] = commentText(doc)
+				fields := map[string]string{}
+				for _, fld := range st.Fields.List {
+					text := commentText(fld.Doc)
+					if c := commentText(fld.Comment); c != "" {
+						text = strings.TrimSpace(text + "\n" + c)
+					}
+					for _, n := range fld.Names {
+						fields[n.Name] = text
+					}
+				}
+				docs.fields[ts.Name.Name] = fields
+			}
+		}
+	}
+	return docs, nil
+}
+
+// コメントの文章 (//の後の空白と、先頭の空行を除く)
+func commentText(g *ast.CommentGroup) string {
+	if g == nil {
+		return ""
+	}
+	return strings.TrimSpace(g.Text())
+}
+
+// フィールドの説明 (なければ型の説明)
+func (d *structDocs) field(t reflect.Type, f reflect.StructField) string {
+	if s := d.fields[t.Name()][f.Name]; s != "" {
+		return s
+	}
+	ft := f.Type
+	for ft.Kind() == reflect.Slice || ft.Kind() == reflect.Pointer {
+		ft = ft.Elem()
+	}
+	if ft.Kind() == reflect.Struct {
+		return d.types[ft.Name()]
+	}
+	return ""
+}
+
+// YAMLのキー (タグがなければyaml.v3と同じく小文字の名前)
+func yamlKey(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(f.Name), true
+	}
+	return name, true
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	weekdaysType = reflect.TypeOf(weekdays(nil))
+)
+
+// config: 設定ファイルの例やJSON Schemaを出力する
+func cmdConfig(args []string) error {
+	if len(args) != 1 {
+		return usageErr("usage: config example|schema")
+	}
+	docs, err := loadStructDocs()
+	if err != nil {
+		return err
+	}
+	// 既定値は設定ファイルがないときの設定から取る
+	defaults, err := loadConfig("")
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "example":
+		return writeConfigExample(os.Stdout, docs, defaults)
+	case "schema":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(configSchema(docs, defaults))
+	default:
+		return usageErr(fmt.Sprintf("unknown config command %q", args[0]))
+	}
+}
+
+// コメント付きの設定ファイルの例
+// 既定値を書き、リストの要素は書き方の例としてコメントにする
+func writeConfigExample(w io.Writer, docs *structDocs, defaults *config) error {
+	fmt.Fprintln(w, "# fetch-blog config")
+	fmt.Fprintln(w, "# yaml-language-server: $schema=config.schema.json")
+	var b strings.Builder
+	exampleStruct(&b, docs, reflect.ValueOf(*defaults), "", false)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func exampleStruct(b *strings.Builder, docs *structDocs, v reflect.Value, indent string, commented bool) {
+	t := v.Type()
+	prefix := indent
+	if commented {
+		prefix = "# " + indent
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, ok := yamlKey(f)
+		if !ok {
+			continue
+		}
+		// 最上位の項目の間だけ空行を入れる
+		if indent == "" {
+			b.WriteString("\n")
+		}
+		if doc := docs.field(t, f); doc != "" {
+			for _, line := range strings.Split(doc, "\n") {
+				b.WriteString(strings.TrimRight(prefix+"# "+line, " ") + "\n")
+			}
+		}
+		fv := v.Field(i)
+		switch {
+		case f.Type == durationType:
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, key, yamlScalar(fv.Interface().(time.Duration).String()))
+		case f.Type == weekdaysType:
+			var names []string
+			for _, d := range fv.Interface().(weekdays) {
+				names = append(names, strings.ToLower(d.String()[:3]))
+			}
+			fmt.Fprintf(b, "%s%s: [%s]\n", prefix, key, strings.Join(names, ", "))
+		case f.Type.Kind() == reflect.Struct:
+			fmt.Fprintf(b, "%s%s:\n", prefix, key)
+			exampleStruct(b, docs, fv, indent+"  ", commented)
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct:
+			// 空のリストにして、要素の例をコメントで書く
+			if commented {
+				fmt.Fprintf(b, "%s%s:\n", prefix, key)
+			} else {
+				fmt.Fprintf(b, "%s%s: []\n", prefix, key)
+			}
+			var item strings.Builder
+			exampleStruct(&item, docs, reflect.New(f.Type.Elem()).Elem(), indent+"    ", true)
+			// 最初のキーの前に "- " を置く
+			b.WriteString(listItem(item.String(), indent+"  "))
+		case f.Type.Kind() == reflect.Slice:
+			items := make([]string, 0, fv.Len())
+			for j := 0; j < fv.Len(); j++ {
+				items = append(items, yamlScalar(fv.Index(j).Interface()))
+			}
+			fmt.Fprintf(b, "%s%s: [%s]\n", prefix, key, strings.Join(items, ", "))
+		case f.Type.Kind() == reflect.Map:
+			fmt.Fprintf(b, "%s%s: {}\n", prefix, key)
+		default:
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, key, yamlScalar(fv.Interface()))
+		}
+	}
+}
+
+// コメントにしたリストの要素の最初のキー (説明の行は飛ばす) を "- key:" にする
+func listItem(s, indent string) string {
+	lines := strings.Split(strings.TrimLeft(s, "\n"), "\n")
+	for i, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "# "+indent+"  "); ok && !strings.HasPrefix(rest, " ") && !strings.HasPrefix(rest, "#") {
+			lines[i] = "# " + indent + "- " + rest
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func yamlScalar(v any) string {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// 設定ファイルのJSON Schema (エディタでの検証と補完に使う)
+func configSchema(docs *structDocs, defaults *config) map[string]any {
+	s := structSchema(docs, reflect.ValueOf(*defaults))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "fetch-blog config"
+	return s
+}
+
+func structSchema(docs *structDocs, v reflect.Value) map[string]any {
+	t := v.Type()
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, ok := yamlKey(f)
+		if !ok {
+			continue
+		}
+		p := typeSchema(docs, f.Type, v.Field(i))
+		if doc := docs.field(t, f); doc != "" {
+			p["description"] = doc
+		}
+		props[key] = p
+	}
+	s := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	if doc := docs.types[t.Name()]; doc != "" {
+		s["description"] = doc
+	}
+	return s
+}
+
+func typeSchema(docs *structDocs, t reflect.Type, v reflect.Value) map[string]any {
+	switch {
+	case t == durationType:
+		s := map[string]any{"type": "string", "pattern": `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`}
+		if v.IsValid() && v.Int() != 0 {
+			s["default"] = time.Duration(v.Int()).String()
+		}
+		return s
+	case t == weekdaysType:
+		names := make([]string, 0, len(weekdayNames))
+		for n := range weekdayNames {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return map[string]any{"type": "array", "items": map[string]any{"type": "string", "enum": names}}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if !v.IsValid() {
+			v = reflect.New(t).Elem()
+		}
+		return structSchema(docs, v)
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(docs, t.Elem(), reflect.Value{})}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(docs, t.Elem(), reflect.Value{})}
+	case reflect.Bool:
+		s := map[string]any{"type": "boolean"}
+		if v.IsValid() && v.Bool() {
+			s["default"] = true
+		}
+		return s
+	case reflect.Int, reflect.Int64:
+		s := map[string]any{"type": "integer"}
+		if v.IsValid() && v.Int() != 0 {
+			s["default"] = v.Int()
+		}
+		return s
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	s := map[string]any{"type": "string"}
+	if v.IsValid() && v.Kind() == reflect.String && v.String() != "" {
+		s["default"] = v.String()
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+
+	"fetch-blog/fetcher"
+)
+
+// Cookieの同意の画面 (記事の代わりに返ってくるもの) への対処
+// 同意の画面だとわかったら、同意済みを表すCookieを付けて一覧を取り直す
+type consentConfig struct {
+	// 同意済みのCookieの組 (google, onetrust, cookiebot, cookieconsent, generic)
+	Presets []string `yaml:"presets"`
+	// 追加で付けるCookie (名前: 値)
+	Cookies map[string]string `yaml:"cookies"`
+	// 同意の画面とみなす要素のCSSセレクタ (既定の判定に加える)
+	Marker string `yaml:"marker"`
+}
+
+// 同意の管理ツールごとの同意済みのCookie
+// domainが空ならブログのホストに付ける
+type consentCookie struct {
+	name, value string
+	domain      string
+}
+
+var consentPresets = map[string][]consentCookie{
+	// consent.google.comへのリダイレクト
+	"google": {{name: "CONSENT", value: "YES+cb", domain: "google.com"}, {name: "SOCS", value: "CAI", domain: "google.com"}},
+	"onetrust": {
+		{name: "OptanonAlertBoxClosed", value: "2024-01-01T00:00:00.000Z"},
+		{name: "OptanonConsent", value: "isGpcEnabled=0&groups=C0001:1,C0002:1,C0003:1,C0004:1"},
+	},
+	// -1は同意が不要な地域を表す (画面を出さない)
+	"cookiebot":     {{name: "CookieConsent", value: "-1"}},
+	"cookieconsent": {{name: "cookieconsent_status", value: "dismiss"}},
+	// 自作の同意の画面でよく使われる名前
+	"generic": {{name: "cookie_consent", value: "accepted"}, {name: "cookies_accepted", value: "true"}, {name: "gdpr", value: "1"}},
+}
+
+// 同意の管理ツールの画面
+const consentSelector = `#onetrust-consent-sdk, #CybotCookiebotDialog, #didomi-host, .qc-cmp2-container, #sp_message_container,
+.fc-consent-root, #usercentrics-root, form[action*="consent"]`
+
+func (c consentConfig) enabled() bool { return len(c.Presets) > 0 || len(c.Cookies) > 0 }
+
+func (c consentConfig) validate(key string) error {
+	for _, p := range c.Presets {
+		if _, ok := consentPresets[p]; !ok {
+			return fmt.Errorf("%s.presets: unknown preset %q", key, p)
+		}
+	}
+	if c.Marker != "" {
+		if _, err := cascadia.ParseGroup(c.Marker); err != nil {
+			return fmt.Errorf("%s.marker: invalid selector %q: %w", key, c.Marker, err)
+		}
+	}
+	return nil
+}
+
+// 同意済みのCookieをjarに入れる
+func (c consentConfig) setCookies(jar http.CookieJar, pageURL string) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return
+	}
+	expires := time.Now().AddDate(1, 0, 0)
+	byDomain := map[string][]*http.Cookie{}
+	add := func(domain, name, value string) {
+		byDomain[domain] = append(byDomain[domain], &http.Cookie{Name: name, Value: value, Path: "/", Domain: domain, Expires: expires})
+	}
+	for _, p := range c.Presets {
+		for _, ck := range consentPresets[p] {
+			add(ck.domain, ck.name, ck.value)
+		}
+	}
+	names := make([]string, 0, len(c.Cookies))
+	for name := range c.Cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add("", name, c.Cookies[name])
+	}
+	for domain, cookies := range byDomain {
+		target := u
+		if domain != "" {
+			target = &url.URL{Scheme: "https", Host: domain, Path: "/"}
+		}
+		jar.SetCookies(target, cookies)
+	}
+}
+
+// 記事の代わりに同意の画面が返ってきたか
+// 同意を求めるURLへリダイレクトされたか、一覧に記事がなく同意の管理ツールの画面がある
+func isConsentPage(b blog, resp *http.Response, doc *goquery.Document) bool {
+	if resp.Request != nil {
+		u := resp.Request.URL
+		if strings.Contains(strings.ToLower(u.Host+u.Path), "consent") && u.Host != urlHost(b.url) {
+			return true
+		}
+	}
+	if m := b.cfg.Consent.Marker; m != "" && doc.Find(m).Length() > 0 {
+		return true
+	}
+	if doc.Find(consentSelector).Length() == 0 {
+		return false
+	}
+	items, skipped := fetcher.ParseList(doc, b.url, b.cfg.Selectors.fetcher())
+	return len(items) == 0 && len(skipped) == 0
+}
+
+// 同意の画面なら同意済みのCookieを付けて1度だけ取り直す
+// 取り直しても同意の画面ならエラーにする
+func passConsent(ctx context.Context, b blog, client *http.Client, resp *http.Response, doc *goquery.Document) (*http.Response, *goquery.Document, error) {
+	if !isConsentPage(b, resp, doc) {
+		return resp, doc, nil
+	}
+	if !b.cfg.Consent.enabled() || client.Jar == nil {
+		return nil, nil, &fetchError{kind: fetchErrParseEmpty,
+			err: fmt.Errorf("%s: got a cookie consent page instead of the list (set consent.presets or consent.cookies)", b.url)}
+	}
+	log.Printf("%s: got a cookie consent page, retrying with consent cookies", b.name())
+	b.cfg.Consent.setCookies(client.Jar, b.url)
+	resp, body, err := getListPage(ctx, client, b.url, listValidators{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc, err = goquery.NewDocumentFromReader(bytes.NewReader(body)); err != nil {
+		return nil, nil, err
+	}
+	if isConsentPage(b, resp, doc) {
+		return nil, nil, &fetchError{kind: fetchErrParseEmpty,
+			err: fmt.Errorf("%s: still got a cookie consent page with the consent cookies", b.url)}
+	}
+	return resp, doc, nil
+}
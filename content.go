@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 記事の本文の保存
+// 記事ページから読む部分だけを取り出してcontent列に保存し、オフラインでの閲覧や検索、要約に使う
+type contentConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 保存する本文の文字数の上限 (既定は200000、超えた分は捨てる)
+	MaxLength int `yaml:"max_length"`
+	// 取り出してからこの日数が過ぎた記事のページを取り直し、書き換えられていれば前の版を残す (0なら取り直さない)
+	RecheckDays int `yaml:"recheck_days"`
+	// 取り直すのは一覧の日付からこの日数までの記事 (既定は30)
+	RecheckMaxAgeDays int `yaml:"recheck_max_age_days"`
+}
+
+const defaultContentMaxLength = 200000
+
+func (c contentConfig) maxLength() int {
+	if c.MaxLength <= 0 {
+		return defaultContentMaxLength
+	}
+	return c.MaxLength
+}
+
+const defaultContentRecheckMaxAgeDays = 30
+
+func (c contentConfig) recheckMaxAgeDays() int {
+	if c.RecheckMaxAgeDays <= 0 {
+		return defaultContentRecheckMaxAgeDays
+	}
+	return c.RecheckMaxAgeDays
+}
+
+// 本文を取り出していない記事と、取り直す時期の来た記事
+func (c contentConfig) pending(now time.Time) string {
+	if c.RecheckDays <= 0 {
+		return "content_at IS NULL"
+	}
+	checkedBefore := now.AddDate(0, 0, -c.RecheckDays).UTC().Format(time.RFC3339)
+	since := now.AddDate(0, 0, -c.recheckMaxAgeDays()).Format("2006-01-02")
+	return fmt.Sprintf("content_at IS NULL OR (content_at < '%s' AND date >= '%s')", checkedBefore, since)
+}
+
+// 本文の中でも読む部分ではない要素 (ナビゲーション、共有ボタン、コメント欄など)
+const boilerplateSelector = `nav, aside, header, footer, form, button, [role="navigation"], [role="complementary"], [aria-hidden="true"],
+.share, .sns, .social, .related, .comments, #comments, .ad, .ads, .advertisement, .breadcrumb, .pagination`
+
+// 記事ページから本文を取り出して保存する処理
+// HTMLでないページは空の本文として済ませる
+// 取り直した本文が前と違えば前の版をarticle_revisionsに残す
+func contentStep(c contentConfig) pageStep {
+	return pageStep{
+		name:    "content",
+		pending: c.pending(time.Now()),
+		handle: func(ctx context.Context, p *articlePage) error {
+			text := ""
+			if strings.Contains(p.contentType, "html") || p.contentType == "" {
+				doc, err := goquery.NewDocumentFromReader(bytes.NewReader(p.body))
+				if err != nil {
+					return err
+				}
+				text = readableText(doc, c.maxLength())
+			}
+			return saveContent(ctx, p.url, text)
+		},
+	}
+}
+
+// 読む部分の本文 (article, mainの中からナビゲーションなどを除いたもの)
+func readableText(doc *goquery.Document, maxLength int) string {
+	root := contentRoot(doc)
+	// 記事のheaderにあるタイトルや日付も除く (タイトルは別に保存している)
+	root.Find(boilerplateSelector).Remove()
+	text := extractText(root)
+	if utf8.RuneCountInString(text) > maxLength {
+		text = string([]rune(text)[:maxLength])
+	}
+	return text
+}
+
+// 本文を保存する
+// 前に取り出した本文と違えば前の版を残す (取り直して空になったときは前の本文を残す)
+func saveContent(ctx context.Context, url, text string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var old, oldAt sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT content, content_at FROM articles WHERE url = ? ORDER BY rowid DESC LIMIT 1", url).Scan(&old, &oldAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	switch {
+	case oldAt.Valid && text == "":
+		_, err = tx.ExecContext(ctx, "UPDATE articles SET content_at = ? WHERE url = ?", now, url)
+	case oldAt.Valid && old.String != "" && old.String != text:
+		if _, err := tx.ExecContext(ctx, "INSERT INTO article_revisions (url, content, fetched_at, replaced_at) VALUES (?, ?, ?, ?)",
+			url, old.String, oldAt.String, now); err != nil {
+			return err
+		}
+		log.Printf("content of %s changed (run \"diff %s\")", url, url)
+		fallthrough
+	default:
+		_, err = tx.ExecContext(ctx, "UPDATE articles SET content = ?, content_at = ? WHERE url = ?", text, now, url)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+	// 夏時間のテストはシステムのタイムゾーンの情報に頼らない
+	_ "time/tzdata"
+)
+
+func TestParseCronFields(t *testing.T) {
+	bits := func(vs ...int) uint64 {
+		var b uint64
+		for _, v := range vs {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+	tests := []struct {
+		expr           string
+		minute, dow    uint64
+		domAny, dowAny bool
+	}{
+		{"0 * * * *", bits(0), bits(0, 1, 2, 3, 4, 5, 6), true, true},
+		{"5/15 * * * *", bits(5, 20, 35, 50), bits(0, 1, 2, 3, 4, 5, 6), true, true},
+		{"1-10/3 * * * *", bits(1, 4, 7, 10), bits(0, 1, 2, 3, 4, 5, 6), true, true},
+		{"0,30 * * * mon-fri", bits(0, 30), bits(1, 2, 3, 4, 5), true, false},
+		// 7は日曜日
+		{"0 * * * 7", bits(0), bits(0), true, false},
+		{"0 * 1 * */2", bits(0), bits(0, 2, 4, 6), false, true},
+		{"@hourly", bits(0), bits(0, 1, 2, 3, 4, 5, 6), true, true},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if s.minute != tt.minute || s.dow != tt.dow || s.domAny != tt.domAny || s.dowAny != tt.dowAny {
+			t.Errorf("parseCron(%q) = minute %b dow %b any %v/%v, want %b %b %v/%v",
+				tt.expr, s.minute, s.dow, s.domAny, s.dowAny, tt.minute, tt.dow, tt.domAny, tt.dowAny)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@every",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	load := func(name string) *time.Location {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loc
+	}
+	utc := time.UTC
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"interval", "*/15 * * * *", time.Date(2026, 1, 1, 10, 7, 30, 0, utc), time.Date(2026, 1, 1, 10, 15, 0, 0, utc)},
+		{"strictly after", "*/15 * * * *", time.Date(2026, 1, 1, 10, 15, 0, 0, utc), time.Date(2026, 1, 1, 10, 30, 0, 0, utc)},
+		// 2026-01-02は金曜日
+		{"weekdays", "0 9 * * mon-fri", time.Date(2026, 1, 2, 10, 0, 0, 0, utc), time.Date(2026, 1, 5, 9, 0, 0, 0, utc)},
+		{"sunday as 7", "0 0 * * 7", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Date(2026, 1, 4, 0, 0, 0, 0, utc)},
+		{"day or weekday", "0 0 13 * fri", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Date(2026, 1, 2, 0, 0, 0, 0, utc)},
+		{"day only", "0 0 13 * *", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Date(2026, 1, 13, 0, 0, 0, 0, utc)},
+		{"month name", "0 0 1 mar *", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Date(2026, 3, 1, 0, 0, 0, 0, utc)},
+		{"monthly", "@monthly", time.Date(2026, 1, 15, 0, 0, 0, 0, utc), time.Date(2026, 2, 1, 0, 0, 0, 0, utc)},
+		{"never", "0 0 30 2 *", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Time{}},
+		// 30分ずれたタイムゾーン
+		{"half hour offset", "0 9 * * *", time.Date(2026, 1, 1, 8, 10, 0, 0, load("Asia/Kolkata")), time.Date(2026, 1, 1, 9, 0, 0, 0, load("Asia/Kolkata"))},
+		// 夏時間が始まる日は2:30がない
+		{"skipped by dst", "30 2 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, load("America/New_York")), time.Date(2026, 3, 9, 2, 30, 0, 0, load("America/New_York"))},
+		{"after dst", "0 3 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, load("America/New_York")), time.Date(2026, 3, 8, 3, 0, 0, 0, load("America/New_York"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CSRFトークンを受け取るヘッダーとフォームの項目
+const (
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+)
+
+// セッションに対応するCSRFトークン
+// セッションのCookieはHttpOnlyなので他のサイトからは計算できない
+func csrfToken(session string) string {
+	return sha256Hex([]byte("csrf:" + session))
+}
+
+// HTTPSで受けたリクエストか (TLSを終端するプロキシの後ろも含む)
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// Cookieの属性をそろえる
+// JavaScriptから読めず、HTTPSではSecureを付け、他サイトからのPOSTには送らない
+func secureCookie(r *http.Request, c *http.Cookie) *http.Cookie {
+	c.HttpOnly = true
+	c.Secure = isHTTPS(r)
+	if c.SameSite == http.SameSiteDefaultMode {
+		c.SameSite = http.SameSiteLaxMode
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	return c
+}
+
+// 状態を変えるリクエストを他のサイトから送らせない
+//   - Origin (なければReferer) が自サイト以外なら拒否する
+//   - セッションのCookieで認証するリクエストにはCSRFトークンを求める
+//
+// SlackやActivityPubなどサーバー間の呼び出しはOriginもセッションも持たないので影響しない
+func csrfProtect(publicURL string, next http.Handler) http.Handler {
+	var publicHost string
+	if u, err := url.Parse(publicURL); err == nil {
+		publicHost = u.Host
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			origin = r.Header.Get("Referer")
+		}
+		if origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || (u.Host != r.Host && u.Host != publicHost) {
+				writeError(w, http.StatusForbidden, "cross-origin request rejected")
+				return
+			}
+		}
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			token := r.Header.Get(csrfHeader)
+			if token == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+				token = r.PostFormValue(csrfField)
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(csrfToken(c.Value))) != 1 {
+				writeError(w, http.StatusForbidden, "invalid csrf token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// 画面をフレームに埋め込ませず、Content-Typeを推測させない
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "same-origin")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ダイジェストの並び順
+const (
+	orderOldest   = "oldest"
+	orderNewest   = "newest"
+	orderSource   = "source"
+	orderDate     = "date"
+	orderCategory = "category"
+	orderScore    = "score"
+)
+
+// 見出しごとの記事のまとまり
+type digestGroup struct {
+	header   string
+	articles []article
+}
+
+// 複数記事をまとめた通知
+type digest struct {
+	groups []digestGroup
+	// 輪読会の投票ボタンを付ける (Slack)
+	votable bool
+}
+
+// 記事を指定の順序でダイジェストにまとめる
+//
+//	oldest: 古い順 (見出しなし)
+//	newest: 新しい順 (見出しなし)
+//	source: ブログごとに見出しを付け、その中は古い順
+//	date:   日付ごとに見出しを付け、新しい日付から
+//	category: 分類ごとに見出しを付け (設定の順、分類なしは最後)、その中は古い順
+//	score:  読む優先度の高い順 (見出しなし、scoreLessを参照)
+func buildDigest(articles []article, order string) (*digest, error) {
+	sorted := make([]article, len(articles))
+	copy(sorted, articles)
+
+	var key func(a article) string
+	switch order {
+	case "", orderOldest:
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].sortKey() < sorted[j].sortKey() })
+	case orderNewest:
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].sortKey() > sorted[j].sortKey() })
+	case orderSource:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			si, sj := articleSource(sorted[i]), articleSource(sorted[j])
+			if si != sj {
+				return si < sj
+			}
+			return sorted[i].sortKey() < sorted[j].sortKey()
+		})
+		key = articleSource
+	case orderDate:
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].sortKey() > sorted[j].sortKey() })
+		key = func(a article) string { return a.date }
+	case orderCategory:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			ci, cj := categoryIndex(articleCategory(sorted[i])), categoryIndex(articleCategory(sorted[j]))
+			if ci != cj {
+				return ci < cj
+			}
+			return sorted[i].sortKey() < sorted[j].sortKey()
+		})
+		key = articleCategory
+	case orderScore:
+		sort.SliceStable(sorted, func(i, j int) bool { return scoreLess(sorted[i], sorted[j]) })
+	default:
+		return nil, fmt.Errorf("unknown digest order %q", order)
+	}
+
+	dg := &digest{}
+	if key == nil {
+		dg.groups = []digestGroup{{articles: sorted}}
+		return dg, nil
+	}
+	for _, a := range sorted {
+		k := key(a)
+		if n := len(dg.groups); n == 0 || dg.groups[n-1].header != k {
+			dg.groups = append(dg.groups, digestGroup{header: k})
+		}
+		g := &dg.groups[len(dg.groups)-1]
+		g.articles = append(g.articles, a)
+	}
+	return dg, nil
+}
+
+// 読む優先度の比較 (aを先に読むならtrue)
+// 1. 期限のある記事を期限の近い順に
+// 2. 読みやすい記事 (reading_easeの高い順、求めていない記事は後)
+// 3. 古い順
+func scoreLess(a, b article) bool {
+	if (a.dueAt != "") != (b.dueAt != "") {
+		return a.dueAt != ""
+	}
+	if a.dueAt != b.dueAt {
+		return a.dueAt < b.dueAt
+	}
+	if a.readingEase != b.readingEase {
+		return a.readingEase > b.readingEase
+	}
+	return a.sortKey() < b.sortKey()
+}
+
+// 記事の配信元 (手で追加した記事以外はURLのホスト名)
+func articleSource(a article) string {
+	if a.source != "" {
+		return a.source
+	}
+	return urlHost(a.url)
+}
+
+// URLのホスト名 (わからなければunknown)
+func urlHost(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	return u.Host
+}
+
+// ダイジェストに含まれる記事数
+func (d *digest) len() int {
+	n := 0
+	for _, g := range d.groups {
+		n += len(g.articles)
+	}
+	return n
+}
+
+// ダイジェストに含まれる記事
+func (d *digest) articles() []article {
+	var as []article
+	for _, g := range d.groups {
+		as = append(as, g.articles...)
+	}
+	return as
+}
+
+// 条件に合う記事だけのダイジェストを作る
+// 記事がなくなった見出しは除く
+func (d *digest) filter(keep func(a article) bool) *digest {
+	res := &digest{votable: d.votable}
+	for _, g := range d.groups {
+		ng := digestGroup{header: g.header}
+		for _, a := range g.articles {
+			if keep(a) {
+				ng.articles = append(ng.articles, a)
+			}
+		}
+		if len(ng.articles) > 0 {
+			res.groups = append(res.groups, ng)
+		}
+	}
+	return res
+}
+
+// テキスト形式に整形
+// headerFormatは見出しの書式 (Slackなら"*%s*")
+// 記事には見出しをまたいで通し番号を付ける
+func (d *digest) text(headerFormat string) string {
+	var b strings.Builder
+	n := 0
+	for i, g := range d.groups {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if g.header != "" {
+			fmt.Fprintf(&b, headerFormat+"\n", g.header)
+		}
+		for _, a := range g.articles {
+			n++
+			fmt.Fprintf(&b, "%d. %s (%s)\n   %s\n", n, displayTitle(a), a.date, a.url)
+		}
+	}
+	return b.String()
+}
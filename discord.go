@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	discordAPI     = "https://discord.com/api/v10"
+	discordGateway = "wss://gateway.discord.gg/?v=10&encoding=json"
+
+	// GUILD_MESSAGES | GUILD_MESSAGE_REACTIONS | MESSAGE_CONTENT
+	discordIntents = 1<<9 | 1<<10 | 1<<15
+
+	// 既読にするリアクション
+	readReaction = "✅"
+)
+
+// Discord REST APIを呼び出す
+func discordREST(ctx context.Context, token, method, path string, payload, out any) error {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, discordAPI+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("discord %s %s: status code %d: %s", method, path, resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// チャンネルにメッセージを投稿してIDを返す
+func discordPost(ctx context.Context, token, channelID, content string) (string, error) {
+	var msg struct {
+		ID string `json:"id"`
+	}
+	payload := map[string]any{
+		"content": content,
+		// 記事のURL以外でメンションしない
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	}
+	// 同じnonceの投稿は数分以内なら重複として扱われる
+	if key := idempotencyKeyFrom(ctx); key != "" {
+		payload["nonce"] = key[:25]
+		payload["enforce_nonce"] = true
+	}
+	err := discordREST(ctx, token, http.MethodPost, "/channels/"+channelID+"/messages", payload, &msg)
+	return msg.ID, err
+}
+
+// 通知メッセージと記事の対応を記録する
+// リアクションからどの記事かを引けるようにする
+func recordMessageRef(ctx context.Context, platform, messageID, url string) error {
+	_, err := db.ExecContext(ctx, "INSERT OR REPLACE INTO message_refs (platform, message_id, url, created_at) VALUES (?, ?, ?, ?)",
+		platform, messageID, url, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// メッセージに対応する記事のURL
+func lookupMessageRef(ctx context.Context, platform, messageID string) (string, error) {
+	var u string
+	err := db.QueryRowContext(ctx, "SELECT url FROM message_refs WHERE platform = ? AND message_id = ?", platform, messageID).Scan(&u)
+	return u, err
+}
+
+// Botとしてチャンネルに投稿する通知先
+type discordDestination struct {
+	label     string
+	token     string
+	channelID string
+	// メッセージの文字数の上限 (0なら切り詰めない)
+	maxLength int
+}
+
+func (d *discordDestination) name() string { return d.label }
+
+func (d *discordDestination) send(ctx context.Context, a article) error {
+	msg := fmt.Sprintf("**%s** (%s)\n%s", displayTitle(a), a.date, a.url) + articleExtras(a, "**%s**")
+	id, err := discordPost(ctx, d.token, d.channelID, truncateMessage(msg, d.maxLength))
+	if err != nil {
+		return err
+	}
+	// 投稿は済んでいるので記録の失敗は通知の失敗にしない
+	if err := recordMessageRef(ctx, "discord", id, a.url); err != nil {
+		log.Printf("discord: %v", err)
+	}
+	return nil
+}
+
+func (d *discordDestination) sendDigest(ctx context.Context, dg *digest) error {
+	_, err := discordPost(ctx, d.token, d.channelID, truncateMessage(dg.text("**%s**"), d.maxLength))
+	return err
+}
+
+// Gatewayに接続してコマンドとリアクションを処理するBot
+type discordBot struct {
+	cfg discordConfig
+
+	mu    sync.Mutex
+	seq   *int64
+	botID string
+}
+
+// ゲートウェイのメッセージ
+type gatewayPayload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d,omitempty"`
+	S  *int64          `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+// 切断されても再接続し続ける
+func (b *discordBot) run(ctx context.Context) error {
+	if b.cfg.BotToken == "" {
+		return errors.New("discord.bot_token is required")
+	}
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := b.session(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("discord: disconnected: %v (reconnecting in %s)", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// 1回の接続
+func (b *discordBot) session(ctx context.Context) error {
+	wsConf, err := websocket.NewConfig(discordGateway, "https://discord.com")
+	if err != nil {
+		return err
+	}
+	wsConf.Dialer = connectDialer()
+	ws, err := websocket.DialConfig(wsConf)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	// Helloでハートビートの間隔を受け取る
+	var hello gatewayPayload
+	if err := websocket.JSON.Receive(ws, &hello); err != nil {
+		return err
+	}
+	var h struct {
+		HeartbeatInterval int `json:"heartbeat_interval"`
+	}
+	if err := json.Unmarshal(hello.D, &h); err != nil || h.HeartbeatInterval == 0 {
+		return fmt.Errorf("unexpected hello: %s", hello.D)
+	}
+
+	var sendMu sync.Mutex
+	send := func(v any) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return websocket.JSON.Send(ws, v)
+	}
+	err = send(map[string]any{"op": 2, "d": map[string]any{
+		"token":   b.cfg.BotToken,
+		"intents": discordIntents,
+		"properties": map[string]string{
+			"os": "linux", "browser": "fetch-blog", "device": "fetch-blog",
+		},
+	}})
+	if err != nil {
+		return err
+	}
+
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		t := time.NewTicker(time.Duration(h.HeartbeatInterval) * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				b.mu.Lock()
+				seq := b.seq
+				b.mu.Unlock()
+				if err := send(map[string]any{"op": 1, "d": seq}); err != nil {
+					ws.Close()
+					return
+				}
+			case <-sessCtx.Done():
+				ws.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		var p gatewayPayload
+		if err := websocket.JSON.Receive(ws, &p); err != nil {
+			return err
+		}
+		if p.S != nil {
+			b.mu.Lock()
+			b.seq = p.S
+			b.mu.Unlock()
+		}
+		switch p.Op {
+		case 0:
+			b.dispatch(sessCtx, p.T, p.D)
+		case 7, 9:
+			// 再接続の要求、またはセッションが無効
+			return fmt.Errorf("gateway op %d", p.Op)
+		}
+	}
+}
+
+func (b *discordBot) dispatch(ctx context.Context, event string, data json.RawMessage) {
+	switch event {
+	case "READY":
+		var r struct {
+			User struct {
+				ID       string `json:"id"`
+				Username string `json:"username"`
+			} `json:"user"`
+		}
+		json.Unmarshal(data, &r)
+		b.botID = r.User.ID
+		log.Printf("discord: connected as %s", r.User.Username)
+	case "MESSAGE_CREATE":
+		var m struct {
+			ChannelID string `json:"channel_id"`
+			Content   string `json:"content"`
+			Author    struct {
+				ID  string `json:"id"`
+				Bot bool   `json:"bot"`
+			} `json:"author"`
+		}
+		if json.Unmarshal(data, &m) != nil || m.Author.Bot {
+			return
+		}
+		if b.cfg.ChannelID != "" && m.ChannelID != b.cfg.ChannelID {
+			return
+		}
+		go b.command(ctx, m.ChannelID, m.Content)
+	case "MESSAGE_REACTION_ADD":
+		var r struct {
+			UserID    string `json:"user_id"`
+			MessageID string `json:"message_id"`
+			Emoji     struct {
+				Name string `json:"name"`
+			} `json:"emoji"`
+		}
+		if json.Unmarshal(data, &r) != nil || r.UserID == b.botID || r.Emoji.Name != readReaction {
+			return
+		}
+		go func() {
+			u, err := lookupMessageRef(ctx, "discord", r.MessageID)
+			if err != nil {
+				return
+			}
+			if err := acknowledge(ctx, u); err != nil {
+				log.Printf("discord: mark as read: %v", err)
+			}
+		}()
+	}
+}
+
+// !next, !stats
+func (b *discordBot) command(ctx context.Context, channelID, content string) {
+	switch content {
+	case "!next":
+		articles, err := queryArticles(ctx, articleFilter{read: boolPtr(false), status: statusOK, awake: true, limit: 1})
+		if err != nil {
+			log.Printf("discord: %v", err)
+			return
+		}
+		if len(articles) == 0 {
+			discordPost(ctx, b.cfg.BotToken, channelID, "未読の記事はありません 🎉")
+			return
+		}
+		a := articles[0]
+		id, err := discordPost(ctx, b.cfg.BotToken, channelID,
+			fmt.Sprintf("**%s** (%s)\n%s\n読んだら%sを付けてください", displayTitle(a), a.date, a.url, readReaction))
+		if err != nil {
+			log.Printf("discord: %v", err)
+			return
+		}
+		if err := recordMessageRef(ctx, "discord", id, a.url); err != nil {
+			log.Printf("discord: %v", err)
+		}
+	case "!stats":
+		st, err := getOverallStats(ctx)
+		if err != nil {
+			log.Printf("discord: %v", err)
+			return
+		}
+		discordPost(ctx, b.cfg.BotToken, channelID,
+			fmt.Sprintf("未読 %d件 / 既読 %d件 / 確認待ち %d件", st.unread, st.read, st.review))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// 記事への外部の議論
+type discussion struct {
+	site     string
+	url      string
+	points   int
+	comments int
+}
+
+// Hacker NewsとLobstersの議論へのリンクを通知に添える
+type discussionsConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// 記事のURLが投稿された議論を探す
+// サイトごとの失敗はほかのサイトの結果に影響しない
+func findDiscussions(ctx context.Context, articleURL string) ([]discussion, []error) {
+	lookups := []func(context.Context, string) (*discussion, error){hackerNewsDiscussion, lobstersDiscussion}
+	found := make([]*discussion, len(lookups))
+	errs := make([]error, len(lookups))
+	var wg sync.WaitGroup
+	for i, lookup := range lookups {
+		i, lookup := i, lookup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found[i], errs[i] = lookup(ctx, articleURL)
+		}()
+	}
+	wg.Wait()
+	var res []discussion
+	var failed []error
+	for i := range lookups {
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+		}
+		if found[i] != nil {
+			res = append(res, *found[i])
+		}
+	}
+	return res, failed
+}
+
+// Algoliaの検索APIでURLが一致する投稿のうち最もポイントの多いもの
+func hackerNewsDiscussion(ctx context.Context, articleURL string) (*discussion, error) {
+	q := url.Values{
+		"query":                        {articleURL},
+		"restrictSearchableAttributes": {"url"},
+		"tags":                         {"story"},
+	}
+	var res struct {
+		Hits []struct {
+			ObjectID    string `json:"objectID"`
+			URL         string `json:"url"`
+			Points      int    `json:"points"`
+			NumComments int    `json:"num_comments"`
+		} `json:"hits"`
+	}
+	if err := getJSON(ctx, "https://hn.algolia.com/api/v1/search?"+q.Encode(), "", &res); err != nil {
+		return nil, fmt.Errorf("hacker news: %w", err)
+	}
+	var best *discussion
+	for _, h := range res.Hits {
+		// 検索は部分一致なので同じURLだけを採る
+		if !sameArticleURL(h.URL, articleURL) {
+			continue
+		}
+		if best == nil || h.Points > best.points {
+			best = &discussion{
+				site:     "Hacker News",
+				url:      "https://news.ycombinator.com/item?id=" + h.ObjectID,
+				points:   h.Points,
+				comments: h.NumComments,
+			}
+		}
+	}
+	return best, nil
+}
+
+// LobstersのURLごとの投稿一覧のうち最もスコアの高いもの
+func lobstersDiscussion(ctx context.Context, articleURL string) (*discussion, error) {
+	var stories []struct {
+		URL          string `json:"url"`
+		Score        int    `json:"score"`
+		CommentCount int    `json:"comment_count"`
+		CommentsURL  string `json:"comments_url"`
+	}
+	if err := getJSON(ctx, "https://lobste.rs/stories/url/all.json?url="+url.QueryEscape(articleURL), "", &stories); err != nil {
+		return nil, fmt.Errorf("lobsters: %w", err)
+	}
+	var best *discussion
+	for _, s := range stories {
+		if best == nil || s.Score > best.points {
+			best = &discussion{site: "Lobsters", url: s.CommentsURL, points: s.Score, comments: s.CommentCount}
+		}
+	}
+	return best, nil
+}
+
+// スキームと末尾の/の違いを無視して比べる
+func sameArticleURL(a, b string) bool {
+	norm := func(s string) string {
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+		return strings.TrimSuffix(s, "/")
+	}
+	return norm(a) == norm(b)
+}
+
+// 議論と関連記事の一覧 (なければ空)
+// headerFormatは見出しの書式 (Slackなら"*%s*")
+func articleExtras(a article, headerFormat string) string {
+	var b strings.Builder
+	for _, d := range a.discussions {
+		fmt.Fprintf(&b, "\n💬 %s (%d points, %d comments)\n  %s", d.site, d.points, d.comments, d.url)
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString(relatedText(a, headerFormat))
+	return b.String()
+}
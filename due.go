@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 期限の何時間前にリマインドするかの既定
+const defaultRemindBefore = 24 * time.Hour
+
+// 記事の期限 (「企画会議までに読む」など)
+// 期限のある記事は通知で優先し、期限が近づくと改めて通知する
+type dueConfig struct {
+	// 期限のこれだけ前になったらリマインドする (既定は24h)
+	RemindBefore time.Duration `yaml:"remind_before"`
+}
+
+// due: 記事に期限を付ける・外す・一覧する
+//
+//	due                           期限のある記事の一覧
+//	due [-note text] <url> <when> whenは2006-01-02, "2006-01-02 15:04", 3d, 12hのいずれか
+//	due -clear <url>
+func cmdDue(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("due", flag.ExitOnError)
+	note := fs.String("note", "", "why the article has a deadline")
+	clear := fs.Bool("clear", false, "remove the deadline")
+	parseFlags(fs, args)
+	switch {
+	case fs.NArg() == 0 && !*clear:
+		return printDue(ctx)
+	case *clear && fs.NArg() == 1:
+		return execOne(ctx, "UPDATE articles SET due_at = NULL, due_note = '', due_reminded_at = NULL WHERE url = ?", fs.Arg(0))
+	case !*clear && fs.NArg() == 2:
+		due, err := parseDue(fs.Arg(1), time.Now())
+		if err != nil {
+			return err
+		}
+		// 期限を変えたらもう一度リマインドする
+		return execOne(ctx, "UPDATE articles SET due_at = ?, due_note = ?, due_reminded_at = NULL WHERE url = ?",
+			due.UTC().Format(time.RFC3339), *note, fs.Arg(0))
+	}
+	return usageErr("usage: due [-note text] <url> <when> | due -clear <url> | due")
+}
+
+// 期限を解釈する
+// 日付だけならその日の始まり (配信元のタイムゾーン) までとする
+func parseDue(s string, now time.Time) (time.Time, error) {
+	loc := sourceLocation()
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	d, err := parseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deadline %q", s)
+	}
+	return now.Add(d), nil
+}
+
+func printDue(ctx context.Context) error {
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT url, title, due_at, due_note, read FROM articles WHERE due_at IS NOT NULL ORDER BY due_at")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	now := time.Now()
+	for rows.Next() {
+		var u, title, dueAt, note string
+		var read bool
+		if err := rows.Scan(&u, &title, &dueAt, &note, &read); err != nil {
+			return err
+		}
+		mark := " "
+		if t, err := time.Parse(time.RFC3339, dueAt); err == nil {
+			dueAt = t.In(sourceLocation()).Format("2006-01-02 15:04")
+			if t.Before(now) {
+				mark = "!"
+			}
+		}
+		if note != "" {
+			note = " (" + note + ")"
+		}
+		fmt.Printf("%s %s  %s%s\n  %s\n", mark, dueAt, title, note, u)
+	}
+	return rows.Err()
+}
+
+// 期限が近づいた記事を改めて通知する
+// 記事ごとに1回だけ送り、期限を変えるとまた送る
+func remindDue(ctx context.Context, dests []destination) error {
+	before := conf.Due.RemindBefore
+	if before <= 0 {
+		before = defaultRemindBefore
+	}
+	rows, err := db.QueryContext(ctx, `
+SELECT title, url, date, due_at, due_note FROM articles
+WHERE due_at IS NOT NULL AND due_reminded_at IS NULL AND due_at <= ?
+GROUP BY url ORDER BY due_at`, time.Now().Add(before).UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	var due []article
+	for rows.Next() {
+		var a article
+		if err := rows.Scan(&a.title, &a.url, &a.date, &a.dueAt, &a.dueNote); err != nil {
+			rows.Close()
+			return err
+		}
+		a.date = dateOnly(a.date)
+		due = append(due, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, a := range due {
+		header := "期限: " + a.dueAt
+		if t, err := time.Parse(time.RFC3339, a.dueAt); err == nil {
+			header = "期限: " + t.In(sourceLocation()).Format("2006-01-02 15:04")
+		}
+		if a.dueNote != "" {
+			header += " " + a.dueNote
+		}
+		dg := &digest{groups: []digestGroup{{header: header, articles: []article{a}}}}
+		results := dispatch(ctx, dests, func(ctx context.Context, d destination) error {
+			return d.sendDigest(ctx, dg)
+		})
+		logFailures("due "+a.url, results)
+		// どこにも届かなければ次の実行でもう一度送る
+		if !anyDelivered(results) {
+			continue
+		}
+		if err := execOne(ctx, "UPDATE articles SET due_reminded_at = ? WHERE url = ?", time.Now().UTC().Format(time.RFC3339), a.url); err != nil {
+			log.Printf("due %s: %v", a.url, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+
+	"fetch-blog/store"
+)
+
+// 記事に起きた出来事の種類
+const (
+	eventAdded = store.EventAdded
+	eventRead  = store.EventRead
+	// 記事をほかの人に送った (detailは送り先とメモ)
+	eventShared = "shared"
+	// 既読の記事を未読に戻した
+	eventUnread = "unread"
+)
+
+// SQLを実行できるもの (*sql.DB, *sql.Tx)
+type execer = store.Execer
+
+// 記事の出来事を記録する
+// 記事の更新と同じトランザクションで呼ぶ
+func recordEvent(ctx context.Context, ex execer, url, typ string) error {
+	return recordEventDetail(ctx, ex, url, typ, "")
+}
+
+// 付随する情報と一緒に出来事を記録する
+func recordEventDetail(ctx context.Context, ex execer, url, typ, detail string) error {
+	return store.RecordEvent(ctx, ex, url, typ, detail)
+}
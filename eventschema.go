@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// 外に出す出来事 (JSON) の形の版
+// 受け取る側はschema_versionを見て読み方を決める
+//
+// 互換性の約束:
+//   - 同じ版の中では項目を増やすだけにする (消さない、名前や型や意味を変えない)
+//   - 受け取る側は知らない項目を無視する (JSON Schemaも追加の項目を許す)
+//   - 互換性のない変更は版を上げ、リリースノートに書く
+//   - omitemptyの項目は値がないときに省くので、受け取る側は省略を空として扱う
+const eventSchemaVersion = 1
+
+// 記事に起きた出来事
+// eventsコマンドが1行に1つずつ出力する
+type eventPayload struct {
+	// 形の版 (互換性のない変更で上がる)
+	SchemaVersion int `json:"schema_version"`
+	// 出来事の番号 (増えていくので、続きから読むときや重複を除くときに使う)
+	ID int64 `json:"id"`
+	// added, read, shared
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// 起きた日時 (RFC3339, UTC)
+	OccurredAt string `json:"occurred_at"`
+	// 付随する情報 (sharedなら送り先とメモ)
+	Detail string `json:"detail,omitempty"`
+	// 出来事の時点ではなく出力する時点の記事 (消された記事ならない)
+	Article *eventArticle `json:"article,omitempty"`
+}
+
+// 出来事の記事
+type eventArticle struct {
+	Title string `json:"title"`
+	// 一覧の日付 (YYYY-MM-DD)
+	Date string `json:"date"`
+	// 公開日時 (RFC3339, UTC)
+	PublishedAt string `json:"published_at,omitempty"`
+	// 配信元のブログの名前
+	Source     string   `json:"source,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// schema: 外に出すJSONの形を出力する
+func cmdSchema(args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return usageErr("usage: schema print")
+	}
+	docs, err := loadStructDocs()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(eventSchema(docs))
+}
+
+// 出来事のJSON Schema
+func eventSchema(docs *structDocs) map[string]any {
+	s := jsonSchema(docs, reflect.TypeOf(eventPayload{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "fetch-blog event"
+	props := s["properties"].(map[string]any)
+	props["schema_version"].(map[string]any)["const"] = eventSchemaVersion
+	props["type"].(map[string]any)["enum"] = []string{eventAdded, eventRead, eventShared, eventUnread}
+	return s
+}
+
+// JSONのタグからJSON Schemaを作る
+// 版の中で項目が増えても受け取れるように追加の項目を許す
+func jsonSchema(docs *structDocs, t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			p := jsonSchema(docs, f.Type)
+			if doc := docs.field(t, f); doc != "" {
+				p["description"] = doc
+			}
+			props[name] = p
+			if opts != "omitempty" {
+				required = append(required, name)
+			}
+		}
+		s := map[string]any{"type": "object", "properties": props, "required": required, "additionalProperties": true}
+		if doc := docs.types[t.Name()]; doc != "" {
+			s["description"] = doc
+		}
+		return s
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": jsonSchema(docs, t.Elem())}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{"type": "string"}
+}
+
+// events: 記事の出来事をJSON Lines (1行に1つのeventPayload) で出力する
+// 続きから読むときは最後に読んだidを-afterに渡す
+func cmdEvents(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	after := fs.Int64("after", 0, "only print events with a larger id")
+	limit := fs.Int("limit", 1000, "maximum number of events to print")
+	parseFlags(fs, args)
+	if fs.NArg() > 0 {
+		return usageErr("usage: events [-after id] [-limit n]")
+	}
+	rows, err := db.QueryContext(ctx, `
+SELECT e.id, e.url, e.type, e.created_at, e.detail, a.rowid IS NOT NULL,
+       COALESCE(a.title, ''), COALESCE(substr(a.date, 1, 10), ''), COALESCE(a.published_at, ''), COALESCE(a.source, ''),
+       COALESCE((SELECT group_concat(category, ',') FROM article_categories c WHERE c.url = e.url), '')
+FROM events e
+LEFT JOIN articles a ON a.rowid = (SELECT MAX(rowid) FROM articles WHERE url = e.url)
+WHERE e.id > ? ORDER BY e.id LIMIT ?`, *after, *limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	enc := json.NewEncoder(os.Stdout)
+	for rows.Next() {
+		e := eventPayload{SchemaVersion: eventSchemaVersion}
+		var found bool
+		var a eventArticle
+		var categories string
+		if err := rows.Scan(&e.ID, &e.URL, &e.Type, &e.OccurredAt, &e.Detail, &found, &a.Title, &a.Date, &a.PublishedAt, &a.Source, &categories); err != nil {
+			return err
+		}
+		if found {
+			if categories != "" {
+				a.Categories = strings.Split(categories, ",")
+			}
+			e.Article = &a
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
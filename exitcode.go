@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// 終了コード
+// cronやsystemdのラッパーが結果によって分岐できるようにする
+//
+//	0  すべて成功
+//	1  その他のエラー
+//	2  一部の通知先や記事ページで失敗した
+//	3  設定ファイルの誤り
+//	4  DBのエラー
+//	5  通知がどこにも届かなかった
+//	6  指定した記事やブログがない
+//	7  記事一覧の取得に失敗した
+//	8  コマンドの使い方の誤り (知らないコマンドや引数)
+//	130  SIGINTかSIGTERMで中断した
+const (
+	exitOK           = 0
+	exitError        = 1
+	exitPartial      = 2
+	exitConfig       = 3
+	exitDB           = 4
+	exitNotifyFailed = 5
+	exitNotFound     = 6
+	exitFetchFailed  = 7
+	exitUsage        = 8
+	exitInterrupted  = 130
+)
+
+var (
+	// 指定した記事やブログがない
+	errNotFound = errors.New("not found")
+	// 記事一覧を取得できなかった (通信、応答、解析の失敗)
+	errFetchFailed = errors.New("fetch failed")
+)
+
+// コマンドの使い方の誤り
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func usageErr(msg string) error { return &usageError{msg: msg} }
+
+// 終了コードを決めたエラー (設定やDBを開くときの失敗)
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// サブコマンドの引数を読む
+// flagのExitOnErrorは誤った引数で2 (一部の失敗と同じ) で終了するので、exitUsageで終了する
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Init(fs.Name(), flag.ContinueOnError)
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitOK)
+	}
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+}
+
+// エラーを出力して終了コードを返す
+func fail(err error) int {
+	log.Print(err)
+	return exitCodeOf(err)
+}
+
+const exitCodesHelp = `
+Exit codes:
+  0  success
+  1  other error
+  2  partial failure (some destinations or article pages failed)
+  3  configuration error
+  4  database error
+  5  every notification failed
+  6  the given article or source was not found
+  7  fetching an article list failed
+  8  invalid usage (unknown command or arguments)
+  130  interrupted by SIGINT or SIGTERM
+`
+
+// エラーの種類から終了コードを決める
+func exitCodeOf(err error) int {
+	var sqliteErr sqlite3.Error
+	var usage *usageError
+	var coded *codedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.As(err, &sqliteErr):
+		return exitDB
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, errNotFound):
+		return exitNotFound
+	case errors.Is(err, errFetchFailed):
+		return exitFetchFailed
+	}
+	return exitError
+}
+
+// 1回の実行で起きた失敗の集計
+type runReport struct {
+	mu        sync.Mutex
+	delivered int
+	failed    int
+	errors    int
+}
+
+// 実行全体の集計
+var report runReport
+
+// 通知の結果を集計する (送らなかったものは数えない)
+func (r *runReport) addResults(results []deliveryResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, res := range results {
+		switch {
+		case res.err == nil:
+			r.delivered++
+		case errors.Is(res.err, errSkipped), errors.Is(res.err, errAlreadyDelivered):
+		default:
+			r.failed++
+		}
+	}
+}
+
+// 通知以外の失敗 (記事ページの取得など) を数える
+func (r *runReport) addError() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors++
+}
+
+// serveの定期実行で、実行ごとに数え直す
+func (r *runReport) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.delivered, r.failed, r.errors = 0, 0, 0
+}
+
+func (r *runReport) exitCode() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.failed > 0 && r.delivered == 0:
+		return exitNotifyFailed
+	case r.failed > 0 || r.errors > 0:
+		return exitPartial
+	}
+	return exitOK
+}
+
+func (r *runReport) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprintf("%d delivered, %d failed deliveries, %d other errors", r.delivered, r.failed, r.errors)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestExitCodeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"other", errors.New("boom"), exitError},
+		{"usage", usageErr("usage: diff <url|id>"), exitUsage},
+		{"wrapped usage", fmt.Errorf("diff: %w", usageErr("usage")), exitUsage},
+		{"config", withExitCode(exitConfig, errors.New("bad yaml")), exitConfig},
+		{"coded wins over the wrapped error", withExitCode(exitDB, errNotFound), exitDB},
+		{"not found", fmt.Errorf("x: %w", errNotFound), exitNotFound},
+		{"fetch failed", fmt.Errorf("x: %w", errFetchFailed), exitFetchFailed},
+		{"sqlite", sqlite3.Error{Code: sqlite3.ErrBusy}, exitDB},
+		{"canceled", fmt.Errorf("x: %w", context.Canceled), exitInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeOf(tt.err); got != tt.want {
+				t.Errorf("exitCodeOf(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageIsNotPartialFailure(t *testing.T) {
+	if exitUsage == exitPartial || exitConfig == exitPartial {
+		t.Fatalf("usage (%d) and config (%d) errors must not share the partial failure code %d", exitUsage, exitConfig, exitPartial)
+	}
+	if withExitCode(exitConfig, nil) != nil {
+		t.Error("withExitCode(nil) should stay nil")
+	}
+}
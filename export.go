@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 共有用ファイルのスキーマの版
+// 列を変えるときは上げ、既存の列の意味は変えない
+const shareSchemaVersion = 1
+
+// 共有用ファイルのスキーマ
+// 認証情報や通知の記録などの内部のテーブルは含めない
+const shareSchema = `
+CREATE TABLE share.meta (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+CREATE TABLE share.articles (
+    url TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    date TEXT NOT NULL,
+    published_at TEXT,
+    read INTEGER NOT NULL,
+    read_at TEXT,
+    paywalled INTEGER NOT NULL
+);
+CREATE TABLE share.article_tags (
+    url TEXT NOT NULL REFERENCES articles (url),
+    tag TEXT NOT NULL,
+    PRIMARY KEY (url, tag)
+);
+`
+
+// export: 記事を他のツールで読める形式で書き出す
+// sqlite以外は出力先を省くと標準出力に書く
+func cmdExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "", "output format (sqlite, json, csv or markdown)")
+	force := fs.Bool("force", false, "overwrite the output file if it exists")
+	read := fs.String("read", "", "filter by read state (true or false)")
+	source := fs.String("source", "", "filter by blog host")
+	since := fs.String("since", "", "only articles published on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only articles published on or before this date (YYYY-MM-DD)")
+	parseFlags(fs, args)
+	out := fs.Arg(0)
+	if *format == "sqlite" && out == "" {
+		return usageErr("usage: export -format sqlite|json|csv|markdown [-read true|false] [-source host] [-since date] [-until date] [-force] [out]")
+	}
+	// 確認待ちや絞り込みで除いた記事は書き出さない
+	f := articleFilter{status: statusOK, source: *source, since: *since, until: *until}
+	for _, d := range []string{*since, *until} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return fmt.Errorf("invalid date %q: want YYYY-MM-DD", d)
+		}
+	}
+	switch *read {
+	case "":
+	case "true":
+		f.read = boolPtr(true)
+	case "false":
+		f.read = boolPtr(false)
+	default:
+		return fmt.Errorf("invalid -read value %q", *read)
+	}
+	var write func(io.Writer, []article) error
+	switch *format {
+	case "sqlite":
+		return exportSQLite(ctx, out, *force, f)
+	case "json":
+		write = writeArticlesJSON
+	case "csv":
+		write = writeArticlesCSV
+	case "markdown":
+		write = writeArticlesMarkdown
+	default:
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+	articles, err := queryArticles(ctx, f)
+	if err != nil {
+		return err
+	}
+	if out == "" || out == "-" {
+		return write(os.Stdout, articles)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(out, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists (use -force to overwrite)", out)
+	}
+	if err != nil {
+		return err
+	}
+	if err := write(file, articles); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d articles to %s\n", len(articles), out)
+	return nil
+}
+
+// JSONで書き出す記事
+type exportedArticle struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	// 一覧の日付 (YYYY-MM-DD)
+	Date string `json:"date"`
+	// 公開日時 (RFC3339, UTC)
+	PublishedAt string   `json:"published_at,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Read        bool     `json:"read"`
+	Categories  []string `json:"categories,omitempty"`
+}
+
+func writeArticlesJSON(w io.Writer, articles []article) error {
+	res := make([]exportedArticle, 0, len(articles))
+	for _, a := range articles {
+		res = append(res, exportedArticle{Title: a.title, URL: a.url, Date: a.date, PublishedAt: a.publishedAt,
+			Source: a.source, Read: a.read, Categories: a.categories})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// 分類は;区切りの1列にする
+func writeArticlesCSV(w io.Writer, articles []article) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"title", "url", "date", "published_at", "source", "read", "categories"})
+	for _, a := range articles {
+		cw.Write([]string{a.title, a.url, a.date, a.publishedAt, a.source, strconv.FormatBool(a.read), strings.Join(a.categories, ";")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 未読と既読に分けたチェックリスト
+func writeArticlesMarkdown(w io.Writer, articles []article) error {
+	var b strings.Builder
+	b.WriteString("# Reading list\n")
+	for _, read := range []bool{false, true} {
+		heading := "\n## Unread\n\n"
+		mark := " "
+		if read {
+			heading = "\n## Read\n\n"
+			mark = "x"
+		}
+		b.WriteString(heading)
+		for _, a := range articles {
+			if a.read != read {
+				continue
+			}
+			fmt.Fprintf(&b, "- [%s] [%s](%s) (%s", mark, markdownEscaper.Replace(a.title), strings.ReplaceAll(a.url, ")", "%29"), a.date)
+			if a.source != "" {
+				b.WriteString(", " + a.source)
+			}
+			b.WriteString(")\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// リンクの文字列で意味を持つ記号
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`, "`", "\\`")
+
+// 条件に合う記事と分類 (タグ) だけを別のSQLiteファイルに書き出す
+func exportSQLite(ctx context.Context, out string, force bool, f articleFilter) error {
+	if _, err := os.Stat(out); err == nil {
+		if !force {
+			return fmt.Errorf("%s already exists (use -force to overwrite)", out)
+		}
+		if err := os.Remove(out); err != nil {
+			return err
+		}
+	}
+	// ATTACHは接続ごとなので1つの接続で行う
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS share", out); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE share")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, shareSchema); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO share.meta (key, value) VALUES ('schema_version', ?), ('exported_at', ?)",
+		fmt.Sprint(shareSchemaVersion), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	// 同じURLの記事が複数あれば最後に保存したものを使う
+	q := f.query()
+	q.columns, q.order = []string{"rowid"}, ""
+	cond, cargs := q.build()
+	res, err := tx.ExecContext(ctx, `
+INSERT OR REPLACE INTO share.articles (url, title, date, published_at, read, read_at, paywalled)
+SELECT url, title, substr(date, 1, 10), published_at, read, read_at, paywalled
+FROM articles
+WHERE rowid IN (`+cond+`)
+ORDER BY rowid`, cargs...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT OR IGNORE INTO share.article_tags (url, tag)
+SELECT c.url, c.category FROM article_categories c
+WHERE c.url IN (SELECT url FROM share.articles)`)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d articles to %s\n", n, out)
+	return nil
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// 記事ページの本文をテキストにする
+// 段落や見出しは行を分け、画像は代替テキストを ![alt](src) として、図のキャプションは *caption* の行として残す
+// 画像の多い記事でも検索や要約で図の内容を失わないようにする
+// コードブロックは言語名を付けたMarkdownのフェンスにして、空白をそのまま残す
+// 数式 (MathJax, KaTeX, MathML) は元のLaTeXを $...$ と $$...$$ で囲んで残す
+func extractText(s *goquery.Selection) string {
+	w := &textWriter{}
+	for _, n := range s.Nodes {
+		w.node(n)
+	}
+	return strings.TrimSpace(w.b.String())
+}
+
+// 本文の要素 (article、main、なければbody)
+func contentRoot(doc *goquery.Document) *goquery.Selection {
+	for _, sel := range []string{"article", "main", `[role="main"]`} {
+		if s := doc.Find(sel).First(); s.Length() > 0 {
+			return s
+		}
+	}
+	return doc.Find("body")
+}
+
+// 文字を含まない要素
+var skippedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Svg: true, atom.Iframe: true, atom.Head: true,
+}
+
+// 前後で行を分ける要素
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Blockquote: true, atom.Pre: true, atom.Table: true, atom.Tr: true,
+	atom.Figure: true, atom.Figcaption: true, atom.Header: true, atom.Footer: true, atom.Hr: true,
+}
+
+type textWriter struct {
+	b strings.Builder
+	// 次の文字の前に空白か改行を入れる
+	space, newline bool
+}
+
+func (w *textWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	case html.DocumentNode:
+		w.children(n)
+		return
+	default:
+		return
+	}
+	// 数式はscriptやMathMLの中にあるので、読み飛ばす要素より先に見る
+	if tex, display, ok := mathSource(n); ok {
+		w.math(tex, display)
+		return
+	}
+	if skippedElements[n.DataAtom] || hasClass(n, renderedMathClasses...) {
+		return
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		w.newline = true
+		return
+	case atom.Img:
+		w.image(n)
+		return
+	case atom.Pre:
+		w.codeBlock(n)
+		return
+	case atom.Code, atom.Kbd, atom.Samp:
+		// 行中のコード
+		var c strings.Builder
+		codeText(&c, n)
+		if code := strings.TrimSpace(c.String()); code != "" {
+			w.raw(inlineCode(code))
+		}
+		return
+	case atom.Figcaption:
+		// キャプションは図の下の独立した行にする
+		var c textWriter
+		c.children(n)
+		if caption := strings.TrimSpace(c.b.String()); caption != "" {
+			w.newline = true
+			w.raw("*" + strings.Join(strings.Fields(caption), " ") + "*")
+			w.newline = true
+		}
+		return
+	}
+	if blockElements[n.DataAtom] {
+		w.newline = true
+		w.children(n)
+		w.newline = true
+		return
+	}
+	w.children(n)
+}
+
+func (w *textWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+// 代替テキストのない画像は装飾として飛ばす
+func (w *textWriter) image(n *html.Node) {
+	var alt, src string
+	for _, a := range n.Attr {
+		switch a.Key {
+		case "alt":
+			alt = strings.Join(strings.Fields(a.Val), " ")
+		case "src":
+			src = a.Val
+		}
+	}
+	if alt == "" {
+		return
+	}
+	w.raw("![" + strings.NewReplacer("[", `\[`, "]", `\]`).Replace(alt) + "](" + src + ")")
+}
+
+func (w *textWriter) text(s string) {
+	if s == "" {
+		return
+	}
+	// 前後の空白は1つの空白にまとめる
+	if strings.TrimLeft(s, " \t\r\n") != s {
+		w.space = true
+	}
+	fields := strings.Fields(s)
+	for i, f := range fields {
+		if i > 0 {
+			w.space = true
+		}
+		w.raw(f)
+	}
+	if len(fields) > 0 && strings.TrimRight(s, " \t\r\n") != s {
+		w.space = true
+	}
+}
+
+// 区切りを入れてから書く
+func (w *textWriter) raw(s string) {
+	if w.b.Len() > 0 {
+		switch {
+		case w.newline:
+			w.b.WriteString("\n")
+		case w.space:
+			w.b.WriteString(" ")
+		}
+	}
+	w.space, w.newline = false, false
+	w.b.WriteString(s)
+}
+
+// コードブロックをフェンスで囲む
+func (w *textWriter) codeBlock(n *html.Node) {
+	var c strings.Builder
+	codeText(&c, n)
+	code := strings.Trim(c.String(), "\n")
+	if strings.TrimSpace(code) == "" {
+		return
+	}
+	// コードに含まれるより長いフェンスにする
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	w.newline = true
+	w.raw(fence + codeLanguage(n) + "\n" + code + "\n" + fence)
+	w.newline = true
+}
+
+// 行番号の列 (ハイライトのライブラリが付ける)
+var lineNumberClasses = []string{"lineno", "line-number", "line-numbers-rows", "gutter", "linenos"}
+
+// コードの文字をそのまま集める (<br>は改行)
+func codeText(b *strings.Builder, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			b.WriteString(c.Data)
+		case html.ElementNode:
+			if c.DataAtom == atom.Br {
+				b.WriteString("\n")
+				continue
+			}
+			if skippedElements[c.DataAtom] || hasClass(c, lineNumberClasses...) {
+				continue
+			}
+			codeText(b, c)
+		}
+	}
+}
+
+// preか中のcodeのclass (language-go, lang-go, highlight-go, brush: go) かdata-langから言語名を取る
+func codeLanguage(pre *html.Node) string {
+	nodes := []*html.Node{pre}
+	for c := pre.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Code {
+			nodes = append([]*html.Node{c}, nodes...)
+		}
+	}
+	for _, n := range nodes {
+		for _, a := range n.Attr {
+			switch a.Key {
+			case "data-lang", "data-language":
+				if v := strings.TrimSpace(a.Val); v != "" {
+					return strings.ToLower(v)
+				}
+			case "class":
+				fields := strings.Fields(a.Val)
+				for i, f := range fields {
+					for _, p := range []string{"language-", "lang-", "highlight-source-", "highlight-"} {
+						if lang, ok := strings.CutPrefix(f, p); ok && lang != "" {
+							return strings.ToLower(lang)
+						}
+					}
+					if f == "brush:" && i+1 < len(fields) {
+						return strings.ToLower(strings.TrimSuffix(fields[i+1], ";"))
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, names ...string) bool {
+	for _, a := range n.Attr {
+		if a.Key != "class" {
+			continue
+		}
+		for _, f := range strings.Fields(a.Val) {
+			for _, name := range names {
+				if f == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// 中のバッククォートより長いバッククォートで囲む
+func inlineCode(code string) string {
+	ticks := "`"
+	for strings.Contains(code, ticks) {
+		ticks += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		return ticks + " " + code + " " + ticks
+	}
+	return ticks + code + ticks
+}
+
+// MathJax 2が描画した数式 (元のLaTeXはmath/texのscriptに残っている)
+var renderedMathClasses = []string{"MathJax", "MathJax_Preview", "MathJax_Display", "MathJax_SVG", "MathJax_SVG_Display", "MathJax_CHTML"}
+
+// 数式の要素ならLaTeXと別行立てかを返す
+//
+//	MathJax 2:  <script type="math/tex; mode=display">
+//	KaTeX:      <span class="katex"> (<span class="katex-display">なら別行立て) の中のannotation
+//	MathJax 3:  <mjx-container display="true"> の中のMathML
+//	MathML:     <math> のannotationかalttext
+func mathSource(n *html.Node) (tex string, display, ok bool) {
+	switch {
+	case n.DataAtom == atom.Script:
+		typ := strings.ToLower(attr(n, "type"))
+		if !strings.HasPrefix(typ, "math/tex") {
+			return "", false, false
+		}
+		var b strings.Builder
+		codeText(&b, n)
+		return strings.TrimSpace(b.String()), strings.Contains(typ, "mode=display"), true
+	case hasClass(n, "katex-display"):
+		tex, ok := texAnnotation(n)
+		return tex, true, ok
+	case hasClass(n, "katex"):
+		tex, ok := texAnnotation(n)
+		return tex, false, ok
+	case n.Data == "mjx-container":
+		display = attr(n, "display") == "true"
+		if tex, ok := texAnnotation(n); ok {
+			return tex, display, true
+		}
+		// LaTeXがなければ読み上げ用のMathMLの文字を使う
+		if m := findElement(n, "math"); m != nil {
+			return mathText(m), display, true
+		}
+	case n.DataAtom == atom.Math:
+		display = attr(n, "display") == "block"
+		if tex, ok := texAnnotation(n); ok {
+			return tex, display, true
+		}
+		if alt := strings.TrimSpace(attr(n, "alttext")); alt != "" {
+			return alt, display, true
+		}
+		return mathText(n), display, true
+	}
+	return "", false, false
+}
+
+// <annotation encoding="application/x-tex">の中身
+func texAnnotation(n *html.Node) (string, bool) {
+	var found string
+	var walk func(*html.Node) bool
+	walk = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == "annotation" && attr(n, "encoding") == "application/x-tex" {
+			var b strings.Builder
+			codeText(&b, n)
+			found = strings.TrimSpace(b.String())
+			return true
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if walk(c) {
+				return true
+			}
+		}
+		return false
+	}
+	return found, walk(n) && found != ""
+}
+
+// MathMLの文字をつなげる (annotationは除く)
+func mathText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "annotation" || c.Data == "annotation-xml") {
+				continue
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func findElement(n *html.Node, name string) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == name {
+			return c
+		}
+		if f := findElement(c, name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// 数式をLaTeXの区切りで囲む
+func (w *textWriter) math(tex string, display bool) {
+	if tex == "" {
+		return
+	}
+	if display {
+		w.newline = true
+		w.raw("$$" + tex + "$$")
+		w.newline = true
+		return
+	}
+	w.raw("$" + tex + "$")
+}
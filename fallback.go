@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"fetch-blog/fetcher"
+)
+
+// 既定で何回続けて失敗したらフィードに切り替えるか
+const defaultFallbackAfter = 3
+
+// User-Agentを順に替え、リクエストの前にランダムに待つ
+type rotatingTransport struct {
+	base       http.RoundTripper
+	userAgents []string
+	jitter     time.Duration
+}
+
+func (t *rotatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.jitter > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(t.jitter)))):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if len(t.userAgents) > 0 {
+		// RoundTripperはリクエストを変更してはいけないので複製する
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgents[rand.Intn(len(t.userAgents))])
+	}
+	return t.base.RoundTrip(req)
+}
+
+// ブログの記事一覧を取得する
+// 直接の取得が続けて失敗していればフィードで代わりに取得する
+// writeがfalseなら (fetch -diff) Cookieも失敗回数も保存しない
+func fetchListing(ctx context.Context, b blog, write bool) ([]article, error) {
+	if b.cfg.Mode == sourceModeFeed {
+		return fetchFeedListing(ctx, b, write)
+	}
+	articles, err := scrapeArticles(ctx, b, write)
+	if err == nil {
+		tagSource(articles, b)
+		log.Printf("fetched %s directly (%d articles)", b.name(), len(articles))
+		if write {
+			if err := recordListingResult(ctx, b, nil); err != nil {
+				return nil, err
+			}
+		}
+		return articles, nil
+	}
+	if write {
+		recordFetchError(ctx, b.name(), b.url, err)
+		if rerr := recordListingResult(ctx, b, err); rerr != nil {
+			return nil, rerr
+		}
+	}
+	failures, rerr := listingFailures(ctx, b)
+	if rerr != nil {
+		return nil, rerr
+	}
+	if !write {
+		// 今回の失敗は記録していない
+		failures++
+	}
+	if b.cfg.FeedURL == "" || failures < b.cfg.FallbackAfter {
+		return nil, err
+	}
+	log.Printf("fetch %s: %v (%d consecutive failures, falling back to feed %s)", b.name(), err, failures, b.cfg.FeedURL)
+	articles, ferr := fetchFeed(ctx, b, b.cfg.FeedURL)
+	if ferr != nil {
+		if write {
+			recordFetchError(ctx, b.name(), b.cfg.FeedURL, ferr)
+		}
+		return nil, fmt.Errorf("%w; feed fallback: %v", err, ferr)
+	}
+	log.Printf("fetched %s via feed (%d articles)", b.name(), len(articles))
+	tagSource(articles, b)
+	return articles, nil
+}
+
+// 記事に配信元のブログを記録する
+func tagSource(articles []article, b blog) {
+	for i := range articles {
+		articles[i].source = b.name()
+	}
+}
+
+// フィードだけで取得するブログ (mode: feed)
+// feed_urlがなければurlをフィードとして読む
+func fetchFeedListing(ctx context.Context, b blog, write bool) ([]article, error) {
+	feedURL := b.cfg.FeedURL
+	if feedURL == "" {
+		feedURL = b.url
+	}
+	articles, err := fetchFeed(ctx, b, feedURL)
+	if err == nil && len(articles) == 0 {
+		err = &fetchError{kind: fetchErrParseEmpty, err: fmt.Errorf("no entries found in %s", feedURL)}
+	}
+	if err != nil {
+		if write {
+			recordFetchError(ctx, b.name(), feedURL, err)
+		}
+		return nil, err
+	}
+	log.Printf("fetched %s via feed (%d articles)", b.name(), len(articles))
+	tagSource(articles, b)
+	return articles, nil
+}
+
+// 直接の取得の結果を記録する (成功なら連続失敗をリセット)
+func recordListingResult(ctx context.Context, b blog, err error) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err == nil {
+		_, err := db.ExecContext(ctx, "DELETE FROM source_failures WHERE source = ?", b.name())
+		return err
+	}
+	_, dberr := db.ExecContext(ctx, `
+INSERT INTO source_failures (source, consecutive, last_error, updated_at) VALUES (?, 1, ?, ?)
+ON CONFLICT (source) DO UPDATE SET consecutive = consecutive + 1, last_error = excluded.last_error, updated_at = excluded.updated_at`,
+		b.name(), err.Error(), now)
+	return dberr
+}
+
+// 直接の取得が続けて失敗している回数
+func listingFailures(ctx context.Context, b blog) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(consecutive), 0) FROM source_failures WHERE source = ?", b.name()).Scan(&n)
+	return n, err
+}
+
+// フィードから記事を取得する
+func fetchFeed(ctx context.Context, b blog, feedURL string) ([]article, error) {
+	client, err := newSourceClient(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(withoutRender(ctx), http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &fetcher.StatusError{Code: resp.StatusCode}
+	}
+	items, err := fetcher.ParseFeed(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	articles := feedArticles(items, b.location())
+	tagProvenance(articles, provenance{Via: viaFeed, FeedURL: feedURL})
+	return articles, nil
+}
+
+// フィードの項目を記事にする (フィードの日時は時刻まで正確、日付はlocで決める)
+func feedArticles(items []fetcher.Item, loc *time.Location) []article {
+	articles := make([]article, 0, len(items))
+	for _, it := range items {
+		articles = append(articles, article{
+			title:       it.Title,
+			url:         it.URL,
+			date:        it.Date.In(loc).Format("2006-01-02"),
+			publishedAt: it.Date.UTC().Format(time.RFC3339),
+		})
+	}
+	return articles
+}
@@ -0,0 +1,119 @@
+package fetcher
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// RSS 2.0とAtomの必要な部分
+type feedDocument struct {
+	Channel struct {
+		Items []struct {
+			Title   string `xml:"title"`
+			Link    string `xml:"link"`
+			PubDate string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+	Entries []struct {
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+// RSSかAtomのフィードを解析する
+// 日時かリンクのない項目は飛ばす。ItemのDateは時刻を含む
+func ParseFeed(r io.Reader) ([]Item, error) {
+	var doc feedDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse feed: %w", err)
+	}
+	var items []Item
+	add := func(title, link, published string) {
+		t, ok := ParseFeedTime(published)
+		link = strings.TrimSpace(link)
+		if !ok || link == "" {
+			return
+		}
+		items = append(items, Item{Title: strings.TrimSpace(title), URL: link, Date: t})
+	}
+	for _, it := range doc.Channel.Items {
+		add(it.Title, it.Link, it.PubDate)
+	}
+	for _, e := range doc.Entries {
+		var link string
+		for _, l := range e.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = l.Href
+				break
+			}
+		}
+		published := e.Published
+		if published == "" {
+			published = e.Updated
+		}
+		add(e.Title, link, published)
+	}
+	return items, nil
+}
+
+// フィードの日時 (RSSはRFC 1123、AtomはRFC 3339)
+func ParseFeedTime(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 応答がフィードかどうか (Content-Typeか本文の先頭で判断する)
+func LooksLikeFeed(contentType string, body []byte) bool {
+	ct := strings.ToLower(contentType)
+	if strings.Contains(ct, "rss") || strings.Contains(ct, "atom") {
+		return true
+	}
+	if strings.Contains(ct, "html") {
+		return false
+	}
+	head := body
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	return bytes.Contains(head, []byte("<rss")) || bytes.Contains(head, []byte("<feed"))
+}
+
+// HTMLの<link rel="alternate">からフィードのURLを探す (なければ空)
+func DiscoverFeed(doc *goquery.Document, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	var found string
+	doc.Find(`link[rel="alternate"]`).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		typ, _ := s.Attr("type")
+		href, ok := s.Attr("href")
+		if !ok || (typ != "application/rss+xml" && typ != "application/atom+xml") {
+			return true
+		}
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return true
+		}
+		found = base.ResolveReference(ref).String()
+		return false
+	})
+	return found
+}
@@ -0,0 +1,73 @@
+package fetcher
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name string
+		feed string
+		want []Item
+	}{
+		{
+			name: "rss",
+			feed: `<rss version="2.0"><channel>
+<item><title> A </title><link>https://example.com/a</link><pubDate>Thu, 01 Jan 2026 09:00:00 +0900</pubDate></item>
+<item><title>no date</title><link>https://example.com/b</link></item>
+<item><title>no link</title><pubDate>Thu, 01 Jan 2026 09:00:00 +0900</pubDate></item>
+</channel></rss>`,
+			want: []Item{{Title: "A", URL: "https://example.com/a", Date: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "atom",
+			feed: `<feed xmlns="http://www.w3.org/2005/Atom">
+<entry><title>A</title><link rel="self" href="https://example.com/a.atom"/><link href="https://example.com/a"/><published>2026-01-01T00:00:00Z</published></entry>
+<entry><title>B</title><link rel="alternate" href="https://example.com/b"/><updated>2026-01-02T00:00:00Z</updated></entry>
+</feed>`,
+			want: []Item{
+				{Title: "A", URL: "https://example.com/a", Date: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{Title: "B", URL: "https://example.com/b", Date: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFeed(strings.NewReader(tt.feed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d items, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i].Title != tt.want[i].Title || got[i].URL != tt.want[i].URL || !got[i].Date.Equal(tt.want[i].Date) {
+					t.Errorf("item %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+	if _, err := ParseFeed(strings.NewReader("<rss><channel>")); err == nil {
+		t.Error("ParseFeed accepted a broken feed")
+	}
+}
+
+func TestLooksLikeFeed(t *testing.T) {
+	tests := []struct {
+		contentType, body string
+		want              bool
+	}{
+		{"application/rss+xml", "", true},
+		{"application/atom+xml; charset=utf-8", "", true},
+		{"text/html", "<rss>", false},
+		{"application/xml", `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom">`, true},
+		{"application/xml", "<urlset>", false},
+	}
+	for _, tt := range tests {
+		if got := LooksLikeFeed(tt.contentType, []byte(tt.body)); got != tt.want {
+			t.Errorf("LooksLikeFeed(%q, %q) = %v, want %v", tt.contentType, tt.body, got, tt.want)
+		}
+	}
+}
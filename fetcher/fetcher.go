@@ -0,0 +1,255 @@
+// Package fetcher はブログの記事一覧を取得して解析する
+//
+// ログインやCookieの保存、DBへの記録は呼び出し側で行う
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// 一覧に記事が1件もない (一覧の構造が変わったか、ブロック用のページが返ってきた)
+var ErrNoItems = errors.New("no articles found")
+
+// 一覧の記事
+type Item struct {
+	Title string
+	URL   string
+	// 一覧に書かれた日付 (時刻なし、UTC)
+	Date time.Time
+}
+
+// 一覧の要素は読めたが記事として扱えなかった
+type ItemError struct {
+	Href string
+	Err  error
+}
+
+func (e *ItemError) Error() string { return fmt.Sprintf("%s: %v", e.Href, e.Err) }
+func (e *ItemError) Unwrap() error { return e.Err }
+
+// 200以外の応答
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string { return fmt.Sprintf("status code %d", e.Code) }
+
+// 一覧から記事を取り出すCSSセレクタ
+// 空の項目は既定 (.article-list li、aのhrefとtitle属性、.dateの2006.01.02) を使う
+type Selectors struct {
+	// 一覧の要素
+	List string
+	// 一覧の中の記事ごとの要素
+	Item string
+	// 記事へのリンク (href属性)
+	Link string
+	// タイトル (空ならリンクのtitle属性、なければリンクの文字列)
+	Title string
+	// タイトルを読む属性 (Titleの要素、なければリンクの属性。空なら文字列)
+	TitleAttr string
+	Date      string
+	// 日付を読む属性 (例: <time datetime>のdatetime、空なら文字列)
+	DateAttr string
+	// Dateの書式 (Goのtime.Parseのレイアウト)
+	DateFormat string
+	// 次の一覧のページへのリンク (href属性、空ならrel="next")
+	Next string
+}
+
+func (s Selectors) withDefaults() Selectors {
+	if s.List == "" {
+		s.List = ".article-list"
+	}
+	if s.Item == "" {
+		s.Item = "li"
+	}
+	if s.Link == "" {
+		s.Link = "a"
+	}
+	if s.Date == "" {
+		s.Date = ".date"
+	}
+	if s.DateFormat == "" {
+		s.DateFormat = "2006.01.02"
+	}
+	if s.Next == "" {
+		s.Next = `link[rel="next"], a[rel="next"]`
+	}
+	return s
+}
+
+// 一覧のページから記事を取り出すもの
+// 独自の取得方法 (ヘッドレスブラウザやAPIなど) はこれを実装する
+type Fetcher interface {
+	Fetch(ctx context.Context, listURL string, sel Selectors) ([]Item, []error, error)
+}
+
+// HTTPで一覧のページを取得するFetcher
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, listURL string, sel Selectors) ([]Item, []error, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return Fetch(ctx, client, listURL, sel)
+}
+
+// 一覧のページを取得して解析する
+func Fetch(ctx context.Context, client *http.Client, listURL string, sel Selectors) ([]Item, []error, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetch %s: %w", listURL, &StatusError{Code: resp.StatusCode})
+	}
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	items, skipped := ParseList(doc, listURL, sel)
+	if len(items) == 0 && len(skipped) == 0 {
+		return nil, nil, fmt.Errorf("%s: %w", listURL, ErrNoItems)
+	}
+	return items, skipped, nil
+}
+
+// セレクタを解釈できるか確かめる
+func (s Selectors) Validate() error {
+	s = s.withDefaults()
+	for _, f := range []struct{ name, sel string }{
+		{"list", s.List}, {"item", s.Item}, {"link", s.Link}, {"title", s.Title}, {"date", s.Date}, {"next", s.Next},
+	} {
+		if f.sel == "" {
+			continue
+		}
+		if _, err := cascadia.ParseGroup(f.sel); err != nil {
+			return fmt.Errorf("%s: invalid selector %q: %w", f.name, f.sel, err)
+		}
+	}
+	return nil
+}
+
+// 一覧の要素から記事を取り出す
+// 日付やURLが読めない要素は飛ばし、その理由をskippedで返す
+func ParseList(doc *goquery.Document, baseURL string, sel Selectors) (items []Item, skipped []error) {
+	// 既定の一覧は/articles/xxxのhrefを一覧のURLにつなげる (次のページのリンクは関係しない)
+	legacy := sel == Selectors{Next: sel.Next}
+	sel = sel.withDefaults()
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, []error{err}
+	}
+	doc.Find(sel.List).Each(func(i int, s *goquery.Selection) {
+		s.Find(sel.Item).Each(func(j int, s *goquery.Selection) {
+			link := s.Find(sel.Link).First()
+			href, _ := link.Attr("href")
+			var title string
+			titleSel := link
+			if sel.Title != "" {
+				titleSel = s.Find(sel.Title).First()
+			}
+			switch {
+			case sel.TitleAttr != "":
+				title, _ = titleSel.Attr(sel.TitleAttr)
+			case sel.Title != "":
+				title = titleSel.Text()
+			default:
+				if title, _ = link.Attr("title"); title == "" {
+					title = link.Text()
+				}
+			}
+			dateSel := s.Find(sel.Date).First()
+			date := dateSel.Text()
+			if sel.DateAttr != "" {
+				date, _ = dateSel.Attr(sel.DateAttr)
+			}
+			t, err := time.Parse(sel.DateFormat, strings.TrimSpace(date))
+			if err != nil {
+				skipped = append(skipped, &ItemError{Href: href, Err: err})
+				return
+			}
+			var endpoint string
+			if legacy {
+				endpoint, err = url.JoinPath(baseURL, strings.Replace(href, "/articles/", "", 1))
+			} else {
+				var ref *url.URL
+				if ref, err = url.Parse(href); err == nil {
+					endpoint = base.ResolveReference(ref).String()
+				}
+			}
+			if err != nil {
+				skipped = append(skipped, &ItemError{Href: href, Err: err})
+				return
+			}
+			items = append(items, Item{Title: strings.TrimSpace(title), URL: endpoint, Date: t})
+		})
+	})
+	return items, skipped
+}
+
+// 一覧のページから次のページのURLを取り出す
+func NextPages(doc *goquery.Document, pageURL string, sel Selectors) []string {
+	sel = sel.withDefaults()
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	var pages []string
+	seen := map[string]bool{}
+	doc.Find(sel.Next).Each(func(_ int, s *goquery.Selection) {
+		href, ok := s.Attr("href")
+		if !ok {
+			return
+		}
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return
+		}
+		u := base.ResolveReference(ref)
+		u.Fragment = ""
+		if p := u.String(); !seen[p] && p != pageURL {
+			seen[p] = true
+			pages = append(pages, p)
+		}
+	})
+	return pages
+}
+
+// HTMLの<link rel="canonical">が示すURL (なければ空)
+func Canonical(doc *goquery.Document, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	href, ok := doc.Find(`link[rel="canonical"]`).First().Attr("href")
+	if !ok || strings.TrimSpace(href) == "" {
+		return ""
+	}
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
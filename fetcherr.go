@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"fetch-blog/fetcher"
+)
+
+// 取得の失敗の種類
+const (
+	fetchErrDNS        = "dns"
+	fetchErrTLS        = "tls"
+	fetchErrTimeout    = "timeout"
+	fetchErrNetwork    = "network"
+	fetchErrClient     = "4xx"
+	fetchErrServer     = "5xx"
+	fetchErrParseEmpty = "parse-empty"
+	fetchErrDateParse  = "date-parse"
+	fetchErrOther      = "other"
+)
+
+// 種類の付いた取得の失敗
+type fetchError struct {
+	kind string
+	err  error
+}
+
+func (e *fetchError) Error() string { return e.kind + ": " + e.err.Error() }
+func (e *fetchError) Unwrap() error { return e.err }
+
+// 失敗の種類を判定する
+func fetchErrorKind(err error) string {
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		return fe.kind
+	}
+	var se *fetcher.StatusError
+	if errors.As(err, &se) {
+		if se.Code >= 500 {
+			return fetchErrServer
+		}
+		return fetchErrClient
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return fetchErrDNS
+	}
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) || errors.As(err, &recordHeader) {
+		return fetchErrTLS
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fetchErrTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return fetchErrTimeout
+		}
+		return fetchErrNetwork
+	}
+	return fetchErrOther
+}
+
+// 取得の失敗を種類とともに記録する
+// 記録の失敗は取得の処理を止めない
+func recordFetchError(ctx context.Context, source, url string, err error) {
+	_, dberr := db.ExecContext(ctx, `
+INSERT INTO fetch_errors (source, run_started_at, created_at, kind, url, message)
+VALUES (?, ?, ?, ?, ?, ?)`,
+		source, runStartedAt, time.Now().UTC().Format(time.RFC3339), fetchErrorKind(err), url, err.Error())
+	if dberr != nil {
+		log.Printf("fetch errors: %v", dberr)
+	}
+}
+
+// stats errors: ブログごとに失敗の種類を多い順に表示する
+func printFetchErrorStats(ctx context.Context) error {
+	rows, err := db.QueryContext(ctx, `
+SELECT source, kind, COUNT(*), MAX(created_at),
+       (SELECT COUNT(*) FROM fetch_errors f2 WHERE f2.source = f.source)
+FROM fetch_errors f
+GROUP BY source, kind
+ORDER BY source, COUNT(*) DESC, kind`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tKIND\tCOUNT\tSHARE\tLAST")
+	for rows.Next() {
+		var source, kind, last string
+		var n, total int
+		if err := rows.Scan(&source, &kind, &n, &last, &total); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.0f%%\t%s\n", source, kind, n, float64(n)/float64(total)*100, last)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// 保存する記事の絞り込み (タイトルとURLで選ぶ)
+// 全体 (filters) とブログごと (blogs[].filters) の両方を満たす記事だけを残す
+//
+// 規則は大文字と小文字を区別しない語で、/.../で囲むと正規表現 (例: "/go ?1\.2[0-9]/")
+type filterConfig struct {
+	// どれかに一致する記事だけを残す (空ならすべて残す)
+	Include []string `yaml:"include"`
+	// どれかに一致する記事を除く (includeより優先する)
+	Exclude []string `yaml:"exclude"`
+	// 除いた記事を捨てずにskippedとして保存する (通知はしない)
+	// 保存しておけば次の取得で同じ記事を新しい記事として扱わない
+	KeepSkipped bool `yaml:"keep_skipped"`
+}
+
+// タイトルかURLに対する1つの規則
+type filterRule struct {
+	text string
+	re   *regexp.Regexp
+}
+
+func parseFilterRule(s string) (filterRule, error) {
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile("(?i)" + s[1:len(s)-1])
+		if err != nil {
+			return filterRule{}, err
+		}
+		return filterRule{text: s, re: re}, nil
+	}
+	if strings.TrimSpace(s) == "" {
+		return filterRule{}, fmt.Errorf("empty rule")
+	}
+	return filterRule{text: s}, nil
+}
+
+func (r filterRule) matches(a article) bool {
+	if r.re != nil {
+		return r.re.MatchString(a.title) || r.re.MatchString(a.url)
+	}
+	w := strings.ToLower(r.text)
+	return strings.Contains(strings.ToLower(a.title), w) || strings.Contains(strings.ToLower(a.url), w)
+}
+
+func (c filterConfig) validate(key string) error {
+	check := func(name string, rules []string) error {
+		for i, s := range rules {
+			if _, err := parseFilterRule(s); err != nil {
+				return fmt.Errorf("%s.%s[%d]: %w", key, name, i, err)
+			}
+		}
+		return nil
+	}
+	if err := check("include", c.Include); err != nil {
+		return err
+	}
+	return check("exclude", c.Exclude)
+}
+
+// 記事を除く理由 (残すなら空)
+// 規則はloadConfigで確かめてある
+func (c filterConfig) reject(a article) string {
+	for _, s := range c.Exclude {
+		if r, err := parseFilterRule(s); err == nil && r.matches(a) {
+			return fmt.Sprintf("excluded by %q", s)
+		}
+	}
+	if len(c.Include) == 0 {
+		return ""
+	}
+	for _, s := range c.Include {
+		if r, err := parseFilterRule(s); err == nil && r.matches(a) {
+			return ""
+		}
+	}
+	return "matches no include rule"
+}
+
+// 全体とブログの絞り込みを行う
+// 除いた記事はkeep_skippedならskippedにして理由をreview_reasonに残し、それ以外は捨てる
+func applyFilters(b blog, articles []article) []article {
+	keep := conf.Filters.KeepSkipped || b.cfg.Filters.KeepSkipped
+	res := articles[:0]
+	dropped := 0
+	for _, a := range articles {
+		reason := conf.Filters.reject(a)
+		if reason == "" {
+			reason = b.cfg.Filters.reject(a)
+		}
+		switch {
+		case reason == "":
+		case keep:
+			a.status = statusSkipped
+			a.reviewReason = reason
+		default:
+			dropped++
+			continue
+		}
+		res = append(res, a)
+	}
+	if dropped > 0 {
+		log.Printf("%s: filtered out %d articles", b.name(), dropped)
+	}
+	return res
+}
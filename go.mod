@@ -4,10 +4,9 @@ go 1.20
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
+	github.com/andybalholm/cascadia v1.3.1
 	github.com/mattn/go-sqlite3 v1.14.17
-)
-
-require (
-	github.com/andybalholm/cascadia v1.3.1 // indirect
-	golang.org/x/net v0.7.0 // indirect
+	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
 )
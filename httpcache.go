@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ディスクのHTTPキャッシュ (RFC 7234の1利用者向けのキャッシュ)
+// Cache-ControlとExpiresで新しいあいだはサーバーに問い合わせず、古くなったらETagかLast-Modifiedで確かめる
+// 一覧、記事ページ、フィード、画像などすべてのGETに効く
+type httpCacheConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 既定は.cache/http (ブログのホストごとのディレクトリに保存する)
+	Dir string `yaml:"dir"`
+	// これより大きい応答は保存しない (例: "10MB"、既定は10MB)
+	MaxEntrySize string `yaml:"max_entry_size"`
+}
+
+const (
+	defaultHTTPCacheDir     = ".cache/http"
+	defaultHTTPCacheMaxSize = 10 << 20
+)
+
+// キャッシュから返した応答に付けるヘッダー (hit, revalidated)
+const httpCacheHeader = "X-Fetch-Blog-Cache"
+
+type cachingTransport struct {
+	base    http.RoundTripper
+	dir     string
+	maxSize int64
+}
+
+func newCachingTransport(base http.RoundTripper, c httpCacheConfig) (*cachingTransport, error) {
+	dir := c.Dir
+	if dir == "" {
+		dir = defaultHTTPCacheDir
+	}
+	maxSize, err := parseSize(c.MaxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = defaultHTTPCacheMaxSize
+	}
+	return &cachingTransport{base: base, dir: dir, maxSize: maxSize}, nil
+}
+
+// 保存した応答に添える情報
+type cacheMeta struct {
+	// リクエストを送った時刻と応答を受け取った時刻 (鮮度の計算に使う)
+	RequestTime  time.Time `json:"request_time"`
+	ResponseTime time.Time `json:"response_time"`
+	// Varyのヘッダーのリクエストでの値
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// 安全でないメソッドは同じURLの保存した応答を無効にする
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && resp.StatusCode < 400 {
+			os.Remove(t.path(req))
+		}
+		return resp, err
+	}
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+	reqCC := parseCacheControl(req.Header.Get("Cache-Control"))
+	if _, ok := reqCC["no-store"]; ok {
+		return t.base.RoundTrip(req)
+	}
+
+	cached, meta := t.load(req)
+	if cached != nil {
+		_, noCache := reqCC["no-cache"]
+		age := currentAge(cached, meta, time.Now())
+		if !noCache && age < freshnessLifetime(cached, meta) && !exceedsMaxAge(reqCC, age) {
+			cached.Header.Set("Age", strconv.Itoa(int(age.Seconds())))
+			cached.Header.Set(httpCacheHeader, "hit")
+			return cached, nil
+		}
+		// 古くなったので検証子で確かめる
+		etag, lastMod := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+		if etag != "" || lastMod != "" {
+			req = req.Clone(req.Context())
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if lastMod != "" {
+				req.Header.Set("If-Modified-Since", lastMod)
+			}
+		}
+	}
+
+	reqTime := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		if cached != nil {
+			cached.Body.Close()
+		}
+		return nil, err
+	}
+	respTime := time.Now()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		// 保存した応答のヘッダーを新しいものに更新して返す
+		resp.Body.Close()
+		for k, v := range resp.Header {
+			cached.Header[k] = v
+		}
+		meta.RequestTime, meta.ResponseTime = reqTime, respTime
+		cached = t.store(req, cached, meta)
+		cached.Header.Set(httpCacheHeader, "revalidated")
+		return cached, nil
+	}
+	if cached != nil {
+		cached.Body.Close()
+	}
+	if !t.storable(reqCC, resp) {
+		return resp, nil
+	}
+	return t.store(req, resp, cacheMeta{RequestTime: reqTime, ResponseTime: respTime, Vary: varyValues(req, resp)}), nil
+}
+
+// ホストごとのディレクトリにURLのハッシュで保存する
+func (t *cachingTransport) path(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String()))
+	host := strings.NewReplacer(":", "_", "/", "_", `\`, "_").Replace(req.URL.Host)
+	return filepath.Join(t.dir, host, hex.EncodeToString(sum[:]))
+}
+
+// 保存した応答を読む (なければ、またはVaryが合わなければnil)
+func (t *cachingTransport) load(req *http.Request) (*http.Response, cacheMeta) {
+	var meta cacheMeta
+	b, err := os.ReadFile(t.path(req))
+	if err != nil {
+		return nil, meta
+	}
+	line, rest, ok := bytes.Cut(b, []byte("\n"))
+	if !ok || json.Unmarshal(line, &meta) != nil {
+		return nil, meta
+	}
+	for name, v := range meta.Vary {
+		if req.Header.Get(name) != v {
+			return nil, meta
+		}
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rest)), req)
+	if err != nil {
+		return nil, meta
+	}
+	return resp, meta
+}
+
+// 応答を保存し、読み直せる本文を付けて返す
+// 大きすぎる応答や書き込みの失敗では保存せずにそのまま返す
+func (t *cachingTransport) store(req *http.Request, resp *http.Response, meta cacheMeta) *http.Response {
+	if resp.ContentLength > t.maxSize {
+		return resp
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.maxSize+1))
+	if err != nil || int64(len(body)) > t.maxSize {
+		// 読んだ分と残りをつなげて返す
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Del(httpCacheHeader)
+
+	dump, err := httputil.DumpResponse(resp, true)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return resp
+	}
+	line, _ := json.Marshal(meta)
+	path := t.path(req)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return resp
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return resp
+	}
+	_, err = tmp.Write(append(append(line, '\n'), dump...))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || os.Rename(tmp.Name(), path) != nil {
+		os.Remove(tmp.Name())
+	}
+	return resp
+}
+
+// 保存してよい応答か
+func (t *cachingTransport) storable(reqCC map[string]string, resp *http.Response) bool {
+	cc := parseCacheControl(resp.Header.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+	// 鮮度か検証子がなければ保存しても使えない
+	_, maxAge := cc["max-age"]
+	return maxAge || resp.Header.Get("Expires") != "" || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// 新しいとみなせる長さ
+// max-age、Expires、なければLast-Modifiedからの経過の10%
+func freshnessLifetime(resp *http.Response, meta cacheMeta) time.Duration {
+	cc := parseCacheControl(resp.Header.Get("Cache-Control"))
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	if v, ok := cc["max-age"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(n) * time.Second
+	}
+	date := responseDate(resp, meta)
+	if v := resp.Header.Get("Expires"); v != "" {
+		// 日時として読めないExpiresはすでに古い
+		exp, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		return exp.Sub(date)
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && date.After(lm) {
+		return date.Sub(lm) / 10
+	}
+	return 0
+}
+
+// 応答の経過時間 (RFC 7234 4.2.3)
+func currentAge(resp *http.Response, meta cacheMeta, now time.Time) time.Duration {
+	apparent := meta.ResponseTime.Sub(responseDate(resp, meta))
+	if apparent < 0 {
+		apparent = 0
+	}
+	var ageValue time.Duration
+	if n, err := strconv.Atoi(resp.Header.Get("Age")); err == nil {
+		ageValue = time.Duration(n) * time.Second
+	}
+	corrected := ageValue + meta.ResponseTime.Sub(meta.RequestTime)
+	initial := apparent
+	if corrected > initial {
+		initial = corrected
+	}
+	return initial + now.Sub(meta.ResponseTime)
+}
+
+func responseDate(resp *http.Response, meta cacheMeta) time.Time {
+	if d, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		return d
+	}
+	return meta.ResponseTime
+}
+
+// リクエストのmax-ageより古い
+func exceedsMaxAge(reqCC map[string]string, age time.Duration) bool {
+	v, ok := reqCC["max-age"]
+	if !ok {
+		return false
+	}
+	n, err := strconv.Atoi(v)
+	return err != nil || age > time.Duration(n)*time.Second
+}
+
+func varyValues(req *http.Request, resp *http.Response) map[string]string {
+	var vary map[string]string
+	for _, v := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if vary == nil {
+				vary = map[string]string{}
+			}
+			vary[name] = req.Header.Get(name)
+		}
+	}
+	return vary
+}
+
+// Cache-Controlの指示 (値のない指示は空文字)
+func parseCacheControl(s string) map[string]string {
+	cc := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(part, "=")
+		cc[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+	}
+	return cc
+}
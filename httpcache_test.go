@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFreshnessLifetime(t *testing.T) {
+	date := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	meta := cacheMeta{RequestTime: date, ResponseTime: date}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+	}{
+		{"max-age", map[string]string{"Cache-Control": "public, max-age=300"}, 5 * time.Minute},
+		{"max-age wins over expires", map[string]string{"Cache-Control": "max-age=60", "Expires": "Thu, 01 Jan 2026 13:00:00 GMT"}, time.Minute},
+		{"no-cache", map[string]string{"Cache-Control": "no-cache, max-age=300"}, 0},
+		{"bad max-age", map[string]string{"Cache-Control": "max-age=soon"}, 0},
+		{"expires from date", map[string]string{"Date": "Thu, 01 Jan 2026 12:00:00 GMT", "Expires": "Thu, 01 Jan 2026 13:00:00 GMT"}, time.Hour},
+		{"expires without date", map[string]string{"Expires": "Thu, 01 Jan 2026 12:10:00 GMT"}, 10 * time.Minute},
+		// 日時として読めないExpiresはすでに古い
+		{"bad expires", map[string]string{"Expires": "0"}, 0},
+		{"heuristic", map[string]string{"Date": "Thu, 01 Jan 2026 12:00:00 GMT", "Last-Modified": "Thu, 01 Jan 2026 02:00:00 GMT"}, time.Hour},
+		{"modified in the future", map[string]string{"Date": "Thu, 01 Jan 2026 12:00:00 GMT", "Last-Modified": "Thu, 01 Jan 2026 13:00:00 GMT"}, 0},
+		{"nothing", nil, 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		for k, v := range tt.headers {
+			resp.Header.Set(k, v)
+		}
+		if got := freshnessLifetime(resp, meta); got != tt.want {
+			t.Errorf("%s: freshnessLifetime = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCurrentAge(t *testing.T) {
+	req := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		date    string
+		age     string
+		respLag time.Duration
+		now     time.Duration
+		want    time.Duration
+	}{
+		{"fresh from the origin", "Thu, 01 Jan 2026 12:00:00 GMT", "", 0, 10 * time.Second, 10 * time.Second},
+		{"age header", "Thu, 01 Jan 2026 12:00:00 GMT", "100", 0, 0, 100 * time.Second},
+		{"apparent age from an old date", "Thu, 01 Jan 2026 11:59:00 GMT", "", 0, 0, time.Minute},
+		{"response delay is added", "Thu, 01 Jan 2026 12:00:00 GMT", "30", 2 * time.Second, 0, 32 * time.Second},
+		// 時計のずれで未来のDateでも負にはならない
+		{"date in the future", "Thu, 01 Jan 2026 12:05:00 GMT", "", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Date", tt.date)
+		if tt.age != "" {
+			resp.Header.Set("Age", tt.age)
+		}
+		meta := cacheMeta{RequestTime: req, ResponseTime: req.Add(tt.respLag)}
+		if got := currentAge(resp, meta, meta.ResponseTime.Add(tt.now)); got != tt.want {
+			t.Errorf("%s: currentAge = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(`Public, MAX-AGE="60", no-cache, , private="Set-Cookie"`)
+	want := map[string]string{"public": "", "max-age": "60", "no-cache": "", "private": "Set-Cookie"}
+	if len(cc) != len(want) {
+		t.Fatalf("parseCacheControl = %v, want %v", cc, want)
+	}
+	for k, v := range want {
+		if got, ok := cc[k]; !ok || got != v {
+			t.Errorf("%s = %q, %v, want %q", k, got, ok, v)
+		}
+	}
+	if !exceedsMaxAge(map[string]string{"max-age": "10"}, 11*time.Second) || exceedsMaxAge(map[string]string{"max-age": "10"}, 10*time.Second) {
+		t.Error("exceedsMaxAge does not compare with the request max-age")
+	}
+}
+
+func TestCachingTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		// オリジンが付けるヘッダー
+		cacheControl, etag string
+		reqCacheControl    string
+		// 2回目の応答の出どころ ("" ならオリジン)
+		want      string
+		wantCalls int32
+	}{
+		{name: "fresh", cacheControl: "max-age=60", want: "hit", wantCalls: 1},
+		{name: "stale with etag", cacheControl: "max-age=0", etag: `"v1"`, want: "revalidated", wantCalls: 2},
+		{name: "no-store", cacheControl: "no-store, max-age=60", wantCalls: 2},
+		{name: "request no-cache", cacheControl: "max-age=60", etag: `"v1"`, reqCacheControl: "no-cache", want: "revalidated", wantCalls: 2},
+		{name: "nothing to validate", cacheControl: "max-age=0", wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				if tt.etag != "" {
+					w.Header().Set("ETag", tt.etag)
+					if r.Header.Get("If-None-Match") == tt.etag {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+				io.WriteString(w, "body")
+			}))
+			defer srv.Close()
+			ct, err := newCachingTransport(http.DefaultTransport, httpCacheConfig{Dir: t.TempDir()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: ct}
+			get := func() *http.Response {
+				req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.reqCacheControl != "" {
+					req.Header.Set("Cache-Control", tt.reqCacheControl)
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil || string(b) != "body" {
+					t.Fatalf("body = %q, %v", b, err)
+				}
+				return resp
+			}
+			get()
+			resp := get()
+			if got := resp.Header.Get(httpCacheHeader); got != tt.want {
+				t.Errorf("%s = %q, want %q", httpCacheHeader, got, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("origin was asked %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
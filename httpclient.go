@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// 外部への接続の時間制限
+// 応答しないサーバーで実行全体が止まらないようにする
+type httpConfig struct {
+	// リクエスト全体 (応答の読み込みを含む、既定は30秒)
+	Timeout time.Duration `yaml:"timeout"`
+	// 接続とTLSのハンドシェイク (既定は10秒、SMTPとDiscordの接続にも使う)
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// リクエストを送ってから応答ヘッダーが届くまで (既定は20秒)
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	// ディスクのHTTPキャッシュ
+	Cache httpCacheConfig `yaml:"cache"`
+	// 一時的な失敗のやり直し
+	Retry retryConfig `yaml:"retry"`
+}
+
+const (
+	defaultHTTPTimeout           = 30 * time.Second
+	defaultConnectTimeout        = 10 * time.Second
+	defaultResponseHeaderTimeout = 20 * time.Second
+)
+
+func (c httpConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultHTTPTimeout
+	}
+	return c.Timeout
+}
+
+func (c httpConfig) connectTimeout() time.Duration {
+	if c.ConnectTimeout <= 0 {
+		return defaultConnectTimeout
+	}
+	return c.ConnectTimeout
+}
+
+func (c httpConfig) responseHeaderTimeout() time.Duration {
+	if c.ResponseHeaderTimeout <= 0 {
+		return defaultResponseHeaderTimeout
+	}
+	return c.ResponseHeaderTimeout
+}
+
+// setupHTTPで設定した時間制限
+var httpSettings httpConfig
+
+// すべてのHTTPクライアントで共有するTransport (接続を使い回す)
+var httpTransport http.RoundTripper = http.DefaultTransport
+
+// Cookieを持たない共有のクライアント
+var httpClient = &http.Client{Timeout: defaultHTTPTimeout}
+
+// 設定の時間制限とキャッシュで共有のTransportとクライアントを作り直す
+// 設定を読んだらすぐに呼ぶ
+func setupHTTP(c httpConfig) error {
+	httpSettings = c
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = connectDialer().DialContext
+	t.TLSHandshakeTimeout = c.connectTimeout()
+	t.ResponseHeaderTimeout = c.responseHeaderTimeout()
+	// キャッシュから返す応答はやり直さないので、キャッシュの内側でやり直す
+	httpTransport = &retryTransport{base: t, cfg: c.Retry}
+	if c.Cache.Enabled {
+		ct, err := newCachingTransport(httpTransport, c.Cache)
+		if err != nil {
+			return fmt.Errorf("http.cache: %w", err)
+		}
+		httpTransport = ct
+	}
+	httpClient = newHTTPClient(nil)
+	return nil
+}
+
+// 共有のTransportを使うクライアント (jarがあればCookieを送る)
+func newHTTPClient(jar http.CookieJar) *http.Client {
+	return &http.Client{Jar: jar, Transport: httpTransport, Timeout: httpSettings.timeout()}
+}
+
+// HTTP以外の接続 (SMTP、WebSocket) にも同じ接続の時間制限を使う
+func connectDialer() *net.Dialer {
+	return &net.Dialer{Timeout: httpSettings.connectTimeout(), KeepAlive: 30 * time.Second}
+}
+
+// 外部から渡されたURLを取りに行くクライアント (ActivityPubのアクターと配送先)
+// ループバック、プライベート、リンクローカルのアドレスには接続しない
+// 名前を解決したあとの接続ごとに確かめるので、リダイレクトやDNSの書き換えでも内部に届かない
+func newPublicOnlyClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	// プロキシを通すと接続先のアドレスを確かめられない
+	t.Proxy = nil
+	d := connectDialer()
+	d.Control = func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+			return fmt.Errorf("%w: %s", errNonPublicAddress, host)
+		}
+		return nil
+	}
+	t.DialContext = d.DialContext
+	t.TLSHandshakeTimeout = httpSettings.connectTimeout()
+	t.ResponseHeaderTimeout = httpSettings.responseHeaderTimeout()
+	return &http.Client{Transport: t, Timeout: httpSettings.timeout()}
+}
+
+// 接続しないアドレス
+var errNonPublicAddress = errors.New("refusing to connect to a non-public address")
+
+// キャリアグレードNAT (RFC 6598)
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// インターネットから届くアドレスか
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"fetch-blog/notifier"
+)
+
+var (
+	// 同じ通知がすでに届いている
+	errAlreadyDelivered = errors.New("already delivered")
+	// 前回の送信が届いたかわからないので確認を待っている
+	errUnresolved = errors.New("previous delivery is unresolved")
+)
+
+// 届いたかわからない送信の状態
+const deliveryUnknown = "unknown"
+
+// 通知先と記事から決まる冪等キー
+// 再送しても同じキーになるので、受け取った側で重複を見分けられる
+func idempotencyKey(destination string, urls ...string) string {
+	sorted := append([]string(nil), urls...)
+	sort.Strings(sorted)
+	return sha256Hex([]byte(destination + "\n" + strings.Join(sorted, "\n")))[:32]
+}
+
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return notifier.WithIdempotencyKey(ctx, key)
+}
+
+// 送信中の通知の冪等キー (なければ空)
+func idempotencyKeyFrom(ctx context.Context) string {
+	return notifier.IdempotencyKey(ctx)
+}
+
+// 冪等キーごとに一度だけ送る
+// 前回届いていれば送らず、届いたかわからなければ確認されるまで送らない
+func dispatchOnce(ctx context.Context, dests []destination, keyOf func(d destination) string, fn func(context.Context, destination) error) []deliveryResult {
+	results := dispatchAll(ctx, dests, func(ctx context.Context, d destination) error {
+		key := keyOf(d)
+		status, err := lastDeliveryStatus(ctx, key)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case "ok":
+			return errAlreadyDelivered
+		case deliveryUnknown:
+			return fmt.Errorf("%w: run `deliveries resolve %s ok|failed`", errUnresolved, key)
+		}
+		// 送らない通知は上限に数えない
+		if err := sendLimit.wait(ctx); err != nil {
+			return err
+		}
+		return fn(withIdempotencyKey(ctx, key), d)
+	})
+	for i, d := range dests {
+		results[i].key = keyOf(d)
+	}
+	return results
+}
+
+// 冪等キーの最新の送信結果 (記録がなければ空)
+func lastDeliveryStatus(ctx context.Context, key string) (string, error) {
+	var status string
+	err := db.QueryRowContext(ctx, "SELECT status FROM deliveries WHERE idempotency_key = ? ORDER BY rowid DESC LIMIT 1", key).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return status, err
+}
+
+// 送信したあとで応答を受け取れなかったか
+// 接続前の失敗なら届いていないので再送してよい
+func isAmbiguous(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// deliveries: 通知の送信結果を確認する
+//
+//	deliveries [-status unknown]         送信結果の一覧
+//	deliveries resolve <key> ok|failed   届いたかわからない送信の結果を記録する
+//	deliveries queue                     送り直しを待っている通知
+func cmdDeliveries(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("deliveries", flag.ExitOnError)
+	status := fs.String("status", "", "only show deliveries with this status (ok, failed, skipped, unknown)")
+	limit := fs.Int("limit", 50, "maximum number of deliveries to show")
+	parseFlags(fs, args)
+
+	if fs.Arg(0) == "resolve" {
+		key, result := fs.Arg(1), fs.Arg(2)
+		if key == "" || (result != "ok" && result != "failed") {
+			return usageErr("usage: deliveries resolve <key> ok|failed")
+		}
+		res, err := db.ExecContext(ctx, "UPDATE deliveries SET status = ? WHERE idempotency_key = ? AND status = ?", result, key, deliveryUnknown)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			return fmt.Errorf("no unresolved delivery with key %s", key)
+		}
+		fmt.Printf("resolved %d deliveries as %s\n", n, result)
+		return nil
+	}
+	if fs.Arg(0) == "queue" {
+		return printRetryQueue(ctx)
+	}
+	if fs.NArg() > 0 {
+		return usageErr(fmt.Sprintf("unknown deliveries command %q", fs.Arg(0)))
+	}
+
+	q := selectFrom("deliveries", "delivered_at", "destination", "status", "COALESCE(idempotency_key, '')", "url", "error").
+		orderBy("rowid DESC").limitTo(*limit)
+	if *status != "" {
+		q.where("status = ?", *status)
+	}
+	query, qargs := q.build()
+	rows, err := db.QueryContext(ctx, query, qargs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tDESTINATION\tSTATUS\tKEY\tURL\tERROR")
+	for rows.Next() {
+		var at, dest, st, key, u, msg string
+		if err := rows.Scan(&at, &dest, &st, &key, &u, &msg); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", at, dest, st, key, u, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
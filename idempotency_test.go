@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"fetch-blog/blogtest"
+	"fetch-blog/store"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	k := idempotencyKey("slack", "https://example.com/a", "https://example.com/b")
+	if len(k) != 32 {
+		t.Errorf("key %q is not 32 characters", k)
+	}
+	// 記事の順番によらない
+	if k2 := idempotencyKey("slack", "https://example.com/b", "https://example.com/a"); k2 != k {
+		t.Errorf("key depends on the order: %s != %s", k2, k)
+	}
+	if k2 := idempotencyKey("discord", "https://example.com/a", "https://example.com/b"); k2 == k {
+		t.Error("key does not depend on the destination")
+	}
+	if k2 := idempotencyKey("slack", "https://example.com/a"); k2 == k {
+		t.Error("key does not depend on the articles")
+	}
+}
+
+func TestIsAmbiguous(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dns", &net.DNSError{Err: "no such host", Name: "example.com"}, false},
+		{"dial", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, false},
+		{"read", &net.OpError{Op: "read", Err: errors.New("connection reset")}, true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"status", errors.New("status code 500"), false},
+	}
+	for _, tt := range tests {
+		if got := isAmbiguous(tt.err); got != tt.want {
+			t.Errorf("%s: isAmbiguous = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDispatchOnceSettles(t *testing.T) {
+	const url = "https://example.com/a"
+	readErr := &net.OpError{Op: "read", Err: errors.New("connection reset")}
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	tests := []struct {
+		name string
+		// 前回の送信の記録 (空なら記録なし)
+		prior string
+		err   error
+		// 送ったか、記録された最新の状態、記事が既読になるか、再送の予定に入るか
+		wantSent   bool
+		wantStatus string
+		wantRead   bool
+		wantRetry  bool
+	}{
+		{name: "first delivery", wantSent: true, wantStatus: "ok", wantRead: true},
+		{name: "already delivered", prior: "ok", wantStatus: "ok", wantRead: true},
+		{name: "previous failure is resent", prior: "failed", wantSent: true, wantStatus: "ok", wantRead: true},
+		// 確認されるまで再送しない
+		{name: "unresolved", prior: deliveryUnknown, wantStatus: deliveryUnknown},
+		{name: "no response", err: readErr, wantStatus: deliveryUnknown},
+		{name: "not connected", err: dialErr, wantStatus: "failed", wantRetry: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			openTestDB(t)
+			saveTestArticles(t, store.Article{Title: "A", URL: url, Date: "2026-01-01"})
+			key := idempotencyKey("a", url)
+			if tt.prior != "" {
+				if _, err := db.Exec("INSERT INTO deliveries (url, destination, status, error, delivered_at, idempotency_key) VALUES (?, 'a', ?, '', ?, ?)",
+					url, tt.prior, time.Now().UTC().Format(time.RFC3339), key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n := &blogtest.Notifier{Err: tt.err}
+			dests := []destination{&fakeDestination{label: "a", n: n}}
+			results := dispatchOnce(ctx, dests, func(d destination) string { return idempotencyKey(d.name(), url) },
+				func(ctx context.Context, d destination) error { return d.send(ctx, article{title: "A", url: url}) })
+			if err := settleDeliveries(ctx, url, results); err != nil {
+				t.Fatal(err)
+			}
+
+			msgs := n.Messages()
+			if sent := len(msgs) > 0; sent != tt.wantSent {
+				t.Errorf("sent = %v, want %v", sent, tt.wantSent)
+			}
+			if len(msgs) > 0 && msgs[0].IdempotencyKey != key {
+				t.Errorf("sent with key %q, want %q", msgs[0].IdempotencyKey, key)
+			}
+			status, err := lastDeliveryStatus(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", status, tt.wantStatus)
+			}
+			if got := isRead(t, url); got != tt.wantRead {
+				t.Errorf("read = %v, want %v", got, tt.wantRead)
+			}
+			var retries int
+			if err := db.QueryRow("SELECT COUNT(*) FROM notify_retries WHERE url = ?", url).Scan(&retries); err != nil {
+				t.Fatal(err)
+			}
+			if (retries > 0) != tt.wantRetry {
+				t.Errorf("%d retries queued, want %v", retries, tt.wantRetry)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"fetch-blog/fetcher"
+)
+
+// AIMDで同時実行数を調整するリミッター
+// 成功するたびに少しずつ増やし、失敗や遅延があれば半分にする
+type aimdLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    float64
+	max      float64
+	inFlight int
+}
+
+func newAIMDLimiter(initial, max int) *aimdLimiter {
+	if initial < 1 {
+		initial = 1
+	}
+	if initial > max {
+		initial = max
+	}
+	l := &aimdLimiter{limit: float64(initial), max: float64(max)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// 空きができるまで待つ
+func (l *aimdLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// 結果を反映して枠を返す
+func (l *aimdLimiter) release(congested bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if congested {
+		l.limit /= 2
+		if l.limit < 1 {
+			l.limit = 1
+		}
+	} else {
+		// 1往復分の成功でおよそ1増える
+		l.limit += 1 / l.limit
+		if l.limit > l.max {
+			l.limit = l.max
+		}
+	}
+	l.cond.Broadcast()
+}
+
+func (l *aimdLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// ブログごとの取得の計測値
+type laneMetrics struct {
+	source   string
+	requests int
+	errors   int
+	total    time.Duration
+	max      time.Duration
+	// 終了時の同時実行数
+	concurrency int
+}
+
+// ブログごとのレーン
+// 遅いブログや不安定なブログは自分のレーンだけが絞られる
+type fetchLane struct {
+	limiter *aimdLimiter
+
+	mu      sync.Mutex
+	metrics laneMetrics
+}
+
+func (ln *fetchLane) observe(d time.Duration, err error) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	ln.metrics.requests++
+	ln.metrics.total += d
+	if d > ln.metrics.max {
+		ln.metrics.max = d
+	}
+	if err != nil {
+		ln.metrics.errors++
+	}
+}
+
+// 混雑を示す失敗か (サーバーの過負荷、レート制限、通信の失敗)
+// 404などページ固有の失敗では絞らない
+func isCongestion(err error) bool {
+	if err == nil {
+		return false
+	}
+	var se *fetcher.StatusError
+	if errors.As(err, &se) {
+		return se.Code == 429 || se.Code >= 500
+	}
+	return true
+}
+
+// URLをブログ (ホスト名) ごとのレーンで並行に取得する
+// 取得した結果はhandleに1件ずつ渡す (handleは並行に呼ばない)
+func fetchInLanes(ctx context.Context, c fetchConfig, urls []string, fetch func(ctx context.Context, u string) (*articlePage, error), handle func(u string, p *articlePage, err error)) []laneMetrics {
+	lanes := map[string]*fetchLane{}
+	queues := map[string][]string{}
+	var order []string
+	for _, u := range urls {
+		host := urlHost(u)
+		if _, ok := lanes[host]; !ok {
+			lanes[host] = &fetchLane{
+				limiter: newAIMDLimiter(lastConcurrency(ctx, host, c.InitialConcurrency), c.MaxConcurrency),
+				metrics: laneMetrics{source: host},
+			}
+			order = append(order, host)
+		}
+		queues[host] = append(queues[host], u)
+	}
+
+	type result struct {
+		url  string
+		page *articlePage
+		err  error
+	}
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for _, host := range order {
+		ln, queue := lanes[host], queues[host]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var inner sync.WaitGroup
+			for _, u := range queue {
+				if ctx.Err() != nil {
+					break
+				}
+				ln.limiter.acquire()
+				inner.Add(1)
+				go func(u string) {
+					defer inner.Done()
+					start := time.Now()
+					page, err := fetch(ctx, u)
+					d := time.Since(start)
+					ln.observe(d, err)
+					ln.limiter.release(isCongestion(err) || d > c.SlowThreshold)
+					results <- result{url: u, page: page, err: err}
+				}(u)
+			}
+			inner.Wait()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for r := range results {
+		handle(r.url, r.page, r.err)
+	}
+
+	metrics := make([]laneMetrics, 0, len(order))
+	for _, host := range order {
+		ln := lanes[host]
+		m := ln.metrics
+		m.concurrency = ln.limiter.current()
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// 前回の実行で落ち着いた同時実行数から始める
+func lastConcurrency(ctx context.Context, source string, initial int) int {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT concurrency FROM fetch_metrics WHERE source = ? ORDER BY id DESC LIMIT 1", source).Scan(&n)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("fetch metrics: %v", err)
+		}
+		return initial
+	}
+	return n
+}
+
+// 計測値を保存してログに出す
+func saveLaneMetrics(ctx context.Context, metrics []laneMetrics) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, m := range metrics {
+		var avg time.Duration
+		if m.requests > 0 {
+			avg = m.total / time.Duration(m.requests)
+		}
+		log.Printf("fetch %s: %d requests, %d errors, avg %s, max %s, concurrency %d",
+			m.source, m.requests, m.errors, avg.Round(time.Millisecond), m.max.Round(time.Millisecond), m.concurrency)
+		_, err := db.ExecContext(ctx, `
+INSERT INTO fetch_metrics (source, fetched_at, requests, errors, total_ms, max_ms, concurrency)
+VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			m.source, now, m.requests, m.errors, m.total.Milliseconds(), m.max.Milliseconds(), m.concurrency)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stats fetch: ブログごとの取得の遅さと失敗率
+func printFetchStats(ctx context.Context) error {
+	rows, err := db.QueryContext(ctx, `
+SELECT source, COUNT(*), SUM(requests), SUM(errors), SUM(total_ms), MAX(max_ms),
+       (SELECT concurrency FROM fetch_metrics m2 WHERE m2.source = m.source ORDER BY id DESC LIMIT 1)
+FROM fetch_metrics m
+GROUP BY source
+ORDER BY source`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tRUNS\tREQUESTS\tERROR RATE\tAVG\tMAX\tCONCURRENCY")
+	for rows.Next() {
+		var source string
+		var runs, requests, errs, concurrency int
+		var totalMS, maxMS int64
+		if err := rows.Scan(&source, &runs, &requests, &errs, &totalMS, &maxMS, &concurrency); err != nil {
+			return err
+		}
+		var rate float64
+		var avg time.Duration
+		if requests > 0 {
+			rate = float64(errs) / float64(requests) * 100
+			avg = time.Duration(totalMS/int64(requests)) * time.Millisecond
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f%%\t%s\t%s\t%d\n",
+			source, runs, requests, rate, avg, time.Duration(maxMS)*time.Millisecond, concurrency)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
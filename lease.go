@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// リースを手放すのを待つ時間
+const leaseReleaseTimeout = 5 * time.Second
+
+// DBのリースによるリーダー選出
+// 同じDBを共有する複数のインスタンスのうち、リースを持つ1台だけが取得・通知を行う
+type elector struct {
+	name   string
+	holder string
+	ttl    time.Duration
+}
+
+func newElector(c haConfig) *elector {
+	holder := c.InstanceID
+	if holder == "" {
+		host, _ := os.Hostname()
+		holder = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	ttl := c.LeaseTTL
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	return &elector{name: "leader", holder: holder, ttl: ttl}
+}
+
+// リースを取得または延長する
+// 他のインスタンスが有効なリースを持っていればfalse
+func (e *elector) acquire(ctx context.Context) (bool, error) {
+	now := time.Now()
+	res, err := db.ExecContext(ctx, `
+INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+WHERE leases.holder = excluded.holder OR leases.expires_at < ?`,
+		e.name, e.holder, now.Add(e.ttl).Unix(), now.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// 取得したリースを持ち続ける
+// 処理がttlより長くかかっても他のインスタンスに取られないように、ttlの1/3ごとに延長する
+// 延長できなければ返すコンテキストを取り消して処理を止める
+// 返す関数で延長をやめてリースを手放す
+func (e *elector) hold(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(e.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			ok, err := e.acquire(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("renew the lease: %v, stopping", err)
+			} else if !ok {
+				log.Printf("%s lost the lease to another instance, stopping", e.holder)
+			}
+			if err != nil || !ok {
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
+		// 処理のコンテキストは中断 (SIGINT) で取り消されていることがあるので別のコンテキストで手放す
+		rctx, rcancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+		defer rcancel()
+		if err := e.release(rctx); err != nil {
+			log.Printf("release the lease: %v", err)
+		}
+	}
+}
+
+// 自分が持っているリースを手放す
+func (e *elector) release(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM leases WHERE name = ? AND holder = ?", e.name, e.holder)
+	return err
+}
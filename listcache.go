@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 一覧のページの検証子 (ETag、Last-Modified)
+// ブログごとにhttp_cacheへ残し、次の取得でIf-None-MatchとIf-Modified-Sinceを送る
+// 変わっていなければ (304) 一覧を解析しない
+type listValidators struct {
+	etag, lastModified string
+}
+
+func (v listValidators) empty() bool {
+	return v.etag == "" && v.lastModified == ""
+}
+
+func (v listValidators) setHeaders(req *http.Request) {
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+}
+
+// 応答が前回と同じ検証子を持つ
+// ディスクのHTTPキャッシュ (http.cache) を使うと304の代わりに保存した応答が返ってくる
+func (v listValidators) matches(resp *http.Response) bool {
+	if v.empty() {
+		return false
+	}
+	return resp.Header.Get("ETag") == v.etag && resp.Header.Get("Last-Modified") == v.lastModified
+}
+
+// 解析した一覧の検証子 (記事を保存してからhttp_cacheに書く)
+var (
+	pendingValidatorsMu sync.Mutex
+	pendingValidators   = map[string]listValidators{}
+)
+
+// 一覧の設定 (セレクタなど) が変わったら、ページが同じでも解析し直す
+func listFingerprint(b blog) string {
+	fp, _ := json.Marshal(struct {
+		Mode      string
+		Selectors selectorsConfig
+	}{b.cfg.Mode, b.cfg.Selectors})
+	return string(fp)
+}
+
+// 前回の検証子 (一覧のURLか設定が変わっていれば空)
+func loadListValidators(ctx context.Context, b blog) (listValidators, error) {
+	// 前の取得で残ったまま保存されなかった検証子は捨てる
+	pendingValidatorsMu.Lock()
+	delete(pendingValidators, b.name())
+	pendingValidatorsMu.Unlock()
+
+	var v listValidators
+	err := db.QueryRowContext(ctx, "SELECT etag, last_modified FROM http_cache WHERE source = ? AND url = ? AND selectors = ?",
+		b.name(), b.url, listFingerprint(b)).Scan(&v.etag, &v.lastModified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return listValidators{}, nil
+	}
+	return v, err
+}
+
+func holdListValidators(b blog, resp *http.Response) {
+	v := listValidators{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+	pendingValidatorsMu.Lock()
+	defer pendingValidatorsMu.Unlock()
+	pendingValidators[b.name()] = v
+}
+
+// 解析した一覧の検証子を保存する
+// 検証子のない応答なら前回のものを消す
+func saveListValidators(ctx context.Context, b blog) error {
+	pendingValidatorsMu.Lock()
+	v, ok := pendingValidators[b.name()]
+	delete(pendingValidators, b.name())
+	pendingValidatorsMu.Unlock()
+	if !ok {
+		return nil
+	}
+	if v.empty() {
+		_, err := db.ExecContext(ctx, "DELETE FROM http_cache WHERE source = ?", b.name())
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+INSERT INTO http_cache (source, url, etag, last_modified, selectors, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (source) DO UPDATE SET url = excluded.url, etag = excluded.etag, last_modified = excluded.last_modified,
+	selectors = excluded.selectors, updated_at = excluded.updated_at`,
+		b.name(), b.url, v.etag, v.lastModified, listFingerprint(b), time.Now().UTC().Format(time.RFC3339))
+	return err
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 予算を使い切った
+var errLLMBudget = errors.New("llm: token budget exceeded")
+
+// この実行の識別子 (開始時刻)
+// 使用量を実行ごとに集計する
+var runStartedAt = time.Now().UTC().Format(time.RFC3339)
+
+// OpenAI互換のChat Completions APIを呼び出す
+// 分類などの補助的な処理に使う
+type llmClient struct {
+	endpoint string
+	apiKey   string
+	model    string
+	// 0なら無制限
+	monthlyBudget int
+	runBudget     int
+}
+
+// 設定がなければnilを返す
+func newLLMClient(c llmConfig) *llmClient {
+	if c.Model == "" {
+		return nil
+	}
+	endpoint := strings.TrimRight(c.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://api.openai.com/v1"
+	}
+	key := c.APIKey
+	if key == "" {
+		key = os.Getenv("OPENAI_API_KEY")
+	}
+	return &llmClient{endpoint: endpoint, apiKey: key, model: c.Model, monthlyBudget: c.MonthlyTokenBudget, runBudget: c.RunTokenBudget}
+}
+
+// 使ったトークン数
+type llmUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// systemとuserのメッセージを送って応答の本文を返す
+// purpose (classify, translateなど) ごとに使用量を記録する
+func (l *llmClient) complete(ctx context.Context, purpose, system, user string) (string, llmUsage, error) {
+	if err := l.checkBudget(ctx); err != nil {
+		return "", llmUsage{}, err
+	}
+	text, usage, err := l.call(ctx, system, user)
+	// 失敗しても使った分は記録する
+	if usage.PromptTokens+usage.CompletionTokens > 0 {
+		if rerr := recordLLMUsage(ctx, purpose, l.model, usage); rerr != nil {
+			log.Printf("llm usage: %v", rerr)
+		}
+	}
+	return text, usage, err
+}
+
+// 今月とこの実行の使用量が予算に収まっているか
+func (l *llmClient) checkBudget(ctx context.Context) error {
+	if l.monthlyBudget > 0 {
+		used, err := llmTokensSince(ctx, monthStart(time.Now()))
+		if err != nil {
+			return err
+		}
+		if used >= l.monthlyBudget {
+			return fmt.Errorf("%w: %d of %d tokens this month", errLLMBudget, used, l.monthlyBudget)
+		}
+	}
+	if l.runBudget > 0 {
+		var used int
+		err := db.QueryRowContext(ctx, "SELECT COALESCE(SUM(prompt_tokens + completion_tokens), 0) FROM llm_usage WHERE run_started_at = ?", runStartedAt).Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used >= l.runBudget {
+			return fmt.Errorf("%w: %d of %d tokens this run", errLLMBudget, used, l.runBudget)
+		}
+	}
+	return nil
+}
+
+func (l *llmClient) call(ctx context.Context, system, user string) (string, llmUsage, error) {
+	body, err := json.Marshal(map[string]any{
+		"model": l.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": user},
+		},
+		"temperature": 0,
+	})
+	if err != nil {
+		return "", llmUsage{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.endpoint+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", llmUsage{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+l.apiKey)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", llmUsage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", llmUsage{}, fmt.Errorf("llm: status code %d: %s", resp.StatusCode, msg)
+	}
+	var res struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage llmUsage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", llmUsage{}, fmt.Errorf("llm: %w", err)
+	}
+	if len(res.Choices) == 0 {
+		return "", res.Usage, errors.New("llm: empty response")
+	}
+	return strings.TrimSpace(res.Choices[0].Message.Content), res.Usage, nil
+}
+
+func recordLLMUsage(ctx context.Context, purpose, model string, u llmUsage) error {
+	_, err := db.ExecContext(ctx, `
+INSERT INTO llm_usage (run_started_at, created_at, purpose, model, prompt_tokens, completion_tokens)
+VALUES (?, ?, ?, ?, ?, ?)`,
+		runStartedAt, time.Now().UTC().Format(time.RFC3339), purpose, model, u.PromptTokens, u.CompletionTokens)
+	return err
+}
+
+// 指定の時刻以降に使ったトークン数
+func llmTokensSince(ctx context.Context, since time.Time) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(SUM(prompt_tokens + completion_tokens), 0) FROM llm_usage WHERE created_at >= ?",
+		since.UTC().Format(time.RFC3339)).Scan(&n)
+	return n, err
+}
+
+// 月の初め (UTC)
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// stats llm: 月ごと・直近の実行ごとの使用量と今月の残り
+func printLLMStats(ctx context.Context) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MONTH\tPURPOSE\tCALLS\tPROMPT\tCOMPLETION")
+	rows, err := db.QueryContext(ctx, `
+SELECT substr(created_at, 1, 7) AS month, purpose, COUNT(*), SUM(prompt_tokens), SUM(completion_tokens)
+FROM llm_usage
+GROUP BY month, purpose
+ORDER BY month DESC, purpose`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var month, purpose string
+		var calls, prompt, completion int
+		if err := rows.Scan(&month, &purpose, &calls, &prompt, &completion); err != nil {
+			rows.Close()
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", month, purpose, calls, prompt, completion)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nRUN\tCALLS\tTOKENS")
+	rows, err = db.QueryContext(ctx, `
+SELECT run_started_at, COUNT(*), SUM(prompt_tokens + completion_tokens)
+FROM llm_usage
+GROUP BY run_started_at
+ORDER BY run_started_at DESC
+LIMIT 10`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var run string
+		var calls, tokens int
+		if err := rows.Scan(&run, &calls, &tokens); err != nil {
+			rows.Close()
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", run, calls, tokens)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if b := conf.LLM.MonthlyTokenBudget; b > 0 {
+		used, err := llmTokensSince(ctx, monthStart(time.Now()))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\nbudget\t%d of %d tokens used this month\n", used, b)
+	}
+	return w.Flush()
+}
@@ -1,236 +1,737 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	_ "embed"
 
 	"github.com/PuerkitoBio/goquery"
-	_ "github.com/mattn/go-sqlite3"
+
+	"fetch-blog/fetcher"
+	"fetch-blog/store"
 )
 
 //go:embed url.txt
 var baseURL string
 
 type article struct {
+	// DBの行番号 (shareなどで記事を指定するのに使う)
+	id    int64
 	title string
 	url   string
 	date  string
 	read  bool
+	// 公開ページへの掲載を個別に選んだ記事
+	public bool
+	// 有料記事
+	paywalled bool
+	// ok, review
+	status       string
+	reviewReason string
+	// 公開日時 (RFC3339, UTC)。わからなければ空
+	publishedAt string
+	// 分類 (categoriesの名前)
+	categories []string
+	// 通知に添える関連記事
+	related []article
+	// 通知に添える外部の議論
+	discussions []discussion
+	// 通知に使う翻訳したタイトル (翻訳しなければ空)
+	translatedTitle string
+	// 配信元 (空ならURLのホスト名、add-urlで追加した記事はmanual)
+	source string
+	// 期限 (RFC3339, UTC) とその理由。なければ空
+	dueAt   string
+	dueNote string
+	// 記事の言語と、Flesch Reading Ease (英語のみ、求めていなければ負)
+	language    string
+	readingEase float64
+	// 記事を見つけた経路 (保存するときだけ使う)
+	provenance provenance
 }
 
-// title, urlでUKになるSQLite３のDBを作成
-const schema = `
-CREATE TABLE IF NOT EXISTS articles (
-    title TEXT NOT NULL,
-    url TEXT NOT NULL,
-    date DATE NOT NULL,
-    read BOOLEAN DEFAULT FALSE,
-    UNIQUE (url, title)
-);
-`
-
 // db connectionを保持
 var db *sql.DB
 
+// dbに記事を保存する
+var articleStore *store.Store
+
 //go:embed webhook.txt
 var webhookURL string
 
 func init() {
-	// DBを開く
+	baseURL = strings.TrimSpace(baseURL)
+}
+
+// DBを開いてスキーマの変更を適用する
+// manualMigrationsなら適用しない (migrateコマンドで適用する)
+func openDB(path string, wal, manualMigrations bool) error {
+	s, err := store.Open(path, store.Options{
+		WAL:              wal,
+		JournalMode:      conf.SQLite.JournalMode,
+		TempStore:        conf.SQLite.TempStore,
+		TempDir:          conf.SQLite.TempDir,
+		CacheSizeKiB:     conf.SQLite.CacheSizeKiB,
+		ManualMigrations: manualMigrations,
+	})
+	if err != nil {
+		return err
+	}
+	articleStore, db = s, s.DB
+	return nil
+}
+
+func main() {
+	// deferを実行してから終了コードを返す
+	os.Exit(realMain())
+}
+
+func realMain() int {
+	configPath := flag.String("config", "config.yaml", "path to config file")
+	force := flag.Bool("force", false, "run every task regardless of the schedule")
+	digestMode := flag.Bool("digest", false, "send one message per run instead of one per article (overrides digest.enabled)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command] [args]\n", os.Args[0])
+		printCommands(flag.CommandLine.Output())
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitCodesHelp)
+	}
+	// 誤ったフラグはexitUsageで終了する
+	parseFlags(flag.CommandLine, os.Args[1:])
+	switch flag.Arg(0) {
+	case "version":
+		fmt.Println(version)
+		return exitOK
+	case "completion":
+		if err := cmdCompletion(flag.Args()[1:]); err != nil {
+			return fail(err)
+		}
+		return exitOK
+	case "config":
+		if err := cmdConfig(flag.Args()[1:]); err != nil {
+			return fail(err)
+		}
+		return exitOK
+	case "schema":
+		if err := cmdSchema(flag.Args()[1:]); err != nil {
+			return fail(err)
+		}
+		return exitOK
+	case "sandbox":
+		// -configの設定とDBは使わない
+		return cmdSandbox(flag.Args()[1:])
+	}
+
 	var err error
-	db, err = sql.Open("sqlite3", "blog.db")
+	conf, err = loadConfig(*configPath)
 	if err != nil {
-		log.Fatal(err)
+		return fail(err)
 	}
-	// SQLを実行
-	_, err = db.Exec(schema)
+	// -digestを指定したときだけ設定を上書きする
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "digest" {
+			conf.Digest.Enabled = *digestMode
+		}
+	})
+	if err := setupHTTP(conf.HTTP); err != nil {
+		return fail(withExitCode(exitConfig, err))
+	}
+	sendLimit = newSendLimiter(conf.Notify.MaxPerMinute, time.Minute)
+
+	// SIGINTやSIGTERMで実行中のリクエストやトランザクションを中断して終了する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// サービスの登録と更新はDBを開かずに行う
+	switch flag.Arg(0) {
+	case "__complete":
+		// 補完スクリプトから呼ばれる
+		fmt.Println(strings.Join(completionValues(flag.Arg(1)), "\n"))
+		return exitOK
+	case "import-opml":
+		// 設定ファイルだけを書き換える
+		if err := cmdImportOPML(*configPath, flag.Args()[1:]); err != nil {
+			return fail(err)
+		}
+		return exitOK
+	case "install-service", "self-update":
+		var err error
+		if flag.Arg(0) == "install-service" {
+			err = cmdInstallService(*configPath, flag.Args()[1:])
+		} else {
+			err = cmdSelfUpdate(ctx, conf.SelfUpdate, flag.Args()[1:])
+		}
+		if err != nil {
+			return fail(err)
+		}
+		return exitOK
+	}
+	dests, err := newDestinations(conf.Destinations, conf.Public)
 	if err != nil {
-		log.Fatal(err)
+		return fail(withExitCode(exitConfig, err))
 	}
 
-	baseURL = strings.TrimSpace(baseURL)
-}
+	// DBファイルがなければレプリカから復元
+	var rep *replicator
+	if conf.Replication.Enabled {
+		rep = newReplicator(conf.Replication, conf.Database)
+		if err := rep.restore(); err != nil {
+			return fail(withExitCode(exitDB, err))
+		}
+	}
 
-func main() {
-	// 金曜日だけ実行
-	if time.Now().Weekday() != time.Friday {
-		// すべての記事を取得
-		fetchAllArticles()
+	// DBを開く
+	migrating := flag.Arg(0) == "migrate"
+	if err := openDB(conf.Database, conf.Replication.Enabled, conf.SQLite.ManualMigrations || migrating); err != nil {
+		return fail(withExitCode(exitDB, err))
+	}
+	defer db.Close()
+	if conf.SQLite.ManualMigrations && !migrating {
+		if err := checkMigrations(ctx); err != nil {
+			return fail(withExitCode(exitDB, err))
+		}
 	}
 
-	ctx := context.Background()
+	// レプリケーションの開始
+	if rep != nil {
+		if err := rep.start(); err != nil {
+			return fail(withExitCode(exitDB, err))
+		}
+		defer rep.stop()
+	}
 
-	// 記事のURLにアクセス
-	var urls []string
-	rows, err := db.QueryContext(ctx, "SELECT url FROM articles WHERE read = 0 ORDER BY date")
-	if err != nil {
-		log.Fatal(err)
+	var cmdErr error
+	switch flag.Arg(0) {
+	case "":
+	case "serve":
+		// HTTPサーバーとして起動
+		// 読み取りAPIはリーダーかどうかに関わらず提供する
+		cmdErr = cmdServe(ctx, dests, flag.Args()[1:])
+	case "list":
+		cmdErr = cmdList(ctx, flag.Args()[1:])
+	case "diff":
+		cmdErr = cmdDiff(ctx, flag.Args()[1:])
+	case "search":
+		cmdErr = cmdSearch(ctx, flag.Args()[1:])
+	case "stats":
+		cmdErr = cmdStats(ctx, flag.Args()[1:])
+	case "review":
+		cmdErr = cmdReview(ctx, flag.Args()[1:])
+	case "login":
+		cmdErr = cmdLogin(ctx, flag.Args()[1:])
+	case "users":
+		cmdErr = cmdUsers(ctx, flag.Args()[1:])
+	case "fetch":
+		cmdErr = cmdFetch(ctx, flag.Args()[1:])
+	case "notify":
+		cmdErr = cmdNotify(ctx, dests, flag.Args()[1:])
+	case "mark-read":
+		cmdErr = cmdMarkRead(ctx, flag.Args()[1:])
+	case "cache":
+		cmdErr = cmdCache(ctx, flag.Args()[1:])
+	case "responses":
+		cmdErr = cmdResponses(ctx, flag.Args()[1:])
+	case "provenance":
+		cmdErr = cmdProvenance(ctx, flag.Args()[1:])
+	case "deliveries":
+		cmdErr = cmdDeliveries(ctx, flag.Args()[1:])
+	case "translations":
+		cmdErr = cmdTranslations(ctx, flag.Args()[1:])
+	case "export":
+		cmdErr = cmdExport(ctx, flag.Args()[1:])
+	case "unread-feed":
+		cmdErr = cmdUnreadFeed(ctx, flag.Args()[1:])
+	case "merge":
+		cmdErr = cmdMerge(ctx, flag.Args()[1:])
+	case "sources":
+		cmdErr = cmdSources(ctx, *configPath, flag.Args()[1:])
+	case "sync":
+		cmdErr = cmdSync(ctx, flag.Args()[1:])
+	case "warehouse":
+		cmdErr = cmdWarehouse(ctx, flag.Args()[1:])
+	case "migrate":
+		cmdErr = cmdMigrate(ctx, flag.Args()[1:])
+	case "events":
+		cmdErr = cmdEvents(ctx, flag.Args()[1:])
+	case "backfill":
+		cmdErr = cmdBackfill(ctx, flag.Args()[1:])
+	case "add-url":
+		cmdErr = cmdAddURL(ctx, dests, flag.Args()[1:])
+	case "share":
+		cmdErr = cmdShare(ctx, flag.Args()[1:])
+	case "due":
+		cmdErr = cmdDue(ctx, flag.Args()[1:])
+	case "discord":
+		// Discord Botとして常駐する
+		bot := &discordBot{cfg: conf.Discord}
+		cmdErr = bot.run(ctx)
+	default:
+		return fail(usageErr(fmt.Sprintf("unknown command %q", flag.Arg(0))))
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var url string
-		if err := rows.Scan(&url); err != nil {
-			log.Fatal(err)
+	if flag.Arg(0) != "" {
+		if cmdErr != nil {
+			return fail(cmdErr)
 		}
-		urls = append(urls, url)
+		// fetchとnotifyは一部の失敗を終了コードで知らせる
+		return report.exitCode()
 	}
 
-	for _, url := range urls[:3] {
-		// slackに通知
-		if err := notifySlack(url); err != nil {
-			log.Fatal(err)
+	// 複数台構成ではリースを持つインスタンスだけが取得・通知する
+	if conf.HA.Enabled {
+		el := newElector(conf.HA)
+		leader, err := el.acquire(ctx)
+		if err != nil {
+			return fail(withExitCode(exitDB, err))
 		}
-		// 記事を既読にする
-		if err := markAsRead(ctx, url); err != nil {
-			log.Fatal(err)
+		if !leader {
+			log.Printf("%s is not the leader, skipping fetch and notify", el.holder)
+			return exitOK
 		}
+		var release func()
+		ctx, release = el.hold(ctx)
+		defer release()
+	}
+
+	if err := run(ctx, dests, *force); err != nil {
+		return fail(err)
+	}
+	code := report.exitCode()
+	if code != exitOK {
+		log.Printf("finished with failures: %s", &report)
 	}
 	fmt.Println("finish")
+	return code
 }
 
-func notifySlack(msg string) error {
-	// slackに通知
-	//json marshal
-	payload, err := json.Marshal(map[string]string{
-		"text": msg,
-	})
-	if err != nil {
-		log.Fatal(err)
+// 記事の取得から通知までを実行
+// forceならスケジュールに関わらずすべての処理を行う
+func run(ctx context.Context, dests []destination, force bool) error {
+	now := time.Now()
+	if force || conf.Schedule.shouldFetch(now) {
+		if err := fetchPhase(ctx, blogs()); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("fetch is not scheduled on %s", now.Weekday())
 	}
-	// POSTリクエストを送信
-	webhookURL = strings.TrimSpace(webhookURL)
-	resp, err := http.Post(webhookURL, "application/json", strings.NewReader(string(payload)))
-	if err != nil {
-		log.Fatal("post error:", err)
+	if force || conf.Schedule.shouldNotify(now) {
+		if err := notifyPhase(ctx, dests); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("notify is not scheduled on %s", now.Weekday())
 	}
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Error: status code", resp.StatusCode)
-		return err
+	// 未読の記事のフィードを書き直す
+	if conf.UnreadFeed.Path != "" {
+		return writeUnreadFeedFile(ctx, conf.UnreadFeed, conf.UnreadFeed.Path)
 	}
 	return nil
 }
-func markAsRead(ctx context.Context, url string) error {
-	// トランザクションの開始
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		log.Fatal(err)
+
+// 記事を取得して保存する
+func fetchPhase(ctx context.Context, targets []blog) error {
+	// すべての記事を取得
+	if err := fetchAllArticles(ctx, targets); err != nil {
 		return err
 	}
-	// トランザクションの終了
-	defer tx.Rollback()
 
-	// SQLの準備
-	stmt, err := tx.PrepareContext(ctx, "UPDATE articles SET read = 1 WHERE url = ?")
-	if err != nil {
-		log.Fatal(err)
+	// 記事ページを取得して保存・判定する
+	var steps []pageStep
+	var store blobStore
+	if conf.Archive.Enabled {
+		var err error
+		if store, err = newBlobStore(conf.Archive); err != nil {
+			return err
+		}
+		steps = append(steps, archiveStep(store, conf.Archive))
+	}
+	if conf.Paywall.Enabled {
+		steps = append(steps, paywallStep(conf.Paywall))
+	}
+	if conf.Source.PublishedTimeFromPage {
+		steps = append(steps, publishedTimeStep())
+	}
+	if conf.Readability.Enabled {
+		steps = append(steps, readabilityStep())
+	}
+	if conf.Content.Enabled {
+		steps = append(steps, contentStep(conf.Content))
+	}
+	if len(conf.Categories) > 0 {
+		steps = append(steps, classifyStep(conf.Categories, newLLMClient(conf.LLM)))
+	}
+	if err := processArticlePages(ctx, steps); err != nil {
 		return err
 	}
-	// SQLの終了
-	defer stmt.Close()
-	// SQLの実行
-	_, err = stmt.ExecContext(ctx, url)
+	// 保存したページが上限を超えないようにする
+	if store != nil {
+		return evictArchives(ctx, store, conf.Archive)
+	}
+	return nil
+}
+
+// 未読の記事を通知する
+func notifyPhase(ctx context.Context, dests []destination) error {
+	// 未読の記事を取得
+	// 期限のある記事を先に通知する
+	// ダイジェストは未読の記事をまとめて1通にする
+	limit := conf.Notify.limit()
+	if conf.Digest.Enabled {
+		limit = conf.Digest.Limit
+	}
+	articles, err := queryArticles(ctx, articleFilter{read: boolPtr(false), status: statusOK, awake: true, dueFirst: true, limit: limit})
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
-	// トランザクションの終了
-	if err = tx.Commit(); err != nil {
-		log.Fatal(err)
+	// 期限が近い記事をもう一度知らせる
+	if err := remindDue(ctx, dests); err != nil {
 		return err
 	}
+	// ほかの通知先には届いた記事を、失敗した通知先へ送り直す
+	if err := retryFailedDeliveries(ctx, dests); err != nil {
+		return err
+	}
+	// 輪読会の記事を選んで結果を投稿する
+	if err := pickReadingClub(ctx, dests, conf.ReadingClub, time.Now()); err != nil {
+		return err
+	}
+	// Slackに固定した要約を更新する (失敗しても通知は続ける)
+	if err := updateSlackSummary(ctx, conf.Slack, time.Now()); err != nil {
+		log.Printf("slack summary: %v", err)
+		report.addError()
+	}
+	// 通知したまま読まれていない記事を別の送り先で知らせる
+	if err := remindUnread(ctx, conf.Reminders); err != nil {
+		return err
+	}
+
+	targets := translateTitles(ctx, articles)
+	if conf.Digest.Enabled {
+		if err := notifyDigest(ctx, dests, targets); err != nil {
+			return err
+		}
+	} else {
+		for _, a := range targets {
+			if err := notifyArticle(ctx, dests, a); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 購読者それぞれのダイジェスト
+	if err := notifySubscribers(ctx, conf.Subscriptions); err != nil {
+		return err
+	}
+
+	// 読んだ記事をFediverseへ配信
+	if conf.ActivityPub.Enabled {
+		actor, err := newAPActor(conf.ActivityPub)
+		if err != nil {
+			return err
+		}
+		if err := actor.publish(ctx, conf.Public); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func fetchAllArticles() {
-	resp, err := http.Get(baseURL)
-	if err != nil {
-		log.Fatal(err)
+// 記事を1件ずつ通知先へ送信する
+func notifyArticle(ctx context.Context, dests []destination, a article) error {
+	if conf.Related.Limit > 0 {
+		related, err := findRelated(ctx, a, conf.Related.Limit)
+		if err != nil {
+			// 関連記事がなくても通知はする
+			log.Printf("related %s: %v", a.url, err)
+		}
+		a.related = related
 	}
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Error: status code", resp.StatusCode)
-		return
+	if conf.Discussions.Enabled {
+		discussions, errs := findDiscussions(ctx, a.url)
+		for _, err := range errs {
+			log.Printf("discussions %s: %v", a.url, err)
+		}
+		a.discussions = discussions
 	}
-	defer resp.Body.Close()
-	// HTMLをパース
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	// 通知先ごとに独立して送信
+	// 前回の実行で届いていれば送り直さない
+	results := dispatchOnce(ctx, dests, func(d destination) string {
+		return idempotencyKey(d.name(), a.url)
+	}, func(ctx context.Context, d destination) error {
+		return d.send(ctx, a)
+	})
+	logFailures(a.url, results)
+	// どこにも届かなかった記事は未読のまま残す
+	return settleDeliveries(ctx, a.url, results)
+}
+
+// 記事をダイジェストにまとめて通知先へ送信する
+func notifyDigest(ctx context.Context, dests []destination, articles []article) error {
+	dg, err := buildDigest(articles, conf.Digest.Order)
 	if err != nil {
-		log.Fatal(err)
-	}
-	var articles []article
-	// セレクタで指定した要素を取得
-	doc.Find(".article-list").Each(func(i int, s *goquery.Selection) {
-		//sの下にある全てのliタグを取得
-		s.Find("li").Each(func(j int, s *goquery.Selection) {
-			//href属性の値を取得
-			href, _ := s.Find("a").Attr("href")
-			//title属性の値を取得
-			title, _ := s.Find("a").Attr("title")
-			//class="date"の値を取得
-			date := s.Find(".date").Text()
-			// 2023.06.20をtime.Timeに変換
-			t, err := time.Parse("2006.01.02", date)
-			if err != nil {
-				log.Fatal(err)
-			}
-			outputDate := t.Format("2006-01-02")
-			// hrefから/articlesを削除
-			path := strings.Replace(href, "/articles/", "", 1)
-			// url join
-			endpoint, err := url.JoinPath(baseURL, path)
-			if err != nil {
-				log.Fatal(err)
-			}
-			articles = append(articles, article{title: title, url: endpoint, date: outputDate})
-		})
+		return err
+	}
+	dg.votable = conf.ReadingClub.Enabled
+	urls := make([]string, 0, dg.len())
+	for _, a := range dg.articles() {
+		urls = append(urls, a.url)
+	}
+	results := dispatchOnce(ctx, dests, func(d destination) string {
+		return idempotencyKey(d.name(), urls...)
+	}, func(ctx context.Context, d destination) error {
+		return d.sendDigest(ctx, dg)
 	})
+	logFailures("digest", results)
+	for _, a := range dg.articles() {
+		if err := settleDeliveries(ctx, a.url, digestResults(dests, results, a)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func logFailures(target string, results []deliveryResult) {
+	report.addResults(results)
+	for _, r := range results {
+		if r.err != nil && !errors.Is(r.err, errSkipped) && !errors.Is(r.err, errAlreadyDelivered) {
+			log.Printf("notify %s: %s: %v", r.destination, target, r.err)
+		}
+	}
+}
+
+func markAsRead(ctx context.Context, url string) error {
+	_, err := articleStore.MarkRead(ctx, url)
+	return err
+}
 
-	if err := saveAllArticles(articles); err != nil {
-		log.Fatal(err)
+// ブログの記事を取得して保存する
+// 1つのブログの失敗で残りを止めない
+func fetchAllArticles(ctx context.Context, targets []blog) error {
+	var errs []error
+	for _, b := range targets {
+		if err := fetchBlog(ctx, b); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w: %w", b.name(), errFetchFailed, err))
+		}
 	}
+	return errors.Join(errs...)
 }
-func saveAllArticles(articles []article) error {
-	// articlesをDBに保存
-	// トランザクションの開始
-	tx, err := db.Begin()
+
+func fetchBlog(ctx context.Context, b blog) error {
+	// 初回はページを辿って過去の記事もすべて保存する
+	if b.cfg.Pagination.FirstRun {
+		n, err := storedArticleCount(ctx, b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			log.Printf("first fetch of %s: following all pages of the list", b.name())
+			return backfill(ctx, b, 0, false)
+		}
+	}
+	articles, err := fetchListing(ctx, b, true)
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
-	// SQLの準備
-	stmt, err := tx.Prepare("INSERT INTO articles (title, url, date) VALUES (?, ?, ?)")
+	if articles, err = canonicalize(ctx, b, articles, true); err != nil {
+		return err
+	}
+	if articles, err = limitFirstFetch(ctx, b, articles); err != nil {
+		return err
+	}
+	res, err := saveAllArticles(ctx, applyFilters(b, applyQualityGate(articles, conf.Quality)))
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
-	// SQLの終了
-	defer stmt.Close()
-	// SQLの実行
-	for _, article := range articles {
-		_, err := stmt.Exec(article.title, article.url, article.date)
+	if res.Inserted > 0 {
+		log.Printf("%s: saved %d new articles (%d already stored)", b.name(), res.Inserted, res.Skipped)
+	}
+	// 記事を保存できてから検証子を残す (保存に失敗したら次も一覧を解析する)
+	return saveListValidators(ctx, b)
+}
+
+// ブログの保存済みの記事数
+func storedArticleCount(ctx context.Context, b blog) (int, error) {
+	q, args := selectFrom("articles", "COUNT(*)").where(sourceCond(b.name(), urlHost(b.url))).build()
+	var n int
+	err := db.QueryRowContext(ctx, q, args...).Scan(&n)
+	return n, err
+}
+
+// 初めて取得するブログはfirst_fetch_max_ageより新しい記事だけを保存する
+// 古い記事はbackfillで明示的に取り込む
+func limitFirstFetch(ctx context.Context, b blog, articles []article) ([]article, error) {
+	if b.cfg.FirstFetchMaxAge == "" {
+		return articles, nil
+	}
+	n, err := storedArticleCount(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return articles, nil
+	}
+	maxAge, err := parseDuration(b.cfg.FirstFetchMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-maxAge).In(b.location()).Format("2006-01-02")
+	var recent []article
+	for _, a := range articles {
+		if a.date >= cutoff {
+			recent = append(recent, a)
+		}
+	}
+	if skipped := len(articles) - len(recent); skipped > 0 {
+		log.Printf("first fetch of %s: skipped %d articles older than %s (run \"backfill %s\" to store them)", b.name(), skipped, cutoff, b.name())
+	}
+	return recent, nil
+}
+
+// 記事一覧を取得して解析する
+// writeがfalseならDBに書き込まない (Cookie、一覧の検証用ヘッダ、読み飛ばした項目を保存しない)
+func scrapeArticles(ctx context.Context, b blog, write bool) ([]article, error) {
+	// ログイン用のCookieを付けて取得
+	client, err := newSourceClient(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	// 前回から一覧が変わっていなければ解析しない
+	var cached listValidators
+	if write {
+		if cached, err = loadListValidators(ctx, b); err != nil {
+			return nil, err
+		}
+	}
+	resp, body, err := getListPage(ctx, client, b.url, cached)
+	if err != nil {
+		return nil, err
+	}
+	warnIfMoved(b, resp)
+	if resp.StatusCode == http.StatusNotModified || cached.matches(resp) {
+		log.Printf("%s: list not modified since the last fetch", b.name())
+		return nil, nil
+	}
+	// autoなら一覧のURLがフィードを返してもそのまま読む
+	if b.cfg.Mode == sourceModeAuto && fetcher.LooksLikeFeed(resp.Header.Get("Content-Type"), body) {
+		items, err := fetcher.ParseFeed(bytes.NewReader(body))
 		if err != nil {
-			// 重複エラーをチェック
-			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-				continue
-			} else {
-				// トランザクションの終了
-				tx.Rollback()
-				log.Fatal("stmt.Exec: ", err)
-			}
+			return nil, err
+		}
+		if write {
+			holdListValidators(b, resp)
 		}
+		articles := feedArticles(items, b.location())
+		tagProvenance(articles, provenance{Via: viaFeed, FeedURL: b.url})
+		return articles, nil
 	}
-	// コミット
-	if err := tx.Commit(); err != nil {
-		log.Fatal(err)
-		return err
+	// HTMLをパース
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	// Cookieの同意の画面なら同意して取り直す
+	if resp, doc, err = passConsent(ctx, b, client, resp, doc); err != nil {
+		return nil, err
+	}
+	// ログインが切れている
+	if isLoginPage(b, resp, doc) {
+		return nil, fmt.Errorf("%s: %w (run \"login %s\")", b.name(), errLoginRequired, b.name())
+	}
+	if write {
+		if err := saveSourceCookies(ctx, b, client); err != nil {
+			return nil, err
+		}
+	}
+	items, skipped := fetcher.ParseList(doc, b.url, b.cfg.Selectors.fetcher())
+	reportSkipped(ctx, b, b.url, skipped, write)
+	// autoで一覧が読めなければページが案内するフィードを使う
+	if len(items) == 0 && len(skipped) == 0 && b.cfg.Mode == sourceModeAuto {
+		if feedURL := fetcher.DiscoverFeed(doc, b.url); feedURL != "" {
+			log.Printf("%s: no articles in the list, reading the feed %s", b.name(), feedURL)
+			return fetchFeed(ctx, b, feedURL)
+		}
+	}
+	// 一覧の構造が変わったか、ブロック用のページが返ってきた
+	if len(items) == 0 && len(skipped) == 0 {
+		return nil, &fetchError{kind: fetchErrParseEmpty, err: fmt.Errorf("%s: %w", b.url, fetcher.ErrNoItems)}
+	}
+	if write {
+		holdListValidators(b, resp)
+	}
+	articles := itemArticles(items, b.location())
+	tagProvenance(articles, provenance{Via: viaList, ListURL: b.url, Page: 1, Selectors: selectorVersion(b)})
+	return articles, nil
+}
+
+// 一覧で読めなかった記事を記録する (日付が読めない記事は飛ばす)
+func reportSkipped(ctx context.Context, b blog, pageURL string, skipped []error, write bool) {
+	for _, err := range skipped {
+		kind := fetchErrOther
+		var perr *time.ParseError
+		if errors.As(err, &perr) {
+			kind = fetchErrDateParse
+		}
+		err = &fetchError{kind: kind, err: err}
+		log.Print(err)
+		report.addError()
+		if write {
+			recordFetchError(ctx, b.name(), pageURL, err)
+		}
+	}
+}
+
+// 一覧のページを取得して本文を返す
+// 検証子があれば条件付きで取得し、変わっていなければ (304) 本文なしで返す
+func getListPage(ctx context.Context, client *http.Client, pageURL string, v listValidators) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	v.setHeaders(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && !v.empty() {
+		return resp, nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetch %s: %w", pageURL, &fetcher.StatusError{Code: resp.StatusCode})
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// 一覧の記事を保存する形にする (一覧の日付は日付だけで、locの0時とする)
+func itemArticles(items []fetcher.Item, loc *time.Location) []article {
+	articles := make([]article, 0, len(items))
+	for _, it := range items {
+		date := it.Date.Format("2006-01-02")
+		articles = append(articles, article{title: it.Title, url: it.URL, date: date, publishedAt: publishedAtFromDate(date, loc)})
+	}
+	return articles
+}
+func saveAllArticles(ctx context.Context, articles []article) (store.SaveResult, error) {
+	rows := make([]store.Article, 0, len(articles))
+	for _, a := range articles {
+		rows = append(rows, store.Article{Title: a.title, URL: a.url, Date: a.date, Status: a.status, ReviewReason: a.reviewReason, PublishedAt: a.publishedAt, Source: a.source, Provenance: a.provenance.json()})
+	}
+	return articleStore.SaveArticles(ctx, rows)
 }
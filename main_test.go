@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"fetch-blog/blogtest"
+	"fetch-blog/store"
+)
+
+// テスト用の空のDBを開き、設定を既定にする
+// テストが終わったら元に戻す
+func openTestDB(t *testing.T) {
+	t.Helper()
+	oldConf, oldDB, oldStore := conf, db, articleStore
+	conf = &config{}
+	if err := openDB(filepath.Join(t.TempDir(), "blog.db"), false, false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		articleStore.Close()
+		conf, db, articleStore = oldConf, oldDB, oldStore
+	})
+}
+
+// テスト用に記事を保存する
+func saveTestArticles(t *testing.T, articles ...store.Article) {
+	t.Helper()
+	if _, err := articleStore.SaveArticles(context.Background(), articles); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 記事が既読か
+func isRead(t *testing.T, url string) bool {
+	t.Helper()
+	var read bool
+	if err := db.QueryRow("SELECT read FROM articles WHERE url = ?", url).Scan(&read); err != nil {
+		t.Fatal(err)
+	}
+	return read
+}
+
+// blogtest.Notifierに送る通知先
+type fakeDestination struct {
+	label string
+	n     *blogtest.Notifier
+}
+
+func (f *fakeDestination) name() string { return f.label }
+
+func (f *fakeDestination) send(ctx context.Context, a article) error {
+	return f.n.Notify(ctx, a.title)
+}
+
+func (f *fakeDestination) sendDigest(ctx context.Context, dg *digest) error {
+	return f.n.Notify(ctx, "digest")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// merge: 別のDBの記事を取り込む
+// 同じ記事は既読を優先し、タイトルや日付が食い違う記事はこちらを残して報告する
+func cmdMerge(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	parseFlags(fs, args)
+	other := fs.Arg(0)
+	if other == "" {
+		return usageErr("usage: merge [-dry-run] <other.db>")
+	}
+	if _, err := os.Stat(other); err != nil {
+		return err
+	}
+
+	// ATTACHは接続ごとなので1つの接続で行う
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS other", other); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE other")
+
+	// 相手が古い版で列が足りなくても両方にある列だけを取り込む
+	cols, err := commonColumns(ctx, conn, "articles")
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 食い違い
+	rows, err := tx.QueryContext(ctx, `
+SELECT DISTINCT a.url, a.title, o.title, substr(a.date, 1, 10), substr(o.date, 1, 10)
+FROM articles a JOIN other.articles o ON o.url = a.url
+WHERE a.title != o.title OR substr(a.date, 1, 10) != substr(o.date, 1, 10)
+ORDER BY a.url`)
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	for rows.Next() {
+		var u, title, otherTitle, date, otherDate string
+		if err := rows.Scan(&u, &title, &otherTitle, &date, &otherDate); err != nil {
+			rows.Close()
+			return err
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s\n  this:  %s (%s)\n  other: %s (%s)", u, title, date, otherTitle, otherDate))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// こちらにない記事 (同じURLが複数あれば最後に保存したもの)
+	list := strings.Join(cols, ", ")
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`
+INSERT INTO articles (%s)
+SELECT %s FROM other.articles
+WHERE url NOT IN (SELECT url FROM articles)
+  AND rowid IN (SELECT MAX(rowid) FROM other.articles GROUP BY url)`, list, list))
+	if err != nil {
+		return err
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// 既読を優先する
+	hasReadAt := false
+	for _, c := range cols {
+		hasReadAt = hasReadAt || c == "read_at"
+	}
+	setReadAt := ""
+	if hasReadAt {
+		setReadAt = ", read_at = COALESCE(read_at, (SELECT MIN(o.read_at) FROM other.articles o WHERE o.url = articles.url AND o.read))"
+	}
+	res, err = tx.ExecContext(ctx, `
+UPDATE articles SET read = TRUE`+setReadAt+`
+WHERE NOT read AND url IN (SELECT url FROM other.articles WHERE read)`)
+	if err != nil {
+		return err
+	}
+	marked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// 分類と、as-ofで使う追加・既読の履歴
+	extras := []struct{ table, query string }{
+		{"article_categories", "INSERT OR IGNORE INTO article_categories (url, category) SELECT url, category FROM other.article_categories"},
+		{"events", `
+INSERT INTO events (url, type, created_at)
+SELECT o.url, o.type, o.created_at FROM other.events o
+WHERE NOT EXISTS (SELECT 1 FROM events e WHERE e.url = o.url AND e.type = o.type AND e.created_at = o.created_at)`},
+	}
+	for _, x := range extras {
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM other.sqlite_master WHERE type = 'table' AND name = ?", x.table).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, x.query); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range conflicts {
+		fmt.Println("conflict:", c)
+	}
+	fmt.Printf("%d added, %d marked as read, %d conflicts (kept this database's version)\n", added, marked, len(conflicts))
+	if *dryRun {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// 両方のDBにある列
+func commonColumns(ctx context.Context, conn *sql.Conn, table string) ([]string, error) {
+	names := func(schema string) ([]string, error) {
+		rows, err := conn.QueryContext(ctx, "SELECT name FROM pragma_table_info(?, ?)", table, schema)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var cols []string
+		for rows.Next() {
+			var c string
+			if err := rows.Scan(&c); err != nil {
+				return nil, err
+			}
+			cols = append(cols, c)
+		}
+		return cols, rows.Err()
+	}
+	ours, err := names("main")
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := names("other")
+	if err != nil {
+		return nil, err
+	}
+	if len(theirs) == 0 {
+		return nil, fmt.Errorf("other database has no %s table", table)
+	}
+	has := map[string]bool{}
+	for _, c := range theirs {
+		has[c] = true
+	}
+	var cols []string
+	for _, c := range ours {
+		if has[c] {
+			cols = append(cols, c)
+		}
+	}
+	return cols, nil
+}
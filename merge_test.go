@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"fetch-blog/store"
+)
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	openTestDB(t)
+	saveTestArticles(t,
+		store.Article{Title: "both", URL: "https://example.com/both", Date: "2026-01-01"},
+		store.Article{Title: "read here", URL: "https://example.com/read-here", Date: "2026-01-01"},
+		store.Article{Title: "this title", URL: "https://example.com/conflict", Date: "2026-01-01"},
+	)
+	if _, err := articleStore.MarkRead(ctx, "https://example.com/read-here"); err != nil {
+		t.Fatal(err)
+	}
+
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+	other, err := store.Open(otherPath, store.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.SaveArticles(ctx, []store.Article{
+		{Title: "both", URL: "https://example.com/both", Date: "2026-01-01"},
+		{Title: "read here", URL: "https://example.com/read-here", Date: "2026-01-01"},
+		{Title: "other title", URL: "https://example.com/conflict", Date: "2026-01-02"},
+		{Title: "only there", URL: "https://example.com/only-there", Date: "2026-01-03"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"https://example.com/both", "https://example.com/conflict"} {
+		if _, err := other.MarkRead(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other.Close()
+
+	if err := cmdMerge(ctx, []string{otherPath}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url, title string
+		read       bool
+	}{
+		// 既読を優先する
+		{"https://example.com/both", "both", true},
+		// 相手で未読でもこちらの既読は残す
+		{"https://example.com/read-here", "read here", true},
+		// 食い違う記事はこちらを残す
+		{"https://example.com/conflict", "this title", true},
+		{"https://example.com/only-there", "only there", false},
+	}
+	for _, tt := range tests {
+		var title string
+		var read bool
+		if err := db.QueryRow("SELECT title, read FROM articles WHERE url = ?", tt.url).Scan(&title, &read); err != nil {
+			t.Fatalf("%s: %v", tt.url, err)
+		}
+		if title != tt.title || read != tt.read {
+			t.Errorf("%s = %q read %v, want %q read %v", tt.url, title, read, tt.title, tt.read)
+		}
+	}
+
+	// 2回取り込んでも増えない
+	if err := cmdMerge(ctx, []string{otherPath}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM articles").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("%d articles after merging twice, want 4", n)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// スキーマの変更 (store/migrations) の適用と確認
+// migrateで適用していない変更を適用し、migrate statusで一覧を表示する
+func cmdMigrate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	parseFlags(fs, args)
+	switch fs.Arg(0) {
+	case "":
+		applied, err := articleStore.Migrate(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return nil
+	case "status":
+		ms, err := articleStore.Migrations(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, m := range ms {
+			at := m.AppliedAt
+			if at == "" {
+				at = "pending"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, at)
+		}
+		return w.Flush()
+	}
+	return usageErr(fmt.Sprintf("unknown migrate command %q", fs.Arg(0)))
+}
+
+// sqlite.manual_migrationsのとき、適用していない変更があれば実行しない
+// 古いスキーマのまま動かして壊さないようにする
+func checkMigrations(ctx context.Context) error {
+	ms, err := articleStore.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, m := range ms {
+		if m.AppliedAt == "" {
+			pending = append(pending, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database has %d pending migrations (%s); run the migrate command to apply them", len(pending), pending[0])
+	}
+	return nil
+}
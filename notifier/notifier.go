@@ -0,0 +1,88 @@
+// Package notifier は通知先へメッセージを送る
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// メッセージを送れる通知先
+type Notifier interface {
+	Notify(ctx context.Context, msg string) error
+}
+
+// Block Kitのブロックも送れる通知先
+type BlockNotifier interface {
+	Notifier
+	NotifyBlocks(ctx context.Context, msg string, blocks []any) error
+}
+
+var _ BlockNotifier = (*SlackWebhook)(nil)
+
+type idempotencyKeyCtx struct{}
+
+// 送信の冪等キーを付ける
+// 対応する通知先は再送を受け取った側で見分けられるように送る
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// 送信中の通知の冪等キー (なければ空)
+func IdempotencyKey(ctx context.Context) string {
+	k, _ := ctx.Value(idempotencyKeyCtx{}).(string)
+	return k
+}
+
+// SlackのIncoming Webhook (互換のWebhookにも使える)
+type SlackWebhook struct {
+	URL    string
+	Client *http.Client
+	// リンクとメディアのプレビューを展開するか (nilならSlackの既定)
+	Unfurl *bool
+}
+
+func NewSlackWebhook(url string) *SlackWebhook {
+	return &SlackWebhook{URL: url, Client: http.DefaultClient}
+}
+
+func (s *SlackWebhook) Notify(ctx context.Context, msg string) error {
+	return s.NotifyBlocks(ctx, msg, nil)
+}
+
+// Block Kitのブロックを付けて送る
+// msgは通知やブロックを表示できないクライアントで使われる
+func (s *SlackWebhook) NotifyBlocks(ctx context.Context, msg string, blocks []any) error {
+	body := map[string]any{"text": msg}
+	if len(blocks) > 0 {
+		body["blocks"] = blocks
+	}
+	if s.Unfurl != nil {
+		body["unfurl_links"] = *s.Unfurl
+		body["unfurl_media"] = *s.Unfurl
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Slackは使わないが互換のWebhookのため
+	if key := IdempotencyKey(ctx); key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack: status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fetch-blog/notifier"
+	"fetch-blog/store"
+)
+
+// 通知先
+type destination interface {
+	name() string
+	send(ctx context.Context, a article) error
+	sendDigest(ctx context.Context, dg *digest) error
+}
+
+// 通知先ごとの送信結果
+type deliveryResult struct {
+	destination string
+	// 冪等キー (dispatchOnceで送った場合)
+	key string
+	err error
+}
+
+// 設定から通知先を作成
+func newDestinations(cfgs []destinationConfig, pub publicConfig) ([]destination, error) {
+	var dests []destination
+	for _, c := range cfgs {
+		maxLength := c.MaxLength
+		if maxLength == 0 {
+			maxLength = defaultMaxLength[c.Type]
+		}
+		switch c.Type {
+		case "slack":
+			d := &slackDestination{label: c.Name, webhookURL: c.WebhookURL, channelID: c.ChannelID, maxLength: maxLength}
+			switch c.Unfurl {
+			case "", "auto":
+			case "always", "never":
+				unfurl := c.Unfurl == "always"
+				d.unfurl = &unfurl
+			default:
+				return nil, fmt.Errorf("destination %s: unknown unfurl %q (auto, always or never)", c.Name, c.Unfurl)
+			}
+			// channel_idがあればBotとして投稿する (bot_tokenの既定はslack.bot_token)
+			if c.ChannelID != "" {
+				d.token = c.BotToken
+				if d.token == "" {
+					d.token = conf.Slack.BotToken
+				}
+			}
+			dests = append(dests, d)
+		case "email":
+			dests = append(dests, &emailDestination{cfg: c})
+		case "bluesky":
+			pds := strings.TrimRight(c.PDS, "/")
+			if pds == "" {
+				pds = "https://bsky.social"
+			}
+			dests = append(dests, &blueskyDestination{
+				socialPoster: newSocialPoster(c, pub),
+				pds:          pds,
+				handle:       c.Handle,
+				password:     c.AppPassword,
+			})
+		case "x":
+			dests = append(dests, &xDestination{
+				socialPoster:      newSocialPoster(c, pub),
+				consumerKey:       c.ConsumerKey,
+				consumerSecret:    c.ConsumerSecret,
+				accessToken:       c.AccessToken,
+				accessTokenSecret: c.AccessTokenSecret,
+			})
+		case "discord":
+			token := c.BotToken
+			if token == "" {
+				token = conf.Discord.BotToken
+			}
+			dests = append(dests, &discordDestination{label: c.Name, token: token, channelID: c.ChannelID, maxLength: maxLength})
+		default:
+			return nil, fmt.Errorf("destination %q: unknown type %q", c.Name, c.Type)
+		}
+		var accepts []func(a article) bool
+		if c.SkipPaywalled {
+			accepts = append(accepts, func(a article) bool { return !a.paywalled })
+		}
+		if len(c.Categories) > 0 {
+			categories := c.Categories
+			accepts = append(accepts, func(a article) bool { return a.inCategory(categories...) })
+		}
+		if len(accepts) > 0 {
+			dests[len(dests)-1] = &routedDestination{
+				destination: dests[len(dests)-1],
+				accept: func(a article) bool {
+					for _, ok := range accepts {
+						if !ok(a) {
+							return false
+						}
+					}
+					return true
+				},
+			}
+		}
+	}
+	return dests, nil
+}
+
+// 条件に合う記事だけを送る通知先
+type routedDestination struct {
+	destination
+	accept func(a article) bool
+}
+
+func (r *routedDestination) send(ctx context.Context, a article) error {
+	if !r.accept(a) {
+		return errSkipped
+	}
+	return r.destination.send(ctx, a)
+}
+
+func (r *routedDestination) sendDigest(ctx context.Context, dg *digest) error {
+	filtered := dg.filter(r.accept)
+	if filtered.len() == 0 {
+		return errSkipped
+	}
+	return r.destination.sendDigest(ctx, filtered)
+}
+
+// 通知先がダイジェストに載せる記事か (条件のない通知先はすべて載せる)
+func acceptsArticle(d destination, a article) bool {
+	if r, ok := d.(*routedDestination); ok {
+		return r.accept(a)
+	}
+	return true
+}
+
+// ダイジェストの送信結果を1つの記事の結果にする
+// 通知先が条件で除いた記事は、ダイジェストが届いていてもその通知先ではskippedにする
+// 冪等キーはダイジェスト全体のものなので、skippedの記録には付けない
+func digestResults(dests []destination, results []deliveryResult, a article) []deliveryResult {
+	res := make([]deliveryResult, len(results))
+	for i, r := range results {
+		if !acceptsArticle(dests[i], a) {
+			r = deliveryResult{destination: r.destination, err: errSkipped}
+		}
+		res[i] = r
+	}
+	return res
+}
+
+// すべての通知先へ並行して送信する
+// ある通知先の失敗は他の通知先に影響しない
+// notify.max_per_minuteを超える送信は順番が来るまで待つ
+func dispatch(ctx context.Context, dests []destination, fn func(context.Context, destination) error) []deliveryResult {
+	return dispatchAll(ctx, dests, func(ctx context.Context, d destination) error {
+		if err := sendLimit.wait(ctx); err != nil {
+			return err
+		}
+		return fn(ctx, d)
+	})
+}
+
+// 送信の上限を数えずに通知先へ並行して送る
+func dispatchAll(ctx context.Context, dests []destination, fn func(context.Context, destination) error) []deliveryResult {
+	results := make([]deliveryResult, len(dests))
+	var wg sync.WaitGroup
+	for i, d := range dests {
+		wg.Add(1)
+		go func(i int, d destination) {
+			defer wg.Done()
+			err := fn(ctx, d)
+			results[i] = deliveryResult{destination: d.name(), err: err}
+		}(i, d)
+	}
+	wg.Wait()
+	return results
+}
+
+// 1件でも届いた通知先があればtrue
+func anyDelivered(results []deliveryResult) bool {
+	for _, r := range results {
+		if r.err == nil || errors.Is(r.err, errAlreadyDelivered) {
+			return true
+		}
+	}
+	return false
+}
+
+// すべての通知先が対象外として送らなかったらtrue
+// skip_paywalledやcategoriesで除いた記事は、次の実行でも送られない
+func allSkipped(results []deliveryResult) bool {
+	for _, r := range results {
+		if !errors.Is(r.err, errSkipped) {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+// 通知先ごとの送信結果をDBに記録し、どこかに届いていれば記事を既読にする
+// すべての通知先が対象外とした記事も既読にする (未読のまま残すと古い順の通知の先頭に居座り、新しい記事が通知されなくなる)
+// 記録と既読を同じトランザクションにするので、届いていない記事だけが既読になることはない
+// 失敗した通知先はnotify_retriesに残し、次の通知で送り直す
+func settleDeliveries(ctx context.Context, url string, results []deliveryResult) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO deliveries (url, destination, status, error, delivered_at, idempotency_key) VALUES (?, ?, ?, ?, ?, NULLIF(?, ''))")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, r := range results {
+		status, msg := "ok", ""
+		switch {
+		case errors.Is(r.err, errAlreadyDelivered):
+			// 前回の記録がそのまま残っている
+			if err := dequeueRetry(ctx, tx, url, r.destination); err != nil {
+				return err
+			}
+			continue
+		case errors.Is(r.err, errUnresolved):
+			continue
+		case errors.Is(r.err, errSkipped):
+			status = "skipped"
+		case r.err != nil && r.key != "" && isAmbiguous(r.err):
+			// 届いたかわからないので確認されるまで再送しない
+			status, msg = deliveryUnknown, r.err.Error()
+		case r.err != nil:
+			status, msg = "failed", r.err.Error()
+		}
+		if _, err := stmt.ExecContext(ctx, url, r.destination, status, msg, now, r.key); err != nil {
+			return err
+		}
+		if status == "failed" {
+			err = enqueueRetry(ctx, tx, url, r.destination, msg, now)
+		} else {
+			err = dequeueRetry(ctx, tx, url, r.destination)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if anyDelivered(results) || allSkipped(results) {
+		if _, err := store.MarkRead(ctx, tx, url); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Slack Incoming Webhook
+type slackDestination struct {
+	label      string
+	webhookURL string
+	// メッセージの文字数の上限 (0なら切り詰めない)
+	maxLength int
+	// リンクのプレビューを展開するか (nilならSlackに任せる)
+	unfurl *bool
+
+	// Botトークンで投稿する場合
+	// 投稿に✅を付けると既読にできるようメッセージと記事の対応を記録する
+	token     string
+	channelID string
+}
+
+func (s *slackDestination) name() string { return s.label }
+
+func (s *slackDestination) send(ctx context.Context, a article) error {
+	msg := a.url
+	if a.paywalled {
+		msg = paywallMark() + " " + a.url
+	}
+	msg = truncateMessage(msg+articleExtras(a, "*%s*"), s.maxLength)
+	// textは通知とブロックを表示できないクライアントで使われる
+	blocks := articleBlocks(a)
+	if s.token == "" {
+		return s.webhook().NotifyBlocks(ctx, msg, blocks)
+	}
+	ts, err := s.postMessage(ctx, msg, blocks)
+	if err != nil {
+		return err
+	}
+	// 投稿は済んでいるので記録の失敗は通知の失敗にしない
+	if err := recordMessageRef(ctx, "slack", slackMessageID(s.channelID, ts), a.url); err != nil {
+		log.Printf("slack: %v", err)
+	}
+	return nil
+}
+
+func (s *slackDestination) sendDigest(ctx context.Context, dg *digest) error {
+	msg := truncateMessage(dg.text("*%s*"), s.maxLength)
+	blocks := digestBlocks(dg)
+	if dg.votable {
+		blocks = voteBlocks(dg)
+	}
+	if s.token == "" {
+		return s.webhook().NotifyBlocks(ctx, msg, blocks)
+	}
+	_, err := s.postMessage(ctx, msg, blocks)
+	return err
+}
+
+func (s *slackDestination) webhook() *notifier.SlackWebhook {
+	w := slackWebhook(s.webhookURL)
+	w.Unfurl = s.unfurl
+	return w
+}
+
+// ダイジェストのBlock Kit (見出しごとに、タイトルをリンクにした番号付きの一覧)
+// ブロックが多すぎるときはnil (テキストだけで送る)
+func digestBlocks(dg *digest) []any {
+	var blocks []any
+	n := 0
+	for _, g := range dg.groups {
+		if g.header != "" {
+			blocks = append(blocks, map[string]any{"type": "header", "text": plainText(g.header)})
+		}
+		// セクションの文字数の上限を超えないよう行で分ける
+		var b strings.Builder
+		for _, a := range g.articles {
+			n++
+			line := fmt.Sprintf("%d. <%s|%s> (%s)\n", n, a.url, slackEscape(displayTitle(a)), a.date)
+			if b.Len() > 0 && b.Len()+len(line) > maxSlackSectionLength {
+				blocks = append(blocks, map[string]any{"type": "section", "text": mrkdwn(b.String())})
+				b.Reset()
+			}
+			b.WriteString(line)
+		}
+		if b.Len() > 0 {
+			blocks = append(blocks, map[string]any{"type": "section", "text": mrkdwn(b.String())})
+		}
+	}
+	if len(blocks) > maxSlackBlocks {
+		return nil
+	}
+	return blocks
+}
+
+// 記事1件のBlock Kit
+// タイトルを記事へのリンクにし、公開日と配信元のブログを添える
+func articleBlocks(a article) []any {
+	title := displayTitle(a)
+	if a.title == "" && a.translatedTitle == "" {
+		title = a.url
+	}
+	blocks := []any{map[string]any{
+		"type": "section",
+		"text": mrkdwn(fmt.Sprintf("*<%s|%s>*", a.url, slackEscape(title))),
+	}}
+	var meta []any
+	if a.date != "" {
+		meta = append(meta, mrkdwn("📅 "+a.date))
+	}
+	meta = append(meta, mrkdwn("📰 "+slackEscape(articleSource(a))))
+	blocks = append(blocks, map[string]any{"type": "context", "elements": meta})
+	// 議論と関連記事 (セクションの文字数の上限は3000)
+	if extras := strings.TrimSpace(articleExtras(a, "*%s*")); extras != "" {
+		blocks = append(blocks, map[string]any{"type": "section", "text": mrkdwn(truncateMessage(extras, maxSlackSectionLength))})
+	}
+	return blocks
+}
+
+// chat.postMessageで投稿してメッセージのtsを返す
+// blocksがあればBlock Kitで表示する
+func (s *slackDestination) postMessage(ctx context.Context, msg string, blocks []any) (string, error) {
+	var res struct {
+		TS string `json:"ts"`
+	}
+	payload := map[string]any{
+		"channel": s.channelID,
+		"text":    msg,
+	}
+	if len(blocks) > 0 {
+		payload["blocks"] = blocks
+	}
+	if s.unfurl != nil {
+		payload["unfurl_links"] = *s.unfurl
+		payload["unfurl_media"] = *s.unfurl
+	}
+	err := slackAPI(ctx, s.token, "chat.postMessage", payload, &res)
+	return res.TS, err
+}
+
+// tsはチャンネル内でのみ一意なのでチャンネルと組にする
+func slackMessageID(channel, ts string) string {
+	return channel + "/" + ts
+}
+
+// 共有のクライアントで送るSlackのIncoming Webhook
+func slackWebhook(url string) *notifier.SlackWebhook {
+	w := notifier.NewSlackWebhook(url)
+	w.Client = httpClient
+	return w
+}
+
+// SMTPによるメール通知
+type emailDestination struct {
+	cfg destinationConfig
+}
+
+func (e *emailDestination) name() string { return e.cfg.Name }
+
+func (e *emailDestination) send(ctx context.Context, a article) error {
+	title := displayTitle(a)
+	body := fmt.Sprintf("%s\r\n%s\r\n%s\r\n", title, a.date, a.url) + strings.ReplaceAll(articleExtras(a, "[%s]"), "\n", "\r\n")
+	return e.sendMail(ctx, title, body)
+}
+
+func (e *emailDestination) sendDigest(ctx context.Context, dg *digest) error {
+	subject := fmt.Sprintf("ブログ記事ダイジェスト (%d件)", dg.len())
+	return e.sendMail(ctx, subject, strings.ReplaceAll(dg.text("[%s]"), "\n", "\r\n"))
+}
+
+func (e *emailDestination) sendMail(ctx context.Context, subject, body string) error {
+	body = truncateMessage(body, e.cfg.MaxLength)
+	port := e.cfg.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := e.cfg.SMTPHost + ":" + strconv.Itoa(port)
+	var auth smtp.Auth
+	if e.cfg.Username != "" {
+		auth = smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.SMTPHost)
+	}
+	// メール本文を組み立て
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	// 同じ通知の再送はメールソフトで重複として扱われる
+	if key := idempotencyKeyFrom(ctx); key != "" {
+		fmt.Fprintf(&b, "Message-ID: <%s@fetch-blog>\r\n", key)
+	}
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(body)
+
+	err := sendMail(ctx, addr, e.cfg.SMTPHost, auth, e.cfg.From, e.cfg.To, []byte(b.String()))
+	// キャンセルで接続を閉じたときは閉じた接続のエラーではなくその理由を返す
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// smtp.SendMailと同じ手順で送る
+// net/smtpはcontextに対応していないので、接続に期限を付けてキャンセルされたら閉じる
+func sendMail(ctx context.Context, addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := connectDialer().DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(httpSettings.timeout()))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"fetch-blog/blogtest"
+	"fetch-blog/store"
+)
+
+func TestNotifyArticleSettles(t *testing.T) {
+	reject := func(article) bool { return false }
+	tests := []struct {
+		name string
+		// 通知先ごとに条件で除くか、送信に失敗するか
+		skip, fail []bool
+		wantRead   bool
+		wantSent   int
+	}{
+		{name: "delivered", skip: []bool{false}, fail: []bool{false}, wantRead: true, wantSent: 1},
+		{name: "failed", skip: []bool{false}, fail: []bool{true}, wantRead: false},
+		{name: "all skipped", skip: []bool{true, true}, fail: []bool{false, false}, wantRead: true},
+		{name: "skipped and delivered", skip: []bool{true, false}, fail: []bool{false, false}, wantRead: true, wantSent: 1},
+		{name: "skipped and failed", skip: []bool{true, false}, fail: []bool{false, true}, wantRead: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			const url = "https://example.com/a"
+			saveTestArticles(t, store.Article{Title: "A", URL: url, Date: "2026-01-01"})
+
+			var dests []destination
+			var notifiers []*blogtest.Notifier
+			for i := range tt.skip {
+				n := &blogtest.Notifier{}
+				if tt.fail[i] {
+					n.Err = errors.New("boom")
+				}
+				notifiers = append(notifiers, n)
+				var d destination = &fakeDestination{label: string(rune('a' + i)), n: n}
+				if tt.skip[i] {
+					d = &routedDestination{destination: d, accept: reject}
+				}
+				dests = append(dests, d)
+			}
+			if err := notifyArticle(context.Background(), dests, article{title: "A", url: url}); err != nil {
+				t.Fatal(err)
+			}
+			if got := isRead(t, url); got != tt.wantRead {
+				t.Errorf("read = %v, want %v", got, tt.wantRead)
+			}
+			sent := 0
+			for _, n := range notifiers {
+				sent += len(n.Messages())
+			}
+			if sent != tt.wantSent {
+				t.Errorf("sent %d messages, want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestAllSkipped(t *testing.T) {
+	tests := []struct {
+		errs []error
+		want bool
+	}{
+		{nil, false},
+		{[]error{errSkipped}, true},
+		{[]error{errSkipped, errSkipped}, true},
+		{[]error{errSkipped, nil}, false},
+		{[]error{errSkipped, errAlreadyDelivered}, false},
+		{[]error{errSkipped, errors.New("boom")}, false},
+	}
+	for _, tt := range tests {
+		var results []deliveryResult
+		for _, err := range tt.errs {
+			results = append(results, deliveryResult{err: err})
+		}
+		if got := allSkipped(results); got != tt.want {
+			t.Errorf("allSkipped(%v) = %v, want %v", tt.errs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// 通知に失敗した記事と通知先の待ち行列 (notify_retries)
+// どこにも届かなかった記事は未読のまま残るので、次の通知でそのまま送り直す
+// ほかの通知先には届いて既読になった記事は、ここから失敗した通知先へだけ送り直す
+const maxNotifyRetries = 5
+
+func enqueueRetry(ctx context.Context, ex execer, url, destination, msg, now string) error {
+	_, err := ex.ExecContext(ctx, `
+INSERT INTO notify_retries (url, destination, attempts, last_error, first_failed_at, last_failed_at) VALUES (?, ?, 1, ?, ?, ?)
+ON CONFLICT (url, destination) DO UPDATE SET attempts = attempts + 1, last_error = excluded.last_error, last_failed_at = excluded.last_failed_at`,
+		url, destination, msg, now, now)
+	return err
+}
+
+func dequeueRetry(ctx context.Context, ex execer, url, destination string) error {
+	_, err := ex.ExecContext(ctx, "DELETE FROM notify_retries WHERE url = ? AND destination = ?", url, destination)
+	return err
+}
+
+// 既読になった記事を失敗した通知先へ送り直す
+// maxNotifyRetries回失敗したものは諦めて待ち行列に残す (deliveries queueで確認できる)
+func retryFailedDeliveries(ctx context.Context, dests []destination) error {
+	rows, err := db.QueryContext(ctx, `
+SELECT r.url, r.destination FROM notify_retries r
+WHERE r.attempts < ?
+  AND EXISTS (SELECT 1 FROM articles a WHERE a.url = r.url AND a.read = 1)
+  AND EXISTS (SELECT 1 FROM deliveries d WHERE d.url = r.url AND d.status = 'ok')
+ORDER BY r.first_failed_at`, maxNotifyRetries)
+	if err != nil {
+		return err
+	}
+	type queued struct{ url, destination string }
+	var queue []queued
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.url, &q.destination); err != nil {
+			rows.Close()
+			return err
+		}
+		queue = append(queue, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	byName := make(map[string]destination, len(dests))
+	for _, d := range dests {
+		byName[d.name()] = d
+	}
+	for _, q := range queue {
+		d, ok := byName[q.destination]
+		if !ok {
+			// 設定から消えた通知先には送れない
+			log.Printf("notify retry %s: destination %s is no longer configured", q.url, q.destination)
+			if err := dequeueRetry(ctx, db, q.url, q.destination); err != nil {
+				return err
+			}
+			continue
+		}
+		articles, err := queryArticles(ctx, articleFilter{url: q.url, limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(articles) == 0 {
+			if err := dequeueRetry(ctx, db, q.url, q.destination); err != nil {
+				return err
+			}
+			continue
+		}
+		a := articles[0]
+		results := dispatchOnce(ctx, []destination{d}, func(d destination) string {
+			return idempotencyKey(d.name(), a.url)
+		}, func(ctx context.Context, d destination) error {
+			return d.send(ctx, a)
+		})
+		logFailures(a.url, results)
+		if err := settleDeliveries(ctx, a.url, results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 送り直しを待っている通知の一覧
+func printRetryQueue(ctx context.Context) error {
+	rows, err := db.QueryContext(ctx, `
+SELECT last_failed_at, destination, attempts, url, last_error FROM notify_retries ORDER BY first_failed_at`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LAST FAILED\tDESTINATION\tATTEMPTS\tURL\tERROR")
+	for rows.Next() {
+		var at, dest, u, msg string
+		var attempts int
+		if err := rows.Scan(&at, &dest, &attempts, &u, &msg); err != nil {
+			return err
+		}
+		n := fmt.Sprint(attempts)
+		if attempts >= maxNotifyRetries {
+			n += " (gave up)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", at, dest, n, u, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC (OAuth2) によるログイン
+// issuerを指定するとディスカバリで各エンドポイントを調べる (Google, Keycloakなど)
+// GitHubはOIDCに対応していないのでprovider: githubで専用の手順を使う
+type oidcLogin struct {
+	cfg         oidcConfig
+	redirectURL string
+
+	mu   sync.Mutex
+	meta *oidcMetadata
+}
+
+// ディスカバリで得る情報
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// 認可の途中で覚えておく値のCookie
+const oidcStateCookie = "oidc_state"
+
+func newOIDCLogin(c serverAuthConfig) *oidcLogin {
+	o := c.OIDC
+	if o.ClientID == "" {
+		return nil
+	}
+	return &oidcLogin{cfg: o, redirectURL: strings.TrimRight(c.PublicURL, "/") + "/oidc/callback"}
+}
+
+func (o *oidcLogin) register(mux *http.ServeMux, s *sessionAuth) {
+	mux.HandleFunc("/oidc/login", o.handleLogin)
+	mux.HandleFunc("/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+		o.handleCallback(w, r, s)
+	})
+}
+
+// 識別子の発行元 (利用者との対応付けに使う)
+func (o *oidcLogin) issuer() string {
+	if o.cfg.Provider == "github" {
+		return "https://github.com"
+	}
+	return strings.TrimRight(o.cfg.Issuer, "/")
+}
+
+func (o *oidcLogin) metadata(ctx context.Context) (*oidcMetadata, error) {
+	if o.cfg.Provider == "github" {
+		return &oidcMetadata{
+			Issuer:                o.issuer(),
+			AuthorizationEndpoint: "https://github.com/login/oauth/authorize",
+			TokenEndpoint:         "https://github.com/login/oauth/access_token",
+		}, nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.meta != nil {
+		return o.meta, nil
+	}
+	var m oidcMetadata
+	if err := getJSON(ctx, o.issuer()+"/.well-known/openid-configuration", "", &m); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(m.Issuer, "/") != o.issuer() {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", m.Issuer)
+	}
+	o.meta = &m
+	return o.meta, nil
+}
+
+// GET /oidc/login?next=...
+func (o *oidcLogin) handleLogin(w http.ResponseWriter, r *http.Request) {
+	meta, err := o.metadata(r.Context())
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "login provider unavailable", http.StatusBadGateway)
+		return
+	}
+	state, err := newToken()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := newToken()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	// state, PKCEのverifier, 戻り先をCookieに入れてコールバックで照合する
+	http.SetCookie(w, secureCookie(r, &http.Cookie{
+		Name:   oidcStateCookie,
+		Value:  strings.Join([]string{state, verifier, url.QueryEscape(safeNext(r.URL.Query().Get("next")))}, "."),
+		Path:   "/oidc/",
+		MaxAge: 600,
+	}))
+	challenge := sha256.Sum256([]byte(verifier))
+	scope := "openid email profile"
+	if o.cfg.Provider == "github" {
+		scope = "read:user"
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.cfg.ClientID},
+		"redirect_uri":          {o.redirectURL},
+		"scope":                 {scope},
+		"state":                 {state},
+		"nonce":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, meta.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// GET /oidc/callback?code=...&state=...
+func (o *oidcLogin) handleCallback(w http.ResponseWriter, r *http.Request, s *sessionAuth) {
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "login session expired", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, secureCookie(r, &http.Cookie{Name: oidcStateCookie, Path: "/oidc/", MaxAge: -1}))
+	parts := strings.SplitN(c.Value, ".", 3)
+	if len(parts) != 3 || r.URL.Query().Get("state") != parts[0] {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		renderLogin(w, http.StatusUnauthorized, s.page(loginPage{Error: "ログインが取り消されました: " + e}))
+		return
+	}
+	id, err := o.exchange(r.Context(), r.URL.Query().Get("code"), parts[1], parts[0])
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "login failed", http.StatusBadGateway)
+		return
+	}
+	user, err := o.resolveUser(r.Context(), id)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if user == "" {
+		log.Printf("oidc: no user for %s %s (%s)", o.issuer(), id.subject, id.name)
+		renderLogin(w, http.StatusForbidden, s.page(loginPage{Error: id.name + " は招待されていません"}))
+		return
+	}
+	if err := s.startSession(w, r, user); err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	next, _ := url.QueryUnescape(parts[2])
+	http.Redirect(w, r, safeNext(next), http.StatusSeeOther)
+}
+
+// 外部の識別子
+type oidcIdentity struct {
+	subject string
+	// 利用者名との照合に使う (メールアドレスかGitHubのログイン名)
+	name string
+}
+
+// 認可コードをトークンに交換して識別子を得る
+func (o *oidcLogin) exchange(ctx context.Context, code, verifier, nonce string) (*oidcIdentity, error) {
+	meta, err := o.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.redirectURL},
+		"client_id":     {o.cfg.ClientID},
+		"client_secret": {o.cfg.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.Error != "" {
+		return nil, fmt.Errorf("token: status code %d %s", resp.StatusCode, tok.Error)
+	}
+
+	if o.cfg.Provider == "github" {
+		var u struct {
+			ID    int64  `json:"id"`
+			Login string `json:"login"`
+		}
+		if err := getJSON(ctx, "https://api.github.com/user", tok.AccessToken, &u); err != nil {
+			return nil, err
+		}
+		return &oidcIdentity{subject: fmt.Sprint(u.ID), name: u.Login}, nil
+	}
+	return o.verifyIDToken(tok.IDToken, nonce, meta.Issuer)
+}
+
+// IDトークンのクレームを検証する
+// トークンエンドポイントからTLSで直接受け取ったので署名の検証は省く (OIDC Core 3.1.3.7)
+func (o *oidcLogin) verifyIDToken(raw, nonce, issuer string) (*oidcIdentity, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token: malformed")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("id_token: %w", err)
+	}
+	var claims struct {
+		Iss           string          `json:"iss"`
+		Sub           string          `json:"sub"`
+		Aud           json.RawMessage `json:"aud"`
+		Exp           int64           `json:"exp"`
+		Nonce         string          `json:"nonce"`
+		Email         string          `json:"email"`
+		EmailVerified *bool           `json:"email_verified"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("id_token: %w", err)
+	}
+	// audは文字列か配列
+	var aud []string
+	if json.Unmarshal(claims.Aud, &aud) != nil {
+		var one string
+		json.Unmarshal(claims.Aud, &one)
+		aud = []string{one}
+	}
+	audOK := false
+	for _, a := range aud {
+		if a == o.cfg.ClientID {
+			audOK = true
+		}
+	}
+	switch {
+	case claims.Iss != issuer:
+		return nil, fmt.Errorf("id_token: unexpected issuer %q", claims.Iss)
+	case !audOK:
+		return nil, errors.New("id_token: audience mismatch")
+	case time.Now().Unix() > claims.Exp:
+		return nil, errors.New("id_token: expired")
+	case claims.Nonce != nonce:
+		return nil, errors.New("id_token: nonce mismatch")
+	case claims.Sub == "":
+		return nil, errors.New("id_token: missing sub")
+	}
+	id := &oidcIdentity{subject: claims.Sub}
+	// 確認されていないメールアドレスでは利用者に結び付けない
+	if claims.EmailVerified == nil || *claims.EmailVerified {
+		id.name = claims.Email
+	}
+	return id, nil
+}
+
+// 識別子に対応する利用者
+// 初回は同じ名前 (メールアドレスかログイン名) の利用者に結び付ける
+// 該当がなければ空を返す (招待されていない)
+func (o *oidcLogin) resolveUser(ctx context.Context, id *oidcIdentity) (string, error) {
+	var user string
+	err := db.QueryRowContext(ctx, "SELECT user FROM user_identities WHERE issuer = ? AND subject = ?", o.issuer(), id.subject).Scan(&user)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if id.name == "" {
+		return "", nil
+	}
+	err = db.QueryRowContext(ctx, "SELECT name FROM users WHERE name = ?", id.name).Scan(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO user_identities (issuer, subject, user, created_at) VALUES (?, ?, ?, ?)",
+		o.issuer(), id.subject, user, time.Now().UTC().Format(time.RFC3339))
+	return user, err
+}
+
+// GETしてJSONを読み込む
+func getJSON(ctx context.Context, u, bearer string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s: status code %d: %s", u, resp.StatusCode, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OPMLの項目 (フォルダは項目を入れ子にする)
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlDocument struct {
+	Body struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+// フォルダをたどってフィードの項目を集める
+func opmlFeeds(outlines []opmlOutline) []opmlOutline {
+	var feeds []opmlOutline
+	for _, o := range outlines {
+		if o.XMLURL != "" {
+			feeds = append(feeds, o)
+		}
+		feeds = append(feeds, opmlFeeds(o.Outlines)...)
+	}
+	return feeds
+}
+
+// import-opml: フィードリーダーのOPMLからブログを設定ファイルのblogsに追加する
+// フィードで取得するブログ (mode: feed) として追加し、設定済みのフィードは飛ばす
+func cmdImportOPML(configPath string, args []string) error {
+	fs := flag.NewFlagSet("import-opml", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the blogs that would be added without writing the config")
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		return usageErr("usage: import-opml [-dry-run] <file.opml>")
+	}
+	src, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var opml opmlDocument
+	if err := xml.Unmarshal(src, &opml); err != nil {
+		return fmt.Errorf("parse %s: %w", fs.Arg(0), err)
+	}
+
+	// 設定済みのURLと名前
+	known := map[string]bool{}
+	names := map[string]bool{}
+	for _, b := range blogs() {
+		known[b.url] = true
+		known[b.cfg.FeedURL] = true
+		names[b.name()] = true
+	}
+	var added []sourceConfig
+	for _, o := range opmlFeeds(opml.Body.Outlines) {
+		if known[o.XMLURL] || (o.HTMLURL != "" && known[o.HTMLURL]) {
+			continue
+		}
+		known[o.XMLURL] = true
+		s := sourceConfig{URL: o.HTMLURL, Mode: sourceModeFeed, FeedURL: o.XMLURL}
+		if s.URL == "" {
+			s.URL = o.XMLURL
+		}
+		// 名前は記事のsourceにもなるので重ならないようにする (重なればホスト名のまま)
+		name := strings.TrimSpace(o.Title)
+		if name == "" {
+			name = strings.TrimSpace(o.Text)
+		}
+		if name != "" && !names[name] && !names[urlHost(s.URL)] {
+			s.Name = name
+		}
+		names[blog{url: s.URL, cfg: s}.name()] = true
+		added = append(added, s)
+	}
+	for _, s := range added {
+		fmt.Printf("%s\t%s\n", blog{url: s.URL, cfg: s}.name(), s.FeedURL)
+	}
+	if len(added) == 0 || *dryRun {
+		fmt.Printf("%d blogs to add\n", len(added))
+		return nil
+	}
+	nodes := make([]*yaml.Node, len(added))
+	for i, s := range added {
+		nodes[i] = feedSourceNode(s)
+	}
+	if err := addBlogsToConfig(configPath, nodes); err != nil {
+		return err
+	}
+	fmt.Printf("added %d blogs to %s\n", len(added), configPath)
+	return nil
+}
+
+// 設定ファイルのblogsにブログを追加する
+// blogsがなくsourceの1つだけを取得していたなら、そのブログを先頭に移して取得を続ける
+func addBlogsToConfig(path string, added []*yaml.Node) error {
+	var doc yaml.Node
+	src, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: the top level is not a mapping", path)
+	}
+	seq := mappingValue(root, "blogs")
+	if seq == nil || seq.Kind != yaml.SequenceNode || len(seq.Content) == 0 {
+		if seq == nil {
+			seq = &yaml.Node{Kind: yaml.SequenceNode}
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "blogs"}, seq)
+		}
+		seq.Kind, seq.Tag, seq.Value = yaml.SequenceNode, "", ""
+		// sourceの設定を写し、url.txtのURLならurlに書く
+		if current := blogs()[0]; current.url != "" {
+			n := &yaml.Node{Kind: yaml.MappingNode}
+			if m := mappingValue(root, "source"); m != nil && m.Kind == yaml.MappingNode {
+				n.Content = append(n.Content, m.Content...)
+			}
+			setMappingValue(n, "url", current.url)
+			seq.Content = append(seq.Content, n)
+		}
+	}
+	seq.Content = append(seq.Content, added...)
+	return writeConfigNode(path, &doc)
+}
+
+// フィードで取得するブログの設定
+func feedSourceNode(s sourceConfig) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	if s.Name != "" {
+		setMappingValue(n, "name", s.Name)
+	}
+	setMappingValue(n, "url", s.URL)
+	setMappingValue(n, "mode", s.Mode)
+	setMappingValue(n, "feed_url", s.FeedURL)
+	return n
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(m *yaml.Node, key, value string) {
+	if v := mappingValue(m, key); v != nil {
+		v.SetString(value)
+		return
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &yaml.Node{Kind: yaml.ScalarNode, Value: value})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"fetch-blog/fetcher"
+)
+
+// 取得した記事ページ
+type articlePage struct {
+	url          string
+	contentType  string
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// 記事ページに対する処理
+type pageStep struct {
+	name string
+	// 処理が済んでいない記事を表すarticlesへの条件
+	pending string
+	handle  func(ctx context.Context, p *articlePage) error
+}
+
+// 有効な処理が残っている記事のページを1回だけ取得し、必要な処理に渡す
+func processArticlePages(ctx context.Context, steps []pageStep) error {
+	if len(steps) == 0 {
+		return nil
+	}
+	cols := make([]string, len(steps))
+	conds := make([]string, len(steps))
+	for i, s := range steps {
+		cols[i] = "(" + s.pending + ")"
+		conds[i] = cols[i]
+	}
+	query := fmt.Sprintf("SELECT url, %s FROM articles WHERE %s",
+		strings.Join(cols, ", "), strings.Join(conds, " OR "))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		url   string
+		steps []bool
+	}
+	var targets []pending
+	for rows.Next() {
+		p := pending{steps: make([]bool, len(steps))}
+		dest := []any{&p.url}
+		for i := range p.steps {
+			dest = append(dest, &p.steps[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		targets = append(targets, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	// 会員限定の記事も取得できるようにログイン用のCookieを使う
+	client, err := newPagesClient(ctx)
+	if err != nil {
+		return err
+	}
+	// ブログごとのレーンで並行に取得し、処理は1件ずつ行う
+	urls := make([]string, len(targets))
+	stepsOf := map[string][]bool{}
+	for i, t := range targets {
+		urls[i] = t.url
+		stepsOf[t.url] = t.steps
+	}
+	fetch := func(ctx context.Context, u string) (*articlePage, error) {
+		return fetchArticlePage(ctx, client, u)
+	}
+	metrics := fetchInLanes(ctx, conf.Fetch, urls, fetch, func(u string, page *articlePage, err error) {
+		if err != nil {
+			// 1件の失敗で残りを止めない
+			log.Printf("fetch page %s: %v", u, err)
+			report.addError()
+			recordFetchError(ctx, urlHost(u), u, err)
+			return
+		}
+		for i, s := range steps {
+			if !stepsOf[u][i] {
+				continue
+			}
+			if err := s.handle(ctx, page); err != nil {
+				log.Printf("%s %s: %v", s.name, u, err)
+				report.addError()
+			}
+		}
+	})
+	return saveLaneMetrics(ctx, metrics)
+}
+
+func fetchArticlePage(ctx context.Context, client *http.Client, articleURL string) (*articlePage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, articleURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &fetcher.StatusError{Code: resp.StatusCode}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &articlePage{
+		url:          articleURL,
+		contentType:  resp.Header.Get("Content-Type"),
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		body:         body,
+	}, nil
+}
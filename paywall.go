@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 有料記事によくある要素
+var defaultPaywallSelectors = []string{
+	"#paywall", ".paywall", "[data-paywall]", "[class*='paywall']",
+	".piano-offer", ".tp-modal", ".subscriber-only", ".members-only", ".premium-content",
+}
+
+// 有料記事によくある文言 (小文字で比較)
+var defaultPaywallPhrases = []string{
+	"subscribe to continue reading", "subscribe to read the full", "this article is for subscribers",
+	"この記事は会員限定です", "有料会員限定", "続きを読むには",
+}
+
+// JSON-LDで無料ではないと宣言している
+var notFreePattern = regexp.MustCompile(`"isAccessibleForFree"\s*:\s*"?(?i:false)"?`)
+
+// 記事ページが有料記事かどうかを判定する処理
+func paywallStep(c paywallConfig) pageStep {
+	selectors := append(append([]string{}, defaultPaywallSelectors...), c.Selectors...)
+	phrases := append(append([]string{}, defaultPaywallPhrases...), c.Phrases...)
+	return pageStep{
+		name:    "paywall",
+		pending: "paywall_checked_at IS NULL",
+		handle: func(ctx context.Context, p *articlePage) error {
+			paywalled := false
+			if strings.Contains(p.contentType, "html") || p.contentType == "" {
+				doc, err := goquery.NewDocumentFromReader(bytes.NewReader(p.body))
+				if err != nil {
+					return err
+				}
+				paywalled = isPaywalled(doc, selectors, phrases)
+			}
+			_, err := db.ExecContext(ctx, "UPDATE articles SET paywalled = ?, paywall_checked_at = ? WHERE url = ?",
+				paywalled, time.Now().UTC().Format(time.RFC3339), p.url)
+			return err
+		},
+	}
+}
+
+func isPaywalled(doc *goquery.Document, selectors, phrases []string) bool {
+	paywalled := false
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		paywalled = notFreePattern.MatchString(s.Text())
+		return !paywalled
+	})
+	if paywalled {
+		return true
+	}
+	for _, sel := range selectors {
+		if doc.Find(sel).Length() > 0 {
+			return true
+		}
+	}
+	text := strings.ToLower(doc.Find("body").Text())
+	for _, p := range phrases {
+		if strings.Contains(text, strings.ToLower(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// 有料記事の目印
+func paywallMark() string {
+	if conf.Paywall.Emoji != "" {
+		return conf.Paywall.Emoji
+	}
+	return "💰"
+}
+
+// 通知に使うタイトル
+// 有料記事には目印を付ける
+func displayTitle(a article) string {
+	title := a.title
+	if a.translatedTitle != "" {
+		title = a.translatedTitle
+	}
+	if a.paywalled {
+		return paywallMark() + " " + title
+	}
+	return title
+}
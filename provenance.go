@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// 記事を見つけた経路
+const (
+	viaList     = "list"
+	viaFeed     = "feed"
+	viaBackfill = "backfill"
+	viaManual   = "manual"
+)
+
+// 記事を見つけた経路 (articles.provenanceにJSONで保存する)
+// 抽出の不具合を調べるときに、どの取得のどのページから入った記事かを絞り込む
+type provenance struct {
+	// 取得の実行 (開始時刻、fetch_errorsのrun_started_atと同じ)
+	Run string `json:"run"`
+	// list, feed, backfill, manual
+	Via string `json:"via"`
+	// 記事を読んだ一覧のページ
+	ListURL string `json:"list_url,omitempty"`
+	// 記事を読んだフィード
+	FeedURL string `json:"feed_url,omitempty"`
+	// 一覧の何ページ目か (backfillは辿った順)
+	Page int `json:"page,omitempty"`
+	// 一覧を読んだセレクタの版 (selectorsの設定のハッシュ)
+	Selectors string `json:"selectors,omitempty"`
+	// 正規のURLに置き換える前のURL (blogs[].canonical)
+	OriginalURL string `json:"original_url,omitempty"`
+}
+
+// 保存する形 (経路がわからなければ空)
+func (p provenance) json() string {
+	if p.Via == "" {
+		return ""
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// 記事に見つけた経路を記録する
+func tagProvenance(articles []article, p provenance) {
+	p.Run = runStartedAt
+	for i := range articles {
+		articles[i].provenance = p
+	}
+}
+
+// ブログのセレクタの版
+// セレクタを変えると変わるので、変える前と後で抽出した記事を見分けられる
+func selectorVersion(b blog) string {
+	s, err := json.Marshal(b.cfg.Selectors.fetcher())
+	if err != nil {
+		return ""
+	}
+	return sha256Hex(s)[:12]
+}
+
+// provenance: 記事を見つけた経路を一覧する
+// provenance show <id|url> で1件の経路をJSONで出力する
+func cmdProvenance(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "show" {
+		if len(args) != 2 {
+			return usageErr("usage: provenance show <id|url>")
+		}
+		return showProvenance(ctx, args[1])
+	}
+	fs := flag.NewFlagSet("provenance", flag.ExitOnError)
+	source := fs.String("source", "", "only articles of this blog")
+	run := fs.String("run", "", "only articles found by this fetch run (as printed in the run column)")
+	via := fs.String("via", "", "only articles found via list, feed, backfill or manual")
+	selectors := fs.String("selectors", "", "only articles read with this selector version")
+	limit := fs.Int("limit", 100, "maximum number of articles (0 for all)")
+	parseFlags(fs, args)
+	if fs.NArg() > 0 {
+		return usageErr("usage: provenance [-source name] [-run time] [-via kind] [-selectors version] [-limit n] | provenance show <id|url>")
+	}
+	sb := selectFrom("articles", "rowid", "source", "url",
+		"COALESCE(json_extract(provenance, '$.run'), '')", "COALESCE(json_extract(provenance, '$.via'), '')",
+		"COALESCE(json_extract(provenance, '$.page'), 0)", "COALESCE(json_extract(provenance, '$.selectors'), '')").
+		where("provenance IS NOT NULL").orderBy("rowid DESC").limitTo(*limit)
+	if *source != "" {
+		sb.where("source = ?", *source)
+	}
+	if *run != "" {
+		sb.where("json_extract(provenance, '$.run') = ?", *run)
+	}
+	if *via != "" {
+		sb.where("json_extract(provenance, '$.via') = ?", *via)
+	}
+	if *selectors != "" {
+		sb.where("json_extract(provenance, '$.selectors') = ?", *selectors)
+	}
+	q, qargs := sb.build()
+	rows, err := db.QueryContext(ctx, q, qargs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRUN\tSOURCE\tVIA\tPAGE\tSELECTORS\tURL")
+	for rows.Next() {
+		var id int64
+		var page int
+		var src, u, r, v, sel string
+		if err := rows.Scan(&id, &src, &u, &r, &v, &page, &sel); err != nil {
+			return err
+		}
+		pageText := ""
+		if page > 0 {
+			pageText = strconv.Itoa(page)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", id, r, src, v, pageText, sel, u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func showProvenance(ctx context.Context, ref string) error {
+	a, err := lookupArticle(ctx, ref)
+	if err != nil {
+		return err
+	}
+	var raw sql.NullString
+	err = db.QueryRowContext(ctx, "SELECT provenance FROM articles WHERE url = ? ORDER BY rowid DESC LIMIT 1", a.url).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("article %q: %w", ref, errNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	// 経路を記録する前に保存した記事
+	if !raw.Valid {
+		return fmt.Errorf("%s: no provenance recorded", a.url)
+	}
+	var p provenance
+	if err := json.Unmarshal([]byte(raw.String), &p); err != nil {
+		return fmt.Errorf("%s: %w", a.url, err)
+	}
+	out, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+var publicTemplate = template.Must(template.New("public").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<ul>
+{{- range .Articles}}
+<li><a href="{{.URL}}">{{.Title}}</a> <small>{{.ReadAt}}</small></li>
+{{- else}}
+<li>まだありません</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// 公開ページに載せる記事
+type publicArticle struct {
+	Title  string
+	URL    string
+	ReadAt string
+}
+
+// 公開してよい記事か
+// 非公開のブログは常に除外し、それ以外は記事ごとかブログごとに公開を選ぶ
+func (c publicConfig) allows(a article, optedIn bool) bool {
+	src := articleSource(a)
+	for _, s := range c.PrivateSources {
+		if s == src {
+			return false
+		}
+	}
+	if optedIn {
+		return true
+	}
+	for _, s := range c.Sources {
+		if s == src {
+			return true
+		}
+	}
+	return false
+}
+
+// ログインしていない閲覧から隠す配信元 (public.private_sources)
+// ログインを有効にしていなければ誰でも見られるので、APIと画面でも隠す
+func hiddenSources(ctx context.Context) []string {
+	if currentUser(ctx) != "" {
+		return nil
+	}
+	return conf.Public.PrivateSources
+}
+
+// 記事がログインしていない閲覧から隠す配信元のものか
+func isHidden(ctx context.Context, url string) (bool, error) {
+	hidden := hiddenSources(ctx)
+	if len(hidden) == 0 {
+		return false, nil
+	}
+	articles, err := queryArticles(ctx, articleFilter{url: url, excludeSources: hidden, limit: 1})
+	return len(articles) == 0, err
+}
+
+// 最近読んだ記事のうち公開してよいものを取得
+func publicArticles(ctx context.Context, c publicConfig) ([]publicArticle, error) {
+	limit := c.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := db.QueryContext(ctx, `
+SELECT title, url, date, public, COALESCE(read_at, '') FROM articles
+WHERE read = 1 ORDER BY read_at DESC, date DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []publicArticle
+	for rows.Next() && len(res) < limit {
+		var a article
+		var optedIn bool
+		var readAt string
+		if err := rows.Scan(&a.title, &a.url, &a.date, &optedIn, &readAt); err != nil {
+			return nil, err
+		}
+		if !c.allows(a, optedIn) {
+			continue
+		}
+		if len(readAt) >= len("2006-01-02") {
+			readAt = readAt[:len("2006-01-02")]
+		}
+		res = append(res, publicArticle{Title: a.title, URL: a.url, ReadAt: readAt})
+	}
+	return res, rows.Err()
+}
+
+// GET /public
+func handlePublic(c publicConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		articles, err := publicArticles(r.Context(), c)
+		if err != nil {
+			log.Printf("public articles: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		title := c.Title
+		if title == "" {
+			title = "Reading log"
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = publicTemplate.Execute(w, struct {
+			Title    string
+			Articles []publicArticle
+		}{title, articles})
+		if err != nil {
+			log.Printf("render public page: %v", err)
+		}
+	}
+}
+
+// POST /articles/public {"url": "...", "public": true}
+func handleSetPublic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		URL    string `json:"url"`
+		Public bool   `json:"public"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		writeError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	res, err := db.ExecContext(r.Context(), "UPDATE articles SET public = ? WHERE url = ?", req.Public, req.URL)
+	if err != nil {
+		log.Printf("set public: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "article not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 既定のタイムゾーン (source.timezone)
+func sourceLocation() *time.Location {
+	if conf.Source.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(conf.Source.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// ブログのタイムゾーン (blogs[].timezone、なければsource.timezone)
+func (b blog) location() *time.Location {
+	if b.cfg.Timezone == "" {
+		return sourceLocation()
+	}
+	loc, err := time.LoadLocation(b.cfg.Timezone)
+	if err != nil {
+		return sourceLocation()
+	}
+	return loc
+}
+
+// 一覧の日付をブログのタイムゾーンの0時として扱う
+func publishedAtFromDate(date string, loc *time.Location) string {
+	t, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// 並べ替えに使う公開日時
+// 時刻がわからない記事は日付を使う
+func (a article) sortKey() string {
+	if a.publishedAt != "" {
+		return a.publishedAt
+	}
+	return a.date
+}
+
+// 記事ページから公開日時を読み取る処理
+// JSON-LDのdatePublishedかmetaのarticle:published_timeを使う
+func publishedTimeStep() pageStep {
+	return pageStep{
+		name:    "published time",
+		pending: "published_checked_at IS NULL",
+		handle: func(ctx context.Context, p *articlePage) error {
+			var published string
+			if strings.Contains(p.contentType, "html") || p.contentType == "" {
+				doc, err := goquery.NewDocumentFromReader(bytes.NewReader(p.body))
+				if err != nil {
+					return err
+				}
+				if t, ok := extractPublishedTime(doc); ok {
+					published = t.UTC().Format(time.RFC3339)
+				}
+			}
+			now := time.Now().UTC().Format(time.RFC3339)
+			if published == "" {
+				_, err := db.ExecContext(ctx, "UPDATE articles SET published_checked_at = ? WHERE url = ?", now, p.url)
+				return err
+			}
+			_, err := db.ExecContext(ctx, "UPDATE articles SET published_at = ?, published_checked_at = ? WHERE url = ?", published, now, p.url)
+			return err
+		},
+	}
+}
+
+// 時刻を含む公開日時を探す
+func extractPublishedTime(doc *goquery.Document) (time.Time, bool) {
+	var candidates []string
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, s *goquery.Selection) {
+		candidates = append(candidates, jsonLDValues([]byte(s.Text()), "datePublished")...)
+	})
+	if v, ok := doc.Find(`meta[property="article:published_time"]`).Attr("content"); ok {
+		candidates = append(candidates, v)
+	}
+	if v, ok := doc.Find("time[datetime]").First().Attr("datetime"); ok {
+		candidates = append(candidates, v)
+	}
+	for _, c := range candidates {
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(c)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// JSON-LDから指定したキーの文字列値を集める
+// @graphや配列の入れ子にも対応する
+func jsonLDValues(data []byte, key string) []string {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	var res []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch x := v.(type) {
+		case map[string]any:
+			for k, child := range x {
+				if s, ok := child.(string); ok && k == key {
+					res = append(res, s)
+					continue
+				}
+				walk(child)
+			}
+		case []any:
+			for _, child := range x {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+	return res
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// 記事の状態
+const (
+	statusOK     = "ok"
+	statusReview = "review"
+	// 絞り込み (filters) で除いた記事と、確認して却下した記事
+	statusSkipped = "skipped"
+)
+
+// 抽出に失敗したとみられる記事の扱い
+const (
+	qualityReview = "review"
+	qualityReject = "reject"
+)
+
+// タイトルから不要な語を取り除く
+func cleanTitle(title string, noiseWords []string) string {
+	for _, w := range noiseWords {
+		title = strings.ReplaceAll(title, w, "")
+	}
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// 抽出結果がおかしければ理由を返す
+func checkQuality(a article, minTitleLength int, now time.Time) string {
+	if utf8.RuneCountInString(a.title) < minTitleLength {
+		return fmt.Sprintf("title shorter than %d characters", minTitleLength)
+	}
+	if a.title == a.url || strings.TrimRight(a.title, "/") == strings.TrimRight(a.url, "/") {
+		return "title is the URL"
+	}
+	// タイムゾーンの差を考えて1日の余裕を持たせる
+	if a.date > now.AddDate(0, 0, 1).Format("2006-01-02") {
+		return "date is in the future"
+	}
+	return ""
+}
+
+// 保存前に品質を確認する
+// 問題のある記事は設定に応じて捨てるか確認待ちにする
+func applyQualityGate(articles []article, c qualityConfig) []article {
+	minLen := c.MinTitleLength
+	if minLen <= 0 {
+		minLen = 2
+	}
+	now := time.Now()
+	res := articles[:0]
+	for _, a := range articles {
+		a.title = cleanTitle(a.title, c.NoiseWords)
+		a.status = statusOK
+		if reason := checkQuality(a, minLen, now); reason != "" {
+			if c.Action == qualityReject {
+				log.Printf("quality: rejected %s: %s", a.url, reason)
+				continue
+			}
+			a.status = statusReview
+			a.reviewReason = reason
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+// review: 確認待ちの記事を表示・承認・削除する
+//
+//	review                 確認待ちの一覧
+//	review approve <url>   通常の記事として扱う
+//	review reject <url>    除いた記事 (skipped) にする (行を残すので次の取得で確認待ちに戻らない)
+func cmdReview(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	parseFlags(fs, args)
+
+	switch fs.Arg(0) {
+	case "":
+		rows, err := db.QueryContext(ctx, "SELECT date, title, url, review_reason FROM articles WHERE status = ? ORDER BY date", statusReview)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for rows.Next() {
+			var date, title, u, reason string
+			if err := rows.Scan(&date, &title, &u, &reason); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\t%q\t%s\t%s\n", dateOnly(date), title, u, reason)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return w.Flush()
+	case "approve":
+		if fs.Arg(1) == "" {
+			return usageErr("usage: review approve <url>")
+		}
+		return execOne(ctx, "UPDATE articles SET status = ?, review_reason = '' WHERE url = ? AND status = ?", statusOK, fs.Arg(1), statusReview)
+	case "reject":
+		if fs.Arg(1) == "" {
+			return usageErr("usage: review reject <url>")
+		}
+		return execOne(ctx, "UPDATE articles SET status = ?, review_reason = ? WHERE url = ? AND status = ?", statusSkipped, "rejected in review", fs.Arg(1), statusReview)
+	default:
+		return usageErr(fmt.Sprintf("unknown review command %q", fs.Arg(0)))
+	}
+}
+
+// 1行以上を更新するSQLを実行する
+func execOne(ctx context.Context, query string, args ...any) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no matching article: %w", errNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// SELECT文の組み立て
+// 値はすべてプレースホルダで渡し、SQLに文字列として埋め込まない
+type selectBuilder struct {
+	columns []string
+	table   string
+	conds   []string
+	args    []any
+	order   string
+	limit   int
+}
+
+func selectFrom(table string, columns ...string) *selectBuilder {
+	return &selectBuilder{table: table, columns: columns}
+}
+
+// 条件をANDで追加する
+// condには値の数だけ?を含める
+func (b *selectBuilder) where(cond string, args ...any) *selectBuilder {
+	b.conds = append(b.conds, cond)
+	b.args = append(b.args, args...)
+	return b
+}
+
+// 並び順を指定する
+// 利用者の入力をそのまま渡さないこと
+func (b *selectBuilder) orderBy(order string) *selectBuilder {
+	b.order = order
+	return b
+}
+
+func (b *selectBuilder) limitTo(n int) *selectBuilder {
+	b.limit = n
+	return b
+}
+
+// SQLとバインドする値を返す
+func (b *selectBuilder) build() (string, []any) {
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	sb.WriteString(strings.Join(b.columns, ", "))
+	sb.WriteString(" FROM ")
+	sb.WriteString(b.table)
+	if len(b.conds) > 0 {
+		sb.WriteString(" WHERE ")
+		for i, w := range b.conds {
+			if i > 0 {
+				sb.WriteString(" AND ")
+			}
+			sb.WriteString("(" + w + ")")
+		}
+	}
+	if b.order != "" {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(b.order)
+	}
+	args := b.args
+	if b.limit > 0 {
+		sb.WriteString(" LIMIT ?")
+		args = append(args[:len(args):len(args)], b.limit)
+	}
+	return sb.String(), args
+}
+
+// 記事の絞り込み条件
+// CLI、API、通知対象の選択で共通して使う
+type articleFilter struct {
+	// nilなら既読・未読を問わない
+	read *bool
+	// 配信元のホスト名 (add-urlで追加した記事はmanual)
+	source string
+	// 記事のURL (1件に絞る)
+	url string
+	// ok, review
+	status string
+	// trueならスヌーズ中の記事を除く
+	awake bool
+	// trueならスヌーズ中の記事だけ
+	snoozed bool
+	// YYYY-MM-DD (両端を含む)
+	since string
+	until string
+	// YYYY-MM-DD。指定するとその日の終わり時点で未読だった記事に絞り込む
+	asOf string
+	// 購読者名。その購読者にまだ送っていない記事に絞り込む
+	notSentTo string
+	// 分類
+	category string
+	// trueなら新しい順
+	newestFirst bool
+	// trueなら期限のある記事を期限の近い順に先頭へ
+	dueFirst bool
+	// 除く配信元 (ログインしていない閲覧ではpublic.private_sources)
+	excludeSources []string
+	// 言語 (ja, en など)
+	language string
+	// Flesch Reading Ease がこれ以上の記事 (英語のみ、0なら絞り込まない)
+	minEase float64
+	// trueなら易しい記事 (Reading Easeの高い順) から
+	easiestFirst bool
+	// 検索する語 (タイトルと本文、索引はupdateSearchIndexで更新しておく)
+	search string
+	// 0なら無制限
+	limit int
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// 条件に合う記事のSELECT文を組み立てる
+// ブログの記事の条件
+// 名前を付ける前に保存した記事はsourceが空なのでホスト名でも探す
+// 名前に%や_があってもワイルドカードにならないよう、LIKEではなくinstrで前方一致を調べる
+func sourceCond(name, host string) (string, any, any, any) {
+	return "source = ? OR (source = '' AND (instr(url, ?) = 1 OR instr(url, ?) = 1))", name, "http://" + host + "/", "https://" + host + "/"
+}
+
+func (f articleFilter) query() *selectBuilder {
+	q := selectFrom("articles", "title", "url", "date", "read", "public", "paywalled", "status", "COALESCE(published_at, '')",
+		"COALESCE((SELECT group_concat(category, ',') FROM article_categories c WHERE c.url = articles.url), '')", "source", "rowid",
+		"language", "COALESCE(reading_ease, -1)", "COALESCE(due_at, '')")
+	if f.read != nil {
+		q.where("read = ?", *f.read)
+	}
+	if f.source != "" {
+		q.where(sourceCond(f.source, f.source))
+	}
+	for _, s := range f.excludeSources {
+		cond, name, httpPrefix, httpsPrefix := sourceCond(s, s)
+		q.where("NOT ("+cond+")", name, httpPrefix, httpsPrefix)
+	}
+	if f.url != "" {
+		q.where("url = ?", f.url)
+	}
+	if f.status != "" {
+		q.where("status = ?", f.status)
+	}
+	if f.awake {
+		q.where("snoozed_until IS NULL OR snoozed_until <= ?", time.Now().UTC().Format(time.RFC3339))
+	}
+	if f.snoozed {
+		q.where("snoozed_until > ?", time.Now().UTC().Format(time.RFC3339))
+	}
+	if f.since != "" {
+		q.where("date >= ?", f.since)
+	}
+	if f.until != "" {
+		q.where("date <= ?", f.until)
+	}
+	if f.asOf != "" {
+		// 翌日0時より前に追加され、まだ読まれていなかった記事
+		// eventsがない古い記事は公開日とread_atで代用する
+		end := f.asOf
+		if t, err := time.Parse("2006-01-02", f.asOf); err == nil {
+			end = t.AddDate(0, 0, 1).Format("2006-01-02")
+		}
+		q.where("COALESCE((SELECT MIN(created_at) FROM events e WHERE e.url = articles.url AND e.type = 'added'), date) < ?", end)
+		q.where("NOT EXISTS (SELECT 1 FROM events e WHERE e.url = articles.url AND e.type = 'read' AND e.created_at < ?)", end)
+		q.where("NOT (read = 1 AND COALESCE(read_at, '') < ? AND NOT EXISTS (SELECT 1 FROM events e WHERE e.url = articles.url AND e.type = 'read'))", end)
+	}
+	if f.notSentTo != "" {
+		q.where("url NOT IN (SELECT url FROM subscription_deliveries WHERE user = ?)", f.notSentTo)
+	}
+	if f.category != "" {
+		q.where("url IN (SELECT url FROM article_categories WHERE category = ?)", f.category)
+	}
+	if f.language != "" {
+		q.where("language = ?", f.language)
+	}
+	if f.minEase > 0 {
+		q.where("reading_ease >= ?", f.minEase)
+	}
+	if f.search != "" {
+		q.whereMatches(f.search)
+	}
+	// 時刻がわかる記事は同じ日の中でも公開順に並べる
+	order := "date, published_at"
+	if f.newestFirst {
+		order = "date DESC, published_at DESC"
+	}
+	if f.easiestFirst {
+		order = "reading_ease IS NULL, reading_ease DESC, " + order
+	}
+	if f.dueFirst {
+		order = "due_at IS NULL, due_at, " + order
+	}
+	q.orderBy(order)
+	return q.limitTo(f.limit)
+}
+
+// 条件に合う記事を取得する
+func queryArticles(ctx context.Context, f articleFilter) ([]article, error) {
+	query, args := f.query().build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var articles []article
+	for rows.Next() {
+		var a article
+		var categories string
+		if err := rows.Scan(&a.title, &a.url, &a.date, &a.read, &a.public, &a.paywalled, &a.status, &a.publishedAt, &categories, &a.source, &a.id, &a.language, &a.readingEase, &a.dueAt); err != nil {
+			return nil, err
+		}
+		a.date = dateOnly(a.date)
+		if categories != "" {
+			a.categories = strings.Split(categories, ",")
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
+
+// DATE型の列はドライバがRFC3339で返すので日付部分だけにする
+func dateOnly(s string) string {
+	if len(s) > len("2006-01-02") {
+		return s[:len("2006-01-02")]
+	}
+	return s
+}
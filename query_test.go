@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"fetch-blog/store"
+)
+
+func TestSourceFilter(t *testing.T) {
+	openTestDB(t)
+	saveTestArticles(t,
+		store.Article{Title: "named", URL: "https://blog.example/a", Date: "2026-01-01", Source: "blog.example"},
+		store.Article{Title: "legacy", URL: "https://blog.example/b", Date: "2026-01-01"},
+		store.Article{Title: "legacy http", URL: "http://blog.example/c", Date: "2026-01-01"},
+		// blog_exampleのLIKEなら_に一致してしまう
+		store.Article{Title: "lookalike", URL: "https://blogXexample/d", Date: "2026-01-01"},
+		store.Article{Title: "subdomain", URL: "https://blog.example.evil/e", Date: "2026-01-01"},
+		store.Article{Title: "other", URL: "https://other.example/f", Date: "2026-01-01", Source: "other.example"},
+	)
+	titles := func(f articleFilter) []string {
+		t.Helper()
+		articles, err := queryArticles(context.Background(), f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []string
+		for _, a := range articles {
+			res = append(res, a.title)
+		}
+		sort.Strings(res)
+		return res
+	}
+	tests := []struct {
+		name string
+		f    articleFilter
+		want []string
+	}{
+		{"source", articleFilter{source: "blog.example"}, []string{"legacy", "legacy http", "named"}},
+		{"underscore is not a wildcard", articleFilter{source: "blog_example"}, nil},
+		{"percent is not a wildcard", articleFilter{source: "blog%"}, nil},
+		{"exclude", articleFilter{excludeSources: []string{"blog.example"}}, []string{"lookalike", "other", "subdomain"}},
+		{"exclude several", articleFilter{excludeSources: []string{"blog.example", "other.example"}}, []string{"lookalike", "subdomain"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := titles(tt.f); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
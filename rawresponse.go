@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// 一覧とフィードの応答の保存 (調べるため)
+// 解析して記事が0件だったときに、そのときサーバーが何を返したかを responses show で見られる
+type responseDebugConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ブログごとに残す応答の数 (既定は5、古いものから消す)
+	Keep int `yaml:"keep"`
+	// 保存する本文の上限 (既定は"1MB"、超えた分は捨てる)
+	MaxSize string `yaml:"max_size"`
+}
+
+const (
+	defaultResponseKeep    = 5
+	defaultResponseMaxSize = 1 << 20
+)
+
+func (c responseDebugConfig) keep() int {
+	if c.Keep <= 0 {
+		return defaultResponseKeep
+	}
+	return c.Keep
+}
+
+func (c responseDebugConfig) maxSize() int64 {
+	n, err := parseSize(c.MaxSize)
+	if err != nil || n <= 0 {
+		return defaultResponseMaxSize
+	}
+	return n
+}
+
+// 応答の本文を読みながら写し取り、閉じたときに保存するTransport
+// 304 (変わっていない) は本文がないので保存しない
+type recordingTransport struct {
+	base   http.RoundTripper
+	source string
+	cfg    responseDebugConfig
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusNotModified {
+		return resp, err
+	}
+	resp.Body = &recordedBody{ReadCloser: resp.Body, resp: resp, source: t.source, cfg: t.cfg, limit: t.cfg.maxSize()}
+	return resp, nil
+}
+
+type recordedBody struct {
+	io.ReadCloser
+	resp      *http.Response
+	source    string
+	cfg       responseDebugConfig
+	limit     int64
+	buf       bytes.Buffer
+	truncated bool
+	once      sync.Once
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.capture(p[:n])
+	return n, err
+}
+
+func (b *recordedBody) capture(p []byte) {
+	if room := b.limit - int64(b.buf.Len()); int64(len(p)) > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+}
+
+// 読み残した本文も上限まで読んでから保存する
+func (b *recordedBody) Close() error {
+	b.once.Do(func() {
+		if !b.truncated {
+			rest, _ := io.ReadAll(io.LimitReader(b.ReadCloser, b.limit-int64(b.buf.Len())+1))
+			b.capture(rest)
+		}
+		if err := saveResponse(context.Background(), b.source, b.resp, b.buf.Bytes(), b.truncated, b.cfg.keep()); err != nil {
+			log.Printf("%s: save response: %v", b.source, err)
+		}
+	})
+	return b.ReadCloser.Close()
+}
+
+// 応答を保存し、ブログごとにkeep件を超えた古い応答を消す
+func saveResponse(ctx context.Context, source string, resp *http.Response, body []byte, truncated bool, keep int) error {
+	var header bytes.Buffer
+	if err := resp.Header.Write(&header); err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO fetch_responses (source, url, status, header, body, truncated, fetched_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		source, resp.Request.URL.String(), resp.StatusCode, header.String(), body, truncated, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+DELETE FROM fetch_responses WHERE source = ? AND id NOT IN (
+  SELECT id FROM fetch_responses WHERE source = ? ORDER BY id DESC LIMIT ?)`, source, source, keep)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// responses: 保存した応答を見る
+//
+//	responses [-source name]   保存した応答の一覧
+//	responses show <id>        応答のヘッダと本文をそのまま出力する
+func cmdResponses(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "show" {
+		if len(args) != 2 {
+			return usageErr("usage: responses show <id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return usageErr(fmt.Sprintf("invalid id %q", args[1]))
+		}
+		return showResponse(ctx, id)
+	}
+	fs := flag.NewFlagSet("responses", flag.ExitOnError)
+	source := fs.String("source", "", "only responses of this blog")
+	parseFlags(fs, args)
+	if fs.NArg() > 0 {
+		return usageErr("usage: responses [-source name] | responses show <id>")
+	}
+	if !conf.Fetch.DebugResponses.Enabled {
+		log.Print("fetch.debug_responses is not enabled, no new responses are stored")
+	}
+	rows, err := db.QueryContext(ctx, `
+SELECT id, fetched_at, source, status, length(body), truncated, url FROM fetch_responses
+WHERE ? = '' OR source = ? ORDER BY id DESC`, *source, *source)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for rows.Next() {
+		var id, size int64
+		var status int
+		var fetchedAt, src, u string
+		var truncated bool
+		if err := rows.Scan(&id, &fetchedAt, &src, &status, &size, &truncated, &u); err != nil {
+			return err
+		}
+		sizeText := formatSize(size)
+		if truncated {
+			sizeText += " (truncated)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", id, fetchedAt, src, status, sizeText, u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func showResponse(ctx context.Context, id int64) error {
+	var u, header string
+	var status int
+	var body []byte
+	err := db.QueryRowContext(ctx, "SELECT url, status, header, body FROM fetch_responses WHERE id = ?", id).Scan(&u, &status, &header, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("response %d: %w", id, errNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("GET %s\n%d %s\n%s\n", u, status, http.StatusText(status), header)
+	_, err = os.Stdout.Write(body)
+	return err
+}
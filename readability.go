@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 記事の言語と読みやすさ
+// 後輩に送る記事を難しさで選べるよう、記事ページの本文から求めて保存する
+type readabilityConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// 記事ページから言語、文の平均の長さ、Flesch Reading Ease (英語のみ) を求める処理
+func readabilityStep() pageStep {
+	return pageStep{
+		name:    "readability",
+		pending: "readability_at IS NULL",
+		handle: func(ctx context.Context, p *articlePage) error {
+			r := readability{sentenceLength: -1, readingEase: -1}
+			if strings.Contains(p.contentType, "html") || p.contentType == "" {
+				doc, err := goquery.NewDocumentFromReader(bytes.NewReader(p.body))
+				if err != nil {
+					return err
+				}
+				r = measureReadability(extractText(contentRoot(doc)))
+			}
+			now := time.Now().UTC().Format(time.RFC3339)
+			_, err := db.ExecContext(ctx, "UPDATE articles SET language = ?, sentence_length = ?, reading_ease = ?, readability_at = ? WHERE url = ?",
+				r.language, nullFloat(r.sentenceLength), nullFloat(r.readingEase), now, p.url)
+			return err
+		},
+	}
+}
+
+type readability struct {
+	// ja, zh, ko, ru, en (わからなければ空)
+	language string
+	// 文の平均の長さ (空白で区切る言語は語数、日本語などは文字数、求められなければ負)
+	sentenceLength float64
+	// Flesch Reading Ease (高いほど易しい、英語以外は負)
+	readingEase float64
+}
+
+// 負の値はNULLにする
+func nullFloat(v float64) any {
+	if v < 0 {
+		return nil
+	}
+	return math.Round(v*10) / 10
+}
+
+func measureReadability(text string) readability {
+	text = stripCodeBlocks(text)
+	r := readability{language: detectLanguage(text), sentenceLength: -1, readingEase: -1}
+	sentences := splitSentences(text)
+	if len(sentences) == 0 {
+		return r
+	}
+	switch r.language {
+	case "ja", "zh":
+		// 空白で区切らないので文字数で数える
+		chars := 0
+		for _, s := range sentences {
+			for _, c := range s {
+				if !unicode.IsSpace(c) && !unicode.IsPunct(c) {
+					chars++
+				}
+			}
+		}
+		r.sentenceLength = float64(chars) / float64(len(sentences))
+	default:
+		words, syllables := 0, 0
+		for _, s := range sentences {
+			for _, w := range strings.Fields(s) {
+				w = strings.TrimFunc(w, func(c rune) bool { return !unicode.IsLetter(c) && !unicode.IsDigit(c) })
+				if w == "" {
+					continue
+				}
+				words++
+				syllables += countSyllables(w)
+			}
+		}
+		if words == 0 {
+			return r
+		}
+		r.sentenceLength = float64(words) / float64(len(sentences))
+		if r.language == "en" {
+			r.readingEase = 206.835 - 1.015*r.sentenceLength - 84.6*float64(syllables)/float64(words)
+			// 極端に短い文などでは範囲を外れるので0-100に収める
+			r.readingEase = math.Max(0, math.Min(100, r.readingEase))
+		}
+	}
+	return r
+}
+
+// フェンスで囲んだコードは文章ではないので除く
+func stripCodeBlocks(text string) string {
+	var b strings.Builder
+	fence := ""
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence == "" && strings.HasPrefix(trimmed, "```"):
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, "`"))]
+		case fence != "" && trimmed == fence:
+			fence = ""
+		case fence == "":
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// 文に分ける (句点と改行で区切る)
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	runes := []rune(text)
+	flush := func(end int) {
+		if s := strings.TrimSpace(string(runes[start:end])); s != "" {
+			sentences = append(sentences, s)
+		}
+		start = end
+	}
+	for i, c := range runes {
+		switch c {
+		case '。', '！', '？', '\n':
+			flush(i + 1)
+		case '.', '!', '?':
+			// 3.14や略語の途中では区切らない
+			if i+1 == len(runes) || unicode.IsSpace(runes[i+1]) {
+				flush(i + 1)
+			}
+		}
+	}
+	flush(len(runes))
+	return sentences
+}
+
+// 文字の種類と英語のよく使う語で言語を推定する
+func detectLanguage(text string) string {
+	var kana, han, hangul, cyrillic, latin int
+	for _, c := range text {
+		switch {
+		case unicode.In(c, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, c):
+			han++
+		case unicode.Is(unicode.Hangul, c):
+			hangul++
+		case unicode.Is(unicode.Cyrillic, c):
+			cyrillic++
+		case unicode.Is(unicode.Latin, c):
+			latin++
+		}
+	}
+	total := kana + han + hangul + cyrillic + latin
+	if total == 0 {
+		return ""
+	}
+	switch {
+	// 日本語の文章は仮名を含む
+	case kana > 0 && kana+han > total/3:
+		return "ja"
+	case han > total/3:
+		return "zh"
+	case hangul > total/3:
+		return "ko"
+	case cyrillic > total/2:
+		return "ru"
+	case latin > total/2 && isEnglish(text):
+		return "en"
+	}
+	return ""
+}
+
+var englishStopwords = map[string]bool{
+	"the": true, "and": true, "of": true, "to": true, "a": true, "in": true, "is": true,
+	"that": true, "it": true, "for": true, "with": true, "as": true, "this": true, "on": true,
+	"are": true, "be": true, "you": true, "was": true, "we": true, "not": true,
+}
+
+// よく使う語の割合で英語か判断する
+func isEnglish(text string) bool {
+	words, stop := 0, 0
+	for _, w := range strings.Fields(strings.ToLower(text)) {
+		w = strings.TrimFunc(w, func(c rune) bool { return !unicode.IsLetter(c) })
+		if w == "" {
+			continue
+		}
+		words++
+		if englishStopwords[w] {
+			stop++
+		}
+	}
+	return words > 0 && float64(stop)/float64(words) >= 0.15
+}
+
+// 英単語の音節の数 (母音のまとまりを数え、語末の黙字のeを除く)
+func countSyllables(word string) int {
+	word = strings.ToLower(word)
+	n := 0
+	prevVowel := false
+	for _, c := range word {
+		vowel := strings.ContainsRune("aeiouy", c)
+		if vowel && !prevVowel {
+			n++
+		}
+		prevVowel = vowel
+	}
+	if strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") && n > 1 {
+		n--
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
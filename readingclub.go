@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 輪読会の記事を投票で選ぶ
+// Slackのダイジェストの記事に投票ボタンを付け、決めた曜日に前回から最も票を集めた記事を選んで結果を投稿する
+// 投票ボタンはdigest.enabledのときのダイジェストに付く
+// ボタンの押下を受けるにはserveでslack.signing_secretを設定してInteractivityを受け付ける
+type readingClubConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 記事を選ぶ曜日 (既定は月曜日)
+	PickDay string `yaml:"pick_day"`
+	// 結果に載せる件数 (既定は5)
+	Ranking int `yaml:"ranking"`
+}
+
+const (
+	defaultPickDay = time.Monday
+	// Slackの1メッセージのブロック数の上限
+	maxSlackBlocks = 50
+	// Block Kitのセクションの文字数の上限
+	maxSlackSectionLength = 3000
+)
+
+// 投票する (同じ人の同じ記事への投票は1票)
+func recordVote(ctx context.Context, url, user string) error {
+	_, err := db.ExecContext(ctx, "INSERT OR IGNORE INTO votes (url, user, created_at) VALUES (?, ?, ?)",
+		url, user, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+func (c readingClubConfig) pickDay() time.Weekday {
+	if d, ok := weekdayNames[strings.ToLower(c.PickDay)]; ok {
+		return d
+	}
+	return defaultPickDay
+}
+
+// 決めた曜日なら前回の選出からの票を数えて輪読会の記事を選び、結果を投稿する
+// 同じ日に何度実行しても選ぶのは1回だけ
+func pickReadingClub(ctx context.Context, dests []destination, c readingClubConfig, now time.Time) error {
+	if !c.Enabled || now.In(sourceLocation()).Weekday() != c.pickDay() {
+		return nil
+	}
+	day := now.In(sourceLocation()).Format("2006-01-02")
+	var last string
+	err := db.QueryRowContext(ctx, "SELECT picked_at FROM reading_club ORDER BY picked_at DESC LIMIT 1").Scan(&last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	var picked int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reading_club WHERE day = ?", day).Scan(&picked); err != nil {
+		return err
+	}
+	if picked > 0 {
+		return nil
+	}
+
+	limit := c.Ranking
+	if limit <= 0 {
+		limit = 5
+	}
+	rows, err := db.QueryContext(ctx, `
+SELECT v.url, COALESCE((SELECT MAX(title) FROM articles a WHERE a.url = v.url), v.url),
+       COALESCE((SELECT substr(MAX(date), 1, 10) FROM articles a WHERE a.url = v.url), ''), COUNT(*)
+FROM votes v
+WHERE v.created_at > ?
+GROUP BY v.url
+ORDER BY COUNT(*) DESC, MIN(v.created_at)
+LIMIT ?`, last, limit)
+	if err != nil {
+		return err
+	}
+	var ranking []article
+	var top int
+	for rows.Next() {
+		var a article
+		var n int
+		if err := rows.Scan(&a.url, &a.title, &a.date, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		if len(ranking) == 0 {
+			top = n
+		}
+		// 票数はタイトルに添えて見せる
+		a.title = fmt.Sprintf("%s (%d票)", a.title, n)
+		ranking = append(ranking, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// 票がなければ選ばない
+	if len(ranking) == 0 {
+		return nil
+	}
+
+	dg := &digest{groups: []digestGroup{{header: "今週の輪読会の記事", articles: ranking[:1]}}}
+	if len(ranking) > 1 {
+		dg.groups = append(dg.groups, digestGroup{header: "ほかの候補", articles: ranking[1:]})
+	}
+	results := dispatch(ctx, dests, func(ctx context.Context, d destination) error {
+		return d.sendDigest(ctx, dg)
+	})
+	logFailures("reading club", results)
+	if !anyDelivered(results) {
+		return errors.New("reading club: result was not delivered")
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO reading_club (day, url, votes, picked_at) VALUES (?, ?, ?, ?)",
+		day, ranking[0].url, top, now.UTC().Format(time.RFC3339))
+	return err
+}
+
+// ダイジェストを投票ボタン付きのBlock Kitにする
+// ブロックが多すぎるときはnil (テキストだけで送る)
+func voteBlocks(dg *digest) []any {
+	var blocks []any
+	for _, g := range dg.groups {
+		if g.header != "" {
+			blocks = append(blocks, map[string]any{"type": "section", "text": mrkdwn("*" + slackEscape(g.header) + "*")})
+		}
+		for _, a := range g.articles {
+			blocks = append(blocks, map[string]any{
+				"type":      "section",
+				"text":      mrkdwn(fmt.Sprintf("<%s|%s> (%s)", a.url, slackEscape(displayTitle(a)), a.date)),
+				"accessory": button("投票", "vote", a.url),
+			})
+		}
+	}
+	if len(blocks) > maxSlackBlocks {
+		return nil
+	}
+	return blocks
+}
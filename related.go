@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 関連記事を探す範囲 (新しい順)
+const relatedCandidates = 500
+
+// 通知に添える関連記事
+type relatedConfig struct {
+	// 添える件数 (0なら添えない)
+	Limit int `yaml:"limit"`
+}
+
+// 保存済みの記事から関連する記事を探す
+// 共通の分類を重く、タイトルの共通の語を軽く数えて多い順に選ぶ
+func findRelated(ctx context.Context, a article, limit int) ([]article, error) {
+	candidates, err := queryArticles(ctx, articleFilter{status: statusOK, newestFirst: true, limit: relatedCandidates})
+	if err != nil {
+		return nil, err
+	}
+	words := titleWords(a.title)
+	type scored struct {
+		a     article
+		score int
+	}
+	var res []scored
+	for _, c := range candidates {
+		if c.url == a.url {
+			continue
+		}
+		score := 0
+		for _, cat := range c.categories {
+			if a.inCategory(cat) {
+				score += 2
+			}
+		}
+		for w := range titleWords(c.title) {
+			if words[w] {
+				score++
+			}
+		}
+		if score > 0 {
+			res = append(res, scored{c, score})
+		}
+	}
+	// 同点なら新しい記事を優先する
+	sort.SliceStable(res, func(i, j int) bool { return res[i].score > res[j].score })
+	var related []article
+	for i := 0; i < len(res) && i < limit; i++ {
+		related = append(related, res[i].a)
+	}
+	return related, nil
+}
+
+// タイトルの語 (3文字未満は除く)
+func titleWords(title string) map[string]bool {
+	words := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(w) >= 3 {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// 関連記事の一覧 (なければ空)
+// headerFormatは見出しの書式 (Slackなら"*%s*")
+func relatedText(a article, headerFormat string) string {
+	if len(a.related) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n")
+	fmt.Fprintf(&b, headerFormat+"\n", "関連記事")
+	for _, r := range a.related {
+		fmt.Fprintf(&b, "• %s (%s)\n  %s\n", displayTitle(r), r.date, r.url)
+	}
+	return b.String()
+}
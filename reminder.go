@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 通知しても読まれない記事のリマインド
+// 通知すると記事は既読になるので、✅やボタンなどで明示的に既読にしたか (acked_at) で判断する
+type remindersConfig struct {
+	// 通知 (または前回のリマインド) からこれだけ経っても既読にされなければリマインドする ("3d"など)
+	After string `yaml:"after"`
+	// 記事ごとのリマインドの上限 (既定は1)
+	Max int `yaml:"max"`
+	// リマインドの送り先 (例: チャンネルではなくDMのwebhook)
+	Destinations []destinationConfig `yaml:"destinations"`
+}
+
+// 利用者が明示的に既読にした
+func acknowledge(ctx context.Context, url string) error {
+	if err := markAsRead(ctx, url); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "UPDATE articles SET acked_at = ? WHERE url = ? AND acked_at IS NULL", time.Now().UTC().Format(time.RFC3339), url)
+	return err
+}
+
+// 通知したまま既読にされていない記事をリマインドの送り先へ送る
+func remindUnread(ctx context.Context, c remindersConfig) error {
+	if c.After == "" || len(c.Destinations) == 0 {
+		return nil
+	}
+	after, err := parseDuration(c.After)
+	if err != nil {
+		return err
+	}
+	max := c.Max
+	if max <= 0 {
+		max = 1
+	}
+	dests, err := newDestinations(c.Destinations, conf.Public)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	// 設定する前に通知した記事がまとめて届かないように、最後のリマインドが済む期間内に通知した記事に限る
+	rows, err := db.QueryContext(ctx, `
+SELECT a.url, MAX(a.title), substr(MAX(a.date), 1, 10),
+       (SELECT COUNT(*) FROM reminders r WHERE r.url = a.url) AS sent,
+       COALESCE((SELECT MAX(sent_at) FROM reminders r WHERE r.url = a.url), MIN(d.delivered_at)) AS last
+FROM articles a JOIN deliveries d ON d.url = a.url AND d.status = 'ok'
+WHERE a.acked_at IS NULL
+GROUP BY a.url
+HAVING sent < ? AND last <= ? AND MIN(d.delivered_at) >= ?
+ORDER BY last`, max, now.Add(-after).Format(time.RFC3339), now.Add(-after*time.Duration(max+1)).Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		a    article
+		sent int
+	}
+	var targets []pending
+	for rows.Next() {
+		var p pending
+		var last string
+		if err := rows.Scan(&p.a.url, &p.a.title, &p.a.date, &p.sent, &last); err != nil {
+			rows.Close()
+			return err
+		}
+		targets = append(targets, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range targets {
+		header := fmt.Sprintf("未読のリマインド (%d/%d)", p.sent+1, max)
+		dg := &digest{groups: []digestGroup{{header: header, articles: []article{p.a}}}}
+		results := dispatch(ctx, dests, func(ctx context.Context, d destination) error {
+			return d.sendDigest(ctx, dg)
+		})
+		logFailures("reminder "+p.a.url, results)
+		// どこにも届かなければ次の実行でもう一度送る
+		if !anyDelivered(results) {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO reminders (url, sent_at) VALUES (?, ?)", p.a.url, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			log.Printf("reminder %s: %v", p.a.url, err)
+		}
+	}
+	return nil
+}
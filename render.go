@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// 描画サービス (Splashやスクレイピング用のAPI) を通した取得
+// JavaScriptで一覧を描くブログを、ヘッドレスブラウザを同梱せずに読む
+// 一覧のページと、同じホストの記事ページをサービスに描画させたHTMLで読む
+// サービスの描画を待つ時間もhttp.timeoutとhttp.response_header_timeoutに含まれる
+type renderConfig struct {
+	// サービスのURL。{url}を取得するページのURL (エスケープする)、{api_key}をapi_keyで置き換える
+	// 例: "http://localhost:8050/render.html?url={url}&wait=1" (Splash)
+	//     "https://app.scrapingbee.com/api/v1/?api_key={api_key}&url={url}"
+	Endpoint string `yaml:"endpoint"`
+	// $VARは環境変数で置き換える
+	APIKey string `yaml:"api_key"`
+	// 指定するとAPIキーをURLではなくこのヘッダで送る (例: X-Api-Key)
+	APIKeyHeader string `yaml:"api_key_header"`
+}
+
+func (c renderConfig) enabled() bool { return c.Endpoint != "" }
+
+func (c renderConfig) validate(key string) error {
+	if !c.enabled() {
+		return nil
+	}
+	if !strings.Contains(c.Endpoint, "{url}") {
+		return fmt.Errorf("%s.endpoint: must contain {url}", key)
+	}
+	u, err := url.Parse(strings.NewReplacer("{url}", "", "{api_key}", "").Replace(c.Endpoint))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s.endpoint: invalid URL %q", key, c.Endpoint)
+	}
+	return nil
+}
+
+// ページのURLをサービスへのリクエストにする
+func (c renderConfig) request(req *http.Request) (*http.Request, error) {
+	key := os.ExpandEnv(c.APIKey)
+	endpoint := strings.ReplaceAll(c.Endpoint, "{url}", url.QueryEscape(req.URL.String()))
+	if c.APIKeyHeader == "" {
+		endpoint = strings.ReplaceAll(endpoint, "{api_key}", url.QueryEscape(key))
+	}
+	r, err := http.NewRequestWithContext(req.Context(), http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 条件付きの取得やUser-Agentはサービスにそのまま渡す (Cookieはサービスのドメインのものではないので渡さない)
+	for k, v := range req.Header {
+		if k != "Cookie" {
+			r.Header[k] = v
+		}
+	}
+	if c.APIKeyHeader != "" {
+		r.Header.Set(c.APIKeyHeader, key)
+	}
+	return r, nil
+}
+
+// ホストごとに決めた描画サービスを通して取得するTransport
+// 描画しないホストのページはそのまま取得する
+type renderTransport struct {
+	base   http.RoundTripper
+	byHost map[string]renderConfig
+}
+
+// 描画サービスを使うブログのホスト
+// 描画サービスを使うブログがなければnil
+func renderHosts(targets []blog) map[string]renderConfig {
+	var byHost map[string]renderConfig
+	for _, b := range targets {
+		if !b.cfg.Render.enabled() {
+			continue
+		}
+		if byHost == nil {
+			byHost = map[string]renderConfig{}
+		}
+		byHost[urlHost(b.url)] = b.cfg.Render
+	}
+	return byHost
+}
+
+type noRenderKey struct{}
+
+// 描画サービスを通さずに取得するctx (フィードは描画するとHTMLに包まれてしまう)
+func withoutRender(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRenderKey{}, true)
+}
+
+func (t *renderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c, ok := t.byHost[req.URL.Host]
+	if !ok || req.Method != http.MethodGet || req.Context().Value(noRenderKey{}) != nil {
+		return t.base.RoundTrip(req)
+	}
+	r, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, fmt.Errorf("render %s: %w", req.URL, err)
+	}
+	// 呼び出し側にはページを直接取得したように見せる (相対URLの解決やリダイレクトの検出のため)
+	resp.Request = req
+	return resp, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// litestreamによるDBのレプリケーション
+type replicator struct {
+	cfg    replicationConfig
+	dbPath string
+	cmd    *exec.Cmd
+	done   chan error
+}
+
+func newReplicator(cfg replicationConfig, dbPath string) *replicator {
+	if cfg.Litestream == "" {
+		cfg.Litestream = "litestream"
+	}
+	return &replicator{cfg: cfg, dbPath: dbPath}
+}
+
+// レプリカ指定の引数
+// configがあればlitestreamの設定ファイルを、なければreplica_urlを使う
+func (r *replicator) args(cmd string, extra ...string) []string {
+	args := []string{cmd}
+	if r.cfg.Config != "" {
+		args = append(args, "-config", r.cfg.Config)
+		args = append(args, extra...)
+		return append(args, r.dbPath)
+	}
+	args = append(args, extra...)
+	if cmd == "restore" {
+		return append(args, "-o", r.dbPath, r.cfg.ReplicaURL)
+	}
+	return append(args, r.dbPath, r.cfg.ReplicaURL)
+}
+
+// DBファイルがなければレプリカから復元する
+// DBを開く前に呼ぶ
+func (r *replicator) restore() error {
+	if _, err := os.Stat(r.dbPath); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	cmd := exec.Command(r.cfg.Litestream, r.args("restore", "-if-replica-exists")...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("litestream restore: %w", err)
+	}
+	return nil
+}
+
+// レプリケーションを開始する
+// litestreamは子プロセスとして動き、stopで終了する
+func (r *replicator) start() error {
+	r.cmd = exec.Command(r.cfg.Litestream, r.args("replicate")...)
+	r.cmd.Stdout = os.Stdout
+	r.cmd.Stderr = os.Stderr
+	if err := r.cmd.Start(); err != nil {
+		return fmt.Errorf("litestream replicate: %w", err)
+	}
+	r.done = make(chan error, 1)
+	go func() {
+		r.done <- r.cmd.Wait()
+	}()
+	log.Printf("litestream: replicating %s (pid %d)", r.dbPath, r.cmd.Process.Pid)
+	return nil
+}
+
+// レプリケーションを終了する
+// 最後の同期が終わるまで待ち、終わらなければ強制終了する
+func (r *replicator) stop() {
+	if r.cmd == nil || r.cmd.Process == nil {
+		return
+	}
+	if err := r.cmd.Process.Signal(os.Interrupt); err != nil {
+		r.cmd.Process.Kill()
+	}
+	select {
+	case err := <-r.done:
+		if err != nil {
+			log.Printf("litestream: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		log.Print("litestream: shutdown timed out")
+		r.cmd.Process.Kill()
+		<-r.done
+	}
+}
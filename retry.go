@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 一時的な失敗 (接続のエラー、429、5xx) のやり直し
+// 待つ時間は回ごとに倍にし、揃ってやり直さないようにばらつかせる (full jitter)
+// 429と503のRetry-Afterがあればその時間だけ待つ
+// http.timeoutはやり直しを含めた全体の時間制限になる
+type retryConfig struct {
+	// 最初の1回を含む回数 (既定は3、1ならやり直さない)
+	MaxAttempts int `yaml:"max_attempts"`
+	// 最初に待つ時間の上限 (既定は1秒)
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	// 待つ時間の上限 (既定は30秒)
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// Retry-Afterがこれより長ければ待たずに失敗にする (既定は1分)
+	MaxRetryAfter time.Duration `yaml:"max_retry_after"`
+}
+
+const (
+	defaultRetryAttempts  = 3
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+	defaultMaxRetryAfter  = time.Minute
+)
+
+func (c retryConfig) maxAttempts() int {
+	if c.MaxAttempts <= 0 {
+		return defaultRetryAttempts
+	}
+	return c.MaxAttempts
+}
+
+func (c retryConfig) initialBackoff() time.Duration {
+	if c.InitialBackoff <= 0 {
+		return defaultInitialBackoff
+	}
+	return c.InitialBackoff
+}
+
+func (c retryConfig) maxBackoff() time.Duration {
+	if c.MaxBackoff <= 0 {
+		return defaultMaxBackoff
+	}
+	return c.MaxBackoff
+}
+
+func (c retryConfig) maxRetryAfter() time.Duration {
+	if c.MaxRetryAfter <= 0 {
+		return defaultMaxRetryAfter
+	}
+	return c.MaxRetryAfter
+}
+
+type retryTransport struct {
+	base http.RoundTripper
+	cfg  retryConfig
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := t.cfg.maxAttempts()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= attempts || !t.retryable(req, resp, err) {
+			return resp, err
+		}
+		wait := backoff(t.cfg, attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp, time.Now()); ok {
+				if after > t.cfg.maxRetryAfter() {
+					return resp, nil
+				}
+				wait = after
+			}
+		}
+		// 本文を送り直せなければやり直さない
+		next := req
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, berr := req.GetBody()
+			if berr != nil {
+				return resp, err
+			}
+			next = req.Clone(req.Context())
+			next.Body = body
+		}
+		reason := fmt.Sprint(err)
+		if resp != nil {
+			reason = resp.Status
+			resp.Body.Close()
+		}
+		log.Printf("%s %s: %s, retrying in %s (%d/%d)", req.Method, req.URL.Redacted(), reason, wait.Round(time.Millisecond), attempt+1, attempts)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		req = next
+	}
+}
+
+// やり直してよい失敗か
+// 接続のエラーでは、送ったかもしれないPOSTは冪等キーがあるときか接続する前の失敗だけやり直す
+func (t *retryTransport) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		if isIdempotent(req) {
+			return true
+		}
+		var op *net.OpError
+		return errors.As(err, &op) && op.Op == "dial"
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// attempt回目の失敗のあとに待つ時間 (0から上限までのどこか)
+func backoff(c retryConfig, attempt int) time.Duration {
+	limit := c.initialBackoff()
+	for i := 1; i < attempt && limit < c.maxBackoff(); i++ {
+		limit *= 2
+	}
+	if limit > c.maxBackoff() {
+		limit = c.maxBackoff()
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// 429と503のRetry-After (秒数か日時)
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
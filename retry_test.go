@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffLimit(t *testing.T) {
+	c := retryConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		attempt int
+		limit   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		// 0から上限までのどこかになる
+		var longest time.Duration
+		for i := 0; i < 200; i++ {
+			d := backoff(c, tt.attempt)
+			if d < 0 || d > tt.limit {
+				t.Fatalf("backoff(attempt %d) = %s, want 0-%s", tt.attempt, d, tt.limit)
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+		if longest <= tt.limit/2 {
+			t.Errorf("backoff(attempt %d) never exceeded %s in 200 tries", tt.attempt, tt.limit/2)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		status int
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"seconds", http.StatusTooManyRequests, "120", 2 * time.Minute, true},
+		{"date", http.StatusServiceUnavailable, "Thu, 01 Jan 2026 00:00:30 GMT", 30 * time.Second, true},
+		{"date in the past", http.StatusServiceUnavailable, "Wed, 31 Dec 2025 23:59:00 GMT", 0, true},
+		{"missing", http.StatusTooManyRequests, "", 0, false},
+		{"garbage", http.StatusTooManyRequests, "soon", 0, false},
+		{"negative", http.StatusTooManyRequests, "-1", 0, false},
+		{"other status", http.StatusBadGateway, "120", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got, ok := retryAfter(resp, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("retryAfter = %s, %v, want %s, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	post, _ := http.NewRequest(http.MethodPost, "https://example.com/", nil)
+	keyed, _ := http.NewRequest(http.MethodPost, "https://example.com/", nil)
+	keyed.Header.Set("Idempotency-Key", "k")
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Err: errors.New("connection reset")}
+	status := func(code int) *http.Response { return &http.Response{StatusCode: code} }
+	tests := []struct {
+		name string
+		req  *http.Request
+		resp *http.Response
+		err  error
+		want bool
+	}{
+		{"429", get, status(http.StatusTooManyRequests), nil, true},
+		{"500", get, status(http.StatusInternalServerError), nil, true},
+		{"503", post, status(http.StatusServiceUnavailable), nil, true},
+		{"501", get, status(http.StatusNotImplemented), nil, false},
+		{"404", get, status(http.StatusNotFound), nil, false},
+		{"get after a read error", get, nil, readErr, true},
+		{"post after a dial error", post, nil, dialErr, true},
+		// 届いたかもしれないPOSTは送り直さない
+		{"post after a read error", post, nil, readErr, false},
+		{"post with a key after a read error", keyed, nil, readErr, true},
+		{"canceled", get, nil, context.Canceled, false},
+		{"deadline", get, nil, context.DeadlineExceeded, false},
+	}
+	tr := &retryTransport{}
+	for _, tt := range tests {
+		if got := tr.retryable(tt.req, tt.resp, tt.err); got != tt.want {
+			t.Errorf("%s: retryable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		// 応答する状態コードの順 (尽きたら200)
+		statuses   []int
+		retryAfter string
+		body       string
+		want       int
+		wantCalls  int32
+	}{
+		{"succeeds after retries", []int{500, 502}, "", "", 200, 3},
+		{"gives up", []int{500, 500, 500, 500}, "", "", 500, 3},
+		{"not retryable", []int{404}, "", "", 404, 1},
+		{"retry-after too long", []int{429}, "3600", "", 429, 1},
+		{"resends the body", []int{503}, "0", "payload", 200, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				if tt.body != "" {
+					if b, err := io.ReadAll(r.Body); err != nil || string(b) != tt.body {
+						t.Errorf("attempt %d: body = %q, %v", n, b, err)
+					}
+				}
+				if int(n) <= len(tt.statuses) {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.statuses[n-1])
+				}
+			}))
+			defer srv.Close()
+			client := &http.Client{Transport: &retryTransport{
+				base: http.DefaultTransport,
+				cfg:  retryConfig{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+			}}
+			method := http.MethodGet
+			if tt.body != "" {
+				method = http.MethodPut
+			}
+			req, err := http.NewRequest(method, srv.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want || calls != tt.wantCalls {
+				t.Errorf("status %d after %d calls, want %d after %d", resp.StatusCode, calls, tt.want, tt.wantCalls)
+			}
+		})
+	}
+}
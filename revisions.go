@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 本文の書き換えの差分 (語の単位)
+// 前の版はcontentStepが取り直したときにarticle_revisionsに残す
+
+// 差分の1つの区間
+type diffOp struct {
+	// ' ' (同じ), '-' (消えた), '+' (増えた)
+	kind byte
+	text string
+}
+
+// 最長共通部分列を求める表の上限 (前後の同じ部分を除いた語数の積)
+// 超えたら間の部分をまとめて置き換えとする
+const maxDiffCells = 4000000
+
+// 語の単位の差分
+// 英数字は連続した1語、空白は連続した1つ、日本語などはそれ以外の1文字ずつを単位とする
+func wordDiff(before, after string) []diffOp {
+	a, b := diffTokens(before), diffTokens(after)
+	// 前後の同じ部分は表を作らずに済ませる
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var ops []diffOp
+	add := func(kind byte, toks []string) {
+		if len(toks) == 0 {
+			return
+		}
+		text := strings.Join(toks, "")
+		if n := len(ops); n > 0 && ops[n-1].kind == kind {
+			ops[n-1].text += text
+			return
+		}
+		ops = append(ops, diffOp{kind: kind, text: text})
+	}
+	add(' ', a[:prefix])
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(ma)+1)*(len(mb)+1) > maxDiffCells {
+		add('-', ma)
+		add('+', mb)
+	} else {
+		// lcs[i][j]: ma[i:]とmb[j:]の最長共通部分列の長さ
+		w := len(mb) + 1
+		lcs := make([]int32, (len(ma)+1)*w)
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+				} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+					lcs[i*w+j] = lcs[(i+1)*w+j]
+				} else {
+					lcs[i*w+j] = lcs[i*w+j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) && j < len(mb) {
+			switch {
+			case ma[i] == mb[j]:
+				add(' ', ma[i:i+1])
+				i++
+				j++
+			case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+				add('-', ma[i:i+1])
+				i++
+			default:
+				add('+', mb[j:j+1])
+				j++
+			}
+		}
+		add('-', ma[i:])
+		add('+', mb[j:])
+	}
+	add(' ', a[len(a)-suffix:])
+	return ops
+}
+
+func diffTokens(s string) []string {
+	class := func(r rune) int {
+		switch {
+		case unicode.IsSpace(r):
+			return 1
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) ||
+			unicode.In(r, unicode.Latin, unicode.Greek, unicode.Cyrillic):
+			return 2
+		}
+		return 0
+	}
+	var toks []string
+	start, prev := 0, -1
+	for i, r := range s {
+		c := class(r)
+		if i > 0 && (c == 0 || c != prev) {
+			toks = append(toks, s[start:i])
+			start = i
+		}
+		prev = c
+	}
+	if start < len(s) {
+		toks = append(toks, s[start:])
+	}
+	return toks
+}
+
+// 記事の前の版と今の本文
+type articleRevision struct {
+	title     string
+	url       string
+	before    string
+	after     string
+	fetchedAt string
+	changedAt string
+}
+
+// 記事 (URLかlist -idsのid) の直前の版と今の本文
+func latestRevision(ctx context.Context, ref string) (articleRevision, error) {
+	a, err := lookupArticle(ctx, ref)
+	if err != nil {
+		return articleRevision{}, err
+	}
+	r := articleRevision{title: a.title, url: a.url}
+	err = db.QueryRowContext(ctx, `
+SELECT r.content, r.fetched_at, r.replaced_at, COALESCE(a.content, '')
+FROM article_revisions r JOIN articles a ON a.url = r.url
+WHERE r.url = ? ORDER BY r.id DESC, a.rowid DESC LIMIT 1`, a.url).Scan(&r.before, &r.fetchedAt, &r.changedAt, &r.after)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, fmt.Errorf("%s: no earlier version stored: %w", a.url, errNotFound)
+	}
+	return r, err
+}
+
+// 変わっていない部分はこの文字数より長ければ前後だけを出す
+const diffContext = 40
+
+// diff: 記事の本文が前の版からどう書き換えられたかを出す
+// 消えた部分は[-...-]、増えた部分は{+...+}
+func cmdDiff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	full := fs.Bool("full", false, "print unchanged text in full")
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		return usageErr("usage: diff [-full] <url|id>")
+	}
+	r, err := latestRevision(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n%s\n%s -> %s\n\n", r.title, r.url, r.fetchedAt, r.changedAt)
+	var b strings.Builder
+	for _, op := range wordDiff(r.before, r.after) {
+		switch op.kind {
+		case '-':
+			b.WriteString("[-" + op.text + "-]")
+		case '+':
+			b.WriteString("{+" + op.text + "+}")
+		default:
+			b.WriteString(elideUnchanged(op.text, *full))
+		}
+	}
+	fmt.Println(b.String())
+	return nil
+}
+
+// 変わっていない長い部分を前後だけにする
+func elideUnchanged(s string, full bool) string {
+	runes := []rune(s)
+	if full || len(runes) <= 2*diffContext+1 {
+		return s
+	}
+	return string(runes[:diffContext]) + " … " + string(runes[len(runes)-diffContext:])
+}
+
+var diffTemplate = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 48em; margin: auto; padding: 1em; line-height: 1.7; }
+.text { white-space: pre-wrap; }
+del { background: #fdd; }
+ins { background: #dfd; text-decoration: none; }
+</style>
+</head>
+<body>
+<h1><a href="{{.URL}}">{{.Title}}</a></h1>
+<p><small>{{.FetchedAt}} → {{.ChangedAt}}</small></p>
+<div class="text">{{range .Ops}}{{if eq .Kind "-"}}<del>{{.Text}}</del>{{else if eq .Kind "+"}}<ins>{{.Text}}</ins>{{else}}{{.Text}}{{end}}{{end}}</div>
+</body>
+</html>
+`))
+
+// GET /articles/diff?id=... (またはurl=...)
+func handleArticleDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ref := r.URL.Query().Get("id")
+	if ref == "" {
+		ref = r.URL.Query().Get("url")
+	}
+	if ref == "" {
+		http.Error(w, "id or url is required", http.StatusBadRequest)
+		return
+	}
+	rev, err := latestRevision(r.Context(), ref)
+	if errors.Is(err, errNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("article diff: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	hidden, err := isHidden(r.Context(), rev.url)
+	if err != nil {
+		log.Printf("article diff: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if hidden {
+		http.Error(w, "article not found", http.StatusNotFound)
+		return
+	}
+	type op struct{ Kind, Text string }
+	var ops []op
+	for _, o := range wordDiff(rev.before, rev.after) {
+		ops = append(ops, op{Kind: string(o.kind), Text: o.text})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = diffTemplate.Execute(w, struct {
+		Title, URL, FetchedAt, ChangedAt string
+		Ops                              []op
+	}{rev.title, rev.url, rev.fetchedAt, rev.changedAt, ops})
+	if err != nil {
+		log.Printf("render diff: %v", err)
+	}
+}
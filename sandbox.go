@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// sandboxのDB (SQLiteのmemdb)
+// 接続がすべて閉じると消えるので、実行中は接続を1つ持ち続ける
+const sandboxDatabase = "file:/fetch-blog-sandbox?vfs=memdb"
+
+// 標準出力に書く通知先 (sandbox用)
+type consoleDestination struct {
+	w io.Writer
+}
+
+func (d *consoleDestination) name() string { return "console" }
+
+func (d *consoleDestination) send(ctx context.Context, a article) error {
+	_, err := fmt.Fprintf(d.w, "%s (%s)\n%s%s\n\n", displayTitle(a), a.date, a.url, articleExtras(a, "%s"))
+	return err
+}
+
+func (d *consoleDestination) sendDigest(ctx context.Context, dg *digest) error {
+	_, err := fmt.Fprintln(d.w, dg.text("%s"))
+	return err
+}
+
+// 設定の断片からブログの取得に関わる部分だけを取り出す
+// 通知先、外部サービス、ディスクへの書き出し (HTTPキャッシュなど) の設定は使わない
+func sandboxConfig(c *config) *config {
+	s := &config{
+		Source:      c.Source,
+		Blogs:       c.Blogs,
+		Quality:     c.Quality,
+		Filters:     c.Filters,
+		Paywall:     c.Paywall,
+		Readability: c.Readability,
+		Content:     c.Content,
+		Fetch:       c.Fetch,
+		HTTP:        c.HTTP,
+		Notify:      notifyConfig{Limit: c.Notify.Limit},
+		Digest:      c.Digest,
+	}
+	s.HTTP.Cache = httpCacheConfig{}
+	return s
+}
+
+// sandbox: 設定の断片で取得から通知までを試す (新しいブログの設定の確認用)
+// DBはメモリに作って終了時に捨て、通知は標準出力に書くので、普段のDBや通知先には触れない
+func cmdSandbox(args []string) int {
+	fs := flag.NewFlagSet("sandbox", flag.ExitOnError)
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		return fail(usageErr("usage: sandbox <config.yaml>"))
+	}
+	// 設定がなければ既定の設定で動いてしまうので先に確かめる
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err != nil {
+		return fail(withExitCode(exitConfig, err))
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		return fail(err)
+	}
+	if len(c.Blogs) == 0 && c.Source.URL == "" {
+		return fail(withExitCode(exitConfig, fmt.Errorf("%s: set source.url or blogs", path)))
+	}
+	conf = sandboxConfig(c)
+	if err := setupHTTP(conf.HTTP); err != nil {
+		return fail(withExitCode(exitConfig, err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := openDB(sandboxDatabase, false, false); err != nil {
+		return fail(withExitCode(exitDB, err))
+	}
+	defer db.Close()
+	keep, err := db.Conn(ctx)
+	if err != nil {
+		return fail(withExitCode(exitDB, err))
+	}
+	defer keep.Close()
+
+	// 取得に失敗したブログがあっても、取得できた記事は通知する
+	fetchErr := fetchPhase(ctx, blogs())
+	if fetchErr != nil {
+		log.Print(fetchErr)
+	}
+	if err := notifyPhase(ctx, []destination{&consoleDestination{w: os.Stdout}}); err != nil {
+		return fail(err)
+	}
+	if fetchErr != nil && !errors.Is(fetchErr, context.Canceled) {
+		return exitCodeOf(fetchErr)
+	}
+	return report.exitCode()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 実行する曜日の一覧
+// 空ならすべての曜日
+type weekdays []time.Weekday
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// "mon"や"Monday"などの曜日名を読み込む
+func (w *weekdays) UnmarshalYAML(n *yaml.Node) error {
+	var names []string
+	if err := n.Decode(&names); err != nil {
+		return err
+	}
+	days := make(weekdays, 0, len(names))
+	for _, name := range names {
+		d, ok := weekdayNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("unknown weekday %q", name)
+		}
+		days = append(days, d)
+	}
+	*w = days
+	return nil
+}
+
+func (w weekdays) includes(d time.Weekday) bool {
+	if len(w) == 0 {
+		return true
+	}
+	for _, x := range w {
+		if x == d {
+			return true
+		}
+	}
+	return false
+}
+
+// 処理ごとの実行スケジュール
+type scheduleConfig struct {
+	// 記事を取得する曜日 (既定は金曜日以外、fetch_cronを設定したら使わない)
+	FetchDays weekdays `yaml:"fetch_days"`
+	// 通知する曜日 (既定は毎日、notify_cronを設定したら使わない)
+	NotifyDays weekdays `yaml:"notify_days"`
+	// serveで記事を取得する時刻 (cronの書式、例: "0 9 * * 0-4,6")
+	// 1回だけ実行するときも、その日に取得するかはこの日付と曜日で決める
+	FetchCron string `yaml:"fetch_cron"`
+	// serveで通知する時刻 (cronの書式、例: "30 9 * * *")
+	NotifyCron string `yaml:"notify_cron"`
+	// cronと曜日を判定するタイムゾーン (例: Asia/Tokyo、既定はシステムのタイムゾーン)
+	Timezone string `yaml:"timezone"`
+
+	fetchCron, notifyCron *cronSchedule
+	loc                   *time.Location
+}
+
+// 以前の動作 (金曜日は取得しない) に合わせた既定値
+var defaultFetchDays = weekdays{
+	time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Saturday,
+}
+
+// cronとタイムゾーンを読み、曜日の既定値を決める
+func (s *scheduleConfig) init() error {
+	s.loc = time.Local
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return fmt.Errorf("schedule.timezone: %w", err)
+		}
+		s.loc = loc
+	}
+	var err error
+	if s.FetchCron != "" {
+		if s.fetchCron, err = parseCron(s.FetchCron); err != nil {
+			return fmt.Errorf("schedule.fetch_cron: %w", err)
+		}
+	} else if len(s.FetchDays) == 0 {
+		s.FetchDays = defaultFetchDays
+	}
+	if s.NotifyCron != "" {
+		if s.notifyCron, err = parseCron(s.NotifyCron); err != nil {
+			return fmt.Errorf("schedule.notify_cron: %w", err)
+		}
+	}
+	// 2月30日のように実行されない時刻は書き間違い
+	at, _, _ := s.nextRun(time.Now())
+	if s.daemon() && at.IsZero() {
+		return fmt.Errorf("schedule: fetch_cron and notify_cron never run")
+	}
+	return nil
+}
+
+func (s scheduleConfig) location() *time.Location {
+	if s.loc == nil {
+		return time.Local
+	}
+	return s.loc
+}
+
+func (s scheduleConfig) shouldFetch(now time.Time) bool {
+	now = now.In(s.location())
+	if s.fetchCron != nil {
+		return s.fetchCron.matchesDay(now)
+	}
+	return s.FetchDays.includes(now.Weekday())
+}
+
+func (s scheduleConfig) shouldNotify(now time.Time) bool {
+	now = now.In(s.location())
+	if s.notifyCron != nil {
+		return s.notifyCron.matchesDay(now)
+	}
+	return s.NotifyDays.includes(now.Weekday())
+}
+
+// serveで定期実行するか
+func (s scheduleConfig) daemon() bool {
+	return s.fetchCron != nil || s.notifyCron != nil
+}
+
+// nowより後の次の実行時刻と、そのとき行う処理
+func (s scheduleConfig) nextRun(now time.Time) (at time.Time, fetch, notify bool) {
+	now = now.In(s.location())
+	var nf, nn time.Time
+	if s.fetchCron != nil {
+		nf = s.fetchCron.next(now)
+	}
+	if s.notifyCron != nil {
+		nn = s.notifyCron.next(now)
+	}
+	switch {
+	case nf.IsZero():
+		return nn, false, !nn.IsZero()
+	case nn.IsZero() || nf.Before(nn):
+		return nf, true, false
+	case nn.Before(nf):
+		return nn, false, true
+	}
+	return nf, true, true
+}
+
+// serveと一緒に動き、fetch_cronとnotify_cronの時刻に取得と通知を行う
+// 実行中にctxがキャンセルされたら、その実行を中断して戻る
+func runScheduler(ctx context.Context, dests []destination) {
+	for {
+		at, fetch, notify := conf.Schedule.nextRun(time.Now())
+		if at.IsZero() {
+			log.Print("schedule: no upcoming run")
+			return
+		}
+		log.Printf("schedule: next run at %s", at.Format("2006-01-02 15:04 MST"))
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		scheduledRun(ctx, dests, fetch, notify)
+	}
+}
+
+// 1回分の定期実行
+// 失敗してもログに残して次の実行を待つ
+func scheduledRun(ctx context.Context, dests []destination, fetch, notify bool) {
+	// 複数台構成ではリースを持つインスタンスだけが取得・通知する
+	if conf.HA.Enabled {
+		el := newElector(conf.HA)
+		leader, err := el.acquire(ctx)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if !leader {
+			log.Printf("%s is not the leader, skipping the scheduled run", el.holder)
+			return
+		}
+		var release func()
+		ctx, release = el.hold(ctx)
+		defer release()
+	}
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	report.reset()
+	if fetch {
+		if err := fetchPhase(ctx, blogs()); err != nil {
+			log.Printf("scheduled fetch: %v", err)
+		}
+	}
+	if notify {
+		if err := notifyPhase(ctx, dests); err != nil {
+			log.Printf("scheduled notify: %v", err)
+		}
+	}
+	if conf.UnreadFeed.Path != "" {
+		if err := writeUnreadFeedFile(ctx, conf.UnreadFeed, conf.UnreadFeed.Path); err != nil {
+			log.Printf("unread feed: %v", err)
+		}
+	}
+	if report.exitCode() != exitOK {
+		log.Printf("scheduled run finished with failures: %s", &report)
+	}
+}
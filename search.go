@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// 全文検索の索引 (SQLiteのFTS5)
+// 記事から作り直せるので、マイグレーションではなくsearchのたびに記事に合わせて更新する
+// (FTS5のないビルドでもDBを開けるように、記事のテーブルにトリガーは付けない)
+// trigramで3文字ずつ索引するので、分かち書きのない日本語も部分一致で探せる
+const searchIndexSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS articles_fts USING fts5(title, content, content_at UNINDEXED, tokenize = 'trigram')`
+
+// 索引の最小の長さ (これより短い語は索引を使わずに探す)
+const searchTrigram = 3
+
+var errNoFTS5 = errors.New("search needs SQLite with FTS5 (build with -tags sqlite_fts5)")
+
+// 索引を記事に合わせる
+// タイトルか本文 (content_at) が変わった記事と消えた記事を除き、索引にない記事を足す
+func updateSearchIndex(ctx context.Context) error {
+	var fts5 bool
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		return errNoFTS5
+	}
+	if _, err := db.ExecContext(ctx, searchIndexSchema); err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+DELETE FROM articles_fts WHERE rowid NOT IN (
+  SELECT f.rowid FROM articles_fts f JOIN articles a ON a.rowid = f.rowid AND a.title = f.title AND a.content_at IS f.content_at)`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO articles_fts (rowid, title, content, content_at)
+SELECT rowid, title, COALESCE(content, ''), content_at FROM articles WHERE rowid NOT IN (SELECT rowid FROM articles_fts)`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 検索の条件を絞り込みに加える
+// 語はすべて含む記事 (AND)、FTS5の構文は使わず語をそのまま探す
+func (b *selectBuilder) whereMatches(query string) *selectBuilder {
+	var phrases []string
+	for _, term := range strings.Fields(query) {
+		if utf8.RuneCountInString(term) < searchTrigram {
+			// 索引にかからない短い語は大文字と小文字を区別せずに探す
+			b.where("instr(lower(title), lower(?)) > 0 OR instr(lower(COALESCE(content, '')), lower(?)) > 0", term, term)
+			continue
+		}
+		phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	if len(phrases) > 0 {
+		b.where("rowid IN (SELECT rowid FROM articles_fts WHERE articles_fts MATCH ?)", strings.Join(phrases, " "))
+	}
+	return b
+}
+
+// search: タイトルと本文から記事を探す (新しい順)
+func cmdSearch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	read := fs.String("read", "", "filter by read state (true or false)")
+	source := fs.String("source", "", "filter by blog host")
+	limit := fs.Int("limit", 50, "maximum number of articles (0 for no limit)")
+	ids := fs.Bool("ids", false, "show article ids (for share)")
+	parseFlags(fs, args)
+	query := strings.Join(fs.Args(), " ")
+	if strings.TrimSpace(query) == "" {
+		return usageErr("usage: search [-read true|false] [-source host] [-limit n] <words>")
+	}
+	f := articleFilter{source: *source, search: query, newestFirst: true, limit: *limit}
+	switch *read {
+	case "":
+	case "true":
+		f.read = boolPtr(true)
+	case "false":
+		f.read = boolPtr(false)
+	default:
+		return fmt.Errorf("invalid -read value %q", *read)
+	}
+
+	if err := updateSearchIndex(ctx); err != nil {
+		return err
+	}
+	articles, err := queryArticles(ctx, f)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, a := range articles {
+		mark := " "
+		if a.read {
+			mark = "✓"
+		}
+		if *ids {
+			fmt.Fprintf(w, "%d\t", a.id)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.date, mark, a.title, a.url)
+	}
+	return w.Flush()
+}
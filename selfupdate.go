@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ビルド時に -ldflags "-X main.version=v1.2.3" で埋め込む
+var version = "dev"
+
+// リリースの署名を確かめるEd25519の公開鍵 (base64)
+// ビルド時に -ldflags "-X main.updatePublicKey=..." で埋め込む
+var updatePublicKey = ""
+
+// GitHubのリリースからの更新
+// リリースには実行ファイル fetch-blog_<os>_<arch> (Windowsは.exe) と、
+// sha256sumの形式のchecksums.txt、その署名checksums.txt.sig (Ed25519、生の64バイトかbase64) を置く
+type selfUpdateConfig struct {
+	// 既定はdchf12/go-blog-fetch
+	Repo string `yaml:"repo"`
+	// checksums.txtの署名を確かめるEd25519の公開鍵 (base64)
+	// 空ならビルド時に埋め込んだ鍵を使う。どちらもなければ -insecure を付けない限り更新しない
+	PublicKey string `yaml:"public_key"`
+}
+
+const (
+	defaultUpdateRepo = "dchf12/go-blog-fetch"
+	checksumsAsset    = "checksums.txt"
+)
+
+// GitHubのリリース (必要な部分)
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *githubRelease) assetURL(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// この環境向けの実行ファイルの名前
+func releaseAssetName() string {
+	name := fmt.Sprintf("fetch-blog_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// self-update: 最新のリリースを確かめて実行ファイルを置き換える
+func cmdSelfUpdate(ctx context.Context, c selfUpdateConfig, args []string) error {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	check := fs.Bool("check", false, "only report whether an update is available")
+	tag := fs.String("version", "", "install this release tag instead of the latest")
+	insecure := fs.Bool("insecure", false, "install without a public key, verifying the checksum only")
+	parseFlags(fs, args)
+
+	repo := c.Repo
+	if repo == "" {
+		repo = defaultUpdateRepo
+	}
+	pub, err := updateKey(c)
+	if err != nil {
+		return err
+	}
+	// 署名を確かめられないなら、チェックサムと同じ場所から届く実行ファイルを信用することになる
+	if pub == nil && !*check && !*insecure {
+		return errors.New("self_update.public_key is not set and no key was built in; set it, or pass -insecure to verify the checksum only")
+	}
+
+	// 実行ファイルは大きいので時間制限を長くする
+	client := &http.Client{Transport: httpTransport, Timeout: 5 * time.Minute}
+	rel, err := fetchRelease(ctx, client, repo, *tag)
+	if err != nil {
+		return err
+	}
+	if rel.TagName == version {
+		fmt.Printf("already up to date (%s)\n", version)
+		return nil
+	}
+	if *check {
+		fmt.Printf("update available: %s -> %s\n", version, rel.TagName)
+		return nil
+	}
+
+	name := releaseAssetName()
+	binURL, ok := rel.assetURL(name)
+	if !ok {
+		return fmt.Errorf("release %s has no asset %s", rel.TagName, name)
+	}
+	sumsURL, ok := rel.assetURL(checksumsAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s", rel.TagName, checksumsAsset)
+	}
+	sums, err := download(ctx, client, sumsURL)
+	if err != nil {
+		return err
+	}
+	if pub != nil {
+		sigURL, ok := rel.assetURL(checksumsAsset + ".sig")
+		if !ok {
+			return fmt.Errorf("release %s is not signed", rel.TagName)
+		}
+		sig, err := download(ctx, client, sigURL)
+		if err != nil {
+			return err
+		}
+		if err := verifySignature(pub, sums, sig); err != nil {
+			return err
+		}
+	} else {
+		log.Print("-insecure: no public key, verifying the checksum only")
+	}
+	want, err := checksumOf(sums, name)
+	if err != nil {
+		return err
+	}
+	bin, err := download(ctx, client, binURL)
+	if err != nil {
+		return err
+	}
+	got := sha256.Sum256(bin)
+	if hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("checksum mismatch for %s", name)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if err := replaceExecutable(exe, bin); err != nil {
+		return err
+	}
+	fmt.Printf("updated %s -> %s\n", version, rel.TagName)
+	return nil
+}
+
+// 署名を確かめる公開鍵 (設定、なければビルド時に埋め込んだ鍵、どちらもなければnil)
+func updateKey(c selfUpdateConfig) (ed25519.PublicKey, error) {
+	key := c.PublicKey
+	if key == "" {
+		key = updatePublicKey
+	}
+	if key == "" {
+		return nil, nil
+	}
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != ed25519.PublicKeySize {
+		return nil, errors.New("self_update.public_key must be a base64 Ed25519 public key")
+	}
+	return ed25519.PublicKey(k), nil
+}
+
+// リリースを取得する (tagが空なら最新)
+func fetchRelease(ctx context.Context, client *http.Client, repo, tag string) (*githubRelease, error) {
+	u := "https://api.github.com/repos/" + repo + "/releases/latest"
+	if tag != "" {
+		u = "https://api.github.com/repos/" + repo + "/releases/tags/" + tag
+	}
+	body, err := download(ctx, client, u)
+	if err != nil {
+		return nil, err
+	}
+	var rel githubRelease
+	if err := json.Unmarshal(body, &rel); err != nil {
+		return nil, fmt.Errorf("decode release: %w", err)
+	}
+	return &rel, nil
+}
+
+func download(ctx context.Context, client *http.Client, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status code %d", u, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// 署名は生の64バイトかそのbase64
+func verifySignature(pub ed25519.PublicKey, msg, sig []byte) error {
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return errors.New("invalid signature encoding")
+		}
+		sig = decoded
+	}
+	if !ed25519.Verify(pub, msg, sig) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// checksums.txtからファイルのSHA-256を探す
+func checksumOf(sums []byte, name string) (string, error) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// sha256sum -bは名前の前に*を付ける
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", checksumsAsset, name)
+}
+
+// 同じディレクトリに書いてからrenameで置き換える
+// Windowsは実行中のファイルを上書きできないので、先に古いファイルを.oldへ移す
+func replaceExecutable(exe string, bin []byte) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".fetch-blog-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			// 元に戻す
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), exe)
+}
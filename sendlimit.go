@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// すべての通知先を合わせた送信の上限 (notify.max_per_minute)
+// 規則の設定ミスや大量のbackfillで、すべてのチャンネルに一度に通知が流れないようにする
+var sendLimit *sendLimiter
+
+// 直近のwindowの間に送るのをlimit回までにする
+// 超えた送信は呼ばれた順に送れる時刻を予約して待つ
+type sendLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu sync.Mutex
+	// 予約した送信の時刻 (古い順、最大limit件)
+	slots []time.Time
+}
+
+// limitが0なら制限しない (nilを返す)
+func newSendLimiter(limit int, window time.Duration) *sendLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &sendLimiter{limit: limit, window: window}
+}
+
+// 送れる時刻まで待つ
+func (l *sendLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	at := time.Now()
+	if len(l.slots) == l.limit {
+		if next := l.slots[0].Add(l.window); next.After(at) {
+			at = next
+		}
+		l.slots = l.slots[1:]
+	}
+	l.slots = append(l.slots, at)
+	l.mu.Unlock()
+
+	wait := time.Until(at)
+	if wait <= 0 {
+		return nil
+	}
+	log.Printf("notify: %d messages per %s reached, waiting %s", l.limit, l.window, wait.Round(time.Second))
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
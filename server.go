@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIで返す記事
+type articleJSON struct {
+	// POST /articles/{id}/readなどで使う
+	ID     int64  `json:"id"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	Date   string `json:"date"`
+	Read   bool   `json:"read"`
+	Source string `json:"source"`
+}
+
+func toArticleJSON(a article) articleJSON {
+	return articleJSON{ID: a.id, Title: a.title, URL: a.url, Date: a.date, Read: a.read, Source: articleSource(a)}
+}
+
+// HTTPサーバーのハンドラを作成
+func newServer(c *config) (http.Handler, error) {
+	mux := http.NewServeMux()
+	// 公開ページ、Slack、ActivityPubはそれぞれの方法で検証するのでログイン不要
+	auth := newSessionAuth(c.Server.Auth)
+	if auth != nil {
+		auth.register(mux)
+	}
+	// 変更 (既読、公開ページへの掲載、設定ファイルの書き換え、任意のURLの取得) はログインなしでは受け付けない
+	mux.Handle("/articles", requireLoginToChange(auth, http.HandlerFunc(handleArticles)))
+	mux.Handle("/articles/read", requireLoginToChange(auth, http.HandlerFunc(handleMarkRead)))
+	mux.Handle("/articles/public", requireLoginToChange(auth, http.HandlerFunc(handleSetPublic)))
+	mux.Handle("/articles/diff", requireLoginToChange(auth, http.HandlerFunc(handleArticleDiff)))
+	mux.Handle("/articles/", requireLoginToChange(auth, http.HandlerFunc(handleArticle)))
+	mux.Handle("/sources", requireLoginToChange(auth, http.HandlerFunc(handleSources)))
+	mux.Handle("/fetch", requireLoginToChange(auth, http.HandlerFunc(handleFetch)))
+	if c.Server.UI {
+		// ログインなしでは変更できないので操作のボタンも出さない
+		registerUI(mux, auth, c.Server.ReadOnly || auth == nil)
+	}
+	if c.Public.Enabled {
+		mux.HandleFunc("/public", handlePublic(c.Public))
+	}
+	// フィードリーダー向けなのでトークンで認証する
+	if c.UnreadFeed.Token != "" {
+		mux.HandleFunc("/feed.atom", handleUnreadFeed(c.UnreadFeed))
+	}
+	// 同期はトークンで認証する
+	if c.Sync.Token != "" {
+		mux.HandleFunc("/api/sync", handleSync(c.Sync.Token))
+	}
+	if c.Slack.SigningSecret != "" {
+		newSlackApp(c.Slack).register(mux)
+	}
+	if c.ActivityPub.Enabled {
+		actor, err := newAPActor(c.ActivityPub)
+		if err != nil {
+			return nil, err
+		}
+		actor.register(mux)
+	}
+
+	var h http.Handler = mux
+	if c.Server.ReadOnly {
+		h = readOnly(h)
+	}
+	h = csrfProtect(c.Server.Auth.PublicURL, h)
+	return logRequests(securityHeaders(h)), nil
+}
+
+// serve: HTTPサーバーとして起動する
+func cmdServe(ctx context.Context, dests []destination, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	ui := fs.Bool("ui", conf.Server.UI, "serve the web UI for browsing and marking articles at /ui")
+	parseFlags(fs, args)
+	conf.Server.UI = *ui
+	return serve(ctx, conf, dests)
+}
+
+// HTTPサーバーを起動
+// ctxがキャンセルされたら処理中のリクエストを待って終了する
+// schedule.fetch_cronかnotify_cronがあれば、その時刻に取得と通知も行う
+func serve(ctx context.Context, c *config, dests []destination) error {
+	addr := c.Server.Addr
+	if addr == "" {
+		addr = "127.0.0.1:8080"
+	}
+	if !c.Server.Auth.Enabled && !c.Server.ReadOnly && !isLoopbackAddr(addr) {
+		log.Printf("warning: %s is reachable from other hosts without login, anyone can mark articles (set server.auth.enabled or server.read_only)", addr)
+	}
+	h, err := newServer(c)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if c.Schedule.daemon() {
+		// 戻る前に実行中の取得と通知が止まるのを待つ
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			runScheduler(ctx, dests)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+	}
+	log.Printf("listening on %s (read only: %v)", addr, c.Server.ReadOnly)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	log.Print("shutting down")
+	return srv.Shutdown(shutdownCtx)
+}
+
+// このマシンからだけ接続できるアドレスか
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// GET/HEAD以外のリクエストを拒否する
+// ログインとログアウトはデータを変えないので通す
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" || r.URL.Path == "/logout" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "server is in read-only mode")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start))
+	})
+}
+
+// GET /articles?read=false&status=ok&source=example.com&since=2024-01-01&until=2024-12-31&limit=10
+func handleArticles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	f := articleFilter{
+		source:         q.Get("source"),
+		status:         q.Get("status"),
+		since:          q.Get("since"),
+		until:          q.Get("until"),
+		newestFirst:    q.Get("order") == "newest",
+		excludeSources: hiddenSources(r.Context()),
+	}
+	if v := q.Get("read"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid read parameter")
+			return
+		}
+		f.read = &b
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		f.limit = n
+	}
+
+	articles, err := queryArticles(r.Context(), f)
+	if err != nil {
+		log.Printf("query articles: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	res := make([]articleJSON, 0, len(articles))
+	for _, a := range articles {
+		res = append(res, toArticleJSON(a))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// POST /articles/read {"url": "..."}
+func handleMarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		writeError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	if err := acknowledge(r.Context(), req.URL); err != nil {
+		log.Printf("mark as read: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
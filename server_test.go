@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"fetch-blog/store"
+)
+
+func TestServerRefusesChangesWithoutLogin(t *testing.T) {
+	openTestDB(t)
+	conf.Server.UI = true
+	h, err := newServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/articles/read", "/articles/public", "/articles/1/read", "/articles/1/unread", "/sources", "/fetch", "/ui/read", "/ui/snooze"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "server.auth.enabled") {
+			t.Errorf("POST %s: %d %s, want 403 asking for server.auth.enabled", path, rec.Code, rec.Body)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/articles", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /articles: %d, want 200", rec.Code)
+	}
+}
+
+func TestServerHidesPrivateSources(t *testing.T) {
+	openTestDB(t)
+	conf.Server.UI = true
+	conf.Public.PrivateSources = []string{"secret.example"}
+	saveTestArticles(t,
+		store.Article{Title: "Open", URL: "https://open.example/a", Date: "2026-01-01", Source: "open.example"},
+		store.Article{Title: "Secret", URL: "https://secret.example/a", Date: "2026-01-02", Source: "secret.example"},
+		// 名前を付ける前に保存した記事
+		store.Article{Title: "Old secret", URL: "https://secret.example/b", Date: "2026-01-03"},
+	)
+	h, err := newServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	for _, path := range []string{"/articles", "/ui?view=unread"} {
+		rec := get(path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d", path, rec.Code)
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, "Open") || strings.Contains(body, "Secret") || strings.Contains(body, "secret.example") {
+			t.Errorf("GET %s shows private articles: %s", path, body)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO article_revisions (url, content, fetched_at, replaced_at) VALUES ('https://secret.example/a', 'before', '2026-01-02', '2026-01-03')"); err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	if err := db.QueryRow("SELECT rowid FROM articles WHERE url = 'https://secret.example/a'").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/articles/" + strconv.FormatInt(id, 10), "/articles/diff?url=https://secret.example/a"} {
+		if rec := get(path); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: %d, want 404", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// 起動時に動き続けるようにOSのサービスとして登録する
+//
+//	linux:   systemdのユニット (rootならシステム、それ以外はユーザー)
+//	darwin:  launchdのLaunchAgent
+//	windows: タスクスケジューラのタスク (起動時と一定間隔)
+//
+// 既定では一定間隔で取得と通知を1回ずつ実行する。-serveならHTTPサーバーとして常駐させる
+type serviceSpec struct {
+	name string
+	// 実行ファイルと設定ファイル (絶対パス)
+	exe    string
+	config string
+	// 設定ファイルの相対パス (databaseなど) はここから解決する
+	dir      string
+	serve    bool
+	interval time.Duration
+}
+
+// 実行するコマンドライン
+func (s serviceSpec) args() []string {
+	args := []string{s.exe, "-config", s.config}
+	if s.serve {
+		args = append(args, "serve")
+	}
+	return args
+}
+
+// サービスの定義ファイル
+type serviceFile struct {
+	path    string
+	content string
+}
+
+// install-service: OSのサービスとして登録する
+func cmdInstallService(configPath string, args []string) error {
+	fs := flag.NewFlagSet("install-service", flag.ExitOnError)
+	name := fs.String("name", "fetch-blog", "service name")
+	interval := fs.String("interval", "1h", "how often to fetch and notify (e.g. 30m, 1h, 1d)")
+	serveFlag := fs.Bool("serve", false, "run the HTTP server instead of fetching periodically")
+	goos := fs.String("os", runtime.GOOS, "target OS (linux, darwin or windows)")
+	printOnly := fs.Bool("print", false, "print the files and commands without installing")
+	uninstall := fs.Bool("uninstall", false, "stop and remove the service")
+	parseFlags(fs, args)
+
+	d, err := parseDuration(*interval)
+	if err != nil {
+		return fmt.Errorf("invalid -interval: %w", err)
+	}
+	if d < time.Minute {
+		return errors.New("-interval must be at least 1m")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cfg, err := filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+	spec := serviceSpec{name: *name, exe: exe, config: cfg, dir: filepath.Dir(cfg), serve: *serveFlag, interval: d}
+
+	var files []serviceFile
+	var cmds [][]string
+	switch *goos {
+	case "linux":
+		files, cmds, err = systemdService(spec, os.Geteuid() == 0, *uninstall)
+	case "darwin":
+		files, cmds, err = launchdService(spec, *uninstall)
+	case "windows":
+		files, cmds, err = windowsService(spec, *uninstall)
+	default:
+		return fmt.Errorf("install-service: unsupported OS %q", *goos)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *printOnly {
+		for _, f := range files {
+			if *uninstall {
+				fmt.Printf("# remove %s\n", f.path)
+				continue
+			}
+			fmt.Printf("# %s\n%s\n", f.path, f.content)
+		}
+		for _, c := range cmds {
+			fmt.Println(strings.Join(c, " "))
+		}
+		return nil
+	}
+	if !*uninstall {
+		for _, f := range files {
+			if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(f.path, []byte(f.content), 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("wrote %s\n", f.path)
+		}
+	}
+	for _, c := range cmds {
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			// 停止の失敗 (登録されていないなど) は削除を続ける
+			if *uninstall {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", strings.Join(c, " "), err)
+				continue
+			}
+			return fmt.Errorf("%s: %w", strings.Join(c, " "), err)
+		}
+	}
+	if *uninstall {
+		for _, f := range files {
+			if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			fmt.Printf("removed %s\n", f.path)
+		}
+	}
+	return nil
+}
+
+// systemdのサービスとタイマー
+// 定期実行はoneshotのサービスをタイマーで起動し、Persistentで停止中に逃した実行を起動時に行う
+func systemdService(s serviceSpec, system, uninstall bool) ([]serviceFile, [][]string, error) {
+	dir := "/etc/systemd/system"
+	ctl := []string{"systemctl"}
+	wantedBy := "multi-user.target"
+	if !system {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		dir = filepath.Join(home, ".config", "systemd", "user")
+		ctl = append(ctl, "--user")
+		wantedBy = "default.target"
+	}
+	var quoted []string
+	for _, a := range s.args() {
+		quoted = append(quoted, systemdQuote(a))
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=Fetch new blog articles and notify
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+WorkingDirectory=%s
+ExecStart=%s
+`, s.dir, strings.Join(quoted, " "))
+	enable := s.name + ".service"
+	files := []serviceFile{{path: filepath.Join(dir, s.name+".service")}}
+	if s.serve {
+		unit += fmt.Sprintf("Restart=on-failure\nRestartSec=10\n\n[Install]\nWantedBy=%s\n", wantedBy)
+	} else {
+		// 終了コード2 (一部の失敗) はサービスの失敗にしない
+		unit += "Type=oneshot\nSuccessExitStatus=2\n"
+		enable = s.name + ".timer"
+		files = append(files, serviceFile{
+			path: filepath.Join(dir, s.name+".timer"),
+			content: fmt.Sprintf(`[Unit]
+Description=Run %s periodically
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=%d
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, s.name, int(s.interval.Seconds())),
+		})
+	}
+	files[0].content = unit
+
+	if uninstall {
+		return files, [][]string{
+			append(append([]string{}, ctl...), "disable", "--now", enable),
+			append(append([]string{}, ctl...), "daemon-reload"),
+		}, nil
+	}
+	return files, [][]string{
+		append(append([]string{}, ctl...), "daemon-reload"),
+		append(append([]string{}, ctl...), "enable", "--now", enable),
+	}, nil
+}
+
+// systemdの引数のクォート
+func systemdQuote(s string) string {
+	if !strings.ContainsAny(s, " \t\"'\\%$") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$")
+	return `"` + r.Replace(s) + `"`
+}
+
+// launchdのLaunchAgent (ログインしたユーザーで動く)
+func launchdService(s serviceSpec, uninstall bool) ([]serviceFile, [][]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	label := "local." + s.name
+	path := filepath.Join(home, "Library", "LaunchAgents", label+".plist")
+	logPath := filepath.Join(home, "Library", "Logs", s.name+".log")
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	plistKey(&b, "Label", label)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, a := range s.args() {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", html.EscapeString(a))
+	}
+	b.WriteString("\t</array>\n")
+	plistKey(&b, "WorkingDirectory", s.dir)
+	plistKey(&b, "StandardOutPath", logPath)
+	plistKey(&b, "StandardErrorPath", logPath)
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	if s.serve {
+		b.WriteString("\t<key>KeepAlive</key>\n\t<true/>\n")
+	} else {
+		fmt.Fprintf(&b, "\t<key>StartInterval</key>\n\t<integer>%d</integer>\n", int(s.interval.Seconds()))
+	}
+	b.WriteString("</dict>\n</plist>\n")
+
+	files := []serviceFile{{path: path, content: b.String()}}
+	if uninstall {
+		return files, [][]string{{"launchctl", "unload", "-w", path}}, nil
+	}
+	return files, [][]string{{"launchctl", "load", "-w", path}}, nil
+}
+
+func plistKey(b *strings.Builder, key, value string) {
+	fmt.Fprintf(b, "\t<key>%s</key>\n\t<string>%s</string>\n", key, html.EscapeString(value))
+}
+
+// タスクスケジューラのタスク
+// サービスコントロールマネージャーとのやり取りが要らないので、通常の実行ファイルのまま起動時から動かせる
+// 起動時のタスクと定期実行のタスクを分けて登録する
+func windowsService(s serviceSpec, uninstall bool) ([]serviceFile, [][]string, error) {
+	// 設定の相対パスを解決するため、作業ディレクトリを移ってから実行する
+	var quoted []string
+	for _, a := range s.args() {
+		quoted = append(quoted, `"`+a+`"`)
+	}
+	tr := fmt.Sprintf(`cmd /c cd /d "%s" && %s`, s.dir, strings.Join(quoted, " "))
+	// schtasksの/TRは261文字まで
+	if len(tr) > 261 {
+		return nil, nil, fmt.Errorf("install-service: command line is too long for the task scheduler (%d characters)", len(tr))
+	}
+	boot := s.name + " (boot)"
+	if uninstall {
+		cmds := [][]string{{"schtasks", "/Delete", "/TN", boot, "/F"}}
+		if !s.serve {
+			cmds = append(cmds, []string{"schtasks", "/Delete", "/TN", s.name, "/F"})
+		}
+		return nil, cmds, nil
+	}
+	// ログオンしていなくても動くようにSYSTEMで実行する
+	cmds := [][]string{{"schtasks", "/Create", "/F", "/TN", boot, "/SC", "ONSTART", "/RU", "SYSTEM", "/TR", tr}}
+	if !s.serve {
+		minutes := int(s.interval.Minutes())
+		// /SC MINUTEは1439分まで
+		sc, mo := "MINUTE", minutes
+		if minutes > 1439 {
+			if minutes%(24*60) != 0 {
+				return nil, nil, errors.New("install-service: intervals over a day must be whole days on Windows")
+			}
+			sc, mo = "DAILY", minutes/(24*60)
+		}
+		cmds = append(cmds, []string{"schtasks", "/Create", "/F", "/TN", s.name, "/SC", sc, "/MO", fmt.Sprint(mo), "/RU", "SYSTEM", "/TR", tr})
+	}
+	return nil, cmds, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const sessionCookie = "session"
+
+// ログイン後に表示するページ
+const homePath = "/articles"
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>ログイン</title>
+</head>
+<body>
+<h1>{{if .Token}}パスワードの設定{{else}}ログイン{{end}}</h1>
+{{- if .Error}}
+<p><strong>{{.Error}}</strong></p>
+{{- end}}
+{{- if .Token}}
+<form method="post" action="/invite">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+<p><label>パスワード (8文字以上) <input type="password" name="password" required minlength="8" autocomplete="new-password"></label></p>
+<p><button type="submit">設定してログイン</button></p>
+</form>
+{{- else}}
+{{- if .Password}}
+<form method="post" action="/login">
+<input type="hidden" name="next" value="{{.Next}}">
+<input type="hidden" name="csrf_token" value="{{.CSRF}}">
+<p><label>ユーザー名 <input name="user" required autocomplete="username"></label></p>
+<p><label>パスワード <input type="password" name="password" required autocomplete="current-password"></label></p>
+<p><button type="submit">ログイン</button></p>
+</form>
+{{- end}}
+{{- if .OIDC}}
+<p><a href="/oidc/login?next={{.Next}}">外部アカウントでログイン</a></p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// ログイン画面に渡す値
+type loginPage struct {
+	Error string
+	Next  string
+	Token string
+	// 表示するログイン方法
+	Password bool
+	OIDC     bool
+	// 古いセッションのCookieが残っていてもフォームを送れるように
+	CSRF string
+}
+
+// セッションによる認証
+// 認証が無効ならnilで、requireは何もしない
+type sessionAuth struct {
+	cfg  serverAuthConfig
+	oidc *oidcLogin
+}
+
+func newSessionAuth(c serverAuthConfig) *sessionAuth {
+	if !c.Enabled {
+		return nil
+	}
+	return &sessionAuth{cfg: c, oidc: newOIDCLogin(c)}
+}
+
+func (s *sessionAuth) register(mux *http.ServeMux) {
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/logout", s.handleLogout)
+	if !s.cfg.DisablePassword {
+		mux.HandleFunc("/invite", s.handleInvite)
+	}
+	if s.oidc != nil {
+		s.oidc.register(mux, s)
+	}
+}
+
+// ログイン画面の表示内容
+func (s *sessionAuth) page(p loginPage) loginPage {
+	p.Password = !s.cfg.DisablePassword
+	p.OIDC = s.oidc != nil
+	return p
+}
+
+type userKey struct{}
+
+// ログイン中の利用者名 (認証が無効なら空)
+func currentUser(ctx context.Context) string {
+	u, _ := ctx.Value(userKey{}).(string)
+	return u
+}
+
+// ログインしていなければ拒否する
+// ブラウザからのGETはログイン画面へ、それ以外は401を返す
+func (s *sessionAuth) require(next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.sessionUser(r)
+		if err != nil {
+			log.Printf("session: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if user == "" {
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			writeError(w, http.StatusUnauthorized, "login required")
+			return
+		}
+		// APIの利用者が状態を変えるリクエストに付けるトークン
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			w.Header().Set(csrfHeader, csrfToken(c.Value))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// Cookieのセッションが有効なら利用者名を返す
+func (s *sessionAuth) sessionUser(r *http.Request) (string, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", nil
+	}
+	var user string
+	err = db.QueryRowContext(r.Context(), "SELECT user FROM sessions WHERE id_hash = ? AND expires_at > ?",
+		sha256Hex([]byte(c.Value)), time.Now().Unix()).Scan(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return user, err
+}
+
+// セッションを作成してCookieを設定する
+func (s *sessionAuth) startSession(w http.ResponseWriter, r *http.Request, user string) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	expires := now.Add(s.cfg.SessionTTL)
+	_, err = db.ExecContext(r.Context(), "INSERT INTO sessions (id_hash, user, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		sha256Hex([]byte(token)), user, now.UTC().Format(time.RFC3339), expires.Unix())
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, secureCookie(r, &http.Cookie{Name: sessionCookie, Value: token, Expires: expires}))
+	return nil
+}
+
+// GET, POST /login
+func (s *sessionAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderLogin(w, http.StatusOK, s.page(loginPage{Next: r.URL.Query().Get("next"), CSRF: requestCSRF(r)}))
+	case http.MethodPost:
+		if s.cfg.DisablePassword {
+			http.Error(w, "password login is disabled", http.StatusForbidden)
+			return
+		}
+		user, next := r.PostFormValue("user"), r.PostFormValue("next")
+		ok, err := checkPassword(r.Context(), user, r.PostFormValue("password"))
+		if err != nil {
+			log.Printf("login: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			renderLogin(w, http.StatusUnauthorized, s.page(loginPage{Error: "ユーザー名かパスワードが違います", Next: next, CSRF: requestCSRF(r)}))
+			return
+		}
+		if err := s.startSession(w, r, user); err != nil {
+			log.Printf("login: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, safeNext(next), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// POST /logout
+func (s *sessionAuth) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if _, err := db.ExecContext(r.Context(), "DELETE FROM sessions WHERE id_hash = ?", sha256Hex([]byte(c.Value))); err != nil {
+			log.Printf("logout: %v", err)
+		}
+	}
+	http.SetCookie(w, secureCookie(r, &http.Cookie{Name: sessionCookie, MaxAge: -1}))
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// GET, POST /invite?token=...
+// 招待された本人がパスワードを設定してそのままログインする
+func (s *sessionAuth) handleInvite(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "token is required", http.StatusBadRequest)
+			return
+		}
+		renderLogin(w, http.StatusOK, loginPage{Token: token, CSRF: requestCSRF(r)})
+	case http.MethodPost:
+		token := r.PostFormValue("token")
+		user, err := acceptInvite(r.Context(), token, r.PostFormValue("password"))
+		if err != nil {
+			renderLogin(w, http.StatusBadRequest, loginPage{Error: err.Error(), Token: token, CSRF: requestCSRF(r)})
+			return
+		}
+		if err := s.startSession(w, r, user); err != nil {
+			log.Printf("invite: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, homePath, http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// リクエストのセッションに対応するCSRFトークン (セッションがなければ空)
+func requestCSRF(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	return csrfToken(c.Value)
+}
+
+func renderLogin(w http.ResponseWriter, status int, p loginPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := loginTemplate.Execute(w, p); err != nil {
+		log.Printf("render login: %v", err)
+	}
+}
+
+// ログイン後の移動先
+// 他のサイトへ飛ばされないよう同じサイト内のパスだけを許す
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return homePath
+	}
+	return next
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// share: 保存した記事を1件、指定した人か通知先へ送る
+//
+//	share [-note text] -to @user <url|id>   subscriptionsのuserの送り先へ
+//	share [-note text] -to name <url|id>    destinationsのnameの通知先へ
+//
+// idはlist -idsで表示する記事の番号
+func cmdShare(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	to := fs.String("to", "", "@user from subscriptions or a destination name")
+	note := fs.String("note", "", "message sent with the article")
+	parseFlags(fs, args)
+	if *to == "" || fs.NArg() != 1 {
+		return usageErr("usage: share [-note text] -to @user|destination <url|id>")
+	}
+	a, err := lookupArticle(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dests, err := shareDestinations(*to)
+	if err != nil {
+		return err
+	}
+
+	header := "共有された記事"
+	if *note != "" {
+		header += ": " + *note
+	}
+	dg := &digest{groups: []digestGroup{{header: header, articles: []article{a}}}}
+	results := dispatch(ctx, dests, func(ctx context.Context, d destination) error {
+		return d.sendDigest(ctx, dg)
+	})
+	logFailures("share "+a.url, results)
+	if !anyDelivered(results) {
+		return fmt.Errorf("share %s: not delivered to %s", a.url, *to)
+	}
+	detail := *to
+	if *note != "" {
+		detail += " " + *note
+	}
+	if err := recordEventDetail(ctx, db, a.url, eventShared, detail); err != nil {
+		return err
+	}
+	fmt.Printf("shared %s with %s\n", a.url, *to)
+	return nil
+}
+
+// URLか行番号で記事を探す
+func lookupArticle(ctx context.Context, ref string) (article, error) {
+	q := "SELECT title, url, date FROM articles WHERE url = ? ORDER BY rowid DESC LIMIT 1"
+	var arg any = ref
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		q = "SELECT title, url, date FROM articles WHERE rowid = ?"
+		arg = id
+	}
+	var a article
+	err := db.QueryRowContext(ctx, q, arg).Scan(&a.title, &a.url, &a.date)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, fmt.Errorf("article %q: %w", ref, errNotFound)
+	}
+	a.date = dateOnly(a.date)
+	return a, err
+}
+
+// @userなら購読者の送り先、それ以外は名前の一致する通知先
+func shareDestinations(to string) ([]destination, error) {
+	if user, ok := strings.CutPrefix(to, "@"); ok {
+		for _, s := range conf.Subscriptions {
+			if s.User == user {
+				return newDestinations(s.Destinations, conf.Public)
+			}
+		}
+		return nil, fmt.Errorf("no subscription for user %q", user)
+	}
+	for _, c := range conf.Destinations {
+		if c.Name == to {
+			return newDestinations([]destinationConfig{c}, conf.Public)
+		}
+	}
+	return nil, fmt.Errorf("no destination named %q", to)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Slackアプリ (Events API, Interactivity)
+type slackApp struct {
+	cfg slackConfig
+}
+
+func newSlackApp(c slackConfig) *slackApp {
+	return &slackApp{cfg: c}
+}
+
+func (s *slackApp) register(mux *http.ServeMux) {
+	mux.HandleFunc("/slack/events", s.verified(s.handleEvents))
+	mux.HandleFunc("/slack/interactions", s.verified(s.handleInteractions))
+	mux.HandleFunc("/slack/commands", s.verified(s.handleCommand))
+}
+
+// 署名を検証してから本文を渡す
+func (s *slackApp) verified(next func(w http.ResponseWriter, r *http.Request, body []byte)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := verifySlackSignature(s.cfg.SigningSecret, r.Header, body, time.Now()); err != nil {
+			log.Printf("slack: %v", err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, body)
+	}
+}
+
+// Slackのリクエスト署名 (v0) を検証する
+func verifySlackSignature(secret string, h http.Header, body []byte, now time.Time) error {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("missing timestamp")
+	}
+	// リプレイ攻撃を防ぐため5分より古いリクエストは拒否
+	if d := now.Sub(time.Unix(sec, 0)); d > 5*time.Minute || d < -5*time.Minute {
+		return errors.New("stale request")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(h.Get("X-Slack-Signature"))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// POST /slack/events
+func (s *slackApp) handleEvents(w http.ResponseWriter, r *http.Request, body []byte) {
+	var env struct {
+		Type      string          `json:"type"`
+		Challenge string          `json:"challenge"`
+		Event     json.RawMessage `json:"event"`
+	}
+	if err := json.Unmarshal(body, &env); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, env.Challenge)
+		return
+	case "event_callback":
+		var ev struct {
+			Type     string `json:"type"`
+			User     string `json:"user"`
+			Tab      string `json:"tab"`
+			Reaction string `json:"reaction"`
+			Item     struct {
+				Type    string `json:"type"`
+				Channel string `json:"channel"`
+				TS      string `json:"ts"`
+			} `json:"item"`
+		}
+		json.Unmarshal(env.Event, &ev)
+		// Slackは3秒以内の応答を求めるので処理は非同期に行う
+		switch {
+		case ev.Type == "app_home_opened" && ev.Tab == "home":
+			go s.refreshHome(ev.User)
+		case ev.Type == "reaction_added" && ev.Reaction == "white_check_mark" && ev.Item.Type == "message":
+			go markReadByReaction(slackMessageID(ev.Item.Channel, ev.Item.TS))
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /slack/interactions
+func (s *slackApp) handleInteractions(w http.ResponseWriter, r *http.Request, body []byte) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Type string `json:"type"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Actions []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if payload.Type != "block_actions" {
+		return
+	}
+	for _, a := range payload.Actions {
+		action, value, user := a.ActionID, a.Value, payload.User.ID
+		go func() {
+			ctx := context.Background()
+			var err error
+			switch action {
+			case "fetch":
+				err = fetchPhase(ctx, blogs())
+			case "mark_read":
+				err = acknowledge(ctx, value)
+			case "vote":
+				// App Homeは変わらない
+				if err := recordVote(ctx, value, user); err != nil {
+					log.Printf("slack vote: %v", err)
+				}
+				return
+			default:
+				return
+			}
+			if err != nil {
+				log.Printf("slack %s: %v", action, err)
+			}
+			s.refreshHome(user)
+		}()
+	}
+}
+
+// 通知したメッセージに✅が付いたら記事を既読にする
+func markReadByReaction(messageID string) {
+	ctx := context.Background()
+	u, err := lookupMessageRef(ctx, "slack", messageID)
+	if err != nil {
+		// 通知以外のメッセージへのリアクション
+		return
+	}
+	if err := acknowledge(ctx, u); err != nil {
+		log.Printf("slack reaction: %v", err)
+	}
+}
+
+// App Homeタブを最新の状態で表示し直す
+func (s *slackApp) refreshHome(user string) {
+	ctx := context.Background()
+	view, err := homeView(ctx)
+	if err != nil {
+		log.Printf("slack home: %v", err)
+		return
+	}
+	err = slackAPI(ctx, s.cfg.BotToken, "views.publish", map[string]any{
+		"user_id": user,
+		"view":    view,
+	}, nil)
+	if err != nil {
+		log.Printf("slack home: %v", err)
+	}
+}
+
+// App Homeの表示内容
+// 未読の件数 (ブログごと) と未読の記事、操作ボタン
+func homeView(ctx context.Context) (map[string]any, error) {
+	unread, err := queryArticles(ctx, articleFilter{read: boolPtr(false), status: statusOK, awake: true})
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, a := range unread {
+		counts[articleSource(a)]++
+	}
+	sources := make([]string, 0, len(counts))
+	for src := range counts {
+		sources = append(sources, src)
+	}
+	sort.Strings(sources)
+	summary := fmt.Sprintf("*未読 %d件*", len(unread))
+	for _, src := range sources {
+		summary += fmt.Sprintf("\n• %s: %d件", src, counts[src])
+	}
+
+	blocks := []any{
+		map[string]any{"type": "header", "text": plainText("ブログ記事")},
+		map[string]any{"type": "section", "text": mrkdwn(summary)},
+		map[string]any{"type": "actions", "elements": []any{
+			button("今すぐ取得", "fetch", "fetch"),
+		}},
+		map[string]any{"type": "divider"},
+	}
+	const maxItems = 20
+	for i, a := range unread {
+		if i == maxItems {
+			blocks = append(blocks, map[string]any{"type": "context", "elements": []any{
+				mrkdwn(fmt.Sprintf("ほか%d件", len(unread)-maxItems)),
+			}})
+			break
+		}
+		blocks = append(blocks, map[string]any{
+			"type":      "section",
+			"text":      mrkdwn(fmt.Sprintf("<%s|%s>\n%s", a.url, slackEscape(displayTitle(a)), a.date)),
+			"accessory": button("既読にする", "mark_read", a.url),
+		})
+	}
+	return map[string]any{"type": "home", "blocks": blocks}, nil
+}
+
+func plainText(s string) map[string]any {
+	return map[string]any{"type": "plain_text", "text": s}
+}
+
+func mrkdwn(s string) map[string]any {
+	return map[string]any{"type": "mrkdwn", "text": s}
+}
+
+func button(label, actionID, value string) map[string]any {
+	return map[string]any{"type": "button", "text": plainText(label), "action_id": actionID, "value": value}
+}
+
+// mrkdwnで特別な意味を持つ文字をエスケープ
+func slackEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch r {
+		case '&':
+			b.WriteString("&amp;")
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Web APIが返したエラー (codeはmessage_not_foundなど)
+type slackError struct {
+	method, code string
+}
+
+func (e *slackError) Error() string {
+	return fmt.Sprintf("slack %s: %s", e.method, e.code)
+}
+
+func isSlackError(err error, code string) bool {
+	var serr *slackError
+	return errors.As(err, &serr) && serr.code == code
+}
+
+// Slack Web APIを呼び出す
+func slackAPI(ctx context.Context, token, method string, payload, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://slack.com/api/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return fmt.Errorf("slack %s: %w", method, err)
+	}
+	if !res.OK {
+		return &slackError{method: method, code: res.Error}
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// POST /slack/commands
+//
+//	/blogfetch next                 次に読む記事
+//	/blogfetch stats                件数
+//	/blogfetch snooze <url> [3d]    しばらく通知しない (既定は7日)
+func (s *slackApp) handleCommand(w http.ResponseWriter, r *http.Request, body []byte) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	text, err := runSlashCommand(r.Context(), strings.Fields(form.Get("text")))
+	if err != nil {
+		text = "エラー: " + err.Error()
+	}
+	// 実行した本人にだけ見える応答
+	writeJSON(w, http.StatusOK, map[string]string{
+		"response_type": "ephemeral",
+		"text":          text,
+	})
+}
+
+func runSlashCommand(ctx context.Context, args []string) (string, error) {
+	if len(args) == 0 {
+		return "使い方: `/blogfetch next` | `/blogfetch stats` | `/blogfetch snooze <url> [期間]`", nil
+	}
+	switch args[0] {
+	case "next":
+		articles, err := queryArticles(ctx, articleFilter{read: boolPtr(false), status: statusOK, awake: true, limit: 1})
+		if err != nil {
+			return "", err
+		}
+		if len(articles) == 0 {
+			return "未読の記事はありません 🎉", nil
+		}
+		a := articles[0]
+		return fmt.Sprintf("<%s|%s> (%s)", a.url, slackEscape(displayTitle(a)), a.date), nil
+	case "stats":
+		st, err := getOverallStats(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("未読 %d件 / 既読 %d件 / 確認待ち %d件", st.unread, st.read, st.review), nil
+	case "snooze":
+		if len(args) < 2 {
+			return "", errors.New("URLを指定してください")
+		}
+		// SlackはURLを<...>で囲んで送ってくる
+		target := strings.Trim(args[1], "<>")
+		target, _, _ = strings.Cut(target, "|")
+		d := 7 * 24 * time.Hour
+		if len(args) >= 3 {
+			var err error
+			if d, err = parseDuration(args[2]); err != nil {
+				return "", err
+			}
+		}
+		until := time.Now().Add(d)
+		if err := snoozeArticle(ctx, target, until); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s まで通知しません", until.Format("2006-01-02 15:04")), nil
+	default:
+		return "", fmt.Errorf("不明なコマンド %q", args[0])
+	}
+}
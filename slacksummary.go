@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// チャンネルに固定する要約 (未読の件数、多いタグ、次に通知する記事)
+// 最初の実行で投稿してピン留めし、その後は決めた曜日に同じメッセージを書き換える
+// チャンネルを開けばいつでも見られるダッシュボードにする
+type slackSummaryConfig struct {
+	// 要約を置くチャンネル (空なら使わない、slack.bot_tokenが必要)
+	ChannelID string `yaml:"channel_id"`
+	// 更新する曜日 (既定は月曜日)
+	Day string `yaml:"day"`
+	// 載せるタグと記事の件数 (既定は5)
+	Top int `yaml:"top"`
+}
+
+const defaultSummaryTop = 5
+
+func (c slackSummaryConfig) validate(s slackConfig) error {
+	if c.ChannelID == "" {
+		return nil
+	}
+	if s.BotToken == "" {
+		return errors.New("slack.summary: slack.bot_token is required")
+	}
+	if c.Day != "" {
+		if _, ok := weekdayNames[strings.ToLower(c.Day)]; !ok {
+			return fmt.Errorf("slack.summary.day: unknown weekday %q", c.Day)
+		}
+	}
+	if c.Top < 0 {
+		return fmt.Errorf("slack.summary.top: must not be negative (got %d)", c.Top)
+	}
+	return nil
+}
+
+func (c slackSummaryConfig) day() time.Weekday {
+	if d, ok := weekdayNames[strings.ToLower(c.Day)]; ok {
+		return d
+	}
+	return time.Monday
+}
+
+func (c slackSummaryConfig) top() int {
+	if c.Top <= 0 {
+		return defaultSummaryTop
+	}
+	return c.Top
+}
+
+// 要約がまだなければ投稿してピン留めし、決めた曜日なら書き換える
+// 同じ日に何度実行しても更新は1回だけ
+func updateSlackSummary(ctx context.Context, s slackConfig, now time.Time) error {
+	c := s.Summary
+	if c.ChannelID == "" {
+		return nil
+	}
+	local := now.In(sourceLocation())
+	day := local.Format("2006-01-02")
+	var ts, updatedDay string
+	err := db.QueryRowContext(ctx, "SELECT ts, day FROM slack_summary WHERE channel = ?", c.ChannelID).Scan(&ts, &updatedDay)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if ts != "" && (local.Weekday() != c.day() || updatedDay == day) {
+		return nil
+	}
+
+	text, blocks, err := summaryBlocks(ctx, c.top(), local)
+	if err != nil {
+		return err
+	}
+	if ts != "" {
+		err = slackAPI(ctx, s.BotToken, "chat.update", map[string]any{
+			"channel": c.ChannelID,
+			"ts":      ts,
+			"text":    text,
+			"blocks":  blocks,
+		}, nil)
+		// 要約が消されていたら投稿し直す
+		if isSlackError(err, "message_not_found") {
+			ts = ""
+		} else if err != nil {
+			return err
+		}
+	}
+	if ts == "" {
+		var res struct {
+			TS string `json:"ts"`
+		}
+		err := slackAPI(ctx, s.BotToken, "chat.postMessage", map[string]any{
+			"channel": c.ChannelID,
+			"text":    text,
+			"blocks":  blocks,
+		}, &res)
+		if err != nil {
+			return err
+		}
+		ts = res.TS
+		err = slackAPI(ctx, s.BotToken, "pins.add", map[string]any{"channel": c.ChannelID, "timestamp": ts}, nil)
+		if err != nil && !isSlackError(err, "already_pinned") {
+			return err
+		}
+	}
+	_, err = db.ExecContext(ctx, `
+INSERT INTO slack_summary (channel, ts, day, updated_at) VALUES (?, ?, ?, ?)
+ON CONFLICT (channel) DO UPDATE SET ts = excluded.ts, day = excluded.day, updated_at = excluded.updated_at`,
+		c.ChannelID, ts, day, now.UTC().Format(time.RFC3339))
+	return err
+}
+
+// 要約の本文 (通知用の文字列とBlock Kit)
+// タグは未読の記事の分類を多い順に、記事は通知する順に載せる
+func summaryBlocks(ctx context.Context, top int, now time.Time) (string, []any, error) {
+	unread, err := queryArticles(ctx, articleFilter{read: boolPtr(false), status: statusOK, awake: true, dueFirst: true})
+	if err != nil {
+		return "", nil, err
+	}
+	text := fmt.Sprintf("未読 %d件", len(unread))
+	blocks := []any{
+		map[string]any{"type": "header", "text": plainText("ブログ記事の週間まとめ")},
+		map[string]any{"type": "section", "text": mrkdwn("*" + text + "*")},
+	}
+
+	counts := map[string]int{}
+	for _, a := range unread {
+		for _, c := range a.categories {
+			counts[c]++
+		}
+	}
+	tags := make([]string, 0, len(counts))
+	for c := range counts {
+		tags = append(tags, c)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if counts[tags[i]] != counts[tags[j]] {
+			return counts[tags[i]] > counts[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	if len(tags) > top {
+		tags = tags[:top]
+	}
+	if len(tags) > 0 {
+		var b strings.Builder
+		b.WriteString("*多いタグ*")
+		for _, t := range tags {
+			fmt.Fprintf(&b, "\n• %s: %d件", slackEscape(t), counts[t])
+		}
+		blocks = append(blocks, map[string]any{"type": "section", "text": mrkdwn(b.String())})
+	}
+
+	if len(unread) > 0 {
+		lines := []string{"*次に通知する記事*"}
+		for i, a := range unread {
+			if i == top {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("%d. <%s|%s> (%s)", i+1, a.url, slackEscape(displayTitle(a)), a.date))
+		}
+		queue := strings.Join(lines, "\n")
+		if utf8.RuneCountInString(queue) > maxSlackSectionLength {
+			queue = truncateLines(lines, maxSlackSectionLength)
+		}
+		blocks = append(blocks, map[string]any{"type": "section", "text": mrkdwn(queue)})
+	}
+	blocks = append(blocks, map[string]any{"type": "context", "elements": []any{
+		mrkdwn("更新: " + now.Format("2006-01-02 15:04")),
+	}})
+	return text, blocks, nil
+}
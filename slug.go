@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// スラッグの最大のバイト数 (多くのファイルシステムはファイル名が255バイトまで)
+const maxSlugBytes = 120
+
+// Windowsで使えないファイル名
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// タイトルからファイル名に使えるスラッグを作る
+// 文字と数字はどの言語のものも残し、それ以外は-にまとめる
+// transliterateならかなをローマ字にする (漢字はそのまま)
+func slugify(title string, transliterate bool) string {
+	if transliterate {
+		title = kanaToRomaji(title)
+	}
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			if b.Len()+utf8.RuneLen(r) > maxSlugBytes {
+				break
+			}
+			b.WriteRune(r)
+			continue
+		}
+		dash = true
+	}
+	s := b.String()
+	if reservedNames[s] {
+		s += "-"
+	}
+	return s
+}
+
+// 日付とタイトルから他の記事と重ならない保存先のキーを作る
+// タイトルから作れなければURLのハッシュを使う
+func slugArchiveKey(ctx context.Context, articleURL, title, date, ext string, transliterate bool) (string, error) {
+	slug := slugify(title, transliterate)
+	if slug == "" {
+		return sha256Hex([]byte(articleURL))[:32] + ext, nil
+	}
+	base := slug
+	if date != "" {
+		base = dateOnly(date) + "-" + slug
+	}
+	for i := 1; ; i++ {
+		key := base + ext
+		if i > 1 {
+			key = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM archives WHERE key = ?", key).Scan(&n); err != nil {
+			return "", err
+		}
+		if n == 0 {
+			return key, nil
+		}
+	}
+}
+
+// ひらがな (ヘボン式)
+var romaji = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ゎ': "wa",
+}
+
+// 拗音 (きゃ→kya、しゃ→sha)
+var youon = map[rune]string{'ゃ': "a", 'ゅ': "u", 'ょ': "o"}
+
+// ひらがなとカタカナをローマ字にする
+func kanaToRomaji(s string) string {
+	rs := []rune(s)
+	// カタカナはひらがなに寄せる
+	for i, r := range rs {
+		if r >= 'ァ' && r <= 'ヶ' {
+			rs[i] = r - 0x60
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == 'っ':
+			// 次の子音を重ねる
+			if i+1 < len(rs) {
+				if next, ok := romaji[rs[i+1]]; ok && next[0] != 'a' && next[0] != 'i' && next[0] != 'u' && next[0] != 'e' && next[0] != 'o' && next[0] != 'n' {
+					b.WriteByte(next[0])
+				}
+			}
+		case r == 'ー':
+			// 長音は直前の母音を重ねる
+			if out := b.String(); out != "" && strings.ContainsRune("aiueo", rune(out[len(out)-1])) {
+				b.WriteByte(out[len(out)-1])
+			}
+		default:
+			kana, ok := romaji[r]
+			if !ok {
+				b.WriteRune(r)
+				continue
+			}
+			if i+1 < len(rs) {
+				if v, ok := youon[rs[i+1]]; ok && len(kana) >= 2 && strings.HasSuffix(kana, "i") {
+					// し, ち, じは子音だけ、ほかはyを付ける
+					stem := strings.TrimSuffix(kana, "i")
+					if stem != "sh" && stem != "ch" && stem != "j" {
+						stem += "y"
+					}
+					b.WriteString(stem + v)
+					i++
+					continue
+				}
+			}
+			b.WriteString(kana)
+		}
+	}
+	return b.String()
+}
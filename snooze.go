@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 記事を指定の時刻まで通知しない
+func snoozeArticle(ctx context.Context, url string, until time.Time) error {
+	return execOne(ctx, "UPDATE articles SET snoozed_until = ? WHERE url = ?", until.UTC().Format(time.RFC3339), url)
+}
+
+// スヌーズをやめて次の通知から対象に戻す
+func unsnoozeArticle(ctx context.Context, url string) error {
+	return execOne(ctx, "UPDATE articles SET snoozed_until = NULL WHERE url = ?", url)
+}
+
+// "3d"のように日数も使える期間を解釈する
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 通知先が対象外として送らなかった
+var errSkipped = errors.New("skipped")
+
+// SNSへの投稿に共通する処理
+// 投稿済みの記事は送らず、投稿の間隔を空ける
+type socialPoster struct {
+	label       string
+	hashtags    []string
+	publicOnly  bool
+	public      publicConfig
+	minInterval time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newSocialPoster(c destinationConfig, pub publicConfig) *socialPoster {
+	interval := c.MinInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	return &socialPoster{
+		label:       c.Name,
+		hashtags:    c.Hashtags,
+		publicOnly:  c.PublicOnly,
+		public:      pub,
+		minInterval: interval,
+	}
+}
+
+func (p *socialPoster) name() string { return p.label }
+
+// 投稿してよいか判定し、必要なら前回の投稿から間隔が空くまで待つ
+func (p *socialPoster) prepare(ctx context.Context, a article) error {
+	if p.publicOnly && !p.public.allows(a, a.public) {
+		return errSkipped
+	}
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM deliveries WHERE url = ? AND destination = ? AND status = 'ok'",
+		a.url, p.label).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return errSkipped
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if wait := time.Until(p.last.Add(p.minInterval)); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.last = time.Now()
+	return nil
+}
+
+// 投稿本文の末尾に付けるハッシュタグ
+func (p *socialPoster) tags() string {
+	var tags []string
+	for _, t := range p.hashtags {
+		tags = append(tags, "#"+strings.TrimPrefix(t, "#"))
+	}
+	return strings.Join(tags, " ")
+}
+
+// SNSにダイジェストは投稿しない
+func (p *socialPoster) sendDigest(context.Context, *digest) error {
+	return errSkipped
+}
+
+// 投稿の文字数の上限に収まるタイトルとハッシュタグ
+// 本文はタイトル、URL、ハッシュタグを改行でつなげる (urlLenはURLとして数える文字数)
+// 文字はweightで数える (Blueskyは1文字1、XはxWeight)
+// URLとハッシュタグだけで上限に届くならハッシュタグを付けない
+func fitPost(title string, limit, urlLen int, tags string, weight func(rune) int) (string, string) {
+	room := limit - urlLen - weight('\n')
+	if n := weightedLen(tags, weight); tags != "" && room-n-weight('\n') >= 1 {
+		room -= n + weight('\n')
+	} else {
+		tags = ""
+	}
+	return truncateWeighted(title, room, weight), tags
+}
+
+// 1文字を1と数える
+func runeWeight(rune) int { return 1 }
+
+// Xの文字数の数え方 (twitter-textのv3)
+// ラテン文字などは1、それ以外 (日本語や絵文字) は2と数える
+func xWeight(r rune) int {
+	switch {
+	case r <= 0x10ff,
+		0x2000 <= r && r <= 0x200d,
+		0x2010 <= r && r <= 0x201f,
+		0x2032 <= r && r <= 0x2037:
+		return 1
+	}
+	return 2
+}
+
+func weightedLen(s string, weight func(rune) int) int {
+	n := 0
+	for _, r := range s {
+		n += weight(r)
+	}
+	return n
+}
+
+// 文字数の上限に収まるようにタイトルを切り詰める
+func truncateRunes(s string, max int) string {
+	return truncateWeighted(s, max, runeWeight)
+}
+
+// weightで数えた上限に収まるように切り詰め、切ったら末尾に…を付ける
+func truncateWeighted(s string, max int, weight func(rune) int) string {
+	if weightedLen(s, weight) <= max {
+		return s
+	}
+	room := max - weight('…')
+	if room < 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if room < weight(r) {
+			break
+		}
+		room -= weight(r)
+		b.WriteRune(r)
+	}
+	return b.String() + "…"
+}
+
+// Bluesky (AT Protocol)
+type blueskyDestination struct {
+	*socialPoster
+	pds      string
+	handle   string
+	password string
+}
+
+func (b *blueskyDestination) send(ctx context.Context, a article) error {
+	if err := b.prepare(ctx, a); err != nil {
+		return err
+	}
+	// 認証
+	var session struct {
+		AccessJwt string `json:"accessJwt"`
+		DID       string `json:"did"`
+	}
+	err := b.xrpc(ctx, "com.atproto.server.createSession", "", map[string]string{
+		"identifier": b.handle,
+		"password":   b.password,
+	}, &session)
+	if err != nil {
+		return err
+	}
+
+	// 本文とリンク・ハッシュタグの位置 (UTF-8のバイト単位)
+	title, tags := fitPost(displayTitle(a), 300, utf8.RuneCountInString(a.url), b.tags(), runeWeight)
+	text := title + "\n" + a.url
+	facets := []map[string]any{facet(len(title)+1, len(text), map[string]any{
+		"$type": "app.bsky.richtext.facet#link",
+		"uri":   a.url,
+	})}
+	if tags != "" {
+		text += "\n"
+		for i, t := range strings.Fields(tags) {
+			if i > 0 {
+				text += " "
+			}
+			start := len(text)
+			text += t
+			facets = append(facets, facet(start, len(text), map[string]any{
+				"$type": "app.bsky.richtext.facet#tag",
+				"tag":   strings.TrimPrefix(t, "#"),
+			}))
+		}
+	}
+
+	return b.xrpc(ctx, "com.atproto.repo.createRecord", session.AccessJwt, map[string]any{
+		"repo":       session.DID,
+		"collection": "app.bsky.feed.post",
+		"record": map[string]any{
+			"$type":     "app.bsky.feed.post",
+			"text":      text,
+			"facets":    facets,
+			"createdAt": time.Now().UTC().Format(time.RFC3339),
+		},
+	}, nil)
+}
+
+func facet(start, end int, feature map[string]any) map[string]any {
+	return map[string]any{
+		"index":    map[string]int{"byteStart": start, "byteEnd": end},
+		"features": []map[string]any{feature},
+	}
+}
+
+func (b *blueskyDestination) xrpc(ctx context.Context, method, token string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.pds+"/xrpc/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("bluesky %s: status code %d: %s", method, resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// X (旧Twitter) API v2
+type xDestination struct {
+	*socialPoster
+	consumerKey       string
+	consumerSecret    string
+	accessToken       string
+	accessTokenSecret string
+}
+
+const xTweetsURL = "https://api.twitter.com/2/tweets"
+
+func (x *xDestination) send(ctx context.Context, a article) error {
+	if err := x.prepare(ctx, a); err != nil {
+		return err
+	}
+	// URLは文字数に関わらず23文字として数えられる
+	// 日本語は1文字を2と数えるので、文字数だけ見るとAPIに長すぎると断られる
+	title, tags := fitPost(displayTitle(a), 280, 23, x.tags(), xWeight)
+	text := title + "\n" + a.url
+	if tags != "" {
+		text += "\n" + tags
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, xTweetsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", x.oauthHeader(http.MethodPost, xTweetsURL))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("x: status code %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// OAuth 1.0a (HMAC-SHA1) のAuthorizationヘッダ
+// JSONの本文は署名に含めない
+func (x *xDestination) oauthHeader(method, endpoint string) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	params := map[string]string{
+		"oauth_consumer_key":     x.consumerKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_token":            x.accessToken,
+		"oauth_version":          "1.0",
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(params[k], true))
+	}
+	base := method + "&" + uriEncode(endpoint, true) + "&" + uriEncode(strings.Join(pairs, "&"), true)
+	key := uriEncode(x.consumerSecret, true) + "&" + uriEncode(x.accessTokenSecret, true)
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(base))
+	params["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	keys = append(keys, "oauth_signature")
+	sort.Strings(keys)
+	header := make([]string, 0, len(keys))
+	for _, k := range keys {
+		header = append(header, fmt.Sprintf(`%s="%s"`, uriEncode(k, true), uriEncode(params[k], true)))
+	}
+	return "OAuth " + strings.Join(header, ", ")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestXWeight(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"hello", 5},
+		{"café", 4},
+		{"日本語", 6},
+		{"Goの記事", 8},
+		{"“quoted”", 8},
+		{"…", 2},
+		{"😀", 2},
+	}
+	for _, tt := range tests {
+		if got := weightedLen(tt.s, xWeight); got != tt.want {
+			t.Errorf("weightedLen(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestFitPost(t *testing.T) {
+	const xURLLen = 23
+	tests := []struct {
+		name      string
+		title     string
+		limit     int
+		urlLen    int
+		tags      string
+		weight    func(rune) int
+		wantTitle string
+		wantTags  string
+	}{
+		{name: "fits", title: "short", limit: 280, urlLen: xURLLen, tags: "#go", weight: xWeight, wantTitle: "short", wantTags: "#go"},
+		{name: "japanese fits by runes but not by weight", title: strings.Repeat("あ", 200), limit: 280, urlLen: xURLLen, weight: xWeight,
+			wantTitle: strings.Repeat("あ", 127) + "…"},
+		{name: "japanese with tags", title: strings.Repeat("あ", 200), limit: 280, urlLen: xURLLen, tags: "#go", weight: xWeight,
+			wantTitle: strings.Repeat("あ", 125) + "…", wantTags: "#go"},
+		{name: "bluesky counts runes", title: strings.Repeat("あ", 200), limit: 300, urlLen: 30, weight: runeWeight,
+			wantTitle: strings.Repeat("あ", 200)},
+		{name: "no room for tags", title: "t", limit: 30, urlLen: 23, tags: "#golang", weight: xWeight, wantTitle: "t"},
+		{name: "no room at all", title: "title", limit: 20, urlLen: 23, tags: "#go", weight: xWeight},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, tags := fitPost(tt.title, tt.limit, tt.urlLen, tt.tags, tt.weight)
+			if title != tt.wantTitle || tags != tt.wantTags {
+				t.Errorf("fitPost() = %q (%d runes), %q; want %q (%d runes), %q",
+					title, utf8.RuneCountInString(title), tags, tt.wantTitle, utf8.RuneCountInString(tt.wantTitle), tt.wantTags)
+			}
+			total := weightedLen(title, tt.weight) + 1 + tt.urlLen
+			if tags != "" {
+				total += 1 + weightedLen(tags, tt.weight)
+			}
+			if title != "" && total > tt.limit {
+				t.Errorf("post weighs %d, over the limit %d", total, tt.limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 一覧のURLが別のURLへ恒久的に転送されていれば、sources migrateを案内する
+// ブログがドメインを移ると、転送先の記事が新しいURLの別の記事として保存されてしまう
+func warnIfMoved(b blog, resp *http.Response) {
+	final := resp.Request
+	if final == nil || final.Response == nil {
+		return
+	}
+	// 転送をさかのぼり、すべて恒久的な転送 (301, 308) か確かめる
+	for r := final; r.Response != nil; r = r.Response.Request {
+		if code := r.Response.StatusCode; code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+			return
+		}
+	}
+	from, err := url.Parse(b.url)
+	if err != nil || from.Host == final.URL.Host {
+		return
+	}
+	to := final.URL
+	oldBase, newBase := b.url, to.String()
+	// パスが同じならホストごと移ったとみなしてオリジンを案内する
+	if from.Path == to.Path && from.RawQuery == to.RawQuery {
+		oldBase, newBase = from.Scheme+"://"+from.Host, to.Scheme+"://"+to.Host
+	}
+	log.Printf("%s: %s permanently redirects to %s; run \"sources migrate %s %s\" to move the stored articles",
+		b.name(), b.url, to, oldBase, newBase)
+}
+
+// sources: ブログの設定とDBの管理
+//
+//	sources migrate [-dry-run] <old> <new>   ドメインを移ったブログの記事のURLと設定を書き換える
+func cmdSources(ctx context.Context, configPath string, args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return usageErr("usage: sources migrate [-dry-run] <old-url> <new-url>")
+	}
+	fs := flag.NewFlagSet("sources migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	parseFlags(fs, args[1:])
+	if fs.NArg() != 2 {
+		return usageErr("usage: sources migrate [-dry-run] <old-url> <new-url>")
+	}
+	oldBase, newBase := strings.TrimRight(fs.Arg(0), "/"), strings.TrimRight(fs.Arg(1), "/")
+	for _, u := range []string{oldBase, newBase} {
+		if p, err := url.Parse(u); err != nil || p.Host == "" || (p.Scheme != "http" && p.Scheme != "https") {
+			return usageErr(fmt.Sprintf("invalid URL %q", u))
+		}
+	}
+	if oldBase == newBase {
+		return usageErr("the old and new URLs are the same")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	merged, moved, err := moveArticleURLs(ctx, tx, oldBase, newBase)
+	if err != nil {
+		return err
+	}
+	renamed := ""
+	if oldName, newName, ok := movedSourceName(oldBase, newBase); ok {
+		if err := renameSource(ctx, tx, oldName, newName); err != nil {
+			return err
+		}
+		renamed = fmt.Sprintf(", source %s renamed to %s", oldName, newName)
+	}
+	fmt.Printf("%d articles moved, %d merged into articles already stored under the new URL%s\n", moved, merged, renamed)
+	if !*dryRun {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	// DBを書き換えてから設定を書き換える (設定だけ先に変わると古い記事を引き継げない)
+	n, err := rewriteConfigURLs(configPath, oldBase, newBase, *dryRun)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d URLs updated in %s\n", n, configPath)
+	if conf.Source.URL == "" && len(conf.Blogs) == 0 && hasURLPrefix(baseURL, oldBase) {
+		fmt.Println("the list URL comes from url.txt; update it and rebuild")
+	}
+	return nil
+}
+
+// URLがprefixのページか (prefixそのもの、またはその下のパスやクエリ)
+func hasURLPrefix(u, prefix string) bool {
+	rest, ok := strings.CutPrefix(u, prefix)
+	return ok && (rest == "" || strings.ContainsAny(rest[:1], "/?#"))
+}
+
+// ?1のURLの下にあるか (hasURLPrefixと同じ判定)
+const underOldURL = "(url = ?1 OR substr(url, 1, length(?1) + 1) IN (?1 || '/', ?1 || '?', ?1 || '#'))"
+
+// ?1の下のURLを?2の下に移したURL
+const movedURL = "?2 || substr(url, length(?1) + 1)"
+
+// 記事と記事に付随するテーブルのURLを書き換える
+// 新しいURLで保存済みの記事には既読を引き継いで古い方を消す
+func moveArticleURLs(ctx context.Context, tx *sql.Tx, oldBase, newBase string) (merged, moved int64, err error) {
+	// 古いURLの記事 (oldの下に移す前のURL) を既読にしていれば、新しいURLの記事も既読にする
+	if _, err := tx.ExecContext(ctx, `
+UPDATE articles SET read = TRUE, read_at = COALESCE(read_at, (SELECT MIN(o.read_at) FROM articles o
+  WHERE o.read AND o.url = ?1 || substr(articles.url, length(?2) + 1)))
+WHERE NOT read AND EXISTS (SELECT 1 FROM articles o WHERE o.read AND o.url = ?1 || substr(articles.url, length(?2) + 1))
+  AND (url = ?2 OR substr(url, 1, length(?2) + 1) IN (?2 || '/', ?2 || '?', ?2 || '#'))`, oldBase, newBase); err != nil {
+		return 0, 0, err
+	}
+	res, err := tx.ExecContext(ctx, `
+DELETE FROM articles WHERE `+underOldURL+`
+  AND EXISTS (SELECT 1 FROM articles n WHERE n.url = ?2 || substr(articles.url, length(?1) + 1))`, oldBase, newBase)
+	if err != nil {
+		return 0, 0, err
+	}
+	merged, _ = res.RowsAffected()
+	res, err = tx.ExecContext(ctx, "UPDATE articles SET url = "+movedURL+" WHERE "+underOldURL, oldBase, newBase)
+	if err != nil {
+		return 0, 0, err
+	}
+	moved, _ = res.RowsAffected()
+
+	// 配信の記録や分類など、urlの列があるテーブル
+	// 新しいURLの行と重なるもの (分類など) は新しい方を残す
+	tables, err := tablesWithColumn(ctx, tx, "url")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, t := range tables {
+		if t == "articles" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE OR IGNORE "+t+" SET url = "+movedURL+" WHERE "+underOldURL, oldBase, newBase); err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", t, err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+t+" WHERE "+underOldURL, oldBase); err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", t, err)
+		}
+	}
+	return merged, moved, nil
+}
+
+// 名前を付けていないブログの名前 (ホスト名) が変わるなら古い名前と新しい名前
+func movedSourceName(oldBase, newBase string) (string, string, bool) {
+	oldHost, newHost := urlHost(oldBase), urlHost(newBase)
+	if oldHost == newHost {
+		return "", "", false
+	}
+	for _, b := range blogs() {
+		if b.cfg.Name == "" && (hasURLPrefix(b.url, oldBase) || hasURLPrefix(b.url, newBase)) {
+			return oldHost, newHost, true
+		}
+	}
+	return "", "", false
+}
+
+// ブログごとの記録 (sourceの列) を新しい名前に付け替える
+func renameSource(ctx context.Context, tx *sql.Tx, oldName, newName string) error {
+	tables, err := tablesWithColumn(ctx, tx, "source")
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if _, err := tx.ExecContext(ctx, "UPDATE OR IGNORE "+t+" SET source = ? WHERE source = ?", newName, oldName); err != nil {
+			return fmt.Errorf("%s: %w", t, err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+t+" WHERE source = ?", oldName); err != nil {
+			return fmt.Errorf("%s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// 列を持つテーブル
+// 仮想テーブル (検索の索引) はFTS5のないビルドでは読めないので除く
+func tablesWithColumn(ctx context.Context, tx *sql.Tx, column string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT m.name FROM sqlite_master m JOIN pragma_table_info(m.name) p
+WHERE m.type = 'table' AND m.sql NOT LIKE 'CREATE VIRTUAL TABLE%' AND p.name = ? ORDER BY m.name`, column)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+// 設定ファイルのurlとfeed_urlを新しいURLに書き換え、書き換えた数を返す
+// コメントを残すためにYAMLのノードのまま書き換える
+func rewriteConfigURLs(path, oldBase, newBase string, dryRun bool) (int, error) {
+	src, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return 0, err
+	}
+	n := 0
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				k, v := node.Content[i], node.Content[i+1]
+				if (k.Value == "url" || k.Value == "feed_url") && v.Kind == yaml.ScalarNode && hasURLPrefix(v.Value, oldBase) {
+					v.Value = newBase + strings.TrimPrefix(v.Value, oldBase)
+					n++
+				}
+			}
+		}
+		for _, c := range node.Content {
+			walk(c)
+		}
+	}
+	walk(&doc)
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, writeConfigNode(path, &doc)
+}
+
+// 書き換えた設定ファイルを保存する (ファイルの権限は保つ)
+func writeConfigNode(path string, doc *yaml.Node) error {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return os.WriteFile(path, b.Bytes(), mode)
+}
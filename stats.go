@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// stats: 統計を表示
+//
+//	stats                全体の件数
+//	stats source <name>  ブログの投稿頻度
+//	stats fetch          ブログごとの取得の遅さと失敗率
+//	stats compare -from 2024-05-01 -to 2024-05-31 [-baseline 2024-04-01..2024-04-30]
+//	                     2つの期間の記事数、既読率、ブログと分類を比べる
+func cmdStats(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	parseFlags(fs, args)
+
+	switch fs.Arg(0) {
+	case "":
+		return printOverallStats(ctx)
+	case "source":
+		name := fs.Arg(1)
+		if name == "" {
+			name = blogs()[0].name()
+		}
+		return printSourceStats(ctx, name)
+	case "fetch":
+		return printFetchStats(ctx)
+	case "llm":
+		return printLLMStats(ctx)
+	case "errors":
+		return printFetchErrorStats(ctx)
+	case "compare":
+		return cmdStatsCompare(ctx, fs.Args()[1:])
+	default:
+		return usageErr(fmt.Sprintf("unknown stats command %q", fs.Arg(0)))
+	}
+}
+
+// 全体の件数
+type overallStats struct {
+	total, unread, read, review int
+}
+
+func getOverallStats(ctx context.Context) (overallStats, error) {
+	var st overallStats
+	err := db.QueryRowContext(ctx, `
+SELECT COUNT(*), COALESCE(SUM(read = 0 AND status = 'ok'), 0), COALESCE(SUM(read = 1 AND status = 'ok'), 0), COALESCE(SUM(status = 'review'), 0)
+FROM articles`).Scan(&st.total, &st.unread, &st.read, &st.review)
+	return st, err
+}
+
+func printOverallStats(ctx context.Context) error {
+	st, err := getOverallStats(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "articles\t%d\n", st.total)
+	fmt.Fprintf(w, "unread\t%d\n", st.unread)
+	fmt.Fprintf(w, "read\t%d\n", st.read)
+	fmt.Fprintf(w, "needs review\t%d\n", st.review)
+	return w.Flush()
+}
+
+// ブログの投稿頻度
+type cadence struct {
+	dates   []time.Time
+	avgGap  time.Duration
+	next    time.Time
+	weekly  map[string]int
+	monthly map[string]int
+}
+
+// 投稿日から投稿頻度を求める
+func analyzeCadence(dates []time.Time) cadence {
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	c := cadence{dates: dates, weekly: map[string]int{}, monthly: map[string]int{}}
+	for _, d := range dates {
+		y, w := d.ISOWeek()
+		c.weekly[fmt.Sprintf("%04d-W%02d", y, w)]++
+		c.monthly[d.Format("2006-01")]++
+	}
+	if len(dates) >= 2 {
+		c.avgGap = dates[len(dates)-1].Sub(dates[0]) / time.Duration(len(dates)-1)
+		c.next = dates[len(dates)-1].Add(c.avgGap)
+	}
+	return c
+}
+
+// ブログの投稿日を取得
+func sourceDates(ctx context.Context, source string) ([]time.Time, error) {
+	articles, err := queryArticles(ctx, articleFilter{source: source})
+	if err != nil {
+		return nil, err
+	}
+	dates := make([]time.Time, 0, len(articles))
+	for _, a := range articles {
+		t, err := time.Parse("2006-01-02", a.date)
+		if err != nil {
+			continue
+		}
+		dates = append(dates, t)
+	}
+	return dates, nil
+}
+
+func printSourceStats(ctx context.Context, source string) error {
+	dates, err := sourceDates(ctx, source)
+	if err != nil {
+		return err
+	}
+	if len(dates) == 0 {
+		return fmt.Errorf("articles for %s: %w", source, errNotFound)
+	}
+	c := analyzeCadence(dates)
+
+	fmt.Printf("source:        %s\n", source)
+	fmt.Printf("articles:      %d (%s .. %s)\n", len(c.dates),
+		c.dates[0].Format("2006-01-02"), c.dates[len(c.dates)-1].Format("2006-01-02"))
+	if c.avgGap > 0 {
+		fmt.Printf("average gap:   %.1f days\n", c.avgGap.Hours()/24)
+		fmt.Printf("next expected: %s\n", c.next.Format("2006-01-02"))
+	}
+
+	fmt.Println("\nposts per month:")
+	printHistogram(c.monthly)
+	fmt.Println("\nposts per week (last 12 weeks with posts):")
+	keys := sortedKeys(c.weekly)
+	if len(keys) > 12 {
+		keys = keys[len(keys)-12:]
+	}
+	recent := map[string]int{}
+	for _, k := range keys {
+		recent[k] = c.weekly[k]
+	}
+	printHistogram(recent)
+	return nil
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printHistogram(m map[string]int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, k := range sortedKeys(m) {
+		fmt.Fprintf(w, "  %s\t%s %d\n", k, strings.Repeat("█", m[k]), m[k])
+	}
+	w.Flush()
+}
+
+// 期間 (両端の日付を含む)
+type dateRange struct {
+	from, to time.Time
+}
+
+func (r dateRange) String() string {
+	return r.from.Format("2006-01-02") + ".." + r.to.Format("2006-01-02")
+}
+
+// "2024-04-01..2024-04-30" を読む
+func parseDateRange(s string) (dateRange, error) {
+	from, to, ok := strings.Cut(s, "..")
+	if !ok {
+		return dateRange{}, fmt.Errorf("invalid range %q: want YYYY-MM-DD..YYYY-MM-DD", s)
+	}
+	return newDateRange(from, to)
+}
+
+func newDateRange(from, to string) (dateRange, error) {
+	var r dateRange
+	var err error
+	if r.from, err = time.Parse("2006-01-02", from); err != nil {
+		return r, fmt.Errorf("invalid date %q: want YYYY-MM-DD", from)
+	}
+	if r.to, err = time.Parse("2006-01-02", to); err != nil {
+		return r, fmt.Errorf("invalid date %q: want YYYY-MM-DD", to)
+	}
+	if r.to.Before(r.from) {
+		return r, fmt.Errorf("range %s ends before it starts", r)
+	}
+	return r, nil
+}
+
+// 直前の同じ長さの期間
+func (r dateRange) previous() dateRange {
+	days := int(r.to.Sub(r.from).Hours()/24) + 1
+	return dateRange{from: r.from.AddDate(0, 0, -days), to: r.from.AddDate(0, 0, -1)}
+}
+
+// 期間の集計
+type periodStats struct {
+	articles, read int
+	// 期間中に既読にした記事 (公開日に関わらない)
+	readDuring int
+	// ブログごとの記事数と既読数
+	sources, sourcesRead map[string]int
+	categories           map[string]int
+}
+
+func (p periodStats) readRate() float64 {
+	if p.articles == 0 {
+		return 0
+	}
+	return float64(p.read) / float64(p.articles) * 100
+}
+
+// 期間に公開された記事を集計する (確認待ちの記事は除く)
+func getPeriodStats(ctx context.Context, r dateRange) (periodStats, error) {
+	st := periodStats{sources: map[string]int{}, sourcesRead: map[string]int{}, categories: map[string]int{}}
+	articles, err := queryArticles(ctx, articleFilter{
+		status: statusOK,
+		since:  r.from.Format("2006-01-02"),
+		until:  r.to.Format("2006-01-02"),
+	})
+	if err != nil {
+		return st, err
+	}
+	for _, a := range articles {
+		src := articleSource(a)
+		st.articles++
+		st.sources[src]++
+		if a.read {
+			st.read++
+			st.sourcesRead[src]++
+		}
+		for _, c := range a.categories {
+			st.categories[c]++
+		}
+	}
+	// read_atはRFC3339なので翌日の0時より前で比べる
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM articles WHERE read = 1 AND read_at >= ? AND read_at < ?",
+		r.from.Format("2006-01-02"), r.to.AddDate(0, 0, 1).Format("2006-01-02")).Scan(&st.readDuring)
+	return st, err
+}
+
+// stats compare: 2つの期間を比べる
+// ノイズの多いブログを外して読み方が良くなったかを確かめる
+func cmdStatsCompare(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stats compare", flag.ExitOnError)
+	from := fs.String("from", "", "first day of the period (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day of the period (YYYY-MM-DD, defaults to today)")
+	baseline := fs.String("baseline", "", "period to compare with (YYYY-MM-DD..YYYY-MM-DD, defaults to the same length just before -from)")
+	parseFlags(fs, args)
+	if *from == "" {
+		return usageErr("usage: stats compare -from YYYY-MM-DD [-to YYYY-MM-DD] [-baseline YYYY-MM-DD..YYYY-MM-DD]")
+	}
+	if *to == "" {
+		*to = time.Now().Format("2006-01-02")
+	}
+	period, err := newDateRange(*from, *to)
+	if err != nil {
+		return usageErr(err.Error())
+	}
+	base := period.previous()
+	if *baseline != "" {
+		if base, err = parseDateRange(*baseline); err != nil {
+			return usageErr(err.Error())
+		}
+	}
+	b, err := getPeriodStats(ctx, base)
+	if err != nil {
+		return err
+	}
+	p, err := getPeriodStats(ctx, period)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "\tBASELINE %s\tPERIOD %s\tCHANGE\n", base, period)
+	fmt.Fprintf(w, "articles\t%d\t%d\t%s\n", b.articles, p.articles, countChange(b.articles, p.articles))
+	fmt.Fprintf(w, "read\t%d\t%d\t%s\n", b.read, p.read, countChange(b.read, p.read))
+	fmt.Fprintf(w, "read rate\t%.1f%%\t%.1f%%\t%+.1f pt\n", b.readRate(), p.readRate(), p.readRate()-b.readRate())
+	fmt.Fprintf(w, "read during period\t%d\t%d\t%s\n", b.readDuring, p.readDuring, countChange(b.readDuring, p.readDuring))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nsources:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  SOURCE\tBASELINE\tPERIOD\tREAD RATE")
+	for _, src := range unionKeys(b.sources, p.sources) {
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%s -> %s\n", src, b.sources[src], p.sources[src],
+			rateOf(b.sourcesRead[src], b.sources[src]), rateOf(p.sourcesRead[src], p.sources[src]))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(b.categories) == 0 && len(p.categories) == 0 {
+		return nil
+	}
+	fmt.Println("\ntopics:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  CATEGORY\tBASELINE\tPERIOD\tCHANGE")
+	for _, c := range unionKeys(b.categories, p.categories) {
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%s\n", c, b.categories[c], p.categories[c], countChange(b.categories[c], p.categories[c]))
+	}
+	return w.Flush()
+}
+
+// 件数の増減 (+3 (+25%)、基準が0なら差だけ)
+func countChange(before, after int) string {
+	if before == 0 {
+		return fmt.Sprintf("%+d", after-before)
+	}
+	return fmt.Sprintf("%+d (%+.0f%%)", after-before, float64(after-before)/float64(before)*100)
+}
+
+func rateOf(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", float64(n)/float64(total)*100)
+}
+
+// 両方の集計のキーを件数の多い順に (同じなら名前順)
+func unionKeys(a, b map[string]int) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]int{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ni, nj := a[keys[i]]+b[keys[i]], a[keys[j]]+b[keys[j]]
+		if ni != nj {
+			return ni > nj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLiteのスキーマの変更 (migrations/NNNN_name.sql)
+// 番号の順に1度だけ適用し、schema_migrationsに記録する
+// テーブルや列を増やすときは新しいファイルを足す (適用済みのファイルは書き換えない)
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const migrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at DATETIME NOT NULL
+)`
+
+// SQLのあとに同じトランザクションで行う処理 (SQLだけでは書けないもの)
+var migrationHooks = map[int]func(ctx context.Context, tx *sql.Tx) error{
+	1: baseline,
+}
+
+// スキーマの変更
+type Migration struct {
+	Version int
+	Name    string
+	// 適用した日時 (RFC3339、適用していなければ空)
+	AppliedAt string
+
+	sql string
+}
+
+// 埋め込んだマイグレーション (番号の順)
+func migrations() ([]Migration, error) {
+	files, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var ms []Migration
+	for _, f := range files {
+		num, name, ok := strings.Cut(strings.TrimSuffix(f.Name(), ".sql"), "_")
+		v, err := strconv.Atoi(num)
+		if !ok || err != nil || v <= 0 {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_name.sql", f.Name())
+		}
+		b, err := migrationFiles.ReadFile(path.Join("migrations", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, Migration{Version: v, Name: name, sql: string(b)})
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i := 1; i < len(ms); i++ {
+		if ms[i].Version == ms[i-1].Version {
+			return nil, fmt.Errorf("migration %04d: duplicate version", ms[i].Version)
+		}
+	}
+	return ms, nil
+}
+
+// マイグレーションと適用した日時
+func (s *Store) Migrations(ctx context.Context) ([]Migration, error) {
+	return migrationStatus(ctx, s.DB)
+}
+
+// 適用していないマイグレーションを適用し、適用したものを返す
+func (s *Store) Migrate(ctx context.Context) ([]Migration, error) {
+	return migrate(ctx, s.DB)
+}
+
+func migrationStatus(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	ms, err := migrations()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, migrationsTable); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]string{}
+	for rows.Next() {
+		var v int
+		var at string
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range ms {
+		ms[i].AppliedAt = applied[ms[i].Version]
+	}
+	return ms, nil
+}
+
+func migrate(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	ms, err := migrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range ms {
+		if m.AppliedAt != "" {
+			continue
+		}
+		ok, err := apply(ctx, db, &m)
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// 1つのマイグレーションをトランザクションの中で適用する
+// 同時に開いた別のプロセスが先に適用していればfalseを返す
+func apply(ctx context.Context, db *sql.DB, m *Migration) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	// 先に記録して書き込みのロックを取る
+	m.AppliedAt = time.Now().UTC().Format(time.RFC3339)
+	res, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, m.AppliedAt)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return false, err
+	}
+	if hook := migrationHooks[m.Version]; hook != nil {
+		if err := hook(ctx, tx); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// マイグレーションを導入する前のDBに後から追加した列とトリガーを足す
+func baseline(ctx context.Context, tx *sql.Tx) error {
+	if err := addMissingColumns(ctx, tx); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, triggers)
+	return err
+}
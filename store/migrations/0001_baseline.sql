@@ -0,0 +1,200 @@
+-- title, urlでUKになるSQLite３のDBを作成
+-- 以前はOpenのたびに実行していたスキーマで、これより前のDBもそのまま開ける
+CREATE TABLE IF NOT EXISTS articles (
+    title TEXT NOT NULL,
+    url TEXT NOT NULL,
+    date DATE NOT NULL,
+    read BOOLEAN DEFAULT FALSE,
+    UNIQUE (url, title)
+);
+
+CREATE TABLE IF NOT EXISTS deliveries (
+    url TEXT NOT NULL,
+    destination TEXT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    delivered_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS archives (
+    url TEXT NOT NULL UNIQUE,
+    key TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    archived_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS leases (
+    name TEXT PRIMARY KEY,
+    holder TEXT NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ap_followers (
+    actor TEXT PRIMARY KEY,
+    inbox TEXT NOT NULL,
+    followed_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    type TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_url ON events (url, type, created_at);
+
+CREATE TABLE IF NOT EXISTS source_cookies (
+    source TEXT PRIMARY KEY,
+    cookies TEXT NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ap_notes (
+    url TEXT PRIMARY KEY,
+    published_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS message_refs (
+    platform TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (platform, message_id)
+);
+CREATE TABLE IF NOT EXISTS subscription_deliveries (
+    user TEXT NOT NULL,
+    url TEXT NOT NULL,
+    delivered_at DATETIME NOT NULL,
+    PRIMARY KEY (user, url)
+);
+CREATE TABLE IF NOT EXISTS users (
+    name TEXT PRIMARY KEY,
+    password_hash TEXT,
+    created_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS invites (
+    token_hash TEXT PRIMARY KEY,
+    user TEXT NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS fetch_metrics (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT NOT NULL,
+    fetched_at DATETIME NOT NULL,
+    requests INTEGER NOT NULL,
+    errors INTEGER NOT NULL,
+    total_ms INTEGER NOT NULL,
+    max_ms INTEGER NOT NULL,
+    concurrency INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+CREATE TABLE IF NOT EXISTS sessions (
+    id_hash TEXT PRIMARY KEY,
+    user TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS article_categories (
+    url TEXT NOT NULL,
+    category TEXT NOT NULL,
+    PRIMARY KEY (url, category)
+);
+CREATE TABLE IF NOT EXISTS translations (
+    url TEXT NOT NULL,
+    language TEXT NOT NULL,
+    title TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (url, language)
+);
+CREATE TABLE IF NOT EXISTS llm_usage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_started_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    purpose TEXT NOT NULL,
+    model TEXT NOT NULL,
+    prompt_tokens INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS source_failures (
+    source TEXT PRIMARY KEY,
+    consecutive INTEGER NOT NULL,
+    last_error TEXT NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS fetch_errors (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT NOT NULL,
+    run_started_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    kind TEXT NOT NULL,
+    url TEXT NOT NULL,
+    message TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sync_state (
+    remote TEXT PRIMARY KEY,
+    pulled_until TEXT NOT NULL,
+    pushed_until TEXT NOT NULL,
+    synced_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS votes (
+    url TEXT NOT NULL,
+    user TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (url, user)
+);
+CREATE TABLE IF NOT EXISTS reading_club (
+    day DATE PRIMARY KEY,
+    url TEXT NOT NULL,
+    votes INTEGER NOT NULL,
+    picked_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS reminders (
+    url TEXT NOT NULL,
+    sent_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS crawl_frontier (
+    source TEXT NOT NULL,
+    url TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT 'pending',
+    added_at DATETIME NOT NULL,
+    done_at DATETIME,
+    PRIMARY KEY (source, url)
+);
+
+CREATE TABLE IF NOT EXISTS http_cache (
+    source TEXT PRIMARY KEY,
+    url TEXT NOT NULL,
+    etag TEXT NOT NULL DEFAULT '',
+    last_modified TEXT NOT NULL DEFAULT '',
+    selectors TEXT NOT NULL DEFAULT '',
+    updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS notify_retries (
+    url TEXT NOT NULL,
+    destination TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    last_error TEXT NOT NULL DEFAULT '',
+    first_failed_at DATETIME NOT NULL,
+    last_failed_at DATETIME NOT NULL,
+    PRIMARY KEY (url, destination)
+);
+
+CREATE TABLE IF NOT EXISTS warehouse_state (
+    target TEXT PRIMARY KEY,
+    article_rowid INTEGER NOT NULL DEFAULT 0,
+    event_id INTEGER NOT NULL DEFAULT 0,
+    exported_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS slack_summary (
+    channel TEXT PRIMARY KEY,
+    ts TEXT NOT NULL,
+    day TEXT NOT NULL,
+    updated_at DATETIME NOT NULL
+);
@@ -0,0 +1,3 @@
+-- 記事ページから取り出した本文 (content.enabled)
+ALTER TABLE articles ADD COLUMN content TEXT;
+ALTER TABLE articles ADD COLUMN content_at DATETIME;
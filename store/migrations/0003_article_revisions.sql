@@ -0,0 +1,12 @@
+-- 記事の本文が書き換えられる前の版 (content.recheck_days)
+-- 今の本文はarticles.contentにある
+CREATE TABLE IF NOT EXISTS article_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    content TEXT NOT NULL,
+    -- この本文を取り出した日時
+    fetched_at DATETIME NOT NULL,
+    -- 書き換えに気づいた日時
+    replaced_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS article_revisions_url ON article_revisions (url, id);
@@ -0,0 +1,14 @@
+-- 一覧とフィードの取得で返ってきた応答 (fetch.debug_responses)
+-- ブログごとに新しいものからkeep件だけ残す
+CREATE TABLE IF NOT EXISTS fetch_responses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT NOT NULL,
+    url TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    header TEXT NOT NULL,
+    body BLOB NOT NULL,
+    -- max_sizeで切り詰めたか
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    fetched_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS fetch_responses_source ON fetch_responses (source, id);
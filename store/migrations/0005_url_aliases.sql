@@ -0,0 +1,7 @@
+-- AMPやモバイル版の記事URLと、記事ページのrel=canonicalが示す正規のURL (blogs[].canonical)
+-- 一度調べたURLは記事ページを取得し直さない
+CREATE TABLE IF NOT EXISTS url_aliases (
+    url TEXT PRIMARY KEY,
+    canonical TEXT NOT NULL,
+    checked_at DATETIME NOT NULL
+);
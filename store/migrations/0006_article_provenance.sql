@@ -0,0 +1,3 @@
+-- 記事を見つけた経路 (一覧やフィードのURL、ページ番号、セレクタの版、取得の実行) のJSON
+-- 抽出の不具合を調べるときに、どの取得で入った記事かを絞り込む
+ALTER TABLE articles ADD COLUMN provenance TEXT;
@@ -0,0 +1,9 @@
+-- アーカイブしたHTMLが埋め込む画像 (imgのsrcを保存先のキーへの相対パスに書き換える)
+-- ページを削除するときに画像もまとめて削除する
+CREATE TABLE IF NOT EXISTS archive_assets (
+    url TEXT NOT NULL,
+    src TEXT NOT NULL,
+    key TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    PRIMARY KEY (url, key)
+);
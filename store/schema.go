@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// マイグレーションを導入する前に後から追加した列
+// CREATE TABLE IF NOT EXISTSでは既存のテーブルに列が増えないため、最初のマイグレーションで追加する
+// これからの列の追加はmigrationsにファイルを足す
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"articles", "public", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"articles", "read_at", "DATETIME"},
+	{"articles", "paywalled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"articles", "paywall_checked_at", "DATETIME"},
+	{"articles", "status", "TEXT NOT NULL DEFAULT 'ok'"},
+	{"articles", "review_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"articles", "published_at", "DATETIME"},
+	{"articles", "published_checked_at", "DATETIME"},
+	{"articles", "snoozed_until", "DATETIME"},
+	{"articles", "classified_at", "DATETIME"},
+	{"articles", "updated_at", "DATETIME"},
+	{"articles", "source", "TEXT NOT NULL DEFAULT ''"},
+	{"articles", "due_at", "DATETIME"},
+	{"articles", "due_note", "TEXT NOT NULL DEFAULT ''"},
+	{"articles", "due_reminded_at", "DATETIME"},
+	{"articles", "acked_at", "DATETIME"},
+	{"articles", "language", "TEXT NOT NULL DEFAULT ''"},
+	{"articles", "sentence_length", "REAL"},
+	{"articles", "reading_ease", "REAL"},
+	{"articles", "readability_at", "DATETIME"},
+	{"events", "detail", "TEXT NOT NULL DEFAULT ''"},
+	{"deliveries", "idempotency_key", "TEXT"},
+	{"archives", "etag", "TEXT NOT NULL DEFAULT ''"},
+	{"archives", "last_modified", "TEXT NOT NULL DEFAULT ''"},
+	{"archives", "evicted_at", "DATETIME"},
+}
+
+// 列を追加したあとに作るトリガー
+// 同期のために記事の状態が変わった時刻をupdated_atに残す
+// updated_atを指定した更新 (同期での取り込み) はその値を保つ
+// 列を追加する前からある記事は既読にした日時か公開日を使う
+const triggers = `
+UPDATE articles SET updated_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%SZ', COALESCE(read_at, date)), '1970-01-01T00:00:00Z')
+WHERE updated_at IS NULL;
+CREATE TRIGGER IF NOT EXISTS articles_inserted AFTER INSERT ON articles
+WHEN NEW.updated_at IS NULL
+BEGIN
+    UPDATE articles SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE rowid = NEW.rowid;
+END;
+CREATE TRIGGER IF NOT EXISTS articles_updated AFTER UPDATE OF read, read_at, status, published_at ON articles
+WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE articles SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE rowid = NEW.rowid;
+END;
+`
+
+// 足りない列を追加する
+func addMissingColumns(ctx context.Context, tx *sql.Tx) error {
+	for _, c := range addedColumns {
+		var n int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	return nil
+}
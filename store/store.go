@@ -0,0 +1,276 @@
+// Package store は記事を保存するSQLiteのDBを扱う
+//
+// スキーマの変更はOpenでマイグレーションとして適用するので、古いDBもそのまま開ける
+// PostgreSQLやMySQLには対応しない (コマンドのSQLがjson_extractやstrftime、トリガーなどSQLiteのものに頼っている)
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// 記事に起きた出来事の種類
+const (
+	EventAdded = "added"
+	EventRead  = "read"
+)
+
+// 保存する記事
+type Article struct {
+	Title string
+	URL   string
+	// YYYY-MM-DD
+	Date string
+	// ok, review (空ならok)
+	Status       string
+	ReviewReason string
+	// 公開日時 (RFC3339, UTC)。わからなければ空
+	PublishedAt string
+	// 配信元のブログの名前
+	Source string
+	// 記事を見つけた経路 (JSON)。わからなければ空
+	Provenance string
+}
+
+// SaveArticlesで保存した件数
+type SaveResult struct {
+	// 新しく保存した記事
+	Inserted int
+	// 保存済み (同じURLとタイトル) で飛ばした記事
+	Skipped int
+}
+
+// SQLを実行できるもの (*sql.DB, *sql.Tx)
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// 記事を保存するもの
+// DBを使わないテストではblogtest.Storeを使える
+type ArticleStore interface {
+	SaveArticles(ctx context.Context, articles []Article) (SaveResult, error)
+	MarkRead(ctx context.Context, url string) (bool, error)
+}
+
+var _ ArticleStore = (*Store)(nil)
+
+// 記事のDB
+type Store struct {
+	DB *sql.DB
+}
+
+// SQLiteの動作の設定
+// 読み取り専用のルートファイルシステムやメモリの少ないコンテナで動かすために使う
+type Options struct {
+	// WALモードにする (litestreamはWALモードが前提)。JournalModeより優先する
+	WAL bool
+	// delete, truncate, persist, memory, wal, off (空ならDBの設定のまま)
+	// ジャーナルはDBと同じディレクトリに作られるので、書けない場合はmemoryにする
+	JournalMode string
+	// default, file, memory (一時テーブルやソートの作業領域)
+	TempStore string
+	// 一時ファイルを作るディレクトリ (空ならSQLiteの既定、/var/tmpや/tmp)
+	TempDir string
+	// 接続ごとのページキャッシュの大きさ (KiB、0ならSQLiteの既定の約2MiB)
+	CacheSizeKiB int
+	// スキーマの変更を自動で適用しない (Migrateで適用する)
+	ManualMigrations bool
+}
+
+// 設定の値を確かめる
+func (o Options) Validate() error {
+	_, err := o.pragmas()
+	return err
+}
+
+// 接続ごとに実行するPRAGMA
+func (o Options) pragmas() ([]string, error) {
+	pragmas := []string{"PRAGMA busy_timeout = 5000"}
+	mode := strings.ToLower(o.JournalMode)
+	if o.WAL {
+		mode = "wal"
+	}
+	switch mode {
+	case "":
+	case "delete", "truncate", "persist", "memory", "wal", "off":
+		pragmas = append(pragmas, "PRAGMA journal_mode = "+mode)
+	default:
+		return nil, fmt.Errorf("unknown journal mode %q", o.JournalMode)
+	}
+	switch ts := strings.ToLower(o.TempStore); ts {
+	case "":
+	case "default", "file", "memory":
+		pragmas = append(pragmas, "PRAGMA temp_store = "+ts)
+	default:
+		return nil, fmt.Errorf("unknown temp store %q", o.TempStore)
+	}
+	if o.CacheSizeKiB < 0 {
+		return nil, fmt.Errorf("invalid cache size %d", o.CacheSizeKiB)
+	}
+	if o.CacheSizeKiB > 0 {
+		// 負の値はKiB単位
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = -%d", o.CacheSizeKiB))
+	}
+	return pragmas, nil
+}
+
+var (
+	driverMu sync.Mutex
+	drivers  int
+)
+
+// 接続するたびにPRAGMAを実行するドライバを登録して名前を返す
+// database/sqlは接続を作り直すので、1度だけ実行しても新しい接続には効かない
+func registerDriver(pragmas []string) string {
+	driverMu.Lock()
+	defer driverMu.Unlock()
+	drivers++
+	name := fmt.Sprintf("sqlite3_store_%d", drivers)
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, p := range pragmas {
+				if _, err := conn.Exec(p, nil); err != nil {
+					return fmt.Errorf("%s: %w", p, err)
+				}
+			}
+			return nil
+		},
+	})
+	return name
+}
+
+// SQLiteのDBを開き、スキーマの変更 (migrations) を適用する
+func Open(path string, opts Options) (*Store, error) {
+	pragmas, err := opts.pragmas()
+	if err != nil {
+		return nil, err
+	}
+	if opts.TempDir != "" {
+		// SQLiteは一時ファイルの場所をこの環境変数から決める
+		if err := os.MkdirAll(opts.TempDir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.Setenv("SQLITE_TMPDIR", opts.TempDir); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open(registerDriver(pragmas), path)
+	if err != nil {
+		return nil, err
+	}
+	// 書き込めないなどの問題は最初の接続で明らかにする
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	if !opts.ManualMigrations {
+		if _, err := migrate(context.Background(), db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Store{DB: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.DB.Close()
+}
+
+// 記事を保存し、新しく保存した件数と飛ばした件数を返す
+// 保存済みの記事 (同じURLとタイトル) はON CONFLICT DO NOTHINGで飛ばす
+func (s *Store) SaveArticles(ctx context.Context, articles []Article) (SaveResult, error) {
+	var res SaveResult
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO articles (title, url, date, status, review_reason, published_at, source, provenance, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (url, title) DO NOTHING`)
+	if err != nil {
+		return res, err
+	}
+	defer stmt.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, a := range articles {
+		status := a.Status
+		if status == "" {
+			status = "ok"
+		}
+		var published any
+		if a.PublishedAt != "" {
+			published = a.PublishedAt
+		}
+		var provenance any
+		if a.Provenance != "" {
+			provenance = a.Provenance
+		}
+		r, err := stmt.ExecContext(ctx, a.Title, a.URL, a.Date, status, a.ReviewReason, published, a.Source, provenance, now)
+		if err != nil {
+			return SaveResult{}, err
+		}
+		n, err := r.RowsAffected()
+		if err != nil {
+			return SaveResult{}, err
+		}
+		if n == 0 {
+			res.Skipped++
+			continue
+		}
+		if err := RecordEvent(ctx, tx, a.URL, EventAdded, ""); err != nil {
+			return SaveResult{}, err
+		}
+		res.Inserted++
+	}
+	if err := tx.Commit(); err != nil {
+		return SaveResult{}, err
+	}
+	return res, nil
+}
+
+// 記事を既読にする
+// 未読から既読になったときだけtrueを返し、出来事を記録する
+func (s *Store) MarkRead(ctx context.Context, url string) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	ok, err := MarkRead(ctx, tx, url)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// 呼び出し側のトランザクションの中で記事を既読にする
+func MarkRead(ctx context.Context, ex Execer, url string) (bool, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := ex.ExecContext(ctx, "UPDATE articles SET read = TRUE, read_at = ?, updated_at = ? WHERE url = ? AND read = FALSE", now, now, url)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return false, nil
+	}
+	if err := RecordEvent(ctx, ex, url, EventRead, ""); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 記事の出来事を記録する
+// 記事の更新と同じトランザクションで呼ぶ
+func RecordEvent(ctx context.Context, ex Execer, url, typ, detail string) error {
+	_, err := ex.ExecContext(ctx, "INSERT INTO events (url, type, created_at, detail) VALUES (?, ?, ?, ?)",
+		url, typ, time.Now().UTC().Format(time.RFC3339), detail)
+	return err
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "blog.db"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSaveArticles(t *testing.T) {
+	a := Article{Title: "A", URL: "https://example.com/a", Date: "2026-01-01"}
+	retitled := a
+	retitled.Title = "A (updated)"
+	tests := []struct {
+		name  string
+		saved []Article
+		save  []Article
+		want  SaveResult
+	}{
+		{"new", nil, []Article{a}, SaveResult{Inserted: 1}},
+		{"same url and title", []Article{a}, []Article{a}, SaveResult{Skipped: 1}},
+		{"duplicate in one batch", nil, []Article{a, a}, SaveResult{Inserted: 1, Skipped: 1}},
+		// タイトルが変われば別の行として保存する
+		{"new title", []Article{a}, []Article{retitled}, SaveResult{Inserted: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := openTestStore(t)
+			if _, err := s.SaveArticles(ctx, tt.saved); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.SaveArticles(ctx, tt.save)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("SaveArticles = %+v, want %+v", got, tt.want)
+			}
+			// 保存した記事ごとにaddedを1件記録する
+			var events int
+			if err := s.DB.QueryRow("SELECT COUNT(*) FROM events WHERE type = ?", EventAdded).Scan(&events); err != nil {
+				t.Fatal(err)
+			}
+			var rows int
+			if err := s.DB.QueryRow("SELECT COUNT(*) FROM articles").Scan(&rows); err != nil {
+				t.Fatal(err)
+			}
+			if events != rows {
+				t.Errorf("%d added events for %d articles", events, rows)
+			}
+		})
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	const url = "https://example.com/a"
+	if _, err := s.SaveArticles(ctx, []Article{{Title: "A", URL: url, Date: "2026-01-01"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		ok, err := s.MarkRead(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("call %d: MarkRead = %v, want %v", i+1, ok, want)
+		}
+	}
+	if ok, err := s.MarkRead(ctx, "https://example.com/missing"); err != nil || ok {
+		t.Errorf("MarkRead(missing) = %v, %v", ok, err)
+	}
+	var events int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM events WHERE url = ? AND type = ?", url, EventRead).Scan(&events); err != nil {
+		t.Fatal(err)
+	}
+	if events != 1 {
+		t.Errorf("%d read events, want 1", events)
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	// Openで適用済みなので、もう一度適用しても何もしない
+	s := openTestStore(t)
+	ms, err := s.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 0 {
+		t.Errorf("Migrate applied %d migrations again", len(ms))
+	}
+	all, err := migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := s.Migrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != len(all) {
+		t.Fatalf("%d migrations in the status, want %d", len(status), len(all))
+	}
+	for _, m := range status {
+		if m.AppliedAt == "" {
+			t.Errorf("migration %d %s is not applied after Open", m.Version, m.Name)
+		}
+	}
+}
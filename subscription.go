@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// 利用者ごとのダイジェスト購読
+// 既読状態は共有のまま、利用者ごとに送った記事を記録して同じ記事を二度送らない
+type subscriptionConfig struct {
+	User string `yaml:"user"`
+	// 購読するブログ (空なら全て)
+	Sources []string `yaml:"sources"`
+	// この時間帯は送らない (例: "22:00-07:00")
+	QuietHours string `yaml:"quiet_hours"`
+	// quiet_hoursのタイムゾーン (既定はsource.timezone)
+	Timezone string `yaml:"timezone"`
+	// 1回に送る最大件数 (既定は10)
+	Limit int `yaml:"limit"`
+	// oldest, newest, source, date, category, score
+	Order        string              `yaml:"order"`
+	Destinations []destinationConfig `yaml:"destinations"`
+}
+
+// 設定を検証し既定値を埋める
+func (s *subscriptionConfig) normalize() error {
+	if s.User == "" {
+		return fmt.Errorf("user is required")
+	}
+	if len(s.Destinations) == 0 {
+		return fmt.Errorf("%s: destinations are required", s.User)
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("%s: timezone: %w", s.User, err)
+		}
+	}
+	if s.QuietHours != "" {
+		if _, _, err := parseQuietHours(s.QuietHours); err != nil {
+			return fmt.Errorf("%s: quiet_hours: %w", s.User, err)
+		}
+	}
+	if s.Limit <= 0 {
+		s.Limit = 10
+	}
+	for i := range s.Destinations {
+		d := &s.Destinations[i]
+		if d.Name == "" {
+			d.Name = d.Type
+		}
+	}
+	return nil
+}
+
+// "HH:MM-HH:MM" を0時からの分に変換する
+func parseQuietHours(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("want HH:MM-HH:MM, got %q", s)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return 0, 0, err
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// 送らない時間帯かどうか
+// 開始が終了より遅ければ日をまたぐ (22:00-07:00)
+func (s *subscriptionConfig) quiet(now time.Time) bool {
+	if s.QuietHours == "" {
+		return false
+	}
+	start, end, err := parseQuietHours(s.QuietHours)
+	if err != nil {
+		return false
+	}
+	loc := sourceLocation()
+	if s.Timezone != "" {
+		if l, err := time.LoadLocation(s.Timezone); err == nil {
+			loc = l
+		}
+	}
+	t := now.In(loc)
+	m := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return start <= m && m < end
+	}
+	return m >= start || m < end
+}
+
+// 購読しているブログの記事かどうか
+func (s *subscriptionConfig) wants(a article) bool {
+	if len(s.Sources) == 0 {
+		return true
+	}
+	src := articleSource(a)
+	for _, name := range s.Sources {
+		if name == src {
+			return true
+		}
+	}
+	return false
+}
+
+// 購読者ごとにまだ送っていない記事をダイジェストで送る
+// 1人の送信に失敗しても他の購読者には送る
+func notifySubscribers(ctx context.Context, subs []subscriptionConfig) error {
+	now := time.Now()
+	for i := range subs {
+		s := &subs[i]
+		if s.quiet(now) {
+			continue
+		}
+		if err := notifySubscriber(ctx, s); err != nil {
+			log.Printf("subscription %s: %v", s.User, err)
+		}
+	}
+	return nil
+}
+
+func notifySubscriber(ctx context.Context, s *subscriptionConfig) error {
+	dests, err := newDestinations(s.Destinations, conf.Public)
+	if err != nil {
+		return err
+	}
+	candidates, err := queryArticles(ctx, articleFilter{status: statusOK, awake: true, notSentTo: s.User})
+	if err != nil {
+		return err
+	}
+	var targets []article
+	for _, a := range candidates {
+		if len(targets) == s.Limit {
+			break
+		}
+		if s.wants(a) {
+			targets = append(targets, a)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	dg, err := buildDigest(translateTitles(ctx, targets), s.Order)
+	if err != nil {
+		return err
+	}
+	results := dispatch(ctx, dests, func(ctx context.Context, d destination) error {
+		return d.sendDigest(ctx, dg)
+	})
+	logFailures("digest for "+s.User, results)
+	// 購読者へ送っても共有の既読状態は変えない
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, a := range dg.articles() {
+		// 記事を載せた通知先のどれにも届かなければ次の実行でもう一度送る
+		if !anyDelivered(digestResults(dests, results, a)) {
+			continue
+		}
+		_, err := db.ExecContext(ctx, "INSERT OR IGNORE INTO subscription_deliveries (user, url, delivered_at) VALUES (?, ?, ?)", s.User, a.url, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
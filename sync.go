@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// インスタンス間の同期
+//
+//	GET  /api/sync?since=RFC3339  sinceの時刻以降に変わった記事を返す
+//	POST /api/sync                 受け取った記事を取り込む
+//
+// どちらもAuthorization: Bearer <sync.token>が必要
+// 同じ記事はupdated_atが新しい方を採る (同時刻なら既読を優先する)
+type syncConfig struct {
+	// serveで受け付けるとき、syncで接続するときのトークン
+	Token string `yaml:"token"`
+	// syncで接続するインスタンスのURL (例: https://blog.example.com)
+	Remote string `yaml:"remote"`
+	// syncを繰り返す間隔 (0なら1回だけ)
+	Interval time.Duration `yaml:"interval"`
+}
+
+// 同期する記事の状態
+type syncArticle struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Date        string `json:"date"`
+	PublishedAt string `json:"published_at,omitempty"`
+	Read        bool   `json:"read"`
+	ReadAt      string `json:"read_at,omitempty"`
+	Status      string `json:"status"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type syncBatch struct {
+	Articles []syncArticle `json:"articles"`
+}
+
+// since以降 (sinceを含む) に変わった記事
+// 同じ時刻の変更を取りこぼさないように含める (重複して適用しても結果は同じ)
+func changedArticles(ctx context.Context, since string) ([]syncArticle, error) {
+	rows, err := db.QueryContext(ctx, `
+SELECT url, MAX(title), substr(MAX(date), 1, 10), COALESCE(MAX(published_at), ''), MAX(read), COALESCE(MAX(read_at), ''),
+       MAX(status), COALESCE(MAX(updated_at), '')
+FROM articles
+WHERE COALESCE(updated_at, '') >= ?
+GROUP BY url
+ORDER BY 8`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []syncArticle
+	for rows.Next() {
+		var a syncArticle
+		if err := rows.Scan(&a.URL, &a.Title, &a.Date, &a.PublishedAt, &a.Read, &a.ReadAt, &a.Status, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
+
+// 受け取った記事を取り込み、変わった件数を返す
+func applySyncArticles(ctx context.Context, articles []syncArticle) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	changed := 0
+	for _, a := range articles {
+		if a.URL == "" || a.UpdatedAt == "" {
+			continue
+		}
+		if a.Status == "" {
+			a.Status = statusOK
+		}
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM articles WHERE url = ?", a.URL).Scan(&n); err != nil {
+			return 0, err
+		}
+		var res sql.Result
+		if n == 0 {
+			res, err = tx.ExecContext(ctx, `
+INSERT INTO articles (title, url, date, published_at, read, read_at, status, updated_at)
+VALUES (?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), ?, ?)`,
+				a.Title, a.URL, a.Date, a.PublishedAt, a.Read, a.ReadAt, a.Status, a.UpdatedAt)
+			if err == nil {
+				err = recordEvent(ctx, tx, a.URL, eventAdded)
+			}
+		} else {
+			res, err = tx.ExecContext(ctx, `
+UPDATE articles SET read = ?, read_at = NULLIF(?, ''), status = ?, published_at = COALESCE(NULLIF(?, ''), published_at), updated_at = ?
+WHERE url = ? AND (COALESCE(updated_at, '') < ? OR (COALESCE(updated_at, '') = ? AND ? AND NOT read))`,
+				a.Read, a.ReadAt, a.Status, a.PublishedAt, a.UpdatedAt, a.URL, a.UpdatedAt, a.UpdatedAt, a.Read)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if m, err := res.RowsAffected(); err == nil && m > 0 {
+			changed++
+		}
+	}
+	return changed, tx.Commit()
+}
+
+// 同期の要求を受け付ける
+func handleSync(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid sync token")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			articles, err := changedArticles(r.Context(), r.URL.Query().Get("since"))
+			if err != nil {
+				log.Printf("sync: %v", err)
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			writeJSON(w, http.StatusOK, syncBatch{Articles: articles})
+		case http.MethodPost:
+			var batch syncBatch
+			if err := json.NewDecoder(io.LimitReader(r.Body, 32<<20)).Decode(&batch); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid body")
+				return
+			}
+			n, err := applySyncArticles(r.Context(), batch.Articles)
+			if err != nil {
+				log.Printf("sync: %v", err)
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]int{"applied": n})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// sync: 相手のインスタンスと変更をやり取りする
+func cmdSync(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	interval := fs.Duration("interval", conf.Sync.Interval, "repeat at this interval (0 to sync once)")
+	parseFlags(fs, args)
+	c := conf.Sync
+	if c.Remote == "" || c.Token == "" {
+		return errors.New("sync.remote and sync.token are required")
+	}
+	for {
+		if err := syncOnce(ctx, c); err != nil {
+			if *interval == 0 {
+				return err
+			}
+			// 繰り返すときは次の回でやり直す
+			log.Printf("sync: %v", err)
+		}
+		if *interval == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 取り込んでから送る
+func syncOnce(ctx context.Context, c syncConfig) error {
+	var pulledUntil, pushedUntil string
+	err := db.QueryRowContext(ctx, "SELECT pulled_until, pushed_until FROM sync_state WHERE remote = ?", c.Remote).Scan(&pulledUntil, &pushedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var pulled syncBatch
+	if err := syncRequest(ctx, c, http.MethodGet, "?since="+url.QueryEscape(pulledUntil), nil, &pulled); err != nil {
+		return err
+	}
+	applied, err := applySyncArticles(ctx, pulled.Articles)
+	if err != nil {
+		return err
+	}
+	for _, a := range pulled.Articles {
+		if a.UpdatedAt > pulledUntil {
+			pulledUntil = a.UpdatedAt
+		}
+	}
+
+	local, err := changedArticles(ctx, pushedUntil)
+	if err != nil {
+		return err
+	}
+	var pushed struct {
+		Applied int `json:"applied"`
+	}
+	if len(local) > 0 {
+		if err := syncRequest(ctx, c, http.MethodPost, "", syncBatch{Articles: local}, &pushed); err != nil {
+			return err
+		}
+		pushedUntil = local[len(local)-1].UpdatedAt
+	}
+
+	_, err = db.ExecContext(ctx, `
+INSERT INTO sync_state (remote, pulled_until, pushed_until, synced_at) VALUES (?, ?, ?, ?)
+ON CONFLICT (remote) DO UPDATE SET pulled_until = excluded.pulled_until, pushed_until = excluded.pushed_until, synced_at = excluded.synced_at`,
+		c.Remote, pulledUntil, pushedUntil, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	log.Printf("sync %s: pulled %d (%d applied), pushed %d (%d applied)", c.Remote, len(pulled.Articles), applied, len(local), pushed.Applied)
+	return nil
+}
+
+func syncRequest(ctx context.Context, c syncConfig, method, query string, payload, out any) error {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.Remote, "/")+"/api/sync"+query, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sync %s: status code %d: %s", method, resp.StatusCode, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
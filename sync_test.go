@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"fetch-blog/store"
+)
+
+func TestApplySyncArticles(t *testing.T) {
+	const (
+		url     = "https://example.com/a"
+		earlier = "2026-01-01T09:00:00Z"
+		local   = "2026-01-01T10:00:00Z"
+		later   = "2026-01-01T11:00:00Z"
+	)
+	tests := []struct {
+		name      string
+		localRead bool
+		in        syncArticle
+		wantRead  bool
+		changed   int
+	}{
+		{name: "newer wins", in: syncArticle{URL: url, Read: true, UpdatedAt: later}, wantRead: true, changed: 1},
+		{name: "older is ignored", in: syncArticle{URL: url, Read: true, UpdatedAt: earlier}, changed: 0},
+		{name: "newer unread wins", localRead: true, in: syncArticle{URL: url, UpdatedAt: later}, changed: 1},
+		// 同時刻なら既読を優先する
+		{name: "same time read wins", in: syncArticle{URL: url, Read: true, UpdatedAt: local}, wantRead: true, changed: 1},
+		{name: "same time unread loses", localRead: true, in: syncArticle{URL: url, UpdatedAt: local}, wantRead: true, changed: 0},
+		{name: "no time is ignored", in: syncArticle{URL: url, Read: true}, changed: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			saveTestArticles(t, store.Article{Title: "A", URL: url, Date: "2026-01-01"})
+			if _, err := db.Exec("UPDATE articles SET read = ?, updated_at = ? WHERE url = ?", tt.localRead, local, url); err != nil {
+				t.Fatal(err)
+			}
+			n, err := applySyncArticles(context.Background(), []syncArticle{tt.in})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.changed {
+				t.Errorf("changed = %d, want %d", n, tt.changed)
+			}
+			if got := isRead(t, url); got != tt.wantRead {
+				t.Errorf("read = %v, want %v", got, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestApplySyncArticlesInsertsAndRoundTrips(t *testing.T) {
+	openTestDB(t)
+	in := syncArticle{URL: "https://example.com/new", Title: "New", Date: "2026-01-02", Read: true, ReadAt: "2026-01-02T08:00:00Z", UpdatedAt: "2026-01-02T08:00:00Z"}
+	n, err := applySyncArticles(context.Background(), []syncArticle{in})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("changed = %d, want 1", n)
+	}
+	// 同じものを2回適用しても変わらない
+	if n, err := applySyncArticles(context.Background(), []syncArticle{in}); err != nil || n != 0 {
+		t.Fatalf("second apply changed %d, %v", n, err)
+	}
+	out, err := changedArticles(context.Background(), in.UpdatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Status = statusOK
+	if len(out) != 1 || out[0] != in {
+		t.Errorf("changedArticles = %+v, want [%+v]", out, in)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 通知するタイトルの翻訳
+// llmの設定を使う
+type translationConfig struct {
+	// 翻訳先の言語 (例: "ja", "English")。空なら翻訳しない
+	Language string `yaml:"language"`
+}
+
+// 通知する記事のタイトルを翻訳する
+// 翻訳はtranslationsに保存し、再通知やダイジェストではAPIを呼ばない
+// 翻訳できなかった記事は元のタイトルのまま
+func translateTitles(ctx context.Context, articles []article) []article {
+	lang := conf.Translation.Language
+	llm := newLLMClient(conf.LLM)
+	if lang == "" || llm == nil {
+		return articles
+	}
+	res := make([]article, len(articles))
+	for i, a := range articles {
+		t, err := translateTitle(ctx, llm, a, lang)
+		if err != nil {
+			log.Printf("translate %s: %v", a.url, err)
+		}
+		a.translatedTitle = t
+		res[i] = a
+	}
+	return res
+}
+
+func translateTitle(ctx context.Context, llm *llmClient, a article, lang string) (string, error) {
+	var t string
+	err := db.QueryRowContext(ctx, "SELECT title FROM translations WHERE url = ? AND language = ?", a.url, lang).Scan(&t)
+	if err == nil {
+		return t, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	t, _, err = llm.complete(ctx, "translate",
+		fmt.Sprintf("Translate the blog article title into %s. Reply with the translated title only.", lang),
+		a.title)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.ExecContext(ctx, "INSERT OR REPLACE INTO translations (url, language, title, created_at) VALUES (?, ?, ?, ?)",
+		a.url, lang, t, time.Now().UTC().Format(time.RFC3339))
+	return t, err
+}
+
+// translations purge: 保存した翻訳を消す
+// 翻訳先や翻訳のモデルを変えたときに使う
+func cmdTranslations(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "purge" {
+		return usageErr("usage: translations purge [-language lang] [-older-than duration]")
+	}
+	fs := flag.NewFlagSet("translations purge", flag.ExitOnError)
+	lang := fs.String("language", "", "only remove translations into this language")
+	olderThan := fs.String("older-than", "", "only remove translations made before this long ago, e.g. 30d")
+	parseFlags(fs, args[1:])
+	q := "DELETE FROM translations WHERE 1 = 1"
+	var qargs []any
+	if *lang != "" {
+		q += " AND language = ?"
+		qargs = append(qargs, *lang)
+	}
+	if *olderThan != "" {
+		d, err := parseDuration(*olderThan)
+		if err != nil {
+			return err
+		}
+		q += " AND created_at < ?"
+		qargs = append(qargs, time.Now().Add(-d).UTC().Format(time.RFC3339))
+	}
+	res, err := db.ExecContext(ctx, q, qargs...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d translations\n", n)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 通知先ごとのメッセージの文字数の上限の既定
+// これを超えるとSlackやDiscordは投稿を拒否する
+var defaultMaxLength = map[string]int{
+	"slack":   40000,
+	"discord": 2000,
+}
+
+// メッセージを上限の文字数に収める
+// URLを含む行は切らずに残し、ほかの行 (タイトルなど) を語の境界で切り詰める
+// URLの行だけで上限を超える場合 (長いダイジェスト) は行単位で後ろを落とす
+// maxが0なら切り詰めない
+func truncateMessage(msg string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(msg) <= max {
+		return msg
+	}
+	lines := strings.Split(msg, "\n")
+	// 改行とURLの行はそのまま使う
+	fixed := len(lines) - 1
+	for _, l := range lines {
+		if hasURL(l) {
+			fixed += utf8.RuneCountInString(l)
+		}
+	}
+	if fixed > max {
+		return truncateLines(lines, max)
+	}
+	budget := max - fixed
+	var out []string
+	for _, l := range lines {
+		if hasURL(l) {
+			out = append(out, l)
+			continue
+		}
+		n := utf8.RuneCountInString(l)
+		switch {
+		case n <= budget:
+			budget -= n
+		case budget > 0:
+			l = truncateWords(l, budget)
+			budget = 0
+		default:
+			// 残りがなければURL以外の行は落とす
+			continue
+		}
+		out = append(out, l)
+	}
+	return strings.Join(out, "\n")
+}
+
+// 収まる行だけを残し、省略した印を付ける
+func truncateLines(lines []string, max int) string {
+	const more = "…"
+	budget := max - utf8.RuneCountInString(more)
+	var out []string
+	for _, l := range lines {
+		n := utf8.RuneCountInString(l) + 1
+		if n > budget {
+			break
+		}
+		out = append(out, l)
+		budget -= n
+	}
+	// 見出しや記事のタイトルだけが残らないように、最後のURLの行までにする
+	for len(out) > 0 && !hasURL(out[len(out)-1]) {
+		out = out[:len(out)-1]
+	}
+	return strings.Join(append(out, more), "\n")
+}
+
+// 語の境界で切り詰めて…を付ける
+// 日本語のように空白のない文は文字の単位で切る
+func truncateWords(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	if max <= 1 {
+		return strings.Repeat("…", max)
+	}
+	r := []rune(s)[:max-1]
+	// 半分より前まで戻るなら語の途中で切る
+	for i := len(r) - 1; i >= len(r)/2; i-- {
+		if unicode.IsSpace(r[i]) {
+			r = r[:i]
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(r), unicode.IsSpace) + "…"
+}
+
+func hasURL(s string) bool {
+	return strings.Contains(s, "http://") || strings.Contains(s, "https://")
+}
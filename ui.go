@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 画面の1ページに出す記事の数 (「さらに表示」でこの数ずつ増やす)
+const uiPageSize = 100
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Heading}}</title>
+<style>
+body { max-width: 56em; margin: auto; padding: 1em; line-height: 1.6; font-family: sans-serif; }
+nav a { margin-right: 1em; }
+nav a.current { font-weight: bold; text-decoration: none; color: inherit; }
+ul { list-style: none; padding: 0; }
+li { padding: .6em 0; border-bottom: 1px solid #ddd; }
+small { color: #666; }
+form { display: inline; }
+button { font-size: .8em; }
+</style>
+</head>
+<body>
+<nav>
+{{- range .Tabs}}
+<a href="{{.Href}}"{{if .Current}} class="current"{{end}}>{{.Label}}</a>
+{{- end}}
+</nav>
+<form method="get" action="/ui">
+<input type="hidden" name="view" value="{{.View}}">
+<select name="source" onchange="this.form.submit()">
+<option value="">すべてのブログ</option>
+{{- range .Sources}}
+<option value="{{.}}"{{if eq . $.Source}} selected{{end}}>{{.}}</option>
+{{- end}}
+</select>
+<noscript><button type="submit">絞り込む</button></noscript>
+</form>
+<h1>{{.Heading}}</h1>
+<ul>
+{{- range .Articles}}
+<li>
+<a href="{{.URL}}" rel="noreferrer">{{.Title}}</a><br>
+<small>{{.Source}} · {{.Date}}</small>
+{{- if not $.ReadOnly}}
+<form method="post" action="/ui/read">
+<input type="hidden" name="csrf_token" value="{{$.CSRF}}">
+<input type="hidden" name="url" value="{{.URL}}">
+<input type="hidden" name="back" value="{{$.Back}}">
+{{- if .Read}}
+<input type="hidden" name="read" value="false">
+<button type="submit">未読に戻す</button>
+{{- else}}
+<input type="hidden" name="read" value="true">
+<button type="submit">既読にする</button>
+{{- end}}
+</form>
+{{- if not .Read}}
+<form method="post" action="/ui/snooze">
+<input type="hidden" name="csrf_token" value="{{$.CSRF}}">
+<input type="hidden" name="url" value="{{.URL}}">
+<input type="hidden" name="back" value="{{$.Back}}">
+{{- if $.Snoozed}}
+<button type="submit" name="for" value="0">スヌーズをやめる</button>
+{{- else}}
+<button type="submit" name="for" value="1d">明日まで</button>
+<button type="submit" name="for" value="7d">1週間後まで</button>
+{{- end}}
+</form>
+{{- end}}
+{{- end}}
+</li>
+{{- else}}
+<li>記事はありません</li>
+{{- end}}
+</ul>
+{{- if .More}}
+<p><a href="{{.More}}">さらに表示</a></p>
+{{- end}}
+</body>
+</html>
+`))
+
+// 画面の表示 (未読、スヌーズ中、既読)
+var uiViews = []struct {
+	name, label string
+}{
+	{"unread", "未読"},
+	{"snoozed", "スヌーズ中"},
+	{"read", "既読"},
+}
+
+type uiTab struct {
+	Label, Href string
+	Current     bool
+}
+
+type uiArticle struct {
+	Title, URL, Source, Date string
+	Read                     bool
+}
+
+// 記事を一覧して既読やスヌーズにする画面
+// APIと同じログインで守り、フォームはcsrf_tokenを付けて送る
+func registerUI(mux *http.ServeMux, auth *sessionAuth, readOnly bool) {
+	mux.Handle("/ui", auth.require(handleUI(readOnly)))
+	mux.Handle("/ui/read", requireLoginToChange(auth, http.HandlerFunc(handleUIRead)))
+	mux.Handle("/ui/snooze", requireLoginToChange(auth, http.HandlerFunc(handleUISnooze)))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/ui", http.StatusSeeOther)
+	})
+}
+
+// GET /ui?view=unread|snoozed|read&source=...&limit=...
+func handleUI(readOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		view, source := q.Get("view"), q.Get("source")
+		if view == "" {
+			view = "unread"
+		}
+		limit := uiPageSize
+		if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+			limit = n
+		}
+		// 確認待ちと絞り込みで除いた記事は出さない
+		f := articleFilter{status: statusOK, source: source, newestFirst: true, limit: limit + 1, excludeSources: hiddenSources(r.Context())}
+		switch view {
+		case "unread":
+			f.read, f.awake = boolPtr(false), true
+		case "snoozed":
+			f.read, f.snoozed = boolPtr(false), true
+		case "read":
+			f.read = boolPtr(true)
+		default:
+			http.Error(w, "unknown view", http.StatusBadRequest)
+			return
+		}
+		articles, err := queryArticles(r.Context(), f)
+		if err != nil {
+			log.Printf("ui: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		sources, err := uiSources(r.Context(), hiddenSources(r.Context()))
+		if err != nil {
+			log.Printf("ui: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		page := struct {
+			Heading, View, Source, Back, More, CSRF string
+			Snoozed, ReadOnly                       bool
+			Tabs                                    []uiTab
+			Sources                                 []string
+			Articles                                []uiArticle
+		}{View: view, Source: source, Back: r.URL.RequestURI(), Snoozed: view == "snoozed", ReadOnly: readOnly, Sources: sources}
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			page.CSRF = csrfToken(c.Value)
+		}
+		for _, v := range uiViews {
+			page.Tabs = append(page.Tabs, uiTab{Label: v.label, Href: uiURL(v.name, source, 0), Current: v.name == view})
+			if v.name == view {
+				page.Heading = v.label
+			}
+		}
+		if len(articles) > limit {
+			articles = articles[:limit]
+			page.More = uiURL(view, source, limit+uiPageSize)
+		}
+		for _, a := range articles {
+			page.Articles = append(page.Articles, uiArticle{Title: a.title, URL: a.url, Source: articleSource(a), Date: a.date, Read: a.read})
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := uiTemplate.Execute(w, page); err != nil {
+			log.Printf("render ui: %v", err)
+		}
+	}
+}
+
+func uiURL(view, source string, limit int) string {
+	v := url.Values{"view": {view}}
+	if source != "" {
+		v.Set("source", source)
+	}
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	return "/ui?" + v.Encode()
+}
+
+// 絞り込みに出すブログ (設定のブログと、add-urlなどで記事を保存した配信元、hiddenは除く)
+func uiSources(ctx context.Context, hidden []string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, b := range blogs() {
+		seen[b.name()] = true
+	}
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT source FROM articles WHERE source != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		seen[s] = true
+	}
+	for _, s := range hidden {
+		delete(seen, s)
+	}
+	res := make([]string, 0, len(seen))
+	for s := range seen {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res, rows.Err()
+}
+
+// フォームを送った後に戻る画面 (外のURLには戻らない)
+func uiBack(r *http.Request) string {
+	back := r.PostFormValue("back")
+	if !strings.HasPrefix(back, "/ui") {
+		return "/ui"
+	}
+	return back
+}
+
+// POST /ui/read (url, read=true|false)
+func handleUIRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u := r.PostFormValue("url")
+	if u == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if r.PostFormValue("read") == "false" {
+		err = markUnread(r.Context(), u)
+	} else {
+		err = acknowledge(r.Context(), u)
+	}
+	if err != nil {
+		log.Printf("ui read: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, uiBack(r), http.StatusSeeOther)
+}
+
+// POST /ui/snooze (url, for=1d|7d|0)
+// 0ならスヌーズをやめる
+func handleUISnooze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u := r.PostFormValue("url")
+	d, err := parseDuration(r.PostFormValue("for"))
+	if u == "" || err != nil {
+		http.Error(w, "url and a duration are required", http.StatusBadRequest)
+		return
+	}
+	if d == 0 {
+		err = unsnoozeArticle(r.Context(), u)
+	} else {
+		err = snoozeArticle(r.Context(), u, time.Now().Add(d))
+	}
+	if err != nil {
+		log.Printf("ui snooze: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, uiBack(r), http.StatusSeeOther)
+}
+
+// 既読の記事を未読に戻す
+// 次の通知の対象に戻り、リマインドもやり直す
+// 届いた記録の冪等キーを外すので、次の通知で送り直す (記録そのものは残す)
+func markUnread(ctx context.Context, url string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := tx.ExecContext(ctx, "UPDATE articles SET read = FALSE, read_at = NULL, acked_at = NULL, updated_at = ? WHERE url = ? AND read", now, url)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE deliveries SET idempotency_key = NULL WHERE url = ? AND idempotency_key IS NOT NULL", url); err != nil {
+		return err
+	}
+	if err := recordEvent(ctx, tx, url, eventUnread); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"fetch-blog/blogtest"
+	"fetch-blog/store"
+)
+
+func TestMarkUnreadNotifiesAgain(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+	const url = "https://example.com/a"
+	saveTestArticles(t, store.Article{Title: "A", URL: url, Date: "2026-01-01"})
+	n := &blogtest.Notifier{}
+	dests := []destination{&fakeDestination{label: "slack", n: n}}
+	a := article{title: "A", url: url}
+
+	if err := notifyArticle(ctx, dests, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := markUnread(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	if isRead(t, url) {
+		t.Fatal("still read after markUnread")
+	}
+	if err := notifyArticle(ctx, dests, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(n.Messages()); got != 2 {
+		t.Errorf("sent %d messages, want 2", got)
+	}
+	if !isRead(t, url) {
+		t.Error("not read after the second notification")
+	}
+	// 未読に戻さなければ送り直さない
+	if err := notifyArticle(ctx, dests, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(n.Messages()); got != 2 {
+		t.Errorf("sent %d messages after a repeated notification, want 2", got)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 未読の記事のAtomフィード
+// Slackの代わりにフィードリーダーで未読の記事を読む
+type unreadFeedConfig struct {
+	// 既定は"Unread articles"
+	Title string `yaml:"title"`
+	// 載せる件数 (既定は50、新しい順)
+	Limit int `yaml:"limit"`
+	// 取得と通知のたびにフィードを書き出すファイル
+	Path string `yaml:"path"`
+	// 指定するとserveで/feed.atom?token=...として配信する (フィードリーダーはログインできないため)
+	Token string `yaml:"token"`
+}
+
+func (c unreadFeedConfig) limit() int {
+	if c.Limit <= 0 {
+		return 50
+	}
+	return c.Limit
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Updated    string         `xml:"updated"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// 記事の時刻 (公開日時がなければ一覧の日付の0時)
+func atomTime(a article) string {
+	if t, err := time.Parse(time.RFC3339, a.publishedAt); err == nil {
+		return t.UTC().Format(time.RFC3339)
+	}
+	if t, err := time.Parse("2006-01-02", a.date); err == nil {
+		return t.Format(time.RFC3339)
+	}
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// 未読の記事 (確認待ちとスヌーズ中を除く) をAtomで書く
+func writeUnreadFeed(ctx context.Context, w io.Writer, c unreadFeedConfig) error {
+	articles, err := queryArticles(ctx, articleFilter{read: boolPtr(false), status: statusOK, awake: true, newestFirst: true, limit: c.limit()})
+	if err != nil {
+		return err
+	}
+	feed := atomFeed{Title: c.Title, ID: "urn:fetch-blog:unread"}
+	if feed.Title == "" {
+		feed.Title = "Unread articles"
+	}
+	if base := strings.TrimRight(conf.Server.Auth.PublicURL, "/"); base != "" {
+		feed.ID = base + "/feed.atom"
+		feed.Links = []atomLink{{Rel: "self", Href: feed.ID}}
+	}
+	for _, a := range articles {
+		e := atomEntry{Title: a.title, ID: a.url, Link: atomLink{Href: a.url}, Updated: atomTime(a), Author: atomAuthor{Name: articleSource(a)}}
+		for _, cat := range a.categories {
+			e.Categories = append(e.Categories, atomCategory{Term: cat})
+		}
+		// RFC3339のUTCなので文字列で比べられる
+		if e.Updated > feed.Updated {
+			feed.Updated = e.Updated
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	if feed.Updated == "" {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// フィードをファイルに書き出す
+// フィードリーダーが書きかけのファイルを読まないように、一時ファイルに書いてから置き換える
+func writeUnreadFeedFile(ctx context.Context, c unreadFeedConfig, path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".feed-*")
+	if err != nil {
+		return err
+	}
+	err = writeUnreadFeed(ctx, tmp, c)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	// CreateTempは0600で作るので、静的ファイルとして配信できるようにする
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// unread-feed: 未読の記事のAtomフィードを書き出す
+// 出力先を省くとunread_feed.pathに、それもなければ標準出力に書く
+func cmdUnreadFeed(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("unread-feed", flag.ExitOnError)
+	limit := fs.Int("limit", 0, "number of articles (default unread_feed.limit or 50)")
+	parseFlags(fs, args)
+	c := conf.UnreadFeed
+	if *limit > 0 {
+		c.Limit = *limit
+	}
+	out := fs.Arg(0)
+	if out == "" {
+		out = c.Path
+	}
+	if out == "" {
+		return writeUnreadFeed(ctx, os.Stdout, c)
+	}
+	if err := writeUnreadFeedFile(ctx, c, out); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", out)
+	return nil
+}
+
+// GET /feed.atom?token=...
+func handleUnreadFeed(c unreadFeedConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(c.Token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		var buf bytes.Buffer
+		if err := writeUnreadFeed(r.Context(), &buf, c); err != nil {
+			log.Printf("unread feed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
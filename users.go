@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 招待リンクの有効期間
+const inviteTTL = 7 * 24 * time.Hour
+
+// 存在しない利用者の照合に使うハッシュ
+var dummyHash = []byte("$2a$10$p4wcgj2CBYOG17y8qWm.d.7fEPNeJruv0WC5v1KYahaLYkCQEcVyW")
+
+// ランダムなトークン (URLやCookieにそのまま使える)
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// パスワードを設定する
+// 利用者がいなければ作成する
+func setPassword(ctx context.Context, name, password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+INSERT INTO users (name, password_hash, created_at) VALUES (?, ?, ?)
+ON CONFLICT (name) DO UPDATE SET password_hash = excluded.password_hash`,
+		name, string(hash), time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// 利用者名とパスワードを確かめる
+func checkPassword(ctx context.Context, name, password string) (bool, error) {
+	var hash sql.NullString
+	err := db.QueryRowContext(ctx, "SELECT password_hash FROM users WHERE name = ?", name).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 利用者の有無で応答時間が変わらないようにする
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// 招待中でまだパスワードがない
+	if !hash.Valid {
+		return false, nil
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password)) == nil, nil
+}
+
+// 招待を作成してトークンを返す
+// DBにはトークンのハッシュだけを保存する
+func createInvite(ctx context.Context, name string) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	_, err = db.ExecContext(ctx, "INSERT OR IGNORE INTO users (name, created_at) VALUES (?, ?)", name, now.Format(time.RFC3339))
+	if err != nil {
+		return "", err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO invites (token_hash, user, expires_at) VALUES (?, ?, ?)",
+		sha256Hex([]byte(token)), name, now.Add(inviteTTL).Unix())
+	return token, err
+}
+
+// 招待を使ってパスワードを設定する
+// 招待は一度しか使えない
+func acceptInvite(ctx context.Context, token, password string) (string, error) {
+	var name string
+	err := db.QueryRowContext(ctx, "SELECT user FROM invites WHERE token_hash = ? AND expires_at > ?",
+		sha256Hex([]byte(token)), time.Now().Unix()).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errors.New("invite is invalid or expired")
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := setPassword(ctx, name, password); err != nil {
+		return "", err
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM invites WHERE token_hash = ?", sha256Hex([]byte(token))); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// users: Web UIの利用者を管理する
+//
+//	users add <name>       パスワードを標準入力から読んで追加
+//	users invite <name>    招待リンクを発行 (パスワードは本人が設定)
+//	users list
+//	users delete <name>
+func cmdUsers(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("users", flag.ExitOnError)
+	parseFlags(fs, args)
+
+	switch fs.Arg(0) {
+	case "add":
+		name := fs.Arg(1)
+		if name == "" {
+			return usageErr("usage: users add <name>")
+		}
+		fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		if err := setPassword(ctx, name, strings.TrimRight(line, "\r\n")); err != nil {
+			return err
+		}
+		fmt.Printf("added %s\n", name)
+		return nil
+	case "invite":
+		name := fs.Arg(1)
+		if name == "" {
+			return usageErr("usage: users invite <name>")
+		}
+		token, err := createInvite(ctx, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s/invite?token=%s\n", strings.TrimRight(conf.Server.Auth.PublicURL, "/"), token)
+		fmt.Fprintf(os.Stderr, "the link expires in %s\n", inviteTTL)
+		return nil
+	case "list", "":
+		rows, err := db.QueryContext(ctx, "SELECT name, password_hash IS NOT NULL, created_at FROM users ORDER BY name")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATE\tCREATED")
+		for rows.Next() {
+			var name, created string
+			var active bool
+			if err := rows.Scan(&name, &active, &created); err != nil {
+				return err
+			}
+			state := "invited"
+			if active {
+				state = "active"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, state, created)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return w.Flush()
+	case "delete":
+		name := fs.Arg(1)
+		if name == "" {
+			return usageErr("usage: users delete <name>")
+		}
+		// ログイン中のセッションと招待も無効にする
+		for _, q := range []string{
+			"DELETE FROM sessions WHERE user = ?",
+			"DELETE FROM invites WHERE user = ?",
+			"DELETE FROM user_identities WHERE user = ?",
+			"DELETE FROM users WHERE name = ?",
+		} {
+			if _, err := db.ExecContext(ctx, q, name); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("deleted %s\n", name)
+		return nil
+	default:
+		return usageErr(fmt.Sprintf("unknown users command %q", fs.Arg(0)))
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"fetch-blog/fetcher"
+)
+
+// 分析用のデータウェアハウス (BigQuery, ClickHouse) への書き出し
+// 記事と出来事の行を前回の続きから追記するだけで、運用のDBには書き出した位置しか残さない
+// 長い期間の読書の傾向をウェアハウスのSQLで分析する
+type warehouseConfig struct {
+	// bigquery, clickhouse
+	Type string `yaml:"type"`
+	// ClickHouseのHTTPインターフェースのURL (例: http://localhost:8123)
+	URL      string `yaml:"url"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// BigQueryのプロジェクト
+	Project string `yaml:"project"`
+	// BigQueryのデータセット、ClickHouseのデータベース
+	Dataset string `yaml:"dataset"`
+	// BigQueryのアクセストークン
+	// 空ならGOOGLE_OAUTH_ACCESS_TOKEN、それもなければGCEのメタデータサーバーから取る
+	AccessToken string `yaml:"access_token"`
+	// 書き出すテーブル (既定はarticlesとevents)
+	ArticlesTable string `yaml:"articles_table"`
+	EventsTable   string `yaml:"events_table"`
+	// 1回に送る行数 (既定は500)
+	BatchSize int `yaml:"batch_size"`
+	// warehouseを繰り返す間隔 (0なら1回だけ)
+	Interval time.Duration `yaml:"interval"`
+}
+
+const (
+	warehouseBigQuery   = "bigquery"
+	warehouseClickHouse = "clickhouse"
+
+	defaultWarehouseBatchSize = 500
+)
+
+func (c warehouseConfig) validate() error {
+	switch c.Type {
+	case "":
+		return nil
+	case warehouseBigQuery:
+		if c.Project == "" || c.Dataset == "" {
+			return errors.New("warehouse: project and dataset are required for bigquery")
+		}
+	case warehouseClickHouse:
+		if c.URL == "" {
+			return errors.New("warehouse: url is required for clickhouse")
+		}
+	default:
+		return fmt.Errorf("warehouse.type: unknown type %q (bigquery, clickhouse)", c.Type)
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("warehouse.batch_size: must not be negative (got %d)", c.BatchSize)
+	}
+	return nil
+}
+
+func (c warehouseConfig) articlesTable() string {
+	if c.ArticlesTable == "" {
+		return "articles"
+	}
+	return c.ArticlesTable
+}
+
+func (c warehouseConfig) eventsTable() string {
+	if c.EventsTable == "" {
+		return "events"
+	}
+	return c.EventsTable
+}
+
+func (c warehouseConfig) batchSize() int {
+	if c.BatchSize <= 0 {
+		return defaultWarehouseBatchSize
+	}
+	return c.BatchSize
+}
+
+// 書き出した位置を残すときの名前 (送り先が変わったら最初から書き出す)
+func (c warehouseConfig) target() string {
+	if c.Type == warehouseBigQuery {
+		return c.Type + ":" + c.Project + "." + c.Dataset
+	}
+	return c.Type + ":" + c.URL + "/" + c.Dataset
+}
+
+// warehouse: 記事と出来事をデータウェアハウスに追記する
+func cmdWarehouse(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("warehouse", flag.ExitOnError)
+	interval := fs.Duration("interval", conf.Warehouse.Interval, "repeat at this interval (0 to export once)")
+	schema := fs.Bool("schema", false, "print the CREATE TABLE statements for the warehouse and exit")
+	parseFlags(fs, args)
+	c := conf.Warehouse
+	if c.Type == "" {
+		return errors.New("warehouse.type is required")
+	}
+	if *schema {
+		fmt.Print(warehouseSchema(c))
+		return nil
+	}
+	for {
+		if err := exportWarehouse(ctx, c); err != nil {
+			if *interval == 0 {
+				return err
+			}
+			// 繰り返すときは次の回で続きから送る
+			log.Printf("warehouse: %v", err)
+		}
+		if *interval == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 前回の続きから記事と出来事を送る
+// 1回分を送るごとに位置を進めるので、途中で失敗しても送った行は二度送らない
+func exportWarehouse(ctx context.Context, c warehouseConfig) error {
+	var articleRow, eventID int64
+	err := db.QueryRowContext(ctx, "SELECT article_rowid, event_id FROM warehouse_state WHERE target = ?", c.target()).Scan(&articleRow, &eventID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	w, err := newWarehouseWriter(ctx, c)
+	if err != nil {
+		return err
+	}
+	var articles, events int
+	for {
+		rows, last, err := warehouseArticles(ctx, articleRow, c.batchSize())
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		if err := w.insert(ctx, c.articlesTable(), rows); err != nil {
+			return err
+		}
+		articleRow = last
+		articles += len(rows)
+		if err := saveWarehouseState(ctx, c, articleRow, eventID); err != nil {
+			return err
+		}
+	}
+	for {
+		rows, last, err := warehouseEvents(ctx, eventID, c.batchSize())
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		if err := w.insert(ctx, c.eventsTable(), rows); err != nil {
+			return err
+		}
+		eventID = last
+		events += len(rows)
+		if err := saveWarehouseState(ctx, c, articleRow, eventID); err != nil {
+			return err
+		}
+	}
+	log.Printf("warehouse %s: appended %d articles, %d events", c.target(), articles, events)
+	return nil
+}
+
+func saveWarehouseState(ctx context.Context, c warehouseConfig, articleRow, eventID int64) error {
+	_, err := db.ExecContext(ctx, `
+INSERT INTO warehouse_state (target, article_rowid, event_id, exported_at) VALUES (?, ?, ?, ?)
+ON CONFLICT (target) DO UPDATE SET article_rowid = excluded.article_rowid, event_id = excluded.event_id, exported_at = excluded.exported_at`,
+		c.target(), articleRow, eventID, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// 書き出す1行 (列名と値)
+// idはBigQueryで重複を除くのにも使う
+type warehouseRow struct {
+	id     string
+	values map[string]any
+}
+
+// afterより後に追加した記事 (rowidの順)
+func warehouseArticles(ctx context.Context, after int64, limit int) ([]warehouseRow, int64, error) {
+	rs, err := db.QueryContext(ctx, `
+SELECT rowid, url, title, substr(date, 1, 10), COALESCE(published_at, ''), source, status, language, COALESCE(reading_ease, -1),
+       COALESCE((SELECT group_concat(category, ',') FROM article_categories c WHERE c.url = articles.url), '')
+FROM articles WHERE rowid > ? ORDER BY rowid LIMIT ?`, after, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rs.Close()
+	var rows []warehouseRow
+	last := after
+	for rs.Next() {
+		var id int64
+		var u, title, date, published, source, status, language, categories string
+		var ease float64
+		if err := rs.Scan(&id, &u, &title, &date, &published, &source, &status, &language, &ease, &categories); err != nil {
+			return nil, 0, err
+		}
+		if source == "" {
+			source = urlHost(u)
+		}
+		v := map[string]any{
+			"id": id, "url": u, "title": title, "date": date, "source": source, "status": status,
+			"language": language, "categories": splitNonEmpty(categories),
+			"published_at": nil, "reading_ease": nil,
+		}
+		if published != "" {
+			v["published_at"] = published
+		}
+		if ease >= 0 {
+			v["reading_ease"] = ease
+		}
+		rows = append(rows, warehouseRow{id: fmt.Sprintf("article-%d", id), values: v})
+		last = id
+	}
+	return rows, last, rs.Err()
+}
+
+// afterより後の出来事 (idの順)
+func warehouseEvents(ctx context.Context, after int64, limit int) ([]warehouseRow, int64, error) {
+	rs, err := db.QueryContext(ctx, "SELECT id, url, type, detail, created_at FROM events WHERE id > ? ORDER BY id LIMIT ?", after, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rs.Close()
+	var rows []warehouseRow
+	last := after
+	for rs.Next() {
+		var id int64
+		var u, typ, detail, created string
+		if err := rs.Scan(&id, &u, &typ, &detail, &created); err != nil {
+			return nil, 0, err
+		}
+		rows = append(rows, warehouseRow{id: fmt.Sprintf("event-%d", id), values: map[string]any{
+			"id": id, "url": u, "type": typ, "detail": detail, "created_at": created,
+		}})
+		last = id
+	}
+	return rows, last, rs.Err()
+}
+
+func splitNonEmpty(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// テーブルへの行の追記
+type warehouseWriter interface {
+	insert(ctx context.Context, table string, rows []warehouseRow) error
+}
+
+func newWarehouseWriter(ctx context.Context, c warehouseConfig) (warehouseWriter, error) {
+	if c.Type == warehouseClickHouse {
+		return &clickHouseWriter{cfg: c}, nil
+	}
+	token := c.AccessToken
+	if token == "" {
+		token = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	if token == "" {
+		var err error
+		if token, err = metadataAccessToken(ctx); err != nil {
+			return nil, fmt.Errorf("bigquery: no access token (set warehouse.access_token or GOOGLE_OAUTH_ACCESS_TOKEN): %w", err)
+		}
+	}
+	return &bigQueryWriter{cfg: c, token: token}, nil
+}
+
+// ClickHouseのHTTPインターフェースにJSONEachRowで送る
+type clickHouseWriter struct {
+	cfg warehouseConfig
+}
+
+func (w *clickHouseWriter) insert(ctx context.Context, table string, rows []warehouseRow) error {
+	if w.cfg.Dataset != "" {
+		table = w.cfg.Dataset + "." + table
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range rows {
+		if err := enc.Encode(r.values); err != nil {
+			return err
+		}
+	}
+	q := url.Values{}
+	q.Set("query", "INSERT INTO "+table+" FORMAT JSONEachRow")
+	// ウェアハウスにない列は無視する (使う列だけのテーブルでもよい)
+	q.Set("input_format_skip_unknown_fields", "1")
+	// 日時はRFC3339で送る
+	q.Set("date_time_input_format", "best_effort")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(w.cfg.URL, "/")+"/?"+q.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	if w.cfg.User != "" {
+		req.Header.Set("X-ClickHouse-User", w.cfg.User)
+		req.Header.Set("X-ClickHouse-Key", w.cfg.Password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("clickhouse %s: %w: %s", table, &fetcher.StatusError{Code: resp.StatusCode}, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// BigQueryのストリーミング挿入 (tabledata.insertAll) で送る
+// insertIdに行のidを付けるので、送り直しても重複しない
+type bigQueryWriter struct {
+	cfg   warehouseConfig
+	token string
+}
+
+func (w *bigQueryWriter) insert(ctx context.Context, table string, rows []warehouseRow) error {
+	type insertRow struct {
+		InsertID string         `json:"insertId"`
+		JSON     map[string]any `json:"json"`
+	}
+	payload := struct {
+		Rows                []insertRow `json:"rows"`
+		IgnoreUnknownValues bool        `json:"ignoreUnknownValues"`
+	}{IgnoreUnknownValues: true}
+	for _, r := range rows {
+		payload.Rows = append(payload.Rows, insertRow{InsertID: r.id, JSON: r.values})
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("https://bigquery.googleapis.com/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll",
+		url.PathEscape(w.cfg.Project), url.PathEscape(w.cfg.Dataset), url.PathEscape(table))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+w.token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bigquery %s: %w: %s", table, &fetcher.StatusError{Code: resp.StatusCode}, truncateRunes(strings.TrimSpace(string(raw)), 500))
+	}
+	// 200でも行ごとのエラーがある
+	var res struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return fmt.Errorf("bigquery %s: %w", table, err)
+	}
+	if len(res.InsertErrors) > 0 {
+		e := res.InsertErrors[0]
+		msg := "unknown error"
+		if len(e.Errors) > 0 {
+			msg = e.Errors[0].Reason + ": " + e.Errors[0].Message
+		}
+		return fmt.Errorf("bigquery %s: %d rows rejected (row %s: %s)", table, len(res.InsertErrors), rows[e.Index].id, msg)
+	}
+	return nil
+}
+
+// GCEやCloud Runのサービスアカウントのアクセストークン
+func metadataAccessToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server: %w", &fetcher.StatusError{Code: resp.StatusCode})
+	}
+	var res struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	return res.AccessToken, nil
+}
+
+// ウェアハウスに作るテーブル
+func warehouseSchema(c warehouseConfig) string {
+	prefix := c.Dataset
+	if prefix != "" {
+		prefix += "."
+	}
+	if c.Type == warehouseBigQuery {
+		return fmt.Sprintf(`CREATE TABLE %[1]s%[2]s (
+  id INT64 NOT NULL, url STRING NOT NULL, title STRING, date DATE, published_at TIMESTAMP,
+  source STRING, status STRING, language STRING, reading_ease FLOAT64, categories ARRAY<STRING>
+);
+CREATE TABLE %[1]s%[3]s (
+  id INT64 NOT NULL, url STRING NOT NULL, type STRING NOT NULL, detail STRING, created_at TIMESTAMP NOT NULL
+) PARTITION BY DATE(created_at);
+`, prefix, c.articlesTable(), c.eventsTable())
+	}
+	return fmt.Sprintf(`CREATE TABLE %[1]s%[2]s (
+  id Int64, url String, title String, date Date, published_at Nullable(DateTime64(0, 'UTC')),
+  source LowCardinality(String), status LowCardinality(String), language LowCardinality(String),
+  reading_ease Nullable(Float64), categories Array(String)
+) ENGINE = MergeTree ORDER BY id;
+CREATE TABLE %[1]s%[3]s (
+  id Int64, url String, type LowCardinality(String), detail String, created_at DateTime64(0, 'UTC')
+) ENGINE = MergeTree PARTITION BY toYYYYMM(created_at) ORDER BY (created_at, id);
+`, prefix, c.articlesTable(), c.eventsTable())
+}